@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// apiKeyHintLength is how many characters of a key are kept to tell keys apart on the list
+const apiKeyHintLength = len(models.APIKeyPrefix) + 6
+
+var APIKeys configs.APIKeyStore
+
+func init() {
+	APIKeys = configs.NewMongoAPIKeyStore(configs.DB)
+}
+
+// CreateAPIKey issues a new key for the company of the admin, the key is only returned on this response
+func CreateAPIKey() gin.HandlerFunc {
+	log.Info().Msg("Create API key endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		var request models.CreateAPIKeyRequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		if err := validate.Struct(&request); err != nil {
+			log.Error().Err(err).Msg("Error validating request")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		admin := CurrentUser(c)
+		companyId, ok := adminCompany(c, admin)
+		if !ok {
+			return
+		}
+
+		key, keyHash, err := generateAPIKey()
+		if err != nil {
+			log.Error().Err(err).Msg("Error generating API key")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error creating API key", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		apiKey := models.APIKey{
+			Company:    companyId,
+			Name:       request.Name,
+			KeyHash:    keyHash,
+			Hint:       key[:apiKeyHintLength],
+			Scopes:     request.Scopes,
+			AllowedIPs: request.AllowedIPs,
+			CreatedBy:  admin.Id,
+			CreatedAt:  time.Now(),
+		}
+		apiKey.Id, err = APIKeys.CreateAPIKey(ctx, apiKey)
+		if err != nil {
+			log.Error().Err(err).Msg("Error storing API key on database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error creating API key", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		recordAPIKeyEvent(ctx, c, models.AuditActionAPIKeyCreated, admin, &apiKey)
+
+		log.Info().Msg("API key: " + apiKey.Id.Hex() + " created for company: " + companyId.Hex())
+		c.JSON(http.StatusCreated, responses.UserResponse{Status: http.StatusCreated, Message: "success", Data: map[string]interface{}{"apiKey": apiKey, "key": key}})
+	}
+}
+
+// GetAPIKeys lists the keys of the company of the admin, without their secrets
+func GetAPIKeys() gin.HandlerFunc {
+	log.Info().Msg("Get API keys endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		apiKeys, err := APIKeys.FindCompanyAPIKeys(ctx, companyId)
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting API keys from database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error getting API keys from database", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		log.Info().Msg("API keys of company: " + companyId.Hex() + " retrieved successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"apiKeys": apiKeys}})
+	}
+}
+
+// RevokeAPIKey disables a key of the company of the admin
+func RevokeAPIKey() gin.HandlerFunc {
+	log.Info().Msg("Revoke API key endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+
+		admin := CurrentUser(c)
+		companyId, ok := adminCompany(c, admin)
+		if !ok {
+			return
+		}
+
+		keyId, _ := primitive.ObjectIDFromHex(c.Param("keyId"))
+		err := APIKeys.RevokeAPIKey(ctx, companyId, keyId)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			log.Error().Msg("API key: " + c.Param("keyId") + " not found")
+			c.JSON(http.StatusNotFound, responses.UserResponse{Status: http.StatusNotFound, Message: "API key not found", Data: nil})
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Error revoking API key on database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error revoking API key", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		recordAPIKeyEvent(ctx, c, models.AuditActionAPIKeyRevoked, admin, &models.APIKey{Id: keyId, Company: companyId})
+
+		log.Info().Msg("API key: " + keyId.Hex() + " revoked successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: nil})
+	}
+}
+
+// RotateAPIKey replaces the secret of a key keeping its scopes, the new key is only returned on this response
+func RotateAPIKey() gin.HandlerFunc {
+	log.Info().Msg("Rotate API key endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+
+		admin := CurrentUser(c)
+		companyId, ok := adminCompany(c, admin)
+		if !ok {
+			return
+		}
+
+		key, keyHash, err := generateAPIKey()
+		if err != nil {
+			log.Error().Err(err).Msg("Error generating API key")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error rotating API key", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		keyId, _ := primitive.ObjectIDFromHex(c.Param("keyId"))
+		apiKey, err := APIKeys.RotateAPIKey(ctx, companyId, keyId, keyHash, key[:apiKeyHintLength])
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			log.Error().Msg("API key: " + c.Param("keyId") + " not found")
+			c.JSON(http.StatusNotFound, responses.UserResponse{Status: http.StatusNotFound, Message: "API key not found", Data: nil})
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Error rotating API key on database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error rotating API key", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		recordAPIKeyEvent(ctx, c, models.AuditActionAPIKeyRotated, admin, apiKey)
+
+		log.Info().Msg("API key: " + keyId.Hex() + " rotated successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"apiKey": apiKey, "key": key}})
+	}
+}
+
+// generateAPIKey returns a new key and the hash that gets stored
+func generateAPIKey() (string, string, error) {
+	token, err := auth.GenerateToken()
+	if err != nil {
+		return "", "", err
+	}
+	key := models.APIKeyPrefix + token
+	return key, auth.HashToken(key), nil
+}
+
+// adminCompany returns the company on the path, answering 403 when it is not the company of the admin
+func adminCompany(c *gin.Context, admin *models.UserWithCompanyAsObject) (primitive.ObjectID, bool) {
+	companyId, err := primitive.ObjectIDFromHex(c.Param("companyId"))
+	if err != nil || companyId != admin.Company {
+		log.Error().Msg("Admin: " + admin.Id.Hex() + " tried to manage company: " + c.Param("companyId"))
+		c.JSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "You are not allowed to perform this action", Data: nil})
+		return primitive.NilObjectID, false
+	}
+	return companyId, true
+}
+
+func recordAPIKeyEvent(ctx context.Context, c *gin.Context, action string, admin *models.UserWithCompanyAsObject, apiKey *models.APIKey) {
+	recordAuditEvent(ctx, models.AuditEvent{
+		Action:   action,
+		Actor:    &admin.Id,
+		Company:  &apiKey.Company,
+		IP:       c.ClientIP(),
+		Metadata: map[string]interface{}{"apiKeyId": apiKey.Id},
+	})
+}
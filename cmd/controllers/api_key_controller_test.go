@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MockAPIKeyStore is a mock implementation of the API key operations that keeps the keys in a map
+type MockAPIKeyStore struct {
+	Keys map[primitive.ObjectID]*models.APIKey
+}
+
+func NewMockAPIKeyStore() *MockAPIKeyStore {
+	return &MockAPIKeyStore{Keys: map[primitive.ObjectID]*models.APIKey{}}
+}
+
+// CreateAPIKey mocks the creation of an API key
+func (s *MockAPIKeyStore) CreateAPIKey(ctx context.Context, key models.APIKey) (primitive.ObjectID, error) {
+	key.Id = primitive.NewObjectID()
+	s.Keys[key.Id] = &key
+	return key.Id, nil
+}
+
+// FindAPIKey mocks the retrieval of an active API key by hash
+func (s *MockAPIKeyStore) FindAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	for _, key := range s.Keys {
+		if key.KeyHash == keyHash && key.RevokedAt == nil {
+			return key, nil
+		}
+	}
+	return nil, nil
+}
+
+// FindCompanyAPIKeys mocks the retrieval of the API keys of a company
+func (s *MockAPIKeyStore) FindCompanyAPIKeys(ctx context.Context, companyId primitive.ObjectID) ([]*models.APIKey, error) {
+	keys := []*models.APIKey{}
+	for _, key := range s.Keys {
+		if key.Company == companyId {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey mocks the revocation of an API key
+func (s *MockAPIKeyStore) RevokeAPIKey(ctx context.Context, companyId, id primitive.ObjectID) error {
+	key, found := s.Keys[id]
+	if !found || key.Company != companyId || key.RevokedAt != nil {
+		return mongo.ErrNoDocuments
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	return nil
+}
+
+// RotateAPIKey mocks the rotation of an API key
+func (s *MockAPIKeyStore) RotateAPIKey(ctx context.Context, companyId, id primitive.ObjectID, keyHash, hint string) (*models.APIKey, error) {
+	key, found := s.Keys[id]
+	if !found || key.Company != companyId || key.RevokedAt != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	now := time.Now()
+	key.KeyHash, key.Hint, key.RotatedAt = keyHash, hint, &now
+	return key, nil
+}
+
+// TouchAPIKey mocks the update of the last use of an API key
+func (s *MockAPIKeyStore) TouchAPIKey(ctx context.Context, id primitive.ObjectID) error {
+	if key, found := s.Keys[id]; found {
+		now := time.Now()
+		key.LastUsedAt = &now
+	}
+	return nil
+}
+
+func init() {
+	APIKeys = NewMockAPIKeyStore()
+}
+
+// apiKeyRequest performs a GET with the API key on the X-API-Key header and returns the status code
+func apiKeyRequest(router *gin.Engine, path, key string) int {
+	req, _ := http.NewRequest("GET", path, nil)
+	req.Header.Set("X-API-Key", key)
+	req.RemoteAddr = "10.0.0.5:1234"
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp.Code
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	router := gin.Default()
+	store := NewMockAPIKeyStore()
+	APIKeys = store
+	Sessions = memorySessions()
+
+	admin, mockDB := loginTestUser("Tr0ub4dor&3x")
+	mockDB.FindAllUsersFunc = func(ctx context.Context, companyId primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error) {
+		return []*models.UserWithCompanyAsObject{admin}, nil
+	}
+	DB = mockDB
+	ginContext, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginContext.Request = httptest.NewRequest("POST", "/", nil)
+	token, _, _ := createSession(context.Background(), ginContext, admin, "", sessionTTL)
+
+	// Set up the routes
+	apiKeys := router.Group("/companies/:companyId/api-keys", RequireSession(), RequireRole(models.RoleAdmin))
+	apiKeys.POST("", CreateAPIKey())
+	apiKeys.DELETE("/:keyId", RevokeAPIKey())
+	apiKeys.POST("/:keyId/rotate", RotateAPIKey())
+	router.GET("/users", RequireSessionOrAPIKey(models.APIKeyScopeUsersRead), RequireCompanyQuery(), GetUsers())
+
+	path := "/companies/" + admin.Company.Hex() + "/api-keys"
+	code, response := jsonRequest(router, path, token, models.CreateAPIKeyRequest{Name: "backend", Scopes: []string{models.APIKeyScopeUsersRead}})
+	assert.Equal(t, http.StatusCreated, code)
+	key := response.Data["key"].(string)
+	keyId := response.Data["apiKey"].(map[string]interface{})["_id"].(string)
+
+	// Only the hash of the key is stored
+	for _, stored := range store.Keys {
+		assert.NotEqual(t, key, stored.KeyHash)
+		assert.Equal(t, key[:apiKeyHintLength], stored.Hint)
+	}
+
+	users := "/users?company=" + admin.Company.Hex()
+	assert.Equal(t, http.StatusOK, apiKeyRequest(router, users, key))
+	assert.Equal(t, http.StatusUnauthorized, apiKeyRequest(router, users, key+"x"))
+
+	// Keys only work for their company
+	assert.Equal(t, http.StatusForbidden, apiKeyRequest(router, "/users?company="+primitive.NewObjectID().Hex(), key))
+	assert.Equal(t, http.StatusForbidden, apiKeyRequest(router, "/users?email="+admin.Email, key))
+
+	// After a rotation only the new key works
+	code, response = jsonRequest(router, path+"/"+keyId+"/rotate", token, nil)
+	assert.Equal(t, http.StatusOK, code)
+	rotatedKey := response.Data["key"].(string)
+	assert.Equal(t, http.StatusUnauthorized, apiKeyRequest(router, users, key))
+	assert.Equal(t, http.StatusOK, apiKeyRequest(router, users, rotatedKey))
+
+	req, _ := http.NewRequest("DELETE", path+"/"+keyId, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, http.StatusUnauthorized, apiKeyRequest(router, users, rotatedKey))
+}
+
+func TestAPIKeyScopesAndAllowedIPs(t *testing.T) {
+	router := gin.Default()
+	store := NewMockAPIKeyStore()
+	APIKeys = store
+	DB = &MockDB{}
+
+	companyId := primitive.NewObjectID()
+	key, keyHash, _ := generateAPIKey()
+	id, _ := store.CreateAPIKey(context.Background(), models.APIKey{Company: companyId, KeyHash: keyHash, Scopes: []string{models.APIKeyScopeUsersRead}, AllowedIPs: []string{"192.168.0.0/16"}})
+
+	// Set up the route
+	router.GET("/users", RequireSessionOrAPIKey(models.APIKeyScopeUsersRead), RequireCompanyQuery(), GetUsers())
+	users := "/users?company=" + companyId.Hex()
+
+	// The request comes from 10.0.0.5
+	assert.Equal(t, http.StatusForbidden, apiKeyRequest(router, users, key))
+
+	store.Keys[id].AllowedIPs = []string{"192.168.0.0/16", "10.0.0.5"}
+	assert.Equal(t, http.StatusOK, apiKeyRequest(router, users, key))
+	assert.NotNil(t, store.Keys[id].LastUsedAt)
+
+	store.Keys[id].Scopes = []string{"users:write"}
+	assert.Equal(t, http.StatusForbidden, apiKeyRequest(router, users, key))
+}
+
+func TestCreateAPIKeyOtherCompany(t *testing.T) {
+	router := gin.Default()
+	APIKeys = NewMockAPIKeyStore()
+	Sessions = memorySessions()
+
+	admin, mockDB := loginTestUser("Tr0ub4dor&3x")
+	DB = mockDB
+	ginContext, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginContext.Request = httptest.NewRequest("POST", "/", nil)
+	token, _, _ := createSession(context.Background(), ginContext, admin, "", sessionTTL)
+
+	// Set up the route
+	router.POST("/companies/:companyId/api-keys", RequireSession(), RequireRole(models.RoleAdmin), CreateAPIKey())
+
+	code, _ := jsonRequest(router, "/companies/"+primitive.NewObjectID().Hex()+"/api-keys", token, models.CreateAPIKeyRequest{Name: "backend", Scopes: []string{models.APIKeyScopeUsersRead}})
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _ = jsonRequest(router, "/companies/"+admin.Company.Hex()+"/api-keys", token, models.CreateAPIKeyRequest{Name: "backend", Scopes: []string{"users:delete"}})
+	assert.Equal(t, http.StatusBadRequest, code)
+}
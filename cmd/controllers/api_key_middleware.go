@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const currentAPIKeyKey = "currentAPIKey"
+
+// RequireSessionOrAPIKey lets through requests with a full session or with an API key that has the scope.
+// Keys are sent on the X-API-Key header or as a bearer token and are only accepted from their allowed IPs.
+func RequireSessionOrAPIKey(scope string) gin.HandlerFunc {
+	requireSession := RequireSession()
+	return func(c *gin.Context) {
+		token := c.GetHeader("X-API-Key")
+		if token == "" {
+			token = bearerToken(c)
+		}
+		if !strings.HasPrefix(token, models.APIKeyPrefix) {
+			requireSession(c)
+			return
+		}
+
+		apiKey, status, message := authenticateAPIKey(c.ClientIP(), token, scope)
+		if apiKey == nil {
+			c.AbortWithStatusJSON(status, responses.UserResponse{Status: status, Message: message, Data: nil})
+			return
+		}
+		c.Set(currentAPIKeyKey, apiKey)
+		c.Next()
+	}
+}
+
+// authenticateAPIKey returns the API key of the token when it can be used for the scope from the IP of the caller,
+// or the status and message of why it can not
+func authenticateAPIKey(ip, token, scope string) (*models.APIKey, int, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	apiKey, err := APIKeys.FindAPIKey(ctx, auth.HashToken(token))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting API key from database")
+		return nil, http.StatusInternalServerError, "Error getting API key from database"
+	}
+	if apiKey == nil {
+		log.Error().Msg("Invalid or revoked API key")
+		return nil, http.StatusUnauthorized, "Invalid or revoked API key"
+	}
+	if !ipAllowed(ip, apiKey.AllowedIPs) || !apiKey.HasScope(scope) {
+		log.Error().Msg("API key: " + apiKey.Id.Hex() + " is not allowed to " + scope + " from " + ip)
+		return nil, http.StatusForbidden, "You are not allowed to perform this action"
+	}
+
+	if err := APIKeys.TouchAPIKey(ctx, apiKey.Id); err != nil {
+		log.Error().Err(err).Msg("Error updating API key last use")
+	}
+	return apiKey, http.StatusOK, ""
+}
+
+// RequireCompanyQuery must run after RequireSessionOrAPIKey, the company query parameter has to be
+// the one of the user or the API key. API keys can only be used for their own company
+func RequireCompanyQuery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		company := c.Query("company")
+		if apiKey := CurrentAPIKey(c); apiKey != nil {
+			if company != apiKey.Company.Hex() {
+				log.Error().Msg("API key: " + apiKey.Id.Hex() + " tried to access company: " + company)
+				c.AbortWithStatusJSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "You are not allowed to perform this action", Data: nil})
+				return
+			}
+		} else if user := CurrentUser(c); user != nil && company != "" && company != user.Company.Hex() {
+			log.Error().Msg("User: " + user.Id.Hex() + " tried to access company: " + company)
+			c.AbortWithStatusJSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "You are not allowed to perform this action", Data: nil})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAdminOrAPIKey must run after RequireSessionOrAPIKey, sessions have to be of an admin while API keys
+// already passed the check of their scope
+func RequireAdminOrAPIKey() gin.HandlerFunc {
+	requireAdmin := RequireRole(models.RoleAdmin)
+	return func(c *gin.Context) {
+		if CurrentAPIKey(c) != nil {
+			c.Next()
+			return
+		}
+		requireAdmin(c)
+	}
+}
+
+// CurrentAPIKey returns the API key authenticated by RequireSessionOrAPIKey, nil for sessions
+func CurrentAPIKey(c *gin.Context) *models.APIKey {
+	if apiKey, ok := c.Get(currentAPIKeyKey); ok {
+		return apiKey.(*models.APIKey)
+	}
+	return nil
+}
+
+// ipAllowed reports whether the ip is on the allowlist of addresses and CIDR ranges, an empty list allows every ip
+func ipAllowed(ip string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, entry := range allowed {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(parsed) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(entry); allowedIP != nil && allowedIP.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
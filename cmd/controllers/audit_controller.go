@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/export"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	auditDefaultLimit = 50
+	auditMaxLimit     = 200
+)
+
+// auditExportColumns are the columns of an audit log export, changes and metadata are written as JSON
+var auditExportColumns = []string{"createdAt", "action", "actor", "apiKey", "target", "company", "requestId", "ip", "changes", "metadata"}
+
+// GetAuditEvents returns a page of the audit log of the company of the admin, newest first
+func GetAuditEvents() gin.HandlerFunc {
+	log.Info().Msg("Get audit events endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		filter, ok := auditFilter(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+		page, limit, err := auditPage(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error validating audit pagination")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		events, total, err := Audit.FindEvents(ctx, filter, page, limit)
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting audit events from database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error getting audit events from database", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"events": events, "page": page, "limit": limit, "total": total}})
+	}
+}
+
+// ExportAuditEvents streams every event of the audit log matching the filters as CSV, NDJSON or XLSX
+func ExportAuditEvents() gin.HandlerFunc {
+	log.Info().Msg("Export audit events endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+
+		filter, ok := auditFilter(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+		format := strings.ToLower(c.DefaultQuery("format", export.FormatCSV))
+		contentType, found := export.ContentTypes[format]
+		if !found {
+			log.Error().Msg("Unsupported export format: " + format)
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "The format must be csv, ndjson or xlsx", Data: nil})
+			return
+		}
+
+		filename := fmt.Sprintf("audit-%s-%s.%s", filter.Company.Hex(), time.Now().UTC().Format("20060102"), format)
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Status(http.StatusOK)
+
+		writer, err := export.NewWriter(format, c.Writer, auditExportColumns)
+		if err == nil {
+			rows := 0
+			err = Audit.StreamEvents(ctx, filter, func(event *models.AuditEvent) error {
+				rows++
+				if rows%exportFlushRows == 0 {
+					c.Writer.Flush()
+				}
+				return writer.WriteRow(auditEventValues(event, format))
+			})
+			if closeErr := writer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Error exporting audit events of company: " + filter.Company.Hex())
+		}
+	}
+}
+
+// auditFilter reads the filters of the query, answering 400 or 403 when they are not valid.
+// Admins can only read the events of their company, which is the default one
+func auditFilter(c *gin.Context, admin *models.UserWithCompanyAsObject) (models.AuditFilter, bool) {
+	filter := models.AuditFilter{Company: &admin.Company, Action: c.Query("action")}
+	if company := c.Query("company"); company != "" && company != admin.Company.Hex() {
+		log.Error().Msg("Admin: " + admin.Id.Hex() + " tried to read the audit log of company: " + company)
+		c.JSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "You are not allowed to perform this action", Data: nil})
+		return filter, false
+	}
+
+	var err error
+	if filter.Actor, err = optionalObjectID(c.Query("actor")); err != nil {
+		return filter, auditFilterError(c, "actor", err)
+	}
+	if filter.Target, err = optionalObjectID(c.Query("target")); err != nil {
+		return filter, auditFilterError(c, "target", err)
+	}
+	if filter.From, err = optionalTime(c.Query("from")); err != nil {
+		return filter, auditFilterError(c, "from", err)
+	}
+	if filter.To, err = optionalTime(c.Query("to")); err != nil {
+		return filter, auditFilterError(c, "to", err)
+	}
+	return filter, true
+}
+
+func auditFilterError(c *gin.Context, parameter string, err error) bool {
+	log.Error().Err(err).Msg("Invalid audit filter: " + parameter)
+	c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": "invalid " + parameter + ": " + err.Error()}})
+	return false
+}
+
+// auditPage reads the page, starting at 1, and the amount of events per page
+func auditPage(c *gin.Context) (int, int, error) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		return 0, 0, fmt.Errorf("page must be a positive number")
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(auditDefaultLimit)))
+	if err != nil || limit < 1 || limit > auditMaxLimit {
+		return 0, 0, fmt.Errorf("limit must be between 1 and %d", auditMaxLimit)
+	}
+	return page, limit, nil
+}
+
+func optionalObjectID(value string) (*primitive.ObjectID, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// optionalTime reads an RFC 3339 time
+func optionalTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// auditEventValues returns the values of the export columns, nested values are JSON encoded for the formats without them
+func auditEventValues(event *models.AuditEvent, format string) []interface{} {
+	hex := func(id *primitive.ObjectID) interface{} {
+		if id == nil {
+			return nil
+		}
+		return id.Hex()
+	}
+	nested := func(value interface{}) interface{} {
+		if reflectEmpty(value) {
+			return nil
+		}
+		if format == export.FormatNDJSON {
+			return value
+		}
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}
+	return []interface{}{
+		event.CreatedAt, event.Action, hex(event.Actor), hex(event.APIKey), hex(event.Target), hex(event.Company),
+		event.RequestId, event.IP, nested(event.Changes), nested(event.Metadata),
+	}
+}
+
+func reflectEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]models.AuditChange:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return value == nil
+}
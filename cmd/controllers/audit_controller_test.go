@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user-service/cmd/responses"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// authRequest performs a request with the session token and an optional JSON body
+func authRequest(router *gin.Engine, method, path, token string, payload interface{}) (*httptest.ResponseRecorder, responses.UserResponse) {
+	return headerRequest(router, method, path, token, nil, payload)
+}
+
+// patchUser updates a user with If-Match: *, whatever its current version
+func patchUser(router *gin.Engine, path, token string, payload interface{}) (*httptest.ResponseRecorder, responses.UserResponse) {
+	return headerRequest(router, "PATCH", path, token, map[string]string{"If-Match": "*"}, payload)
+}
+
+// headerRequest performs an authRequest with extra headers
+func headerRequest(router *gin.Engine, method, path, token string, headers map[string]string, payload interface{}) (*httptest.ResponseRecorder, responses.UserResponse) {
+	var body bytes.Buffer
+	if payload != nil {
+		json.NewEncoder(&body).Encode(payload)
+	}
+	req, _ := http.NewRequest(method, path, &body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Request-ID", "test-request")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	var response responses.UserResponse
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	return resp, response
+}
+
+// auditedUsers returns an admin, another user of the same company and an audited database that keeps both in memory
+func auditedUsers(audit configs.AuditLog) (*models.UserWithCompanyAsObject, *models.UserWithCompanyAsObject, *MockDB) {
+	admin, mockDB := loginTestUser("Tr0ub4dor&3x")
+	other := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Jane Smith", Email: "jane@example.com", Password: "$argon2id$hash", Role: "user", Company: admin.Company}
+	users := map[primitive.ObjectID]*models.UserWithCompanyAsObject{admin.Id: admin, other.Id: other}
+
+	mockDB.FindUserByIDFunc = func(ctx context.Context, id primitive.ObjectID) (*models.UserWithCompanyAsObject, error) {
+		if user, found := users[id]; found {
+			copied := *user
+			return &copied, nil
+		}
+		return nil, nil
+	}
+	mockDB.UpdateUserFunc = func(ctx context.Context, id primitive.ObjectID, version int64, update models.UserUpdate) (*models.UserWithCompanyAsObject, error) {
+		user := users[id]
+		if user.Version != version {
+			return nil, configs.ErrVersionConflict
+		}
+		user.Version++
+		if update.Name != nil {
+			user.Name = *update.Name
+		}
+		if update.Role != nil {
+			user.Role = *update.Role
+		}
+		copied := *user
+		return &copied, nil
+	}
+	mockDB.DeleteUserFunc = func(ctx context.Context, id primitive.ObjectID) error {
+		delete(users, id)
+		return nil
+	}
+	mockDB.UpdateUserPasswordFunc = func(ctx context.Context, id primitive.ObjectID, passwordHash string) error {
+		users[id].Password = passwordHash
+		return nil
+	}
+	return admin, other, mockDB
+}
+
+func auditTestRouter() *gin.Engine {
+	router := gin.Default()
+	router.Use(RequestID())
+	router.PATCH("/users/:userId", RequireSession(), UpdateUser())
+	router.DELETE("/users/:userId", RequireSession(), RequireRole(models.RoleAdmin), DeleteUser())
+	router.GET("/audit", RequireSession(), RequireRole(models.RoleAdmin), GetAuditEvents())
+	router.GET("/audit/export", RequireSession(), RequireRole(models.RoleAdmin), ExportAuditEvents())
+	return router
+}
+
+func TestAuditedRoleChange(t *testing.T) {
+	Sessions = memorySessions()
+	audit := &MockAuditLog{}
+	Audit = audit
+	admin, other, mockDB := auditedUsers(audit)
+	DB = configs.NewAuditedDatabase(mockDB, audit)
+	token := testSessionToken(admin)
+	router := auditTestRouter()
+
+	resp, response := patchUser(router, "/users/"+other.Id.Hex(), token, map[string]string{"role": "admin"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "test-request", resp.Header().Get("X-Request-ID"))
+	assert.NotContains(t, resp.Body.String(), other.Password)
+	assert.Equal(t, "admin", response.Data["user"].(map[string]interface{})["role"])
+
+	event := audit.Events[len(audit.Events)-1]
+	assert.Equal(t, models.AuditActionUserRoleChanged, event.Action)
+	assert.Equal(t, admin.Id, *event.Actor)
+	assert.Equal(t, other.Id, *event.Target)
+	assert.Equal(t, admin.Company, *event.Company)
+	assert.Equal(t, "test-request", event.RequestId)
+	assert.Equal(t, map[string]models.AuditChange{"role": {Before: "user", After: "admin"}}, event.Changes)
+}
+
+func TestAuditedPasswordChangeIsRedacted(t *testing.T) {
+	audit := &MockAuditLog{}
+	_, other, mockDB := auditedUsers(audit)
+	db := configs.NewAuditedDatabase(mockDB, audit)
+	actor := primitive.NewObjectID()
+	ctx := configs.WithAuditInfo(context.Background(), configs.AuditInfo{Actor: &actor, RequestId: "reset-request", IP: "10.0.0.1"})
+
+	assert.NoError(t, db.UpdateUserPassword(ctx, other.Id, "$argon2id$new"))
+	assert.Len(t, audit.Events, 1)
+	event := audit.Events[0]
+	assert.Equal(t, models.AuditActionPasswordChanged, event.Action)
+	assert.Equal(t, actor, *event.Actor)
+	assert.Equal(t, "10.0.0.1", event.IP)
+	assert.Equal(t, models.AuditChange{Before: models.AuditRedacted, After: models.AuditRedacted}, event.Changes["password"])
+	assert.False(t, event.CreatedAt.IsZero())
+}
+
+func TestAuditedCreateUser(t *testing.T) {
+	audit := &MockAuditLog{}
+	id := primitive.NewObjectID()
+	mockDB := &MockDB{CreateUserFunc: func(ctx context.Context, user models.UserWithCompanyAsObject) (primitive.ObjectID, error) {
+		return id, nil
+	}}
+	db := configs.NewAuditedDatabase(mockDB, audit)
+
+	user := models.UserWithCompanyAsObject{Name: "Jane Smith", Email: "jane@example.com", Password: "$argon2id$hash", Role: "user", Company: primitive.NewObjectID()}
+	_, err := db.CreateUser(context.Background(), user)
+	assert.NoError(t, err)
+
+	event := audit.Events[0]
+	assert.Equal(t, models.AuditActionUserCreated, event.Action)
+	assert.Equal(t, id, *event.Target)
+	assert.Equal(t, models.AuditChange{After: "jane@example.com"}, event.Changes["email"])
+	assert.Equal(t, models.AuditChange{After: models.AuditRedacted}, event.Changes["password"])
+}
+
+func TestAuditedChangeFailsWithoutEvent(t *testing.T) {
+	audit := &MockAuditLog{Err: errors.New("audit log unavailable")}
+	_, other, mockDB := auditedUsers(audit)
+	db := configs.NewAuditedDatabase(mockDB, audit)
+
+	// The change is answered as failed when its event can not be stored
+	assert.Error(t, db.UpdateUserPassword(context.Background(), other.Id, "$argon2id$new"))
+	ids, err := db.CreateUsers(context.Background(), []models.UserWithCompanyAsObject{{Name: "Jane Smith", Email: "jane@example.com", Role: "user", Company: other.Company}})
+	assert.Error(t, err)
+	assert.True(t, ids[0].IsZero())
+}
+
+func TestGetAuditEvents(t *testing.T) {
+	Sessions = memorySessions()
+	audit := &MockAuditLog{}
+	Audit = audit
+	admin, other, mockDB := auditedUsers(audit)
+	DB = configs.NewAuditedDatabase(mockDB, audit)
+	router := auditTestRouter()
+	token := testSessionToken(admin)
+
+	for _, name := range []string{"Jane A", "Jane B", "Jane C"} {
+		resp, _ := patchUser(router, "/users/"+other.Id.Hex(), token, map[string]string{"name": name})
+		assert.Equal(t, http.StatusOK, resp.Code)
+	}
+	// Events of other companies are never returned
+	audit.RecordEvent(context.Background(), models.AuditEvent{Action: models.AuditActionUserUpdated, Target: &other.Id, Company: &primitive.NilObjectID})
+
+	resp, response := authRequest(router, "GET", "/audit?action=user_updated&target="+other.Id.Hex()+"&limit=2&page=1", token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, float64(3), response.Data["total"])
+	events := response.Data["events"].([]interface{})
+	assert.Len(t, events, 2)
+	assert.Equal(t, "Jane C", events[0].(map[string]interface{})["changes"].(map[string]interface{})["name"].(map[string]interface{})["after"])
+
+	_, response = authRequest(router, "GET", "/audit?action=user_updated&limit=2&page=2", token, nil)
+	assert.Len(t, response.Data["events"].([]interface{}), 1)
+
+	resp, _ = authRequest(router, "GET", "/audit?company="+primitive.NewObjectID().Hex(), token, nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp, _ = authRequest(router, "GET", "/audit?actor=invalid", token, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp, _ = authRequest(router, "GET", "/audit?limit=1000", token, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp, _ = authRequest(router, "GET", "/audit", testSessionToken(other), nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp, _ = authRequest(router, "GET", "/audit/export?format=ndjson&action=user_updated", token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, 3, bytes.Count(resp.Body.Bytes(), []byte("\n")))
+	assert.Contains(t, resp.Body.String(), `"requestId":"test-request"`)
+}
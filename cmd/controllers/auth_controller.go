@@ -0,0 +1,589 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+	"user-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// passwordResetTokenTTL is how long a password reset link can be used
+	passwordResetTokenTTL = time.Hour
+	// sessionTTL is how long a login lasts
+	sessionTTL = 12 * time.Hour
+	// mfaChallengeTTL is how long the user has to send the MFA code or enroll after the password
+	mfaChallengeTTL = 5 * time.Minute
+)
+
+var (
+	// dummyPasswordHash is compared when the email does not exist, so both cases take the same time
+	dummyPasswordHash string
+
+	ResetTokens      configs.PasswordResetStore
+	Sessions         configs.SessionStore
+	LoginAttempts    configs.LoginAttemptStore
+	Audit            configs.AuditLog
+	NotificationsURL string
+	PasswordResetURL string
+)
+
+func init() {
+	if hasher := configs.EnvPasswordHasher(); hasher != "" {
+		if err := auth.SetDefaultHasher(hasher); err != nil {
+			log.Error().Err(err).Msg("Error setting the password hasher, using the default one")
+		}
+	}
+	dummyPasswordHash, _ = auth.HashPassword("dummy password")
+	ResetTokens = configs.NewMongoPasswordResetStore(configs.DB)
+	Sessions = configs.NewMongoSessionStore(configs.DB)
+	if configs.EnvLoginAttemptsStore() == "memory" {
+		LoginAttempts = configs.NewMemoryLoginAttemptStore()
+	} else {
+		LoginAttempts = configs.NewMongoLoginAttemptStore(configs.DB)
+	}
+	NotificationsURL = configs.EnvNotificationsURL()
+	PasswordResetURL = configs.EnvPasswordResetURL()
+}
+
+func Login() gin.HandlerFunc {
+	log.Info().Msg("Login endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		var request models.LoginRequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		if err := validate.Struct(&request); err != nil {
+			log.Error().Err(err).Msg("Error validating request")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		// Failures are counted by email even when it is not registered, so lockouts do not reveal which accounts exist
+		accountKey := accountLoginKey(request.Email)
+		ipKey := "ip:" + c.ClientIP()
+		if wait := loginRetryAfter(ctx, accountKey, ipKey); wait > 0 {
+			log.Error().Msg("Login rejected, too many failed attempts")
+			c.Header("Retry-After", retryAfterSeconds(wait))
+			c.JSON(http.StatusTooManyRequests, responses.UserResponse{Status: http.StatusTooManyRequests, Message: "Too many failed login attempts, try again later", Data: nil})
+			return
+		}
+
+		// The companies of the user are only known once it is found, they all share the credentials of the email
+		users, err := DB.FindCredentialsByEmail(configs.WithSystemScope(ctx), request.Email)
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting a user from database with email: " + request.Email)
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error logging in", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		users = acceptedMemberships(users)
+		var user *models.UserWithCompanyAsObject
+		if len(users) > 0 {
+			user = users[0]
+		}
+
+		// Users without a password hash, like the ones whose plain text password was removed, are compared with the
+		// dummy hash too, so they take the same time and fail like an unknown email
+		passwordHash := dummyPasswordHash
+		if user != nil && auth.IsPasswordHash(user.Password) {
+			passwordHash = user.Password
+		}
+		passwordMatches := auth.CheckPassword(passwordHash, request.Password)
+
+		if !passwordMatches || user == nil {
+			recordLoginFailure(ctx, c, user, accountKey, ipKey)
+			c.JSON(http.StatusUnauthorized, responses.UserResponse{Status: http.StatusUnauthorized, Message: "Invalid email or password", Data: nil})
+			return
+		}
+
+		// Users flagged to reset their password can only use the forgot password flow. They are only told once the
+		// password matched, so the flag does not reveal which emails are registered
+		if user.PasswordResetRequired {
+			log.Error().Msg("User: " + user.Id.Hex() + " has to reset the password")
+			c.JSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "Password reset required", Data: map[string]interface{}{"passwordResetRequired": true}})
+			return
+		}
+
+		// The companies are only told once the password matched, and the session is for one of them
+		user = loginMembership(users, request.Company)
+		if user == nil && request.Company != "" {
+			log.Error().Msg("User is not a member of company: " + request.Company)
+			c.JSON(http.StatusUnauthorized, responses.UserResponse{Status: http.StatusUnauthorized, Message: "Invalid email or password", Data: nil})
+			return
+		}
+		if user == nil {
+			companies := make([]string, 0, len(users))
+			for _, member := range users {
+				companies = append(companies, member.Company.Hex())
+			}
+			log.Error().Msg("Login without company for a user of several companies")
+			c.JSON(http.StatusConflict, responses.UserResponse{Status: http.StatusConflict, Message: "The user belongs to several companies, the company is required", Data: map[string]interface{}{"companies": companies}})
+			return
+		}
+
+		// Users deactivated by the identity provider of their company can not log in until it activates them again
+		if !user.IsActive() {
+			log.Error().Msg("User: " + user.Id.Hex() + " is deactivated")
+			c.JSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "User is deactivated", Data: nil})
+			return
+		}
+
+		ctx = withAuthenticatedUser(ctx, user)
+		if err := LoginAttempts.ResetLoginAttempts(ctx, accountKey); err != nil {
+			log.Error().Err(err).Msg("Error resetting login attempts")
+		}
+		rehashPassword(ctx, user, request.Password)
+
+		// With MFA the password only gets a short lived token to send the code
+		if user.MFAEnabled() {
+			token, session, err := createSession(ctx, c, user, models.SessionScopeMFAChallenge, mfaChallengeTTL)
+			if err != nil {
+				log.Error().Err(err).Msg("Error storing session on database")
+				c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error logging in", Data: map[string]interface{}{"data": err.Error()}})
+				return
+			}
+			log.Info().Msg("User: " + user.Id.Hex() + " has to send an MFA code")
+			c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "mfa required", Data: map[string]interface{}{"mfaRequired": true, "mfaToken": token, "expiresAt": session.ExpiresAt}})
+			return
+		}
+
+		// Users of companies that require MFA can only enroll until they do
+		required, err := companyRequiresMFA(ctx, user.Company)
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting company settings from database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error logging in", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		if required {
+			token, session, err := createSession(ctx, c, user, models.SessionScopeMFAEnrollment, mfaChallengeTTL)
+			if err != nil {
+				log.Error().Err(err).Msg("Error storing session on database")
+				c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error logging in", Data: map[string]interface{}{"data": err.Error()}})
+				return
+			}
+			log.Info().Msg("User: " + user.Id.Hex() + " has to enroll MFA")
+			c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "mfa enrollment required", Data: map[string]interface{}{"mfaEnrollmentRequired": true, "token": token, "expiresAt": session.ExpiresAt}})
+			return
+		}
+
+		token, session, err := createSession(ctx, c, user, "", sessionTTL)
+		if err != nil {
+			log.Error().Err(err).Msg("Error storing session on database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error logging in", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		log.Info().Msg("User: " + user.Id.Hex() + " logged in successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"token": token, "expiresAt": session.ExpiresAt}})
+	}
+}
+
+// acceptedMemberships returns the users whose invitation is not pending, the other ones can not log in yet
+func acceptedMemberships(users []*models.UserWithCompanyAsObject) []*models.UserWithCompanyAsObject {
+	accepted := make([]*models.UserWithCompanyAsObject, 0, len(users))
+	for _, user := range users {
+		if !user.InvitationPending {
+			accepted = append(accepted, user)
+		}
+	}
+	return accepted
+}
+
+// loginMembership returns the user of the company among the users of an email, or the only one without a company.
+// It returns nil when the company has none or when the user is in several companies and none is given
+func loginMembership(users []*models.UserWithCompanyAsObject, company string) *models.UserWithCompanyAsObject {
+	if company == "" {
+		if len(users) == 1 {
+			return users[0]
+		}
+		return nil
+	}
+	for _, user := range users {
+		if user.Company.Hex() == company {
+			return user
+		}
+	}
+	return nil
+}
+
+// rehashPassword replaces a hash made with an outdated algorithm or cost now that the password is known,
+// errors are only logged since the login already succeeded
+func rehashPassword(ctx context.Context, user *models.UserWithCompanyAsObject, password string) {
+	if !auth.NeedsRehash(user.Password) {
+		return
+	}
+	passwordHash, err := auth.HashPassword(password)
+	if err != nil {
+		log.Error().Err(err).Msg("Error rehashing password")
+		return
+	}
+	if err := DB.UpdateUserPasswordHash(ctx, user.Id, user.Password, passwordHash); err != nil {
+		log.Error().Err(err).Msg("Error updating password hash on database")
+		return
+	}
+	log.Info().Msg("User: " + user.Id.Hex() + " password rehashed")
+}
+
+// createSession stores a new session for the user and returns its token, which is only known by the client
+func createSession(ctx context.Context, c *gin.Context, user *models.UserWithCompanyAsObject, scope string, ttl time.Duration) (string, models.Session, error) {
+	token, err := auth.GenerateToken()
+	if err != nil {
+		return "", models.Session{}, err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		UserId:    user.Id,
+		Company:   user.Company,
+		TokenHash: auth.HashToken(token),
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Scope:     scope,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	id, err := Sessions.CreateSession(ctx, session)
+	if err != nil {
+		return "", models.Session{}, err
+	}
+	session.Id = id
+
+	// Only full sessions are logins, the scoped ones are steps towards it
+	if scope == "" {
+		recordAuditEvent(ctx, models.AuditEvent{
+			Action:   models.AuditActionLogin,
+			Actor:    &user.Id,
+			Target:   &user.Id,
+			Company:  &user.Company,
+			Metadata: map[string]interface{}{"sessionId": id},
+		})
+	}
+	return token, session, nil
+}
+
+func Logout() gin.HandlerFunc {
+	log.Info().Msg("Logout endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+
+		session := CurrentSession(c)
+		if err := Sessions.DeleteSession(ctx, session.Id); err != nil {
+			log.Error().Err(err).Msg("Error deleting session from database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error logging out", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		log.Info().Msg("User: " + session.UserId.Hex() + " logged out successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: nil})
+	}
+}
+
+// UnlockUser lets an admin clear the failed logins of a user of its company
+func UnlockUser() gin.HandlerFunc {
+	log.Info().Msg("Unlock user endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		userId := c.Param("userId")
+		admin := CurrentUser(c)
+
+		objId, _ := primitive.ObjectIDFromHex(userId)
+		user, err := DB.FindUserByID(ctx, objId)
+		if err != nil || user == nil || user.Company != admin.Company {
+			log.Error().Err(err).Msg("Error getting user to unlock: " + userId)
+			c.JSON(http.StatusNotFound, responses.UserResponse{Status: http.StatusNotFound, Message: "User not found", Data: nil})
+			return
+		}
+
+		if err := LoginAttempts.ResetLoginAttempts(ctx, accountLoginKey(user.Email)); err != nil {
+			log.Error().Err(err).Msg("Error resetting login attempts")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error unlocking user", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		recordAuditEvent(ctx, models.AuditEvent{
+			Action:  models.AuditActionLoginUnlocked,
+			Actor:   &admin.Id,
+			Target:  &user.Id,
+			Company: &user.Company,
+			IP:      c.ClientIP(),
+		})
+
+		log.Info().Msg("User: " + userId + " unlocked successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: nil})
+	}
+}
+
+// retryAfterSeconds formats a wait for the Retry-After header, rounding up so clients do not retry too early
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int((wait + time.Second - 1) / time.Second))
+}
+
+func accountLoginKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginRetryAfter returns how long the account or the IP has to wait before logging in again
+func loginRetryAfter(ctx context.Context, accountKey, ipKey string) time.Duration {
+	now := time.Now()
+	var wait time.Duration
+	for key, policy := range map[string]auth.LockoutPolicy{accountKey: auth.AccountLockoutPolicy, ipKey: auth.IPLockoutPolicy} {
+		attempts, err := LoginAttempts.FindLoginAttempts(ctx, key)
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting login attempts of: " + key)
+			continue
+		}
+		if keyWait := policy.RetryAfter(attempts, now); keyWait > wait {
+			wait = keyWait
+		}
+	}
+	return wait
+}
+
+// recordLoginFailure counts the failure for the account and the IP, locking them when they reach their limit
+func recordLoginFailure(ctx context.Context, c *gin.Context, user *models.UserWithCompanyAsObject, accountKey, ipKey string) {
+	now := time.Now()
+	for key, policy := range map[string]auth.LockoutPolicy{accountKey: auth.AccountLockoutPolicy, ipKey: auth.IPLockoutPolicy} {
+		attempts, err := LoginAttempts.RecordLoginFailure(ctx, key, policy.Window)
+		if err != nil {
+			log.Error().Err(err).Msg("Error recording failed login of: " + key)
+			continue
+		}
+		if !policy.ShouldLock(attempts, now) {
+			continue
+		}
+
+		lockedUntil := now.Add(policy.LockoutDuration)
+		if err := LoginAttempts.LockLogin(ctx, key, lockedUntil); err != nil {
+			log.Error().Err(err).Msg("Error locking login of: " + key)
+			continue
+		}
+
+		log.Info().Msg("Login locked for: " + key)
+		event := models.AuditEvent{
+			Action:   models.AuditActionLoginLocked,
+			IP:       c.ClientIP(),
+			Metadata: map[string]interface{}{"key": key, "failures": attempts.Failures, "lockedUntil": lockedUntil},
+		}
+		if user != nil && key == accountKey {
+			event.Target = &user.Id
+			event.Company = &user.Company
+		}
+		recordAuditEvent(ctx, event)
+	}
+}
+
+// recordAuditEvent appends an event that is not a change to a user, those are recorded by the audited database
+func recordAuditEvent(ctx context.Context, event models.AuditEvent) {
+	event = configs.WithRequestInfo(ctx, event)
+	if err := Audit.RecordEvent(ctx, event); err != nil {
+		log.Error().Err(err).Msg("Error recording audit event: " + event.Action)
+	}
+}
+
+// ForgotPassword always answers 202 so the endpoint can not be used to find out which emails are registered
+func ForgotPassword() gin.HandlerFunc {
+	log.Info().Msg("Forgot password endpoint reached")
+	return func(c *gin.Context) {
+		var request models.ForgotPasswordRequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		if err := validate.Struct(&request); err != nil {
+			log.Error().Err(err).Msg("Error validating request")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		// The link is sent in the background, so the answer takes the same time whether or not the email is registered
+		info := configs.AuditInfoFromContext(auditContext(c))
+		go func() {
+			ctx, cancel := context.WithTimeout(configs.WithAuditInfo(context.Background(), info), 10*time.Second)
+			defer cancel()
+			sendPasswordResetLink(ctx, request.Email)
+		}()
+
+		c.JSON(http.StatusAccepted, responses.UserResponse{
+			Status:  http.StatusAccepted,
+			Message: "If the email is registered a password reset link will be sent to it",
+			Data:    nil,
+		})
+	}
+}
+
+// sendPasswordResetLink stores a new password reset token of the email and sends its link, nothing is sent to
+// unknown emails. Errors are only logged since the request was already answered
+func sendPasswordResetLink(ctx context.Context, email string) {
+	// The password is shared by every company of the email, the link resets it for all of them
+	users, err := DB.FindUsersByEmail(configs.WithSystemScope(ctx), email)
+	if err != nil || len(users) == 0 {
+		log.Info().Msg("Password reset requested for an unknown email")
+		return
+	}
+	user := users[0]
+
+	token, err := auth.GenerateToken()
+	if err != nil {
+		log.Error().Err(err).Msg("Error generating password reset token")
+		return
+	}
+
+	// Only one link can be valid at a time
+	if err := ResetTokens.DeleteUserTokens(ctx, user.Id); err != nil {
+		log.Error().Err(err).Msg("Error deleting previous password reset tokens")
+	}
+
+	now := time.Now()
+	resetToken := models.PasswordResetToken{
+		UserId:    user.Id,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: now.Add(passwordResetTokenTTL),
+		CreatedAt: now,
+	}
+	if err := ResetTokens.CreateToken(ctx, resetToken); err != nil {
+		log.Error().Err(err).Msg("Error storing password reset token")
+		return
+	}
+
+	if err := sendPasswordResetEmail(ctx, user, token, resetToken.ExpiresAt); err != nil {
+		log.Error().Err(err).Msg("Error sending password reset email")
+	}
+	log.Info().Msg("Password reset token created for user: " + user.Id.Hex())
+}
+
+func ResetPassword() gin.HandlerFunc {
+	log.Info().Msg("Reset password endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		var request models.ResetPasswordRequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		resetToken, err := ResetTokens.FindToken(ctx, auth.HashToken(request.Token))
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting password reset token from database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error resetting password", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		if resetToken == nil {
+			log.Error().Msg("Invalid or expired password reset token")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "Invalid or expired password reset token", Data: nil})
+			return
+		}
+
+		user, err := DB.FindUserByID(configs.WithSystemScope(ctx), resetToken.UserId)
+		if err != nil || user == nil {
+			log.Error().Err(err).Msg("Error getting the user of a password reset token")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "Invalid or expired password reset token", Data: nil})
+			return
+		}
+		ctx = withAuthenticatedUser(ctx, user)
+
+		// The token is only used once the new password is valid, so a rejected password does not burn the link
+		policyCtx := userService().PasswordPolicyContext(ctx, user.Company.Hex(), user.Email, user.Name)
+		if err := validate.StructCtx(policyCtx, &request); err != nil {
+			err = services.WithPasswordReasons(policyCtx, err, request.Password)
+			log.Error().Err(err).Msg("Error validating request")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: validationErrorData(err)})
+			return
+		}
+
+		consumed, err := ResetTokens.ConsumeToken(ctx, resetToken.TokenHash)
+		if err != nil {
+			log.Error().Err(err).Msg("Error using password reset token")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error resetting password", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		if consumed == nil {
+			log.Error().Msg("Password reset token was already used")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "Invalid or expired password reset token", Data: nil})
+			return
+		}
+
+		passwordHash, err := auth.HashPassword(request.Password)
+		if err != nil {
+			log.Error().Err(err).Msg("Error hashing password")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error resetting password", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		// Updating the password also sets passwordChangedAt, which revokes every existing session of the user
+		if err := DB.UpdateUserPassword(ctx, resetToken.UserId, passwordHash); err != nil {
+			log.Error().Err(err).Msg("Error updating user password")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error resetting password", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		if err := ResetTokens.DeleteUserTokens(ctx, resetToken.UserId); err != nil {
+			log.Error().Err(err).Msg("Error deleting password reset tokens")
+		}
+
+		log.Info().Msg("Password reset for user: " + resetToken.UserId.Hex())
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: nil})
+	}
+}
+
+func sendPasswordResetEmail(ctx context.Context, user *models.UserWithCompanyAsObject, token string, expiresAt time.Time) error {
+	return sendEmail(ctx, user.Email, "password-reset", map[string]interface{}{
+		"name":      user.Name,
+		"resetUrl":  PasswordResetURL + "?token=" + url.QueryEscape(token),
+		"expiresAt": expiresAt,
+	})
+}
+
+// sendEmail asks the notifications service to send the template to the address with the data
+func sendEmail(ctx context.Context, to, template string, data map[string]interface{}) error {
+	if NotificationsURL == "" {
+		return errors.New("NOTIFICATIONS_URL is not configured")
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"to":       to,
+		"template": template,
+		"data":     data,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, NotificationsURL+"/notifications/email", bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("notifications service answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
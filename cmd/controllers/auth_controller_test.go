@@ -0,0 +1,758 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MockSessionStore is a mock implementation of the session operations
+type MockSessionStore struct {
+	CreateSessionFunc func(ctx context.Context, session models.Session) (primitive.ObjectID, error)
+	FindSessionFunc   func(ctx context.Context, tokenHash string) (*models.Session, error)
+	DeleteSessionFunc func(ctx context.Context, id primitive.ObjectID) error
+}
+
+// CreateSession mocks storing a session
+func (s *MockSessionStore) CreateSession(ctx context.Context, session models.Session) (primitive.ObjectID, error) {
+	if s.CreateSessionFunc != nil {
+		return s.CreateSessionFunc(ctx, session)
+	}
+	return primitive.NewObjectID(), nil
+}
+
+// FindSession mocks the retrieval of a session by its token hash
+func (s *MockSessionStore) FindSession(ctx context.Context, tokenHash string) (*models.Session, error) {
+	if s.FindSessionFunc != nil {
+		return s.FindSessionFunc(ctx, tokenHash)
+	}
+	return nil, nil
+}
+
+// DeleteSession mocks removing a session
+func (s *MockSessionStore) DeleteSession(ctx context.Context, id primitive.ObjectID) error {
+	if s.DeleteSessionFunc != nil {
+		return s.DeleteSessionFunc(ctx, id)
+	}
+	return nil
+}
+
+// MockAuditLog keeps the recorded events in memory
+type MockAuditLog struct {
+	Events []models.AuditEvent
+	// Err fails every RecordEvent when it is set
+	Err error
+}
+
+// RecordEvent mocks appending an event to the audit log
+func (a *MockAuditLog) RecordEvent(ctx context.Context, event models.AuditEvent) error {
+	if a.Err != nil {
+		return a.Err
+	}
+	a.Events = append(a.Events, event)
+	return nil
+}
+
+// FindEvents mocks a page of the events matching the filter, newest first
+func (a *MockAuditLog) FindEvents(ctx context.Context, filter models.AuditFilter, page, limit int) ([]*models.AuditEvent, int64, error) {
+	var matching []*models.AuditEvent
+	_ = a.StreamEvents(ctx, filter, func(event *models.AuditEvent) error {
+		matching = append(matching, event)
+		return nil
+	})
+	total := int64(len(matching))
+	start := (page - 1) * limit
+	if start >= len(matching) {
+		return []*models.AuditEvent{}, total, nil
+	}
+	end := start + limit
+	if end > len(matching) {
+		end = len(matching)
+	}
+	return matching[start:end], total, nil
+}
+
+// StreamEvents mocks the iteration over the events matching the filter, newest first
+func (a *MockAuditLog) StreamEvents(ctx context.Context, filter models.AuditFilter, fn func(event *models.AuditEvent) error) error {
+	for i := len(a.Events) - 1; i >= 0; i-- {
+		event := a.Events[i]
+		if !auditEventMatches(&event, filter) {
+			continue
+		}
+		if err := fn(&event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func auditEventMatches(event *models.AuditEvent, filter models.AuditFilter) bool {
+	sameID := func(want, got *primitive.ObjectID) bool {
+		return want == nil || (got != nil && *want == *got)
+	}
+	return sameID(filter.Company, event.Company) && sameID(filter.Actor, event.Actor) && sameID(filter.Target, event.Target) &&
+		(filter.Action == "" || filter.Action == event.Action) &&
+		(filter.From == nil || !event.CreatedAt.Before(*filter.From)) && (filter.To == nil || !event.CreatedAt.After(*filter.To))
+}
+
+func init() {
+	Sessions = &MockSessionStore{}
+	Audit = &MockAuditLog{}
+	LoginAttempts = configs.NewMemoryLoginAttemptStore()
+}
+
+// loginRequest performs a login against the router and returns the recorded response
+func loginRequest(router *gin.Engine, email, password string) *httptest.ResponseRecorder {
+	payload, _ := json.Marshal(models.LoginRequest{Email: email, Password: password})
+	req, _ := http.NewRequest("POST", "/auth/login", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+// loginTestUser returns a user with a hashed password and a mock database that finds it by email and id
+func loginTestUser(password string) (*models.UserWithCompanyAsObject, *MockDB) {
+	hash, _ := auth.HashPassword(password)
+	user := &models.UserWithCompanyAsObject{
+		Id:       primitive.NewObjectID(),
+		Name:     "Test User",
+		Email:    "test@example.com",
+		Password: hash,
+		Role:     models.RoleAdmin,
+		Company:  primitive.NewObjectID(),
+	}
+	mockDB := &MockDB{
+		FindUsersByEmailFunc: func(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+			if email == user.Email {
+				return []*models.UserWithCompanyAsObject{user}, nil
+			}
+			return []*models.UserWithCompanyAsObject{}, nil
+		},
+		FindUserByIDFunc: func(ctx context.Context, id primitive.ObjectID) (*models.UserWithCompanyAsObject, error) {
+			if id == user.Id {
+				return user, nil
+			}
+			return nil, mongo.ErrNoDocuments
+		},
+	}
+	return user, mockDB
+}
+
+func TestLogin(t *testing.T) {
+	router := gin.Default()
+	LoginAttempts = configs.NewMemoryLoginAttemptStore()
+
+	user, mockDB := loginTestUser("Tr0ub4dor&3x")
+	DB = mockDB
+
+	var storedSession models.Session
+	Sessions = &MockSessionStore{
+		CreateSessionFunc: func(ctx context.Context, session models.Session) (primitive.ObjectID, error) {
+			storedSession = session
+			return primitive.NewObjectID(), nil
+		},
+	}
+
+	// Set up the route
+	router.POST("/auth/login", Login())
+
+	// Perform the request and record the response
+	resp := loginRequest(router, user.Email, "Tr0ub4dor&3x")
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// Parse the response body
+	var response responses.UserResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+
+	// Only the hash of the token is stored
+	token := response.Data["token"].(string)
+	assert.Equal(t, auth.HashToken(token), storedSession.TokenHash)
+	assert.Equal(t, user.Id, storedSession.UserId)
+	assert.Equal(t, user.Company, storedSession.Company)
+}
+
+func TestLoginWrongPassword(t *testing.T) {
+	router := gin.Default()
+	LoginAttempts = configs.NewMemoryLoginAttemptStore()
+
+	user, mockDB := loginTestUser("Tr0ub4dor&3x")
+	DB = mockDB
+
+	sessionCreated := false
+	Sessions = &MockSessionStore{
+		CreateSessionFunc: func(ctx context.Context, session models.Session) (primitive.ObjectID, error) {
+			sessionCreated = true
+			return primitive.NewObjectID(), nil
+		},
+	}
+
+	// Set up the route
+	router.POST("/auth/login", Login())
+
+	// A wrong password and an unknown email get the same answer
+	resp := loginRequest(router, user.Email, "wrong password")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = loginRequest(router, "unknown@example.com", "wrong password")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.False(t, sessionCreated)
+}
+
+func TestLoginProgressiveDelay(t *testing.T) {
+	router := gin.Default()
+	LoginAttempts = configs.NewMemoryLoginAttemptStore()
+
+	user, mockDB := loginTestUser("Tr0ub4dor&3x")
+	DB = mockDB
+
+	// Set up the route
+	router.POST("/auth/login", Login())
+
+	resp := loginRequest(router, user.Email, "wrong password")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// Retrying right away is rejected even with the right password
+	resp = loginRequest(router, user.Email, "Tr0ub4dor&3x")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "1", resp.Header().Get("Retry-After"))
+}
+
+func TestLoginLockoutAndUnlock(t *testing.T) {
+	router := gin.Default()
+	LoginAttempts = configs.NewMemoryLoginAttemptStore()
+	auditLog := &MockAuditLog{}
+	Audit = auditLog
+
+	// Without delays to reach the lockout right away
+	defaultPolicy := auth.AccountLockoutPolicy
+	auth.AccountLockoutPolicy = auth.LockoutPolicy{MaxFailures: 3, LockoutDuration: time.Hour, Window: time.Hour}
+	defer func() { auth.AccountLockoutPolicy = defaultPolicy }()
+
+	user, mockDB := loginTestUser("Tr0ub4dor&3x")
+	DB = mockDB
+
+	// The admin session belongs to the same company as the locked user
+	admin := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Role: models.RoleAdmin, Company: user.Company}
+	findUserByID := mockDB.FindUserByIDFunc
+	mockDB.FindUserByIDFunc = func(ctx context.Context, id primitive.ObjectID) (*models.UserWithCompanyAsObject, error) {
+		if id == admin.Id {
+			return admin, nil
+		}
+		return findUserByID(ctx, id)
+	}
+	Sessions = &MockSessionStore{
+		FindSessionFunc: func(ctx context.Context, tokenHash string) (*models.Session, error) {
+			if tokenHash == auth.HashToken("admin-token") {
+				return &models.Session{Id: primitive.NewObjectID(), UserId: admin.Id, Company: admin.Company, CreatedAt: time.Now()}, nil
+			}
+			return nil, nil
+		},
+	}
+
+	// Set up the routes
+	router.POST("/auth/login", Login())
+	router.POST("/users/:userId/unlock", RequireSession(), RequireRole(models.RoleAdmin), UnlockUser())
+
+	for i := 0; i < 3; i++ {
+		resp := loginRequest(router, user.Email, "wrong password")
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	}
+
+	// The account is locked and the lockout is audited
+	resp := loginRequest(router, user.Email, "Tr0ub4dor&3x")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Len(t, auditLog.Events, 1)
+	assert.Equal(t, models.AuditActionLoginLocked, auditLog.Events[0].Action)
+	assert.Equal(t, user.Id, *auditLog.Events[0].Target)
+
+	// An admin of the company unlocks it
+	req, _ := http.NewRequest("POST", "/users/"+user.Id.Hex()+"/unlock", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	unlockResp := httptest.NewRecorder()
+	router.ServeHTTP(unlockResp, req)
+	assert.Equal(t, http.StatusOK, unlockResp.Code)
+	assert.Equal(t, models.AuditActionLoginUnlocked, auditLog.Events[1].Action)
+	assert.Equal(t, admin.Id, *auditLog.Events[1].Actor)
+
+	resp = loginRequest(router, user.Email, "Tr0ub4dor&3x")
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestUnlockUserRequiresSession(t *testing.T) {
+	router := gin.Default()
+	Sessions = &MockSessionStore{}
+
+	// Set up the route
+	router.POST("/users/:userId/unlock", RequireSession(), RequireRole(models.RoleAdmin), UnlockUser())
+
+	// Create a POST request without token
+	req, _ := http.NewRequest("POST", "/users/"+primitive.NewObjectID().Hex()+"/unlock", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestRequireSessionRevokedByPasswordChange(t *testing.T) {
+	router := gin.Default()
+
+	user, mockDB := loginTestUser("Tr0ub4dor&3x")
+	passwordChangedAt := time.Now()
+	user.PasswordChangedAt = &passwordChangedAt
+	DB = mockDB
+
+	// The session was created before the password was reset
+	Sessions = &MockSessionStore{
+		FindSessionFunc: func(ctx context.Context, tokenHash string) (*models.Session, error) {
+			return &models.Session{Id: primitive.NewObjectID(), UserId: user.Id, CreatedAt: passwordChangedAt.Add(-time.Minute)}, nil
+		},
+	}
+
+	// Set up the route
+	router.POST("/auth/logout", RequireSession(), Logout())
+
+	req, _ := http.NewRequest("POST", "/auth/logout", nil)
+	req.Header.Set("Authorization", "Bearer old-token")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+// MockPasswordResetStore is a mock implementation of the password reset token operations
+type MockPasswordResetStore struct {
+	CreateTokenFunc      func(ctx context.Context, token models.PasswordResetToken) error
+	FindTokenFunc        func(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error)
+	ConsumeTokenFunc     func(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error)
+	DeleteUserTokensFunc func(ctx context.Context, userId primitive.ObjectID) error
+}
+
+// CreateToken mocks storing a password reset token
+func (s *MockPasswordResetStore) CreateToken(ctx context.Context, token models.PasswordResetToken) error {
+	if s.CreateTokenFunc != nil {
+		return s.CreateTokenFunc(ctx, token)
+	}
+	return nil
+}
+
+// FindToken mocks the retrieval of a password reset token
+func (s *MockPasswordResetStore) FindToken(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+	if s.FindTokenFunc != nil {
+		return s.FindTokenFunc(ctx, tokenHash)
+	}
+	return nil, nil
+}
+
+// ConsumeToken mocks the single use retrieval of a password reset token
+func (s *MockPasswordResetStore) ConsumeToken(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+	if s.ConsumeTokenFunc != nil {
+		return s.ConsumeTokenFunc(ctx, tokenHash)
+	}
+	return nil, nil
+}
+
+// DeleteUserTokens mocks removing the pending tokens of a user
+func (s *MockPasswordResetStore) DeleteUserTokens(ctx context.Context, userId primitive.ObjectID) error {
+	if s.DeleteUserTokensFunc != nil {
+		return s.DeleteUserTokensFunc(ctx, userId)
+	}
+	return nil
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	router := gin.Default()
+
+	searched := make(chan string, 1)
+	mockDB := &MockDB{}
+	mockDB.FindUsersByEmailFunc = func(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+		searched <- email
+		return nil, errors.New("mongo: no documents in result")
+	}
+	DB = mockDB
+
+	tokenCreated := make(chan models.PasswordResetToken, 1)
+	ResetTokens = &MockPasswordResetStore{
+		CreateTokenFunc: func(ctx context.Context, token models.PasswordResetToken) error {
+			tokenCreated <- token
+			return nil
+		},
+	}
+
+	// Set up the route
+	router.POST("/auth/password/forgot", ForgotPassword())
+
+	// Create a POST request with the payload
+	payload, _ := json.Marshal(models.ForgotPasswordRequest{Email: "unknown@example.com"})
+	req, _ := http.NewRequest("POST", "/auth/password/forgot", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Perform the request and record the response
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	// The answer must be the same as for a registered email
+	assert.Equal(t, http.StatusAccepted, resp.Code)
+
+	// The email is looked up in the background, and nothing is stored for it
+	select {
+	case email := <-searched:
+		assert.Equal(t, "unknown@example.com", email)
+	case <-time.After(time.Second):
+		t.Fatal("the email was not looked up")
+	}
+	select {
+	case <-tokenCreated:
+		t.Fatal("no token must be created for an unknown email")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestForgotPasswordSendsResetLink(t *testing.T) {
+	router := gin.Default()
+
+	user := &models.UserWithCompanyAsObject{
+		Id:      primitive.NewObjectID(),
+		Name:    "Test User",
+		Email:   "test@example.com",
+		Role:    "admin",
+		Company: primitive.NewObjectID(),
+	}
+	mockDB := &MockDB{}
+	mockDB.FindUsersByEmailFunc = func(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+		return []*models.UserWithCompanyAsObject{user}, nil
+	}
+	DB = mockDB
+
+	var storedToken models.PasswordResetToken
+	ResetTokens = &MockPasswordResetStore{
+		CreateTokenFunc: func(ctx context.Context, token models.PasswordResetToken) error {
+			storedToken = token
+			return nil
+		},
+	}
+
+	// Capture the email sent through the notifications service, the link is sent after the response
+	sent := make(chan map[string]interface{}, 1)
+	GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		assert.Equal(t, "/notifications/email", req.URL.Path)
+		var email map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&email)
+		sent <- email
+		return &http.Response{StatusCode: http.StatusAccepted, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	}
+	Client = &MockClient{}
+	NotificationsURL = "http://notifications"
+	PasswordResetURL = "http://frontend/reset-password"
+
+	// Set up the route
+	router.POST("/auth/password/forgot", ForgotPassword())
+
+	// Create a POST request with the payload
+	payload, _ := json.Marshal(models.ForgotPasswordRequest{Email: user.Email})
+	req, _ := http.NewRequest("POST", "/auth/password/forgot", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Perform the request and record the response
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusAccepted, resp.Code)
+
+	var sentEmail map[string]interface{}
+	select {
+	case sentEmail = <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("the password reset email was not sent")
+	}
+	assert.Equal(t, user.Id, storedToken.UserId)
+	assert.True(t, storedToken.ExpiresAt.After(time.Now()))
+
+	// Only the hash is stored, the plain token travels in the email
+	assert.Equal(t, user.Email, sentEmail["to"])
+	resetUrl := sentEmail["data"].(map[string]interface{})["resetUrl"].(string)
+	token := resetUrl[len("http://frontend/reset-password?token="):]
+	assert.Equal(t, auth.HashToken(token), storedToken.TokenHash)
+}
+
+func TestResetPassword(t *testing.T) {
+	router := gin.Default()
+
+	user := &models.UserWithCompanyAsObject{
+		Id:      primitive.NewObjectID(),
+		Name:    "Test User",
+		Email:   "test@example.com",
+		Role:    "admin",
+		Company: primitive.NewObjectID(),
+	}
+	resetToken := &models.PasswordResetToken{UserId: user.Id, TokenHash: auth.HashToken("reset-token"), ExpiresAt: time.Now().Add(time.Hour)}
+	ResetTokens = &MockPasswordResetStore{
+		FindTokenFunc: func(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+			assert.Equal(t, auth.HashToken("reset-token"), tokenHash)
+			return resetToken, nil
+		},
+		ConsumeTokenFunc: func(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+			return resetToken, nil
+		},
+	}
+
+	var storedHash string
+	mockDB := &MockDB{}
+	mockDB.FindUserByIDFunc = func(ctx context.Context, id primitive.ObjectID) (*models.UserWithCompanyAsObject, error) {
+		return user, nil
+	}
+	mockDB.UpdateUserPasswordFunc = func(ctx context.Context, id primitive.ObjectID, passwordHash string) error {
+		assert.Equal(t, user.Id, id)
+		storedHash = passwordHash
+		return nil
+	}
+	DB = mockDB
+
+	// Set up the route
+	router.POST("/auth/password/reset", ResetPassword())
+
+	// Create a POST request with the payload
+	payload, _ := json.Marshal(models.ResetPasswordRequest{Token: "reset-token", Password: "N3w-Secret-Phrase"})
+	req, _ := http.NewRequest("POST", "/auth/password/reset", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Perform the request and record the response
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.True(t, auth.CheckPassword(storedHash, "N3w-Secret-Phrase"))
+}
+
+func TestResetPasswordWeakPassword(t *testing.T) {
+	router := gin.Default()
+
+	user := &models.UserWithCompanyAsObject{
+		Id:      primitive.NewObjectID(),
+		Name:    "Test User",
+		Email:   "test@example.com",
+		Company: primitive.NewObjectID(),
+	}
+	tokenConsumed := false
+	ResetTokens = &MockPasswordResetStore{
+		FindTokenFunc: func(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+			return &models.PasswordResetToken{UserId: user.Id, TokenHash: tokenHash, ExpiresAt: time.Now().Add(time.Hour)}, nil
+		},
+		ConsumeTokenFunc: func(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+			tokenConsumed = true
+			return nil, nil
+		},
+	}
+
+	mockDB := &MockDB{}
+	mockDB.FindUserByIDFunc = func(ctx context.Context, id primitive.ObjectID) (*models.UserWithCompanyAsObject, error) {
+		return user, nil
+	}
+	DB = mockDB
+
+	// Set up the route
+	router.POST("/auth/password/reset", ResetPassword())
+
+	// Create a POST request with a password containing the user email
+	payload, _ := json.Marshal(models.ResetPasswordRequest{Token: "reset-token", Password: "Test1234567"})
+	req, _ := http.NewRequest("POST", "/auth/password/reset", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Perform the request and record the response
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.False(t, tokenConsumed, "a rejected password must not use the token")
+
+	// Parse the response body
+	var response responses.UserResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	assert.Equal(t, "validation error", response.Message)
+	assert.Equal(t, []interface{}{"must not contain your email or name"}, response.Data["password"])
+}
+
+func TestResetPasswordInvalidToken(t *testing.T) {
+	router := gin.Default()
+
+	ResetTokens = &MockPasswordResetStore{
+		FindTokenFunc: func(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+			return nil, nil
+		},
+	}
+
+	passwordUpdated := false
+	mockDB := &MockDB{}
+	mockDB.UpdateUserPasswordFunc = func(ctx context.Context, id primitive.ObjectID, passwordHash string) error {
+		passwordUpdated = true
+		return nil
+	}
+	DB = mockDB
+
+	// Set up the route
+	router.POST("/auth/password/reset", ResetPassword())
+
+	// Create a POST request with the payload
+	payload, _ := json.Marshal(models.ResetPasswordRequest{Token: "used-or-expired", Password: "N3w-Secret-Phrase"})
+	req, _ := http.NewRequest("POST", "/auth/password/reset", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Perform the request and record the response
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.False(t, passwordUpdated)
+
+	// Parse the response body
+	var response responses.UserResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	assert.Equal(t, "Invalid or expired password reset token", response.Message)
+}
+
+func TestLoginRehashesOutdatedPassword(t *testing.T) {
+	router := gin.Default()
+	LoginAttempts = configs.NewMemoryLoginAttemptStore()
+	Sessions = memorySessions()
+
+	user, mockDB := loginTestUser("Tr0ub4dor&3x")
+	bcryptHash, _ := auth.NewBcryptHasher(auth.BcryptCost).Hash("Tr0ub4dor&3x")
+	user.Password = bcryptHash
+	passwordChanged := false
+	mockDB.UpdateUserPasswordFunc = func(ctx context.Context, id primitive.ObjectID, passwordHash string) error {
+		passwordChanged = true
+		return nil
+	}
+	mockDB.UpdateUserPasswordHashFunc = func(ctx context.Context, id primitive.ObjectID, oldHash, newHash string) error {
+		assert.Equal(t, bcryptHash, oldHash)
+		user.Password = newHash
+		return nil
+	}
+	DB = mockDB
+
+	// Set up the route
+	router.POST("/auth/login", Login())
+
+	resp := loginRequest(router, user.Email, "Tr0ub4dor&3x")
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// The bcrypt hash is replaced without revoking the sessions
+	assert.True(t, strings.HasPrefix(user.Password, "$argon2id$"))
+	assert.True(t, auth.CheckPassword(user.Password, "Tr0ub4dor&3x"))
+	assert.False(t, passwordChanged)
+
+	resp = loginRequest(router, user.Email, "Tr0ub4dor&3x")
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestLoginPasswordResetRequired(t *testing.T) {
+	router := gin.Default()
+	LoginAttempts = configs.NewMemoryLoginAttemptStore()
+	Sessions = memorySessions()
+
+	user, mockDB := loginTestUser("Tr0ub4dor&3x")
+	user.PasswordResetRequired = true
+	DB = mockDB
+
+	// Set up the route
+	router.POST("/auth/login", Login())
+
+	resp := loginRequest(router, user.Email, "")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = loginRequest(router, user.Email, "Tr0ub4dor&3x")
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	var response responses.UserResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	assert.Equal(t, "Password reset required", response.Message)
+	assert.Equal(t, true, response.Data["passwordResetRequired"])
+
+	// The flag is only told once the password matched, so it does not reveal that the email is registered
+	resp = loginRequest(router, user.Email, "Wr0ng-Password")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestLoginPasswordRemoved(t *testing.T) {
+	router := gin.Default()
+	LoginAttempts = configs.NewMemoryLoginAttemptStore()
+	Sessions = memorySessions()
+
+	user, mockDB := loginTestUser("Tr0ub4dor&3x")
+	user.Password = ""
+	user.PasswordResetRequired = true
+	DB = mockDB
+
+	// Set up the route
+	router.POST("/auth/login", Login())
+
+	// Without a password nothing matches, and the answer is the one of an unknown email
+	resp := loginRequest(router, user.Email, "Tr0ub4dor&3x")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	unknown := loginRequest(router, "unknown@example.com", "Tr0ub4dor&3x")
+	assert.Equal(t, unknown.Body.String(), resp.Body.String())
+}
+
+func TestLoginUserOfSeveralCompanies(t *testing.T) {
+	router := gin.Default()
+	LoginAttempts = configs.NewMemoryLoginAttemptStore()
+
+	user, _ := loginTestUser("Tr0ub4dor&3x")
+	member := *user
+	member.Id = primitive.NewObjectID()
+	member.Company = primitive.NewObjectID()
+	DB = memoryUsers(user, &member)
+
+	var storedSession models.Session
+	Sessions = &MockSessionStore{
+		CreateSessionFunc: func(ctx context.Context, session models.Session) (primitive.ObjectID, error) {
+			storedSession = session
+			return primitive.NewObjectID(), nil
+		},
+	}
+
+	// Set up the route
+	router.POST("/auth/login", Login())
+	login := func(password, company string) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(models.LoginRequest{Email: user.Email, Password: password, Company: company})
+		req, _ := http.NewRequest("POST", "/auth/login", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := login("Tr0ub4dor&3x", "")
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	var response responses.UserResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	assert.ElementsMatch(t, []interface{}{user.Company.Hex(), member.Company.Hex()}, response.Data["companies"])
+
+	// The session is for the user of the chosen company
+	resp = login("Tr0ub4dor&3x", member.Company.Hex())
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, member.Id, storedSession.UserId)
+	assert.Equal(t, member.Company, storedSession.Company)
+
+	resp = login("Tr0ub4dor&3x", primitive.NewObjectID().Hex())
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// The companies are only listed once the password is checked
+	resp = login("wrong password", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.NotContains(t, resp.Body.String(), member.Company.Hex())
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	currentUserKey    = "currentUser"
+	currentSessionKey = "currentSession"
+)
+
+// RequireSession only lets through requests with a valid session token on the Authorization header.
+// Sessions with a scope, like the ones waiting for MFA, are rejected unless their scope is listed.
+func RequireSession(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		token := bearerToken(c)
+		if token == "" {
+			abortUnauthorized(c, "Missing session token")
+			return
+		}
+
+		session, err := Sessions.FindSession(ctx, auth.HashToken(token))
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting session from database")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error getting session from database", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		if session == nil || !sessionScopeAllowed(session, scopes) {
+			abortUnauthorized(c, "Invalid or expired session")
+			return
+		}
+
+		// The session is the only thing known of the caller, its user tells the company the request is scoped to
+		user, err := DB.FindUserByID(configs.WithSystemScope(ctx), session.UserId)
+		if err != nil || user == nil {
+			log.Error().Err(err).Msg("Error getting the user of a session")
+			abortUnauthorized(c, "Invalid or expired session")
+			return
+		}
+
+		// Changing the password revokes every session created before it, and deactivating the user every session
+		if !user.IsActive() || user.PasswordChangedAt != nil && session.CreatedAt.Before(*user.PasswordChangedAt) {
+			abortUnauthorized(c, "Invalid or expired session")
+			return
+		}
+
+		c.Set(currentUserKey, user)
+		c.Set(currentSessionKey, session)
+		c.Next()
+	}
+}
+
+// RequireRole must run after RequireSession and only lets through users with one of the roles
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		for _, role := range roles {
+			if user != nil && user.Role == role {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "You are not allowed to perform this action", Data: nil})
+	}
+}
+
+// CurrentUser returns the user authenticated by RequireSession
+func CurrentUser(c *gin.Context) *models.UserWithCompanyAsObject {
+	if user, ok := c.Get(currentUserKey); ok {
+		return user.(*models.UserWithCompanyAsObject)
+	}
+	return nil
+}
+
+// CurrentSession returns the session authenticated by RequireSession
+func CurrentSession(c *gin.Context) *models.Session {
+	if session, ok := c.Get(currentSessionKey); ok {
+		return session.(*models.Session)
+	}
+	return nil
+}
+
+func sessionScopeAllowed(session *models.Session, scopes []string) bool {
+	if session.Scope == "" {
+		return true
+	}
+	for _, scope := range scopes {
+		if session.Scope == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) > len("Bearer ") && strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(header[len("Bearer "):])
+	}
+	return ""
+}
+
+func abortUnauthorized(c *gin.Context, message string) {
+	log.Error().Msg(message)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, responses.UserResponse{Status: http.StatusUnauthorized, Message: message, Data: nil})
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetPasswordPolicy returns the password rules that apply to the users of a company, so clients can show them
+func GetPasswordPolicy() gin.HandlerFunc {
+	log.Info().Msg("Get company password policy endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		companyId := c.Param("companyId")
+
+		companyIdObject, err := primitive.ObjectIDFromHex(companyId)
+		if err != nil {
+			log.Error().Err(err).Msg("Error converting company ID to object")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "error on companyId as an object", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		settings, err := CompanySettings.FindCompanySettings(ctx, companyIdObject)
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting company settings from database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error getting company settings from database", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		policy := auth.DefaultPasswordPolicy
+		if settings != nil {
+			policy = auth.ApplyOverride(policy, settings.PasswordPolicy)
+		}
+
+		log.Info().Msg("Password policy of company: " + companyId + " retrieved successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"passwordPolicy": policy}})
+	}
+}
+
+// GetAttributeSchema returns the custom attributes the users of the company of the caller can have
+func GetAttributeSchema() gin.HandlerFunc {
+	log.Info().Msg("Get company attribute schema endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+		companyId := c.Param("companyId")
+
+		companyIdObject, err := primitive.ObjectIDFromHex(companyId)
+		if err != nil || companyIdObject != callerCompany(c) {
+			log.Error().Msg("Caller of company: " + callerCompany(c).Hex() + " tried to read the attribute schema of company: " + companyId)
+			c.JSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "You are not allowed to perform this action", Data: nil})
+			return
+		}
+
+		attributes, err := userService().AttributeSchema(ctx, companyIdObject)
+		if err != nil {
+			userServiceError(c, err, "Error getting company settings from database")
+			return
+		}
+
+		log.Info().Msg("Attribute schema of company: " + companyId + " retrieved successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"attributes": attributes}})
+	}
+}
+
+// UpdateAttributeSchema replaces the custom attributes the users of the company of the admin can have
+func UpdateAttributeSchema() gin.HandlerFunc {
+	log.Info().Msg("Update company attribute schema endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		var request models.AttributeSchemaRequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		attributes, err := userService().SetAttributeSchema(ctx, companyId, request)
+		if err != nil {
+			userServiceError(c, err, "Error updating company settings on database")
+			return
+		}
+
+		log.Info().Msg("Attribute schema of company: " + companyId.Hex() + " updated successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"attributes": attributes}})
+	}
+}
@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user-service/cmd/responses"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MockCompanySettingsStore is a mock implementation of the company settings operations
+type MockCompanySettingsStore struct {
+	FindCompanySettingsFunc func(ctx context.Context, companyId primitive.ObjectID) (*models.CompanySettings, error)
+	UpdateRequireMFAFunc    func(ctx context.Context, companyId primitive.ObjectID, required bool) error
+
+	UpdateAttributeSchemaFunc func(ctx context.Context, companyId primitive.ObjectID, attributes []models.AttributeDefinition) error
+}
+
+// FindCompanySettings mocks the retrieval of the settings of a company
+func (s *MockCompanySettingsStore) FindCompanySettings(ctx context.Context, companyId primitive.ObjectID) (*models.CompanySettings, error) {
+	if s.FindCompanySettingsFunc != nil {
+		return s.FindCompanySettingsFunc(ctx, companyId)
+	}
+	return nil, nil
+}
+
+// UpdateRequireMFA mocks the update of the MFA requirement of a company
+func (s *MockCompanySettingsStore) UpdateRequireMFA(ctx context.Context, companyId primitive.ObjectID, required bool) error {
+	if s.UpdateRequireMFAFunc != nil {
+		return s.UpdateRequireMFAFunc(ctx, companyId, required)
+	}
+	return nil
+}
+
+// UpdateAttributeSchema mocks the update of the custom attributes of a company
+func (s *MockCompanySettingsStore) UpdateAttributeSchema(ctx context.Context, companyId primitive.ObjectID, attributes []models.AttributeDefinition) error {
+	if s.UpdateAttributeSchemaFunc != nil {
+		return s.UpdateAttributeSchemaFunc(ctx, companyId, attributes)
+	}
+	return nil
+}
+
+// memoryAttributeSchemas returns a mock company settings store that keeps the attribute schemas in memory
+func memoryAttributeSchemas() *MockCompanySettingsStore {
+	schemas := map[primitive.ObjectID][]models.AttributeDefinition{}
+	return &MockCompanySettingsStore{
+		FindCompanySettingsFunc: func(ctx context.Context, companyId primitive.ObjectID) (*models.CompanySettings, error) {
+			if schema, found := schemas[companyId]; found {
+				return &models.CompanySettings{Company: companyId, AttributeSchema: schema}, nil
+			}
+			return nil, nil
+		},
+		UpdateAttributeSchemaFunc: func(ctx context.Context, companyId primitive.ObjectID, attributes []models.AttributeDefinition) error {
+			schemas[companyId] = attributes
+			return nil
+		},
+	}
+}
+
+func init() {
+	CompanySettings = &MockCompanySettingsStore{}
+}
+
+func TestGetPasswordPolicyWithOverride(t *testing.T) {
+	router := gin.Default()
+
+	minLength := 16
+	companyId := primitive.NewObjectID()
+	CompanySettings = &MockCompanySettingsStore{
+		FindCompanySettingsFunc: func(ctx context.Context, id primitive.ObjectID) (*models.CompanySettings, error) {
+			assert.Equal(t, companyId, id)
+			return &models.CompanySettings{Company: id, PasswordPolicy: &models.PasswordPolicyOverride{MinLength: &minLength}}, nil
+		},
+	}
+	defer func() { CompanySettings = &MockCompanySettingsStore{} }()
+
+	// Set up the route
+	router.GET("/companies/:companyId/password-policy", GetPasswordPolicy())
+
+	// Create a GET request
+	req, _ := http.NewRequest("GET", "/companies/"+companyId.Hex()+"/password-policy", nil)
+
+	// Perform the request and record the response
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// Parse the response body
+	var response responses.UserResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+
+	// The override replaces the min length and keeps the other defaults
+	policy := response.Data["passwordPolicy"].(map[string]interface{})
+	assert.Equal(t, float64(16), policy["minLength"])
+	assert.Equal(t, true, policy["requireUpper"])
+}
+
+func TestCreateUserWeakPassword(t *testing.T) {
+	router := gin.Default()
+
+	userCreated := false
+	mockDB := &MockDB{}
+	mockDB.CreateUserFunc = func(ctx context.Context, user models.UserWithCompanyAsObject) (primitive.ObjectID, error) {
+		userCreated = true
+		return primitive.NewObjectID(), nil
+	}
+	DB = mockDB
+
+	// Set up the route
+	router.POST("/users", asAdmin(primitive.NewObjectID()), CreateUser())
+
+	// Create a payload with a short common password
+	payload, _ := json.Marshal(models.User{
+		Name:     "Test User",
+		Email:    "test@example.com",
+		Password: "password",
+		Role:     "admin",
+	})
+	req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Perform the request and record the response
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.False(t, userCreated)
+
+	// Parse the response body
+	var response responses.UserResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+
+	// Every broken rule is reported back
+	assert.Equal(t, "validation error", response.Message)
+	assert.ElementsMatch(t, []interface{}{
+		"must be at least 10 characters long",
+		"must contain an uppercase letter",
+		"must contain a digit",
+		"is too common",
+	}, response.Data["password"])
+}
+
+func TestCreateUserCompanyPasswordPolicy(t *testing.T) {
+	router := gin.Default()
+
+	minLength := 20
+	CompanySettings = &MockCompanySettingsStore{
+		FindCompanySettingsFunc: func(ctx context.Context, id primitive.ObjectID) (*models.CompanySettings, error) {
+			return &models.CompanySettings{Company: id, PasswordPolicy: &models.PasswordPolicyOverride{MinLength: &minLength}}, nil
+		},
+	}
+	defer func() { CompanySettings = &MockCompanySettingsStore{} }()
+	DB = &MockDB{}
+
+	// Set up the route
+	router.POST("/users", asAdmin(primitive.NewObjectID()), CreateUser())
+
+	// The password follows the default policy but not the company one
+	payload, _ := json.Marshal(models.User{
+		Name:     "Test User",
+		Email:    "test@example.com",
+		Password: "Tr0ub4dor&3x",
+		Role:     "admin",
+	})
+	req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Perform the request and record the response
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// Parse the response body
+	var response responses.UserResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	assert.Equal(t, []interface{}{"must be at least 20 characters long"}, response.Data["password"])
+}
+
+// setUpAttributes returns the routes of the attribute schema and the users with an admin, and a user of another company
+func setUpAttributes() (*gin.Engine, string, *models.UserWithCompanyAsObject, *models.UserWithCompanyAsObject) {
+	Groups = NewMockGroupStore()
+	Sessions = memorySessions()
+	CompanySettings = memoryAttributeSchemas()
+	companyId := primitive.NewObjectID()
+	admin := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Ada Chief", Email: "ada@example.com", Role: models.RoleAdmin, Company: companyId, Version: 1}
+	other := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Other Company", Email: "other@example.com", Role: models.RoleUser, Company: primitive.NewObjectID(), Version: 1}
+	DB = memoryUsers(admin, other)
+
+	router := gin.Default()
+	router.GET("/companies/:companyId/settings/attributes", RequireSession(), GetAttributeSchema())
+	router.PUT("/companies/:companyId/settings/attributes", RequireSession(), RequireRole(models.RoleAdmin), UpdateAttributeSchema())
+	router.POST("/users", RequireSession(), RequireRole(models.RoleAdmin), CreateUser())
+	router.PATCH("/users/:userId", RequireSession(), UpdateUser())
+	router.GET("/users", RequireSession(), RequireCompanyQuery(), GetUsers())
+	return router, testSessionToken(admin), admin, other
+}
+
+var testAttributeSchema = models.AttributeSchemaRequest{Attributes: []models.AttributeDefinition{
+	{Name: "employeeNumber", Type: models.AttributeTypeString, Required: true, Pattern: `^E\d{4}$`},
+	{Name: "department", Type: models.AttributeTypeString, Enum: []string{"Sales", "Engineering"}, Indexed: true},
+	{Name: "costCenter", Type: models.AttributeTypeNumber, Indexed: true},
+	{Name: "contractor", Type: models.AttributeTypeBoolean},
+}}
+
+func TestAttributeSchema(t *testing.T) {
+	router, token, admin, other := setUpAttributes()
+	path := "/companies/" + admin.Company.Hex() + "/settings/attributes"
+
+	resp, response := authRequest(router, "GET", path, token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Empty(t, response.Data["attributes"])
+
+	resp, response = authRequest(router, "PUT", path, token, testAttributeSchema)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Len(t, response.Data["attributes"], 4)
+	_, response = authRequest(router, "GET", path, token, nil)
+	assert.Equal(t, "employeeNumber", response.Data["attributes"].([]interface{})[0].(map[string]interface{})["name"])
+
+	invalid := []models.AttributeDefinition{
+		{Name: "cost.center", Type: models.AttributeTypeNumber},
+		{Name: "$where", Type: models.AttributeTypeString},
+		{Name: "level", Type: "date"},
+		{Name: "level", Type: models.AttributeTypeNumber, Enum: []string{"1"}},
+		{Name: "code", Type: models.AttributeTypeString, Pattern: "(["},
+	}
+	for _, attribute := range invalid {
+		resp, _ = authRequest(router, "PUT", path, token, models.AttributeSchemaRequest{Attributes: []models.AttributeDefinition{attribute}})
+		assert.Equal(t, http.StatusBadRequest, resp.Code, attribute.Name)
+	}
+	resp, _ = authRequest(router, "PUT", path, token, models.AttributeSchemaRequest{Attributes: []models.AttributeDefinition{{Name: "a", Type: "string"}, {Name: "a", Type: "number"}}})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp, _ = authRequest(router, "PUT", "/companies/"+other.Company.Hex()+"/settings/attributes", token, testAttributeSchema)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp, _ = authRequest(router, "GET", "/companies/"+other.Company.Hex()+"/settings/attributes", token, nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+}
+
+func TestUserAttributes(t *testing.T) {
+	router, token, admin, _ := setUpAttributes()
+	authRequest(router, "PUT", "/companies/"+admin.Company.Hex()+"/settings/attributes", token, testAttributeSchema)
+	newUser := func(email string, attributes map[string]interface{}) models.User {
+		return models.User{Name: "New User", Email: email, Password: "Tr0ub4dor&3x", Role: models.RoleUser, Company: admin.Company.Hex(), Attributes: attributes}
+	}
+
+	rejected := []map[string]interface{}{
+		nil,
+		{"employeeNumber": "1234"},
+		{"employeeNumber": "E1234", "department": "Legal"},
+		{"employeeNumber": "E1234", "costCenter": "42"},
+		{"employeeNumber": "E1234", "contractor": "yes"},
+		{"employeeNumber": "E1234", "nickname": "Bob"},
+	}
+	for _, attributes := range rejected {
+		resp, _ := authRequest(router, "POST", "/users", token, newUser("rejected@example.com", attributes))
+		assert.Equal(t, http.StatusBadRequest, resp.Code, attributes)
+	}
+
+	resp, response := authRequest(router, "POST", "/users", token, newUser("sam@example.com", map[string]interface{}{"employeeNumber": "E0001", "department": "Sales", "costCenter": 42}))
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	sam := response.Data["user"].(map[string]interface{})["_id"].(string)
+	assert.Equal(t, map[string]interface{}{"employeeNumber": "E0001", "department": "Sales", "costCenter": float64(42)}, response.Data["user"].(map[string]interface{})["attributes"])
+	authRequest(router, "POST", "/users", token, newUser("eve@example.com", map[string]interface{}{"employeeNumber": "E0002", "department": "Engineering", "costCenter": 7}))
+
+	// Updates are merged with the current attributes and null removes one, required ones can not be removed
+	resp, response = patchUser(router, "/users/"+sam, token, map[string]interface{}{"attributes": map[string]interface{}{"contractor": true, "costCenter": nil}})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, map[string]interface{}{"employeeNumber": "E0001", "department": "Sales", "contractor": true}, response.Data["user"].(map[string]interface{})["attributes"])
+	resp, _ = patchUser(router, "/users/"+sam, token, map[string]interface{}{"attributes": map[string]interface{}{"employeeNumber": nil}})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp, _ = patchUser(router, "/users/"+sam, token, map[string]interface{}{"attributes": map[string]interface{}{"department": "Legal"}})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// Only admins change attributes
+	resp, _ = patchUser(router, "/users/"+sam, testSessionToken(&models.UserWithCompanyAsObject{Id: mustObjectId(sam), Company: admin.Company, Role: models.RoleUser}), map[string]interface{}{"attributes": map[string]interface{}{"contractor": false}})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	users := "/users?company=" + admin.Company.Hex()
+	_, response = authRequest(router, "GET", users+"&attributes.department=Engineering", token, nil)
+	assert.Len(t, response.Data["users"], 1)
+	assert.Equal(t, "eve@example.com", response.Data["users"].([]interface{})[0].(map[string]interface{})["email"])
+	_, response = authRequest(router, "GET", users+"&attributes.department=Engineering&attributes.costCenter=7", token, nil)
+	assert.Len(t, response.Data["users"], 1)
+	_, response = authRequest(router, "GET", users+"&attributes.department=Sales&attributes.costCenter=7", token, nil)
+	assert.Empty(t, response.Data["users"])
+
+	// Only indexed attributes filter, with values of their type
+	for _, query := range []string{"&attributes.employeeNumber=E0001", "&attributes.nickname=Bob", "&attributes.costCenter=many"} {
+		resp, _ = authRequest(router, "GET", users+query, token, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.Code, query)
+	}
+}
+
+func mustObjectId(hex string) primitive.ObjectID {
+	id, _ := primitive.ObjectIDFromHex(hex)
+	return id
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeprecated(t *testing.T) {
+	deprecation := time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, time.April, 18, 0, 0, 0, 0, time.UTC)
+	router := gin.Default()
+	handler := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	router.GET("/v1/users/:userId", handler)
+	router.Group("", Deprecated(deprecation, sunset, "/v1")).GET("/users/:userId", handler)
+
+	req, _ := http.NewRequest("GET", "/users/649060d540e3b169621e9629", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	assert.Equal(t, "@1792281600", resp.Header().Get("Deprecation"))
+	assert.Equal(t, "Sun, 18 Apr 2027 00:00:00 GMT", resp.Header().Get("Sunset"))
+	assert.Equal(t, `</v1/users/649060d540e3b169621e9629>; rel="successor-version"`, resp.Header().Get("Link"))
+
+	// The versioned route is not deprecated
+	req, _ = http.NewRequest("GET", "/v1/users/649060d540e3b169621e9629", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	assert.Empty(t, resp.Header().Get("Deprecation"))
+	assert.Empty(t, resp.Header().Get("Sunset"))
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/export"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// exportTimeout is how long an export can take to stream
+	exportTimeout = 10 * time.Minute
+	// exportFlushRows is how often the rows written so far are sent to the client
+	exportFlushRows = 500
+)
+
+// userExportColumns are the columns an export can have, there is no way to export passwords or MFA secrets
+var userExportColumns = map[string]func(user *models.UserWithCompanyAsObject) interface{}{
+	"id":                    func(user *models.UserWithCompanyAsObject) interface{} { return user.Id.Hex() },
+	"name":                  func(user *models.UserWithCompanyAsObject) interface{} { return user.Name },
+	"email":                 func(user *models.UserWithCompanyAsObject) interface{} { return user.Email },
+	"role":                  func(user *models.UserWithCompanyAsObject) interface{} { return user.Role },
+	"company":               func(user *models.UserWithCompanyAsObject) interface{} { return user.Company.Hex() },
+	"mfaEnabled":            func(user *models.UserWithCompanyAsObject) interface{} { return user.MFAEnabled() },
+	"passwordChangedAt":     func(user *models.UserWithCompanyAsObject) interface{} { return user.PasswordChangedAt },
+	"passwordResetRequired": func(user *models.UserWithCompanyAsObject) interface{} { return user.PasswordResetRequired },
+}
+
+var defaultUserExportColumns = []string{"id", "name", "email", "role", "company"}
+
+// ExportUsers streams the users of the company of the admin as they are read from the database
+func ExportUsers() gin.HandlerFunc {
+	log.Info().Msg("Export users endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), exportTimeout)
+		defer cancel()
+
+		admin := CurrentUser(c)
+		companyId, ok := adminCompany(c, admin)
+		if !ok {
+			return
+		}
+
+		format := strings.ToLower(c.DefaultQuery("format", export.FormatCSV))
+		contentType, found := export.ContentTypes[format]
+		if !found {
+			log.Error().Msg("Unsupported export format: " + format)
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "The format must be csv, ndjson or xlsx", Data: nil})
+			return
+		}
+
+		columns, err := exportColumns(c.Query("columns"))
+		if err != nil {
+			log.Error().Err(err).Msg("Error validating export columns")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		filename := fmt.Sprintf("users-%s-%s.%s", companyId.Hex(), time.Now().UTC().Format("20060102"), format)
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Status(http.StatusOK)
+
+		// Once rows are sent the status can not change anymore, errors after this point end the download early
+		writer, err := export.NewWriter(format, c.Writer, columns)
+		rows := 0
+		if err == nil {
+			err = DB.StreamUsers(ctx, companyId, func(user *models.UserWithCompanyAsObject) error {
+				values := make([]interface{}, len(columns))
+				for i, column := range columns {
+					values[i] = userExportColumns[column](user)
+				}
+				if err := writer.WriteRow(values); err != nil {
+					return err
+				}
+				rows++
+				if rows%exportFlushRows == 0 {
+					c.Writer.Flush()
+				}
+				return nil
+			})
+			if closeErr := writer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+
+		metadata := map[string]interface{}{"format": format, "columns": columns, "rows": rows}
+		if err != nil {
+			log.Error().Err(err).Msg("Error exporting users of company: " + companyId.Hex())
+			metadata["error"] = err.Error()
+		}
+		recordAuditEvent(ctx, models.AuditEvent{
+			Action:   models.AuditActionUsersExported,
+			Actor:    &admin.Id,
+			Company:  &companyId,
+			IP:       c.ClientIP(),
+			Metadata: metadata,
+		})
+		if err == nil {
+			log.Info().Msg(fmt.Sprintf("%d users of company: %s exported successfully", rows, companyId.Hex()))
+		}
+	}
+}
+
+// exportColumns returns the columns of a comma separated list, or the default ones when it is empty
+func exportColumns(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return defaultUserExportColumns, nil
+	}
+	var columns []string
+	seen := map[string]bool{}
+	for _, column := range strings.Split(list, ",") {
+		column = strings.TrimSpace(column)
+		if _, found := userExportColumns[column]; !found {
+			return nil, fmt.Errorf("unknown export column %q", column)
+		}
+		if !seen[column] {
+			seen[column] = true
+			columns = append(columns, column)
+		}
+	}
+	return columns, nil
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// exportRequest downloads an export of the company with the session token
+func exportRequest(router *gin.Engine, companyId primitive.ObjectID, token, query string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "/companies/"+companyId.Hex()+"/users/export"+query, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestExportUsersCSV(t *testing.T) {
+	router := gin.Default()
+	Sessions = memorySessions()
+	audit := &MockAuditLog{}
+	Audit = audit
+
+	admin, mockDB := loginTestUser("Tr0ub4dor&3x")
+	mockDB.StreamUsersFunc = func(ctx context.Context, companyId primitive.ObjectID, fn func(user *models.UserWithCompanyAsObject) error) error {
+		assert.Equal(t, admin.Company, companyId)
+		if err := fn(admin); err != nil {
+			return err
+		}
+		return fn(&models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Jane Smith", Email: "jane@example.com", Role: "user", Company: companyId})
+	}
+	DB = mockDB
+	token := testSessionToken(admin)
+
+	// Set up the route
+	router.GET("/companies/:companyId/users/export", RequireSession(), RequireRole(models.RoleAdmin), ExportUsers())
+
+	resp := exportRequest(router, admin.Company, token, "?format=csv&columns=email,name,mfaEnabled")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "text/csv", resp.Header().Get("Content-Type"))
+	assert.Contains(t, resp.Header().Get("Content-Disposition"), "attachment; filename=\"users-"+admin.Company.Hex())
+	assert.Equal(t, "email,name,mfaEnabled\ntest@example.com,Test User,false\njane@example.com,Jane Smith,false\n", resp.Body.String())
+
+	// The password hash is never part of the file
+	assert.NotContains(t, resp.Body.String(), admin.Password)
+
+	// The export is audited
+	event := audit.Events[len(audit.Events)-1]
+	assert.Equal(t, models.AuditActionUsersExported, event.Action)
+	assert.Equal(t, admin.Id, *event.Actor)
+	assert.Equal(t, 2, event.Metadata["rows"])
+}
+
+func TestExportUsersDefaultColumns(t *testing.T) {
+	router := gin.Default()
+	Sessions = memorySessions()
+	Audit = &MockAuditLog{}
+
+	admin, mockDB := loginTestUser("Tr0ub4dor&3x")
+	mockDB.StreamUsersFunc = func(ctx context.Context, companyId primitive.ObjectID, fn func(user *models.UserWithCompanyAsObject) error) error {
+		return fn(admin)
+	}
+	DB = mockDB
+	token := testSessionToken(admin)
+
+	// Set up the route
+	router.GET("/companies/:companyId/users/export", RequireSession(), RequireRole(models.RoleAdmin), ExportUsers())
+
+	resp := exportRequest(router, admin.Company, token, "?format=ndjson")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, `{"id":"`+admin.Id.Hex()+`","name":"Test User","email":"test@example.com","role":"admin","company":"`+admin.Company.Hex()+`"}`, strings.TrimSpace(resp.Body.String()))
+}
+
+func TestExportUsersInvalidRequest(t *testing.T) {
+	router := gin.Default()
+	Sessions = memorySessions()
+	Audit = &MockAuditLog{}
+
+	admin, mockDB := loginTestUser("Tr0ub4dor&3x")
+	DB = mockDB
+	token := testSessionToken(admin)
+
+	// Set up the route
+	router.GET("/companies/:companyId/users/export", RequireSession(), RequireRole(models.RoleAdmin), ExportUsers())
+
+	// Passwords can not be selected
+	resp := exportRequest(router, admin.Company, token, "?columns=email,password")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = exportRequest(router, admin.Company, token, "?format=pdf")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = exportRequest(router, primitive.NewObjectID(), token, "")
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+}
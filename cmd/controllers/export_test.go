@@ -0,0 +1,8 @@
+package controllers
+
+// Helpers of the tests of the package, for the tests that need the routes and so are in the controllers_test package
+var (
+	MemoryUsers      = memoryUsers
+	MemorySessions   = memorySessions
+	TestSessionToken = testSessionToken
+)
@@ -0,0 +1,335 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+	"user-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+var Groups configs.GroupStore
+
+func init() {
+	Groups = configs.NewMongoGroupStore(configs.DB)
+}
+
+func groupService() *services.GroupService {
+	return services.NewGroupService(Groups, userService())
+}
+
+// CreateGroup adds a group to the company of the admin
+func CreateGroup() gin.HandlerFunc {
+	log.Info().Msg("Create group endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+		var request models.GroupRequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		if err := validate.Struct(&request); err != nil {
+			log.Error().Err(err).Msg("Error validating request")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		group, err := groupService().Create(ctx, companyId, request.Name)
+		if err != nil {
+			groupServiceError(c, err, "Error creating group")
+			return
+		}
+
+		log.Info().Msg("Group: " + group.Id.Hex() + " created for company: " + companyId.Hex())
+		c.JSON(http.StatusCreated, responses.UserResponse{Status: http.StatusCreated, Message: "success", Data: map[string]interface{}{"group": group}})
+	}
+}
+
+// GetGroups lists the groups of the company of the admin
+func GetGroups() gin.HandlerFunc {
+	log.Info().Msg("Get groups endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		groups, err := groupService().List(ctx, companyId)
+		if err != nil {
+			groupServiceError(c, err, "Error getting groups from database")
+			return
+		}
+
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"groups": groups}})
+	}
+}
+
+// GetGroup returns a group of the company of the admin with its direct members and nested groups
+func GetGroup() gin.HandlerFunc {
+	log.Info().Msg("Get group endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		group, err := groupService().Get(ctx, companyId, c.Param("groupId"))
+		if err != nil {
+			groupServiceError(c, err, "Error getting group from database")
+			return
+		}
+
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"group": group}})
+	}
+}
+
+// RenameGroup changes the name of a group of the company of the admin
+func RenameGroup() gin.HandlerFunc {
+	log.Info().Msg("Rename group endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+		var request models.GroupRequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		if err := validate.Struct(&request); err != nil {
+			log.Error().Err(err).Msg("Error validating request")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		group, err := groupService().Rename(ctx, companyId, c.Param("groupId"), request.Name)
+		if err != nil {
+			groupServiceError(c, err, "Error renaming group")
+			return
+		}
+
+		log.Info().Msg("Group: " + group.Id.Hex() + " renamed")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"group": group}})
+	}
+}
+
+// DeleteGroup removes a group of the company of the admin, its members are kept
+func DeleteGroup() gin.HandlerFunc {
+	log.Info().Msg("Delete group endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		if err := groupService().Delete(ctx, companyId, c.Param("groupId")); err != nil {
+			groupServiceError(c, err, "Error deleting group")
+			return
+		}
+
+		log.Info().Msg("Group: " + c.Param("groupId") + " deleted successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: nil})
+	}
+}
+
+// AddGroupMember adds a user of the company of the admin to a group
+func AddGroupMember() gin.HandlerFunc {
+	log.Info().Msg("Add group member endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+		var request models.GroupMemberRequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		if err := validate.Struct(&request); err != nil {
+			log.Error().Err(err).Msg("Error validating request")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		group, err := groupService().AddMember(ctx, companyId, c.Param("groupId"), request.UserId)
+		if err != nil {
+			groupServiceError(c, err, "Error adding group member")
+			return
+		}
+
+		log.Info().Msg("User: " + request.UserId + " added to group: " + group.Id.Hex())
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"group": group}})
+	}
+}
+
+// RemoveGroupMember takes a user out of a group of the company of the admin
+func RemoveGroupMember() gin.HandlerFunc {
+	log.Info().Msg("Remove group member endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		group, err := groupService().RemoveMember(ctx, companyId, c.Param("groupId"), c.Param("userId"))
+		if err != nil {
+			groupServiceError(c, err, "Error removing group member")
+			return
+		}
+
+		log.Info().Msg("User: " + c.Param("userId") + " removed from group: " + group.Id.Hex())
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"group": group}})
+	}
+}
+
+// NestGroup makes a group of the company of the admin part of another one
+func NestGroup() gin.HandlerFunc {
+	log.Info().Msg("Nest group endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+		var request models.NestedGroupRequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		if err := validate.Struct(&request); err != nil {
+			log.Error().Err(err).Msg("Error validating request")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		group, err := groupService().Nest(ctx, companyId, c.Param("groupId"), request.GroupId)
+		if err != nil {
+			groupServiceError(c, err, "Error nesting group")
+			return
+		}
+
+		log.Info().Msg("Group: " + request.GroupId + " nested in group: " + group.Id.Hex())
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"group": group}})
+	}
+}
+
+// UnnestGroup takes a nested group out of a group of the company of the admin
+func UnnestGroup() gin.HandlerFunc {
+	log.Info().Msg("Unnest group endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		group, err := groupService().Unnest(ctx, companyId, c.Param("groupId"), c.Param("nestedGroupId"))
+		if err != nil {
+			groupServiceError(c, err, "Error unnesting group")
+			return
+		}
+
+		log.Info().Msg("Group: " + c.Param("nestedGroupId") + " unnested from group: " + group.Id.Hex())
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"group": group}})
+	}
+}
+
+// GetUserGroups lists the effective groups of a user of the company of the caller, the ones it is a member of
+// and the ones they are nested in
+func GetUserGroups() gin.HandlerFunc {
+	log.Info().Msg("Get user groups endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+
+		groups, err := groupService().UserGroups(ctx, callerCompany(c), c.Param("userId"))
+		if err != nil {
+			groupServiceError(c, err, "Error getting user groups from database")
+			return
+		}
+
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"groups": groups}})
+	}
+}
+
+// RequireGroup only lets through the users that are effective members of a group of their company with any of
+// the names, it goes after RequireSession
+func RequireGroup(names ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+
+		user := CurrentUser(c)
+		if user == nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "You are not allowed to perform this action", Data: nil})
+			return
+		}
+		member, err := groupService().InGroup(ctx, user, names...)
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting user groups from database")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error getting user groups from database", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		if !member {
+			log.Error().Msg("User: " + user.Id.Hex() + " is not a member of the required groups")
+			c.AbortWithStatusJSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "You are not allowed to perform this action", Data: nil})
+			return
+		}
+		c.Next()
+	}
+}
+
+// groupServiceError answers an error of the group service with its status, message is the one of unexpected errors
+func groupServiceError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrGroupNotFound):
+		log.Error().Msg("Group: " + c.Param("groupId") + " not found")
+		c.JSON(http.StatusNotFound, responses.UserResponse{Status: http.StatusNotFound, Message: "Group not found", Data: nil})
+	case errors.Is(err, services.ErrGroupExists):
+		log.Error().Msg(err.Error())
+		c.JSON(http.StatusConflict, responses.UserResponse{Status: http.StatusConflict, Message: "A group with the name already exists", Data: nil})
+	case errors.Is(err, services.ErrGroupCycle):
+		log.Error().Msg("Group: " + c.Param("groupId") + " would be nested in itself")
+		c.JSON(http.StatusConflict, responses.UserResponse{Status: http.StatusConflict, Message: "A group can not be nested in itself nor in the groups nested in it", Data: nil})
+	default:
+		userServiceError(c, err, message)
+	}
+}
@@ -0,0 +1,355 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MockGroupStore is a mock implementation of the group operations that keeps the groups in a map,
+// nested groups are followed like $graphLookup does
+type MockGroupStore struct {
+	mu     sync.Mutex
+	Groups map[primitive.ObjectID]*models.Group
+}
+
+func NewMockGroupStore() *MockGroupStore {
+	return &MockGroupStore{Groups: map[primitive.ObjectID]*models.Group{}}
+}
+
+func init() {
+	Groups = NewMockGroupStore()
+}
+
+// CreateGroup mocks the creation of a group, names are unique in a company
+func (s *MockGroupStore) CreateGroup(ctx context.Context, group models.Group) (primitive.ObjectID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.Groups {
+		if existing.Company == group.Company && existing.Name == group.Name {
+			return primitive.NilObjectID, configs.ErrGroupExists
+		}
+	}
+	group.Id = primitive.NewObjectID()
+	s.Groups[group.Id] = &group
+	return group.Id, nil
+}
+
+// FindGroup mocks the retrieval of a group of a company
+func (s *MockGroupStore) FindGroup(ctx context.Context, companyId, id primitive.ObjectID) (*models.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if group, found := s.Groups[id]; found && group.Company == companyId {
+		return copyGroup(group), nil
+	}
+	return nil, nil
+}
+
+// FindCompanyGroups mocks the retrieval of the groups of a company
+func (s *MockGroupStore) FindCompanyGroups(ctx context.Context, companyId primitive.ObjectID) ([]*models.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	groups := []*models.Group{}
+	for _, group := range s.Groups {
+		if group.Company == companyId {
+			groups = append(groups, copyGroup(group))
+		}
+	}
+	sortGroups(groups)
+	return groups, nil
+}
+
+// RenameGroup mocks the change of the name of a group
+func (s *MockGroupStore) RenameGroup(ctx context.Context, companyId, id primitive.ObjectID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.Groups {
+		if existing.Company == companyId && existing.Name == name && existing.Id != id {
+			return configs.ErrGroupExists
+		}
+	}
+	return s.update(companyId, id, func(group *models.Group) { group.Name = name })
+}
+
+// DeleteGroup mocks the removal of a group, which is taken out of the groups it was nested in
+func (s *MockGroupStore) DeleteGroup(ctx context.Context, companyId, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if group, found := s.Groups[id]; !found || group.Company != companyId {
+		return mongo.ErrNoDocuments
+	}
+	delete(s.Groups, id)
+	for _, group := range s.Groups {
+		group.Groups = withoutId(group.Groups, id)
+	}
+	return nil
+}
+
+// AddGroupMember mocks the addition of a member to a group
+func (s *MockGroupStore) AddGroupMember(ctx context.Context, companyId, id, userId primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(companyId, id, func(group *models.Group) { group.Members = append(withoutId(group.Members, userId), userId) })
+}
+
+// RemoveGroupMember mocks the removal of a member from a group
+func (s *MockGroupStore) RemoveGroupMember(ctx context.Context, companyId, id, userId primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(companyId, id, func(group *models.Group) { group.Members = withoutId(group.Members, userId) })
+}
+
+// AddNestedGroup mocks the nesting of a group in another one
+func (s *MockGroupStore) AddNestedGroup(ctx context.Context, companyId, id, nestedId primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(companyId, id, func(group *models.Group) { group.Groups = append(withoutId(group.Groups, nestedId), nestedId) })
+}
+
+// RemoveNestedGroup mocks taking a nested group out of a group
+func (s *MockGroupStore) RemoveNestedGroup(ctx context.Context, companyId, id, nestedId primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(companyId, id, func(group *models.Group) { group.Groups = withoutId(group.Groups, nestedId) })
+}
+
+// FindNestedGroups mocks the retrieval of the groups nested in a group at any depth
+func (s *MockGroupStore) FindNestedGroups(ctx context.Context, companyId, id primitive.ObjectID) ([]*models.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, found := s.Groups[id]
+	if !found || group.Company != companyId {
+		return []*models.Group{}, nil
+	}
+	return s.follow(companyId, group.Groups, func(group *models.Group) []primitive.ObjectID { return group.Groups }), nil
+}
+
+// FindUserGroups mocks the retrieval of the groups of a user, directly or through nested groups
+func (s *MockGroupStore) FindUserGroups(ctx context.Context, companyId, userId primitive.ObjectID) ([]*models.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var direct []primitive.ObjectID
+	for _, group := range s.Groups {
+		if group.Company == companyId && containsId(group.Members, userId) {
+			direct = append(direct, group.Id)
+		}
+	}
+	return s.follow(companyId, direct, func(group *models.Group) []primitive.ObjectID {
+		var parents []primitive.ObjectID
+		for _, parent := range s.Groups {
+			if containsId(parent.Groups, group.Id) {
+				parents = append(parents, parent.Id)
+			}
+		}
+		return parents
+	}), nil
+}
+
+// RemoveUserFromGroups mocks the removal of a user from every group
+func (s *MockGroupStore) RemoveUserFromGroups(ctx context.Context, userId primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, group := range s.Groups {
+		group.Members = withoutId(group.Members, userId)
+	}
+	return nil
+}
+
+func (s *MockGroupStore) update(companyId, id primitive.ObjectID, fn func(group *models.Group)) error {
+	group, found := s.Groups[id]
+	if !found || group.Company != companyId {
+		return mongo.ErrNoDocuments
+	}
+	fn(group)
+	return nil
+}
+
+// follow returns the groups of the company reached from start through next, each one once
+func (s *MockGroupStore) follow(companyId primitive.ObjectID, start []primitive.ObjectID, next func(group *models.Group) []primitive.ObjectID) []*models.Group {
+	seen := map[primitive.ObjectID]bool{}
+	groups := []*models.Group{}
+	pending := append([]primitive.ObjectID{}, start...)
+	for len(pending) > 0 {
+		id := pending[0]
+		pending = pending[1:]
+		group, found := s.Groups[id]
+		if seen[id] || !found || group.Company != companyId {
+			continue
+		}
+		seen[id] = true
+		groups = append(groups, copyGroup(group))
+		pending = append(pending, next(group)...)
+	}
+	sortGroups(groups)
+	return groups
+}
+
+func copyGroup(group *models.Group) *models.Group {
+	copied := *group
+	copied.Members = append([]primitive.ObjectID{}, group.Members...)
+	copied.Groups = append([]primitive.ObjectID{}, group.Groups...)
+	return &copied
+}
+
+func sortGroups(groups []*models.Group) {
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+}
+
+func containsId(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+func withoutId(ids []primitive.ObjectID, id primitive.ObjectID) []primitive.ObjectID {
+	kept := []primitive.ObjectID{}
+	for _, candidate := range ids {
+		if candidate != id {
+			kept = append(kept, candidate)
+		}
+	}
+	return kept
+}
+
+// setUpGroups returns the group routes with an admin, a user of its company and a user of another company
+func setUpGroups() (*gin.Engine, string, *models.UserWithCompanyAsObject, *models.UserWithCompanyAsObject, *models.UserWithCompanyAsObject) {
+	Groups = NewMockGroupStore()
+	Sessions = memorySessions()
+	companyId := primitive.NewObjectID()
+	admin := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Jane Admin", Email: "jane@example.com", Role: models.RoleAdmin, Company: companyId, Version: 1}
+	member := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "John Member", Email: "john@example.com", Role: models.RoleUser, Company: companyId, Version: 1}
+	other := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Other Company", Email: "other@example.com", Role: models.RoleUser, Company: primitive.NewObjectID(), Version: 1}
+	DB = memoryUsers(admin, member, other)
+
+	router := gin.Default()
+	groups := router.Group("/companies/:companyId/groups", RequireSession(), RequireRole(models.RoleAdmin))
+	groups.POST("", CreateGroup())
+	groups.GET("", GetGroups())
+	groups.PATCH("/:groupId", RenameGroup())
+	groups.DELETE("/:groupId", DeleteGroup())
+	groups.POST("/:groupId/members", AddGroupMember())
+	groups.DELETE("/:groupId/members/:userId", RemoveGroupMember())
+	groups.POST("/:groupId/groups", NestGroup())
+	groups.DELETE("/:groupId/groups/:nestedGroupId", UnnestGroup())
+	router.GET("/users", RequireSession(), RequireCompanyQuery(), GetUsers())
+	router.GET("/users/:userId/groups", RequireSession(), GetUserGroups())
+	router.DELETE("/users/:userId", RequireSession(), RequireRole(models.RoleAdmin), DeleteUser())
+	router.GET("/engineering", RequireSession(), RequireGroup("Engineering"), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	return router, testSessionToken(admin), admin, member, other
+}
+
+// groupNames returns the names of the groups of a response
+func groupNames(response map[string]interface{}) []string {
+	names := []string{}
+	for _, group := range response["groups"].([]interface{}) {
+		names = append(names, group.(map[string]interface{})["name"].(string))
+	}
+	return names
+}
+
+func TestGroupManagement(t *testing.T) {
+	router, token, admin, member, other := setUpGroups()
+	groups := "/companies/" + admin.Company.Hex() + "/groups"
+
+	resp, response := authRequest(router, "POST", groups, token, models.GroupRequest{Name: "Backend"})
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	backend := response.Data["group"].(map[string]interface{})["_id"].(string)
+	_, response = authRequest(router, "POST", groups, token, models.GroupRequest{Name: "Engineering"})
+	engineering := response.Data["group"].(map[string]interface{})["_id"].(string)
+
+	// Names are unique in the company
+	resp, _ = authRequest(router, "POST", groups, token, models.GroupRequest{Name: "Backend"})
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	resp, _ = authRequest(router, "PATCH", groups+"/"+backend, token, models.GroupRequest{Name: "Engineering"})
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	resp, response = authRequest(router, "PATCH", groups+"/"+backend, token, models.GroupRequest{Name: "Platform"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "Platform", response.Data["group"].(map[string]interface{})["name"])
+
+	// Only users of the company can be members
+	resp, _ = authRequest(router, "POST", groups+"/"+backend+"/members", token, models.GroupMemberRequest{UserId: other.Id.Hex()})
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp, response = authRequest(router, "POST", groups+"/"+backend+"/members", token, models.GroupMemberRequest{UserId: member.Id.Hex()})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, []interface{}{member.Id.Hex()}, response.Data["group"].(map[string]interface{})["members"])
+
+	// The members of a nested group are members of the groups it is nested in, which can not be nested in it
+	resp, _ = authRequest(router, "POST", groups+"/"+engineering+"/groups", token, models.NestedGroupRequest{GroupId: backend})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp, _ = authRequest(router, "POST", groups+"/"+backend+"/groups", token, models.NestedGroupRequest{GroupId: engineering})
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	resp, _ = authRequest(router, "POST", groups+"/"+backend+"/groups", token, models.NestedGroupRequest{GroupId: backend})
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	resp, response = authRequest(router, "GET", "/users/"+member.Id.Hex()+"/groups", token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, []string{"Engineering", "Platform"}, groupNames(response.Data))
+	resp, _ = authRequest(router, "GET", "/users/"+other.Id.Hex()+"/groups", token, nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	_, response = authRequest(router, "GET", "/users?company="+admin.Company.Hex()+"&group="+engineering, token, nil)
+	users := response.Data["users"].([]interface{})
+	assert.Len(t, users, 1)
+	assert.Equal(t, member.Id.Hex(), users[0].(map[string]interface{})["_id"])
+	resp, _ = authRequest(router, "GET", "/users?company="+admin.Company.Hex()+"&group="+primitive.NewObjectID().Hex(), token, nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp, _ = authRequest(router, "DELETE", groups+"/"+engineering+"/groups/"+backend, token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	_, response = authRequest(router, "GET", "/users?company="+admin.Company.Hex()+"&group="+engineering, token, nil)
+	assert.Empty(t, response.Data["users"])
+
+	resp, _ = authRequest(router, "DELETE", groups+"/"+backend+"/members/"+member.Id.Hex(), token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp, _ = authRequest(router, "DELETE", groups+"/"+backend, token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	_, response = authRequest(router, "GET", groups, token, nil)
+	assert.Equal(t, []string{"Engineering"}, groupNames(response.Data))
+
+	// Groups of other companies can not be reached
+	resp, _ = authRequest(router, "GET", "/companies/"+other.Company.Hex()+"/groups", token, nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+}
+
+func TestRequireGroup(t *testing.T) {
+	router, token, admin, member, _ := setUpGroups()
+	engineering, _ := groupService().Create(context.Background(), admin.Company, "Engineering")
+	backend, _ := groupService().Create(context.Background(), admin.Company, "Backend")
+	groupService().Nest(context.Background(), admin.Company, engineering.Id.Hex(), backend.Id.Hex())
+	memberToken := testSessionToken(member)
+
+	resp, _ := authRequest(router, "GET", "/engineering", memberToken, nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	// Members of a nested group pass
+	groupService().AddMember(context.Background(), admin.Company, backend.Id.Hex(), member.Id.Hex())
+	resp, _ = authRequest(router, "GET", "/engineering", memberToken, nil)
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	resp, _ = authRequest(router, "GET", "/engineering", token, nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+}
+
+func TestDeleteUserRemovesGroupMemberships(t *testing.T) {
+	router, token, admin, member, _ := setUpGroups()
+	group, _ := groupService().Create(context.Background(), admin.Company, "Backend")
+	groupService().AddMember(context.Background(), admin.Company, group.Id.Hex(), member.Id.Hex())
+	groupService().AddMember(context.Background(), admin.Company, group.Id.Hex(), admin.Id.Hex())
+
+	resp, _ := authRequest(router, "DELETE", "/users/"+member.Id.Hex(), token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	group, _ = groupService().Get(context.Background(), admin.Company, group.Id.Hex())
+	assert.Equal(t, []primitive.ObjectID{admin.Id}, group.Members)
+}
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"user-service/internal/models"
+	userv1 "user-service/internal/pb/user/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type grpcAPIKeyKey struct{}
+
+// grpcScopes are the API key scopes the methods of user.v1.UserService require, reads need users:read
+var grpcScopes = map[string]string{
+	userv1.UserService_CreateUser_FullMethodName: models.APIKeyScopeUsersWrite,
+}
+
+// GRPCAuthUnaryInterceptor authenticates the unary calls to user.v1.UserService, see grpcAuthenticate
+func GRPCAuthUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := grpcAuthenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// GRPCAuthStreamInterceptor authenticates the streaming calls to user.v1.UserService, see grpcAuthenticate
+func GRPCAuthStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := grpcAuthenticate(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authenticatedStream is a server stream whose context carries the API key of the call
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcAuthenticate requires an API key of a company on the authorization metadata as a bearer token, or on
+// x-api-key, with the scope of the method and from its allowed IPs. The calls can only reach the users of its company.
+// Health checks and reflection are not part of user.v1.UserService and need no key
+func grpcAuthenticate(ctx context.Context, method string) (context.Context, error) {
+	if !strings.HasPrefix(method, "/"+userv1.UserService_ServiceDesc.ServiceName+"/") {
+		return ctx, nil
+	}
+	scope, found := grpcScopes[method]
+	if !found {
+		scope = models.APIKeyScopeUsersRead
+	}
+
+	token := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if keys := md.Get("x-api-key"); len(keys) > 0 {
+			token = keys[0]
+		} else if values := md.Get("authorization"); len(values) > 0 {
+			token = strings.TrimPrefix(values[0], "Bearer ")
+		}
+	}
+	if !strings.HasPrefix(token, models.APIKeyPrefix) {
+		return nil, status.Error(codes.Unauthenticated, "An API key is required")
+	}
+
+	apiKey, code, message := authenticateAPIKey(grpcPeerIP(ctx), token, scope)
+	if apiKey == nil {
+		switch code {
+		case http.StatusUnauthorized:
+			return nil, status.Error(codes.Unauthenticated, message)
+		case http.StatusForbidden:
+			return nil, status.Error(codes.PermissionDenied, message)
+		}
+		return nil, status.Error(codes.Internal, message)
+	}
+	return context.WithValue(ctx, grpcAPIKeyKey{}, apiKey), nil
+}
+
+// grpcAPIKey returns the API key grpcAuthenticate authenticated the call with
+func grpcAPIKey(ctx context.Context) *models.APIKey {
+	apiKey, _ := ctx.Value(grpcAPIKeyKey{}).(*models.APIKey)
+	return apiKey
+}
+
+// grpcPeerIP returns the address the call comes from, gRPC is not served behind proxies
+func grpcPeerIP(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+	}
+	return ""
+}
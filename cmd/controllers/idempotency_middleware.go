@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// idempotencyKeyMaxLength is the longest key accepted
+	idempotencyKeyMaxLength = 255
+	// idempotencyTTL is how long a response can be replayed
+	idempotencyTTL = 24 * time.Hour
+	// idempotencyLockTTL is how long a request that never finished, like after a crash, blocks its key
+	idempotencyLockTTL = time.Minute
+)
+
+var IdempotencyKeys configs.IdempotencyStore
+
+func init() {
+	if configs.EnvIdempotencyStore() == "memory" {
+		IdempotencyKeys = configs.NewMemoryIdempotencyStore()
+	} else {
+		IdempotencyKeys = configs.NewMongoIdempotencyStore(configs.DB)
+	}
+}
+
+// idempotencyWriter keeps a copy of the response so it can be replayed
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyWriter) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// Idempotent makes retries of a request with the same Idempotency-Key header return the first response
+// instead of running it again. Keys are scoped to the caller, the same key with another body answers 422, and
+// requests without the header run as usual. Server errors are not stored so they can be retried. The status and
+// body are replayed as they were written, so handlers must not answer with data that should not be kept
+func Idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > idempotencyKeyMaxLength {
+			log.Error().Msg("Idempotency key is too long")
+			c.AbortWithStatusJSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": "Idempotency-Key can not be longer than 255 characters"}})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			log.Error().Err(err).Msg("Error reading request body")
+			c.AbortWithStatusJSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "Error reading request body", Data: nil})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		now := time.Now()
+		record := models.IdempotencyRecord{
+			Id:          idempotencyRecordId(c, key),
+			Fingerprint: hashIdempotentRequest(c, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(idempotencyLockTTL),
+		}
+		existing, err := IdempotencyKeys.StartIdempotentRequest(ctx, record)
+		cancel()
+		if err != nil {
+			log.Error().Err(err).Msg("Error storing idempotency key")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error storing idempotency key", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		if existing != nil {
+			replayIdempotentRequest(c, existing, record.Fingerprint)
+			return
+		}
+
+		writer := &idempotencyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		// The handler may have used most of the time of the first context, the key gets a context of its own so it
+		// is not left locked
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		status := writer.Status()
+		if status >= http.StatusInternalServerError {
+			err = IdempotencyKeys.DeleteIdempotentRequest(ctx, record.Id)
+		} else {
+			err = IdempotencyKeys.CompleteIdempotentRequest(ctx, record.Id, status, writer.body.Bytes(), writer.Header().Get("Content-Type"), time.Now().Add(idempotencyTTL))
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Error storing idempotent response")
+		}
+	}
+}
+
+// replayIdempotentRequest answers with the stored response, or an error when the request is not the same or did not finish
+func replayIdempotentRequest(c *gin.Context, record *models.IdempotencyRecord, fingerprint string) {
+	switch {
+	case record.Fingerprint != fingerprint:
+		log.Error().Msg("Idempotency key reused with a different request")
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, responses.UserResponse{Status: http.StatusUnprocessableEntity, Message: "Idempotency-Key was already used with a different request", Data: nil})
+	case record.Status == 0:
+		log.Error().Msg("Idempotency key reused while the first request is running")
+		c.AbortWithStatusJSON(http.StatusConflict, responses.UserResponse{Status: http.StatusConflict, Message: "A request with this Idempotency-Key is still being processed", Data: nil})
+	default:
+		log.Info().Msg("Replaying idempotent response")
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(record.Status, record.ContentType, record.Body)
+		c.Abort()
+	}
+}
+
+// idempotencyRecordId scopes the key to the route and the caller, identified by its credentials or by its IP when it sends none
+func idempotencyRecordId(c *gin.Context, key string) string {
+	caller := c.GetHeader("X-API-Key")
+	if caller == "" {
+		caller = bearerToken(c)
+	}
+	if caller != "" {
+		caller = auth.HashToken(caller)
+	} else {
+		caller = "ip:" + c.ClientIP()
+	}
+	return c.Request.Method + " " + c.FullPath() + " " + caller + " " + key
+}
+
+// hashIdempotentRequest identifies the request a key was used with. JSON bodies are compared by their content,
+// so a retry that orders the fields or spaces them in another way is the same request
+func hashIdempotentRequest(c *gin.Context, body []byte) string {
+	var content interface{}
+	if err := json.Unmarshal(body, &content); err == nil {
+		body, _ = json.Marshal(content)
+	}
+	hash := sha256.New()
+	io.WriteString(hash, c.Request.Method+" "+c.Request.URL.RequestURI()+"\n")
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// idempotentRequest creates a user with the key and the raw JSON body
+func idempotentRequest(router *gin.Engine, key, token, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/users", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+// idempotencyTestRouter counts the users created, CreateUser fails with a server error while failing is set.
+// Emails are never taken, so the same body can create several users
+func idempotencyTestRouter(created *int32, failing *atomic.Bool) *gin.Engine {
+	IdempotencyKeys = configs.NewMemoryIdempotencyStore()
+	users := memoryUsers()
+	users.FindUsersByEmailFunc = func(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+		return []*models.UserWithCompanyAsObject{}, nil
+	}
+	createUser := users.CreateUserFunc
+	users.CreateUserFunc = func(ctx context.Context, user models.UserWithCompanyAsObject) (primitive.ObjectID, error) {
+		if failing != nil && failing.Load() {
+			return primitive.NilObjectID, assert.AnError
+		}
+		atomic.AddInt32(created, 1)
+		return createUser(ctx, user)
+	}
+	DB = users
+	router := gin.Default()
+	router.POST("/users", asAdmin(mustObjectId("649060d540e3b169621e9629")), Idempotent(), CreateUser())
+	return router
+}
+
+// completionIdempotencyStore keeps in memory what the last completed request stored
+type completionIdempotencyStore struct {
+	*configs.MemoryIdempotencyStore
+	completed models.IdempotencyRecord
+}
+
+func (s *completionIdempotencyStore) CompleteIdempotentRequest(ctx context.Context, id string, status int, body []byte, contentType string, expiresAt time.Time) error {
+	s.completed = models.IdempotencyRecord{Id: id, Status: status, Body: body, ContentType: contentType, ExpiresAt: expiresAt}
+	return s.MemoryIdempotencyStore.CompleteIdempotentRequest(ctx, id, status, body, contentType, expiresAt)
+}
+
+const idempotentUserBody = `{"name": "Jane Smith", "email": "jane@example.com", "password": "Tr0ub4dor&3x", "role": "user", "company": "649060d540e3b169621e9629"}`
+
+func TestIdempotentCreateUserReplaysResponse(t *testing.T) {
+	var created int32
+	router := idempotencyTestRouter(&created, nil)
+
+	first := idempotentRequest(router, "retry-1", "", idempotentUserBody)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.NotContains(t, first.Body.String(), "password")
+
+	// The same request, even with its fields in another order, gets the first response without creating the user again
+	reordered := `{"company":"649060d540e3b169621e9629","role":"user","password":"Tr0ub4dor&3x","email":"jane@example.com","name":"Jane Smith"}`
+	replay := idempotentRequest(router, "retry-1", "", reordered)
+	assert.Equal(t, http.StatusCreated, replay.Code)
+	assert.Equal(t, first.Body.String(), replay.Body.String())
+	assert.Equal(t, "application/json; charset=utf-8", replay.Header().Get("Content-Type"))
+	assert.Equal(t, "true", replay.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, int32(1), created)
+
+	// Another body with the same key is rejected
+	other := idempotentRequest(router, "retry-1", "", `{"name": "John Smith", "email": "john@example.com", "password": "Tr0ub4dor&3x", "role": "user", "company": "649060d540e3b169621e9629"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, other.Code)
+
+	// Keys are scoped to the caller, and requests without a key are not deduplicated
+	assert.Equal(t, http.StatusCreated, idempotentRequest(router, "retry-1", "other-caller-token", idempotentUserBody).Code)
+	assert.Equal(t, http.StatusCreated, idempotentRequest(router, "", "", idempotentUserBody).Code)
+	assert.Equal(t, int32(3), created)
+}
+
+func TestIdempotentCreateUserStoresTheResponse(t *testing.T) {
+	var created int32
+	router := idempotencyTestRouter(&created, nil)
+	store := &completionIdempotencyStore{MemoryIdempotencyStore: configs.NewMemoryIdempotencyStore()}
+	IdempotencyKeys = store
+	first := idempotentRequest(router, "retry-4", "", idempotentUserBody)
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	// The stored record has the response as it was written, without the password
+	assert.Equal(t, http.StatusCreated, store.completed.Status)
+	assert.Equal(t, first.Body.String(), string(store.completed.Body))
+	assert.NotContains(t, string(store.completed.Body), "Tr0ub4dor&3x")
+	var response responses.UserResponse
+	json.NewDecoder(bytes.NewReader(store.completed.Body)).Decode(&response)
+	id := response.Data["user"].(map[string]interface{})["_id"].(string)
+
+	// Changes to the user since do not change the replay
+	name := "Jane Doe"
+	DB.UpdateUser(context.Background(), mustObjectId(id), 0, models.UserUpdate{Name: &name})
+	DB.DeleteUser(context.Background(), mustObjectId(id))
+	replay := idempotentRequest(router, "retry-4", "", idempotentUserBody)
+	assert.Equal(t, http.StatusCreated, replay.Code)
+	assert.Equal(t, first.Body.String(), replay.Body.String())
+	assert.Equal(t, int32(1), created)
+}
+
+func TestIdempotentCreateUserRetriesServerErrors(t *testing.T) {
+	var created int32
+	var failing atomic.Bool
+	failing.Store(true)
+	router := idempotencyTestRouter(&created, &failing)
+
+	assert.Equal(t, http.StatusInternalServerError, idempotentRequest(router, "retry-2", "", idempotentUserBody).Code)
+	failing.Store(false)
+	assert.Equal(t, http.StatusCreated, idempotentRequest(router, "retry-2", "", idempotentUserBody).Code)
+	assert.Equal(t, int32(1), created)
+}
+
+func TestIdempotentRequestInProgress(t *testing.T) {
+	IdempotencyKeys = configs.NewMemoryIdempotencyStore()
+	started, release := make(chan struct{}), make(chan struct{})
+	router := gin.Default()
+	router.POST("/users", Idempotent(), func(c *gin.Context) {
+		close(started)
+		<-release
+		c.JSON(http.StatusCreated, gin.H{})
+	})
+
+	done := make(chan int)
+	go func() { done <- idempotentRequest(router, "retry-3", "", idempotentUserBody).Code }()
+	<-started
+
+	// A retry while the first request is still running does not run it twice
+	assert.Equal(t, http.StatusConflict, idempotentRequest(router, "retry-3", "", idempotentUserBody).Code)
+	close(release)
+	assert.Equal(t, http.StatusCreated, <-done)
+	assert.Equal(t, http.StatusCreated, idempotentRequest(router, "retry-3", "", idempotentUserBody).Code)
+}
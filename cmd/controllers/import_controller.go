@@ -0,0 +1,445 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+	"user-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	importFormatCSV    = "csv"
+	importFormatNDJSON = "ndjson"
+
+	// importBatchSize is how many users are inserted on each CreateUsers call
+	importBatchSize = 100
+	// importMaxBodyBytes limits the size of the uploaded file
+	importMaxBodyBytes = 10 << 20
+	// importTimeout is how long an import answered on the same request can take, passwords are slow to hash on purpose
+	importTimeout = time.Minute
+	// importJobTimeout is how long a background import can take
+	importJobTimeout = time.Hour
+	// importJobTTL is how long the report of a background import is kept
+	importJobTTL = 7 * 24 * time.Hour
+)
+
+var (
+	ImportJobs configs.ImportJobStore
+	// importBackgroundRows is the amount of rows from which an import runs as a background job
+	importBackgroundRows = 100
+)
+
+// importCSVColumns are the columns a CSV file must have on its header, in any order
+var importCSVColumns = []string{"name", "email", "password", "role"}
+
+// importCSVAttributePrefix starts the names of the optional CSV columns with the custom attributes of the users
+const importCSVAttributePrefix = "attributes."
+
+func init() {
+	ImportJobs = configs.NewMongoImportJobStore(configs.DB)
+}
+
+// importRow is a parsed row of an import file, Err is set when the row could not be read
+type importRow struct {
+	Row  int
+	User models.User
+	// Attributes has the text of the attribute columns of a CSV row, parsed to the types of the attribute schema
+	Attributes map[string]string
+	Err        error
+}
+
+// ImportUsers creates the users of a CSV or NDJSON file in the company of the admin.
+// Small files are answered with the report, larger ones return a job to poll
+func ImportUsers() gin.HandlerFunc {
+	log.Info().Msg("Import users endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), importTimeout)
+		defer cancel()
+
+		admin := CurrentUser(c)
+		companyId, ok := adminCompany(c, admin)
+		if !ok {
+			return
+		}
+		dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+
+		format := importFormat(c)
+		if format == "" {
+			log.Error().Msg("Unsupported import format: " + c.ContentType())
+			c.JSON(http.StatusUnsupportedMediaType, responses.UserResponse{Status: http.StatusUnsupportedMediaType, Message: "The file must be CSV or NDJSON", Data: nil})
+			return
+		}
+
+		rows, err := parseImportRows(format, http.MaxBytesReader(c.Writer, c.Request.Body, importMaxBodyBytes))
+		if err != nil {
+			log.Error().Err(err).Msg("Error reading import file")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "Error reading import file", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		if len(rows) == 0 {
+			log.Error().Msg("Import file without rows")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "The import file has no rows", Data: nil})
+			return
+		}
+
+		// The company policy is read once for every row
+		policyCtx := userService().PasswordPolicyContext(context.Background(), companyId.Hex())
+
+		if len(rows) >= importBackgroundRows {
+			now := time.Now()
+			job := models.ImportJob{
+				Company:   companyId,
+				CreatedBy: admin.Id,
+				Status:    models.ImportJobPending,
+				DryRun:    dryRun,
+				Total:     len(rows),
+				CreatedAt: now,
+				ExpiresAt: now.Add(importJobTTL),
+			}
+			job.Id, err = ImportJobs.CreateImportJob(ctx, job)
+			if err != nil {
+				log.Error().Err(err).Msg("Error storing import job on database")
+				c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error starting import", Data: map[string]interface{}{"data": err.Error()}})
+				return
+			}
+			go runImportJob(policyCtx, configs.AuditInfoFromContext(ctx), job, rows)
+
+			log.Info().Msg("Import job: " + job.Id.Hex() + " started for company: " + companyId.Hex())
+			c.JSON(http.StatusAccepted, responses.UserResponse{Status: http.StatusAccepted, Message: "import started", Data: map[string]interface{}{"job": job}})
+			return
+		}
+
+		report := importUsers(ctx, policyCtx, companyId, rows, dryRun, nil)
+		log.Info().Msg(fmt.Sprintf("Import for company: %s finished, %d created, %d invalid", companyId.Hex(), report.Created, report.Invalid))
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"report": report}})
+	}
+}
+
+// GetImportJob returns the progress of a background import, and its report once it finished
+func GetImportJob() gin.HandlerFunc {
+	log.Info().Msg("Get import job endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		jobId, _ := primitive.ObjectIDFromHex(c.Param("jobId"))
+		job, err := ImportJobs.FindImportJob(ctx, companyId, jobId)
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting import job from database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error getting import job from database", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		if job == nil {
+			log.Error().Msg("Import job: " + c.Param("jobId") + " not found")
+			c.JSON(http.StatusNotFound, responses.UserResponse{Status: http.StatusNotFound, Message: "Import job not found", Data: nil})
+			return
+		}
+
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"job": job}})
+	}
+}
+
+// runImportJob imports the rows of a background job recording its progress, the users are audited as created by auditInfo
+func runImportJob(policyCtx context.Context, auditInfo configs.AuditInfo, job models.ImportJob, rows []importRow) {
+	ctx, cancel := context.WithTimeout(configs.WithAuditInfo(context.Background(), auditInfo), importJobTimeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Msg(fmt.Sprintf("Import job: %s panicked: %v", job.Id.Hex(), r))
+			if err := ImportJobs.FinishImportJob(ctx, job.Id, models.ImportJobFailed, nil, "unexpected error"); err != nil {
+				log.Error().Err(err).Msg("Error updating import job on database")
+			}
+		}
+	}()
+
+	progress := func(processed int) {
+		if err := ImportJobs.UpdateImportJobProgress(ctx, job.Id, models.ImportJobRunning, processed); err != nil {
+			log.Error().Err(err).Msg("Error updating import job progress")
+		}
+	}
+	progress(0)
+
+	report := importUsers(ctx, policyCtx, job.Company, rows, job.DryRun, progress)
+	if err := ImportJobs.FinishImportJob(ctx, job.Id, models.ImportJobCompleted, report, ""); err != nil {
+		log.Error().Err(err).Msg("Error updating import job on database")
+		return
+	}
+	log.Info().Msg(fmt.Sprintf("Import job: %s finished, %d created, %d invalid", job.Id.Hex(), report.Created, report.Invalid))
+}
+
+// importUsers validates every row like POST /users does and inserts the valid ones in batches.
+// Emails that already exist, or that appear earlier on the file, are skipped as duplicates
+func importUsers(ctx, policyCtx context.Context, companyId primitive.ObjectID, rows []importRow, dryRun bool, progress func(processed int)) *models.ImportReport {
+	ctx = configs.WithTenant(ctx, companyId)
+	results := make([]models.ImportRowResult, len(rows))
+	seen := map[string]bool{}
+	var batch []models.UserWithCompanyAsObject
+	var batchRows []int
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ids, err := DB.CreateUsers(ctx, batch)
+		if err != nil {
+			log.Error().Err(err).Msg("Error storing imported users on database")
+		}
+		for i, index := range batchRows {
+			if i < len(ids) && !ids[i].IsZero() {
+				results[index].Status = models.ImportRowCreated
+				results[index].UserId = ids[i].Hex()
+				if batch[i].InvitationPending {
+					batch[i].Id = ids[i]
+					userService().Invite(ctx, &batch[i])
+				}
+			} else {
+				results[index].Status = models.ImportRowFailed
+				results[index].Error = "error storing user on database"
+			}
+		}
+		batch, batchRows = nil, nil
+	}
+
+	for i, row := range rows {
+		row.User.Company = companyId.Hex()
+		results[i] = models.ImportRowResult{Row: row.Row, Email: row.User.Email}
+		var invite bool
+		results[i].Status, results[i].Error, results[i].Password, invite = importRowStatus(ctx, policyCtx, &row, seen)
+
+		if results[i].Status == models.ImportRowValid && !dryRun {
+			passwordHash, err := auth.HashPassword(row.User.Password)
+			if err != nil {
+				log.Error().Err(err).Msg("Error hashing password")
+				results[i].Status, results[i].Error = models.ImportRowFailed, "error hashing password"
+				continue
+			}
+			batch = append(batch, models.UserWithCompanyAsObject{
+				Name:       row.User.Name,
+				Email:      row.User.Email,
+				Password:   passwordHash,
+				Role:       row.User.Role,
+				Company:    companyId,
+				Attributes: row.User.Attributes,
+				// Emails of other companies keep the credentials of their person, who has to accept the invitation
+				InvitationPending: invite,
+			})
+			batchRows = append(batchRows, i)
+		}
+
+		if (i+1)%importBatchSize == 0 {
+			flush()
+			if progress != nil {
+				progress(i + 1)
+			}
+		}
+	}
+	flush()
+
+	report := &models.ImportReport{DryRun: dryRun, Total: len(rows), Rows: make([]models.ImportRowResult, 0, len(rows))}
+	for _, result := range results {
+		report.Add(result)
+	}
+	return report
+}
+
+// importRowStatus checks a row before it is inserted, returning valid or why it can not be, and whether the email
+// is in other companies so its person has to accept the invitation. Those rows are reported like any other valid row.
+// The attributes of the row are checked against the attribute schema of the company and left on its user
+func importRowStatus(ctx, policyCtx context.Context, row *importRow, seen map[string]bool) (string, string, []string, bool) {
+	if row.Err != nil {
+		return models.ImportRowInvalid, row.Err.Error(), nil, false
+	}
+	if err := userService().ValidateUser(policyCtx, row.User); err != nil {
+		var policyErr *auth.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return models.ImportRowInvalid, err.Error(), policyErr.Reasons, false
+		}
+		return models.ImportRowInvalid, err.Error(), nil, false
+	}
+	companyId, _ := primitive.ObjectIDFromHex(row.User.Company)
+	if status, message := importRowAttributes(ctx, companyId, row); status != "" {
+		return status, message, nil, false
+	}
+
+	email := strings.ToLower(row.User.Email)
+	if seen[email] {
+		return models.ImportRowDuplicate, "email is repeated on the file", nil, false
+	}
+	seen[email] = true
+
+	invite, err := userService().NeedsInvitation(ctx, companyId, row.User.Email)
+	var existsErr *services.UserExistsError
+	if errors.As(err, &existsErr) {
+		return models.ImportRowDuplicate, "user already exists", nil, false
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting a user from database with email: " + row.User.Email)
+		return models.ImportRowFailed, "error getting user from database", nil, false
+	}
+	return models.ImportRowValid, "", nil, invite
+}
+
+// importRowAttributes parses the attribute columns of the row and checks its attributes like POST /users does, it
+// returns the status and error of the row when they are not valid
+func importRowAttributes(ctx context.Context, companyId primitive.ObjectID, row *importRow) (string, string) {
+	var validationErr *services.ValidationError
+	if len(row.Attributes) > 0 {
+		attributes, err := userService().ParseAttributes(ctx, companyId, row.Attributes)
+		if errors.As(err, &validationErr) {
+			return models.ImportRowInvalid, err.Error()
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting the attribute schema of company: " + companyId.Hex())
+			return models.ImportRowFailed, "error getting attribute schema"
+		}
+		row.User.Attributes = attributes
+	}
+	attributes, err := userService().NewUserAttributes(ctx, companyId, row.User.Attributes)
+	if errors.As(err, &validationErr) {
+		return models.ImportRowInvalid, err.Error()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting the attribute schema of company: " + companyId.Hex())
+		return models.ImportRowFailed, "error getting attribute schema"
+	}
+	if len(attributes) == 0 {
+		attributes = nil
+	}
+	row.User.Attributes = attributes
+	return "", ""
+}
+
+// importFormat returns the format of the file from the format query parameter or the Content-Type
+func importFormat(c *gin.Context) string {
+	switch strings.ToLower(c.Query("format")) {
+	case importFormatCSV:
+		return importFormatCSV
+	case importFormatNDJSON:
+		return importFormatNDJSON
+	}
+	switch c.ContentType() {
+	case "text/csv":
+		return importFormatCSV
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return importFormatNDJSON
+	}
+	return ""
+}
+
+// parseImportRows reads the rows of a file, errors are only returned when the file as a whole can not be read
+func parseImportRows(format string, body io.Reader) ([]importRow, error) {
+	if format == importFormatCSV {
+		return parseCSVRows(body)
+	}
+	return parseNDJSONRows(body)
+}
+
+// parseCSVRows reads a CSV file whose header has the name, email, password and role columns, rows are numbered from the first record after the header
+func parseCSVRows(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	// Attribute columns are named attributes.<name>, the name keeps its case
+	attributeColumns := map[string]int{}
+	for i, column := range header {
+		column = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		if name, found := strings.CutPrefix(column, importCSVAttributePrefix); found {
+			attributeColumns[name] = i
+			continue
+		}
+		columns[strings.ToLower(column)] = i
+	}
+	for _, column := range importCSVColumns {
+		if _, found := columns[column]; !found {
+			return nil, fmt.Errorf("missing column %q on the header", column)
+		}
+	}
+
+	value := func(record []string, column string) string {
+		if i := columns[column]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []importRow
+	for number := 1; ; number++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, importRow{Row: number, Err: err})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := importRow{Row: number, User: models.User{
+			Name:     value(record, "name"),
+			Email:    value(record, "email"),
+			Password: value(record, "password"),
+			Role:     value(record, "role"),
+		}}
+		if len(attributeColumns) > 0 {
+			row.Attributes = map[string]string{}
+			for name, i := range attributeColumns {
+				if i < len(record) {
+					row.Attributes[name] = strings.TrimSpace(record[i])
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// parseNDJSONRows reads a file with a user JSON object on each line, rows are numbered by line and empty lines are ignored
+func parseNDJSONRows(body io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []importRow
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		row := importRow{Row: number}
+		if err := json.Unmarshal([]byte(line), &row.User); err != nil {
+			row.Err = err
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
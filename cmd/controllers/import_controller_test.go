@@ -0,0 +1,312 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MockImportJobStore is a mock implementation of the import job operations that keeps the jobs in a map
+type MockImportJobStore struct {
+	mutex sync.Mutex
+	jobs  map[primitive.ObjectID]models.ImportJob
+}
+
+func NewMockImportJobStore() *MockImportJobStore {
+	return &MockImportJobStore{jobs: map[primitive.ObjectID]models.ImportJob{}}
+}
+
+// CreateImportJob mocks the creation of an import job
+func (s *MockImportJobStore) CreateImportJob(ctx context.Context, job models.ImportJob) (primitive.ObjectID, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job.Id = primitive.NewObjectID()
+	s.jobs[job.Id] = job
+	return job.Id, nil
+}
+
+// FindImportJob mocks the retrieval of an import job of a company
+func (s *MockImportJobStore) FindImportJob(ctx context.Context, companyId, id primitive.ObjectID) (*models.ImportJob, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job, found := s.jobs[id]
+	if !found || job.Company != companyId {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+// UpdateImportJobProgress mocks the update of the progress of an import job
+func (s *MockImportJobStore) UpdateImportJobProgress(ctx context.Context, id primitive.ObjectID, status string, processed int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job := s.jobs[id]
+	job.Status, job.Processed = status, processed
+	s.jobs[id] = job
+	return nil
+}
+
+// FinishImportJob mocks the end of an import job
+func (s *MockImportJobStore) FinishImportJob(ctx context.Context, id primitive.ObjectID, status string, report *models.ImportReport, jobErr string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job := s.jobs[id]
+	job.Status, job.Report, job.Error = status, report, jobErr
+	if report != nil {
+		job.Processed = report.Total
+	}
+	s.jobs[id] = job
+	return nil
+}
+
+func init() {
+	ImportJobs = NewMockImportJobStore()
+}
+
+// testSessionToken returns the token of a full session of the user
+func testSessionToken(user *models.UserWithCompanyAsObject) string {
+	ginContext, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginContext.Request = httptest.NewRequest("POST", "/", nil)
+	token, _, _ := createSession(context.Background(), ginContext, user, "", sessionTTL)
+	return token
+}
+
+// importRequest uploads a file to the import endpoint of the company
+func importRequest(router *gin.Engine, companyId primitive.ObjectID, token, contentType, query, body string) (int, responses.UserResponse) {
+	req, _ := http.NewRequest("POST", "/companies/"+companyId.Hex()+"/users/import"+query, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	var response responses.UserResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	return resp.Code, response
+}
+
+// importReport converts the report of a response back to its type
+func importReport(t *testing.T, data interface{}) models.ImportReport {
+	var report models.ImportReport
+	encoded, _ := json.Marshal(data)
+	assert.NoError(t, json.Unmarshal(encoded, &report))
+	return report
+}
+
+func TestImportUsersCSV(t *testing.T) {
+	router := gin.Default()
+	Sessions = memorySessions()
+
+	admin, mockDB := loginTestUser("Tr0ub4dor&3x")
+	var created []models.UserWithCompanyAsObject
+	mockDB.CreateUsersFunc = func(ctx context.Context, users []models.UserWithCompanyAsObject) ([]primitive.ObjectID, error) {
+		created = append(created, users...)
+		ids := make([]primitive.ObjectID, len(users))
+		for i := range users {
+			ids[i] = primitive.NewObjectID()
+		}
+		return ids, nil
+	}
+	DB = mockDB
+	token := testSessionToken(admin)
+
+	// Set up the route
+	router.POST("/companies/:companyId/users/import", RequireSession(), RequireRole(models.RoleAdmin), ImportUsers())
+
+	file := strings.Join([]string{
+		"Email,Name,Password,Role",
+		"jane@example.com,Jane Smith,Tr0ub4dor&3x,user",
+		"weak@example.com,Weak Password,password,user",
+		"JANE@example.com,Jane Again,Tr0ub4dor&3x,user",
+		admin.Email + ",Test User,Tr0ub4dor&3x,admin",
+		"noname@example.com,,Tr0ub4dor&3x,user",
+	}, "\n")
+	code, response := importRequest(router, admin.Company, token, "text/csv", "", file)
+	assert.Equal(t, http.StatusOK, code)
+
+	report := importReport(t, response.Data["report"])
+	assert.Equal(t, 5, report.Total)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 2, report.Duplicates)
+	assert.Equal(t, 2, report.Invalid)
+
+	statuses := []string{}
+	for _, row := range report.Rows {
+		statuses = append(statuses, row.Status)
+	}
+	assert.Equal(t, []string{models.ImportRowCreated, models.ImportRowInvalid, models.ImportRowDuplicate, models.ImportRowDuplicate, models.ImportRowInvalid}, statuses)
+	assert.Contains(t, report.Rows[1].Password, "is too common")
+
+	// Users are created in the company of the path with hashed passwords
+	assert.Len(t, created, 1)
+	assert.Equal(t, admin.Company, created[0].Company)
+	assert.True(t, auth.CheckPassword(created[0].Password, "Tr0ub4dor&3x"))
+	assert.Equal(t, created[0].Email, report.Rows[0].Email)
+}
+
+func TestImportUsersNDJSONDryRun(t *testing.T) {
+	router := gin.Default()
+	Sessions = memorySessions()
+
+	admin, mockDB := loginTestUser("Tr0ub4dor&3x")
+	mockDB.CreateUsersFunc = func(ctx context.Context, users []models.UserWithCompanyAsObject) ([]primitive.ObjectID, error) {
+		t.Fatal("dry runs must not create users")
+		return nil, nil
+	}
+	DB = mockDB
+	token := testSessionToken(admin)
+
+	// Set up the route
+	router.POST("/companies/:companyId/users/import", RequireSession(), RequireRole(models.RoleAdmin), ImportUsers())
+
+	file := `{"name":"Jane Smith","email":"jane@example.com","password":"Tr0ub4dor&3x","role":"user"}
+
+{"name":"Broken JSON"
+{"name":"John Doe","email":"john@example.com","password":"Tr0ub4dor&3x","role":"user","company":"ignored"}`
+	code, response := importRequest(router, admin.Company, token, "application/x-ndjson", "?dryRun=true", file)
+	assert.Equal(t, http.StatusOK, code)
+
+	report := importReport(t, response.Data["report"])
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 2, report.Valid)
+	assert.Equal(t, 1, report.Invalid)
+	assert.Equal(t, 0, report.Created)
+
+	// Rows are numbered by line
+	assert.Equal(t, 3, report.Rows[1].Row)
+	assert.Equal(t, models.ImportRowInvalid, report.Rows[1].Status)
+}
+
+func TestImportUsersAttributes(t *testing.T) {
+	router := gin.Default()
+	Sessions = memorySessions()
+	CompanySettings = memoryAttributeSchemas()
+	defer func() { CompanySettings = &MockCompanySettingsStore{} }()
+
+	admin, mockDB := loginTestUser("Tr0ub4dor&3x")
+	var created []models.UserWithCompanyAsObject
+	mockDB.CreateUsersFunc = func(ctx context.Context, users []models.UserWithCompanyAsObject) ([]primitive.ObjectID, error) {
+		created = append(created, users...)
+		ids := make([]primitive.ObjectID, len(users))
+		for i := range users {
+			ids[i] = primitive.NewObjectID()
+		}
+		return ids, nil
+	}
+	DB = mockDB
+	token := testSessionToken(admin)
+	CompanySettings.UpdateAttributeSchema(context.Background(), admin.Company, testAttributeSchema.Attributes)
+
+	// Set up the route
+	router.POST("/companies/:companyId/users/import", RequireSession(), RequireRole(models.RoleAdmin), ImportUsers())
+
+	// CSV attribute columns are parsed to the types of the schema
+	file := strings.Join([]string{
+		"Email,Name,Password,Role,attributes.employeeNumber,attributes.costCenter,attributes.contractor",
+		"jane@example.com,Jane Smith,Tr0ub4dor&3x,user,E0001,42,true",
+		"missing@example.com,Missing Number,Tr0ub4dor&3x,user,,42,",
+		"pattern@example.com,Bad Pattern,Tr0ub4dor&3x,user,1234,,",
+		"number@example.com,Bad Number,Tr0ub4dor&3x,user,E0002,many,",
+	}, "\n")
+	code, response := importRequest(router, admin.Company, token, "text/csv", "", file)
+	assert.Equal(t, http.StatusOK, code)
+
+	report := importReport(t, response.Data["report"])
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 3, report.Invalid)
+	assert.Contains(t, report.Rows[1].Error, "employeeNumber is required")
+	assert.Len(t, created, 1)
+	assert.Equal(t, map[string]interface{}{"employeeNumber": "E0001", "costCenter": float64(42), "contractor": true}, created[0].Attributes)
+
+	// NDJSON rows have the attributes of POST /users
+	created = nil
+	file = `{"name":"John Doe","email":"john@example.com","password":"Tr0ub4dor&3x","role":"user","attributes":{"employeeNumber":"E0003","department":"Sales"}}
+{"name":"No Attributes","email":"none@example.com","password":"Tr0ub4dor&3x","role":"user"}
+{"name":"Unknown","email":"unknown@example.com","password":"Tr0ub4dor&3x","role":"user","attributes":{"employeeNumber":"E0004","nickname":"Bob"}}`
+	code, response = importRequest(router, admin.Company, token, "application/x-ndjson", "", file)
+	assert.Equal(t, http.StatusOK, code)
+
+	report = importReport(t, response.Data["report"])
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 2, report.Invalid)
+	assert.Len(t, created, 1)
+	assert.Equal(t, map[string]interface{}{"employeeNumber": "E0003", "department": "Sales"}, created[0].Attributes)
+}
+
+func TestImportUsersBackgroundJob(t *testing.T) {
+	router := gin.Default()
+	Sessions = memorySessions()
+	ImportJobs = NewMockImportJobStore()
+	importBackgroundRows = 2
+	defer func() { importBackgroundRows = 100 }()
+
+	admin, mockDB := loginTestUser("Tr0ub4dor&3x")
+	mockDB.CreateUsersFunc = func(ctx context.Context, users []models.UserWithCompanyAsObject) ([]primitive.ObjectID, error) {
+		// The second user of the batch fails
+		return []primitive.ObjectID{primitive.NewObjectID(), primitive.NilObjectID}, mongo.BulkWriteException{}
+	}
+	DB = mockDB
+	token := testSessionToken(admin)
+
+	// Set up the routes
+	router.POST("/companies/:companyId/users/import", RequireSession(), RequireRole(models.RoleAdmin), ImportUsers())
+	router.GET("/companies/:companyId/users/import/:jobId", RequireSession(), RequireRole(models.RoleAdmin), GetImportJob())
+
+	file := "name,email,password,role\nJane Smith,jane@example.com,Tr0ub4dor&3x,user\nJohn Doe,john@example.com,Tr0ub4dor&3x,user\n"
+	code, response := importRequest(router, admin.Company, token, "text/csv", "", file)
+	assert.Equal(t, http.StatusAccepted, code)
+	jobId := response.Data["job"].(map[string]interface{})["_id"].(string)
+
+	var job models.ImportJob
+	assert.Eventually(t, func() bool {
+		req, _ := http.NewRequest("GET", "/companies/"+admin.Company.Hex()+"/users/import/"+jobId, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		var response responses.UserResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		encoded, _ := json.Marshal(response.Data["job"])
+		json.Unmarshal(encoded, &job)
+		return job.Status == models.ImportJobCompleted
+	}, 10*time.Second, 50*time.Millisecond)
+
+	assert.Equal(t, 2, job.Processed)
+	assert.Equal(t, 1, job.Report.Created)
+	assert.Equal(t, 1, job.Report.Failed)
+}
+
+func TestImportUsersUnsupportedFormat(t *testing.T) {
+	router := gin.Default()
+	Sessions = memorySessions()
+
+	admin, mockDB := loginTestUser("Tr0ub4dor&3x")
+	DB = mockDB
+	token := testSessionToken(admin)
+
+	// Set up the route
+	router.POST("/companies/:companyId/users/import", RequireSession(), RequireRole(models.RoleAdmin), ImportUsers())
+
+	code, _ := importRequest(router, admin.Company, token, "application/xml", "", "<users/>")
+	assert.Equal(t, http.StatusUnsupportedMediaType, code)
+
+	code, response := importRequest(router, admin.Company, token, "text/csv", "", "name,email\nJane,jane@example.com")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, response.Data["data"], `missing column "password"`)
+
+	code, _ = importRequest(router, primitive.NewObjectID(), token, "text/csv", "", "name,email,password,role\n")
+	assert.Equal(t, http.StatusForbidden, code)
+}
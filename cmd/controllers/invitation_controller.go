@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// invitationTTL is how long an invitation to a company can be accepted
+const invitationTTL = 7 * 24 * time.Hour
+
+var (
+	Invitations   configs.InvitationStore
+	InvitationURL string
+)
+
+func init() {
+	Invitations = configs.NewMongoInvitationStore(configs.DB)
+	InvitationURL = configs.EnvInvitationURL()
+}
+
+// emailInviter sends the invitations by email, with a link to accept them
+type emailInviter struct{}
+
+// Invite stores the invitation and sends its email in the background, so creating the user takes the same time
+// whether or not its email was already registered
+func (emailInviter) Invite(ctx context.Context, user *models.UserWithCompanyAsObject) error {
+	token, err := auth.GenerateToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	invitation := models.Invitation{
+		UserId:    user.Id,
+		Company:   user.Company,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: now.Add(invitationTTL),
+		CreatedAt: now,
+	}
+	if err := Invitations.CreateInvitation(ctx, invitation); err != nil {
+		return err
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := sendEmail(ctx, user.Email, "invitation", map[string]interface{}{
+			"name":      user.Name,
+			"company":   user.Company.Hex(),
+			"acceptUrl": InvitationURL + "?token=" + url.QueryEscape(token),
+			"expiresAt": invitation.ExpiresAt,
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Error sending invitation email to user: " + user.Id.Hex())
+		}
+	}()
+	log.Info().Msg("Invitation created for user: " + user.Id.Hex())
+	return nil
+}
+
+// AcceptInvitation lets the person of an email join the company that invited it, with the link of the invitation email
+func AcceptInvitation() gin.HandlerFunc {
+	log.Info().Msg("Accept invitation endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		var request models.AcceptInvitationRequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+		if err := validate.Struct(&request); err != nil {
+			log.Error().Err(err).Msg("Error validating request")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		invitation, err := Invitations.ConsumeInvitation(ctx, auth.HashToken(request.Token))
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting invitation from database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error accepting invitation", Data: nil})
+			return
+		}
+		if invitation == nil {
+			log.Error().Msg("Invalid or expired invitation")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "Invalid or expired invitation", Data: nil})
+			return
+		}
+
+		user, err := DB.FindUserByID(configs.WithSystemScope(ctx), invitation.UserId)
+		if err != nil || user == nil {
+			log.Error().Err(err).Msg("Error getting the user of an invitation")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "Invalid or expired invitation", Data: nil})
+			return
+		}
+		ctx = withAuthenticatedUser(ctx, user)
+
+		if user.InvitationPending {
+			accepted := false
+			if _, err := DB.UpdateUser(ctx, user.Id, user.Version, models.UserUpdate{InvitationPending: &accepted}); err != nil {
+				log.Error().Err(err).Msg("Error accepting invitation of user: " + user.Id.Hex())
+				c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error accepting invitation", Data: nil})
+				return
+			}
+		}
+		if err := Invitations.DeleteUserInvitations(ctx, user.Id); err != nil {
+			log.Error().Err(err).Msg("Error deleting invitations of user: " + user.Id.Hex())
+		}
+
+		log.Info().Msg("User: " + user.Id.Hex() + " accepted the invitation of company: " + user.Company.Hex())
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"company": user.Company}})
+	}
+}
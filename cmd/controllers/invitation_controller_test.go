@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MockInvitationStore is a mock implementation of the invitation operations that keeps the invitations by token hash
+type MockInvitationStore struct {
+	mu          sync.Mutex
+	Invitations map[string]models.Invitation
+}
+
+func NewMockInvitationStore() *MockInvitationStore {
+	return &MockInvitationStore{Invitations: map[string]models.Invitation{}}
+}
+
+// CreateInvitation mocks storing an invitation
+func (s *MockInvitationStore) CreateInvitation(ctx context.Context, invitation models.Invitation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Invitations[invitation.TokenHash] = invitation
+	return nil
+}
+
+// ConsumeInvitation mocks the single use retrieval of an invitation that did not expire
+func (s *MockInvitationStore) ConsumeInvitation(ctx context.Context, tokenHash string) (*models.Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	invitation, found := s.Invitations[tokenHash]
+	if !found || invitation.ExpiresAt.Before(time.Now()) {
+		return nil, nil
+	}
+	delete(s.Invitations, tokenHash)
+	return &invitation, nil
+}
+
+// DeleteUserInvitations mocks removing the invitations of a user
+func (s *MockInvitationStore) DeleteUserInvitations(ctx context.Context, userId primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for tokenHash, invitation := range s.Invitations {
+		if invitation.UserId == userId {
+			delete(s.Invitations, tokenHash)
+		}
+	}
+	return nil
+}
+
+// userInvitations returns the invitations of a user
+func (s *MockInvitationStore) userInvitations(userId primitive.ObjectID) []models.Invitation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	invitations := []models.Invitation{}
+	for _, invitation := range s.Invitations {
+		if invitation.UserId == userId {
+			invitations = append(invitations, invitation)
+		}
+	}
+	return invitations
+}
+
+func acceptInvitationRequest(router *gin.Engine, token string) *httptest.ResponseRecorder {
+	payload, _ := json.Marshal(models.AcceptInvitationRequest{Token: token})
+	req, _ := http.NewRequest("POST", "/auth/invitations/accept", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	router := gin.Default()
+	LoginAttempts = configs.NewMemoryLoginAttemptStore()
+	Sessions = memorySessions()
+
+	hash, _ := auth.HashPassword("Tr0ub4dor&3x")
+	member := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Test User", Email: "test@example.com", Password: hash, Role: "user", Company: primitive.NewObjectID(), Version: 1}
+	invited := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Test User", Email: member.Email, Password: hash, Role: "user", Company: primitive.NewObjectID(), Version: 1, InvitationPending: true}
+	DB = memoryUsers(member, invited)
+	invitations := NewMockInvitationStore()
+	Invitations = invitations
+	invitations.CreateInvitation(context.Background(), models.Invitation{UserId: invited.Id, Company: invited.Company, TokenHash: auth.HashToken("invitation-token"), ExpiresAt: time.Now().Add(time.Hour)})
+
+	router.POST("/auth/login", Login())
+	router.POST("/auth/invitations/accept", AcceptInvitation())
+
+	// Until the invitation is accepted the password only signs in to the company that already had the user
+	resp := loginRequest(router, member.Email, "Tr0ub4dor&3x")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var response responses.UserResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	assert.NotEmpty(t, response.Data["token"])
+
+	resp = acceptInvitationRequest(router, "invitation-token")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	stored, _ := DB.FindUserByID(context.Background(), invited.Id)
+	assert.False(t, stored.InvitationPending)
+	assert.Empty(t, invitations.userInvitations(invited.Id))
+
+	// The invitation can only be accepted once
+	resp = acceptInvitationRequest(router, "invitation-token")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// Now both companies are offered
+	resp = loginRequest(router, member.Email, "Tr0ub4dor&3x")
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	response = responses.UserResponse{}
+	json.NewDecoder(resp.Body).Decode(&response)
+	assert.Len(t, response.Data["companies"], 2)
+}
+
+func TestAcceptInvitationExpired(t *testing.T) {
+	router := gin.Default()
+
+	invited := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Test User", Email: "test@example.com", Role: "user", Company: primitive.NewObjectID(), Version: 1, InvitationPending: true}
+	DB = memoryUsers(invited)
+	invitations := NewMockInvitationStore()
+	Invitations = invitations
+	invitations.CreateInvitation(context.Background(), models.Invitation{UserId: invited.Id, Company: invited.Company, TokenHash: auth.HashToken("invitation-token"), ExpiresAt: time.Now().Add(-time.Minute)})
+
+	router.POST("/auth/invitations/accept", AcceptInvitation())
+
+	resp := acceptInvitationRequest(router, "invitation-token")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = acceptInvitationRequest(router, "unknown-token")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	stored, _ := DB.FindUserByID(context.Background(), invited.Id)
+	assert.True(t, stored.InvitationPending)
+}
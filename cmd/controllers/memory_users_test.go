@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"context"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memoryUsers returns a mock database that keeps the users in memory, like MongoDB would.
+// Reports and chains of managers are followed level by level like the $graphLookup, stopping at visited users.
+// Like the filters of MongoDB, only the users of the company of the tenant scope of the context are reached.
+// Only FindCredentialsByEmail returns the passwords
+func memoryUsers(users ...*models.UserWithCompanyAsObject) *MockDB {
+	stored := map[primitive.ObjectID]*models.UserWithCompanyAsObject{}
+	var order []primitive.ObjectID
+	for _, user := range users {
+		stored[user.Id] = user
+		order = append(order, user.Id)
+	}
+	copyOf := func(user *models.UserWithCompanyAsObject) *models.UserWithCompanyAsObject {
+		copied := *user
+		copied.Password = ""
+		return &copied
+	}
+	return &MockDB{
+		CreateUserFunc: func(ctx context.Context, user models.UserWithCompanyAsObject) (primitive.ObjectID, error) {
+			user.Id = primitive.NewObjectID()
+			stored[user.Id] = &user
+			order = append(order, user.Id)
+			return user.Id, nil
+		},
+		FindUserByIDFunc: func(ctx context.Context, id primitive.ObjectID) (*models.UserWithCompanyAsObject, error) {
+			if user, found := stored[id]; found && inScope(ctx, user) {
+				return copyOf(user), nil
+			}
+			return nil, mongo.ErrNoDocuments
+		},
+		FindUserByEmailFunc: func(ctx context.Context, companyId primitive.ObjectID, email string) (*models.UserWithCompanyAsObject, error) {
+			for _, user := range stored {
+				if user.Company == companyId && user.Email == email && inScope(ctx, user) {
+					return copyOf(user), nil
+				}
+			}
+			return nil, mongo.ErrNoDocuments
+		},
+		FindUsersByEmailFunc: func(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+			users := []*models.UserWithCompanyAsObject{}
+			for _, id := range order {
+				if user, found := stored[id]; found && user.Email == email && inScope(ctx, user) {
+					users = append(users, copyOf(user))
+				}
+			}
+			return users, nil
+		},
+		FindCredentialsByEmailFunc: func(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+			users := []*models.UserWithCompanyAsObject{}
+			for _, id := range order {
+				if user, found := stored[id]; found && user.Email == email && inScope(ctx, user) {
+					copied := *user
+					users = append(users, &copied)
+				}
+			}
+			return users, nil
+		},
+		FindAllUsersFunc: func(ctx context.Context, companyId primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error) {
+			users := []*models.UserWithCompanyAsObject{}
+			for _, id := range order {
+				if user, found := stored[id]; found && user.Company == companyId && inScope(ctx, user) {
+					users = append(users, copyOf(user))
+				}
+			}
+			return users, nil
+		},
+		FindUsersFunc: func(ctx context.Context, companyId primitive.ObjectID, filter models.UserFilter) ([]*models.UserWithCompanyAsObject, error) {
+			ids := map[primitive.ObjectID]bool{}
+			for _, id := range filter.Ids {
+				ids[id] = true
+			}
+			users := []*models.UserWithCompanyAsObject{}
+			for _, id := range order {
+				if user, found := stored[id]; found && user.Company == companyId && inScope(ctx, user) && (filter.Ids == nil || ids[id]) && hasAttributes(user, filter.Attributes) {
+					users = append(users, copyOf(user))
+				}
+			}
+			return users, nil
+		},
+		StreamUsersFunc: func(ctx context.Context, companyId primitive.ObjectID, fn func(user *models.UserWithCompanyAsObject) error) error {
+			for _, id := range order {
+				if user, found := stored[id]; found && user.Company == companyId && inScope(ctx, user) {
+					if err := fn(copyOf(user)); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		FindReportsFunc: func(ctx context.Context, companyId, managerId primitive.ObjectID, depth int) ([]*models.UserWithCompanyAsObject, error) {
+			users := []*models.UserWithCompanyAsObject{}
+			if manager, found := stored[managerId]; !found || manager.Company != companyId || !inScope(ctx, manager) {
+				return users, nil
+			}
+			seen := map[primitive.ObjectID]bool{managerId: true}
+			level := []primitive.ObjectID{managerId}
+			for ; depth > 0 && len(level) > 0; depth-- {
+				var next []primitive.ObjectID
+				for _, id := range order {
+					user, found := stored[id]
+					if found && !seen[id] && user.Company == companyId && user.Manager != nil && containsId(level, *user.Manager) {
+						seen[id] = true
+						next = append(next, id)
+						users = append(users, copyOf(user))
+					}
+				}
+				level = next
+			}
+			return users, nil
+		},
+		FindManagerChainFunc: func(ctx context.Context, companyId, id primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error) {
+			users := []*models.UserWithCompanyAsObject{}
+			user, found := stored[id]
+			if !found || user.Company != companyId || !inScope(ctx, user) {
+				return users, nil
+			}
+			seen := map[primitive.ObjectID]bool{id: true}
+			for user.Manager != nil && !seen[*user.Manager] {
+				seen[*user.Manager] = true
+				if user, found = stored[*user.Manager]; !found || user.Company != companyId {
+					break
+				}
+				users = append(users, copyOf(user))
+			}
+			return users, nil
+		},
+		UpdateUserFunc: func(ctx context.Context, id primitive.ObjectID, version int64, update models.UserUpdate) (*models.UserWithCompanyAsObject, error) {
+			user, found := stored[id]
+			if !found || !inScope(ctx, user) {
+				return nil, mongo.ErrNoDocuments
+			}
+			if user.Version != version {
+				return nil, configs.ErrVersionConflict
+			}
+			user.Version++
+			if update.Name != nil {
+				user.Name = *update.Name
+			}
+			if update.Role != nil {
+				user.Role = *update.Role
+			}
+			if update.Email != nil {
+				user.Email = *update.Email
+			}
+			if update.ExternalId != nil {
+				user.ExternalId = *update.ExternalId
+			}
+			if update.Active != nil {
+				user.Active = update.Active
+			}
+			if update.Attributes != nil {
+				user.Attributes = update.Attributes
+			}
+			if update.InvitationPending != nil {
+				user.InvitationPending = *update.InvitationPending
+			}
+			if update.RemovesManager() {
+				user.Manager = nil
+			} else if update.Manager != nil {
+				manager := *update.Manager
+				user.Manager = &manager
+			}
+			return copyOf(user), nil
+		},
+		UpdateUserPasswordFunc: func(ctx context.Context, id primitive.ObjectID, passwordHash string) error {
+			user, found := stored[id]
+			if !found || !inScope(ctx, user) {
+				return mongo.ErrNoDocuments
+			}
+			user.Password = passwordHash
+			user.Version++
+			return nil
+		},
+		DeleteUserFunc: func(ctx context.Context, id primitive.ObjectID) error {
+			if user, found := stored[id]; !found || !inScope(ctx, user) {
+				return mongo.ErrNoDocuments
+			}
+			delete(stored, id)
+			for _, user := range stored {
+				if user.Manager != nil && *user.Manager == id {
+					user.Manager = nil
+					user.Version++
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// hasAttributes reports whether the user has the values of the attributes
+func hasAttributes(user *models.UserWithCompanyAsObject, attributes map[string]interface{}) bool {
+	for name, value := range attributes {
+		if user.Attributes[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// inScope reports whether the tenant scope of the context reaches the user, every user when it has none
+func inScope(ctx context.Context, user *models.UserWithCompanyAsObject) bool {
+	company, system, ok := configs.TenantFromContext(ctx)
+	return !ok || system || user.Company == company
+}
@@ -0,0 +1,347 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recoveryCodesCount is how many recovery codes a user gets when enabling MFA
+const recoveryCodesCount = 10
+
+var (
+	MFAEncryptionKey []byte
+	MFAIssuer        string
+)
+
+func init() {
+	MFAEncryptionKey = configs.EnvMFAEncryptionKey()
+	MFAIssuer = configs.EnvMFAIssuer()
+}
+
+// EnrollMFA starts the enrollment, the secret is only used once it is confirmed with a code
+func EnrollMFA() gin.HandlerFunc {
+	log.Info().Msg("Enroll MFA endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		user := CurrentUser(c)
+
+		if user.MFAEnabled() {
+			log.Error().Msg("MFA is already enabled for user: " + user.Id.Hex())
+			c.JSON(http.StatusConflict, responses.UserResponse{Status: http.StatusConflict, Message: "MFA is already enabled", Data: nil})
+			return
+		}
+
+		secret, err := auth.GenerateTOTPSecret()
+		if err != nil {
+			log.Error().Err(err).Msg("Error generating TOTP secret")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error enrolling MFA", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		encryptedSecret, err := auth.Encrypt(MFAEncryptionKey, secret)
+		if err != nil {
+			log.Error().Err(err).Msg("Error encrypting TOTP secret")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error enrolling MFA", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		if err := DB.UpdateUserMFA(ctx, user.Id, &models.MFA{PendingSecret: encryptedSecret}); err != nil {
+			log.Error().Err(err).Msg("Error storing MFA settings on database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error enrolling MFA", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		log.Info().Msg("MFA enrollment started for user: " + user.Id.Hex())
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{
+			"secret":     secret,
+			"otpauthUri": auth.TOTPURI(MFAIssuer, user.Email, secret),
+		}})
+	}
+}
+
+// ConfirmMFA enables MFA once the user proves the authenticator app works, and returns the recovery codes
+func ConfirmMFA() gin.HandlerFunc {
+	log.Info().Msg("Confirm MFA endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		user := CurrentUser(c)
+		var request models.MFACodeRequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		if err := validate.Struct(&request); err != nil {
+			log.Error().Err(err).Msg("Error validating request")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		if user.MFA == nil || user.MFA.PendingSecret == "" {
+			log.Error().Msg("MFA enrollment was not started for user: " + user.Id.Hex())
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "MFA enrollment was not started", Data: nil})
+			return
+		}
+
+		secret, err := auth.Decrypt(MFAEncryptionKey, user.MFA.PendingSecret)
+		if err != nil {
+			log.Error().Err(err).Msg("Error decrypting TOTP secret")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error confirming MFA", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		step, valid := auth.MatchTOTP(secret, request.Code, time.Now())
+		if !valid {
+			log.Error().Msg("Invalid MFA code for user: " + user.Id.Hex())
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "Invalid MFA code", Data: nil})
+			return
+		}
+
+		recoveryCodes, err := auth.GenerateRecoveryCodes(recoveryCodesCount)
+		if err != nil {
+			log.Error().Err(err).Msg("Error generating recovery codes")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error confirming MFA", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		recoveryCodeHashes := make([]string, len(recoveryCodes))
+		for i, code := range recoveryCodes {
+			recoveryCodeHashes[i] = auth.HashToken(code)
+		}
+
+		now := time.Now()
+		mfa := &models.MFA{
+			Enabled:       true,
+			EnabledAt:     &now,
+			Secret:        user.MFA.PendingSecret,
+			RecoveryCodes: recoveryCodeHashes,
+			// The code that confirmed the enrollment can not be used again to log in
+			LastTOTPStep: step,
+		}
+		if err := DB.UpdateUserMFA(ctx, user.Id, mfa); err != nil {
+			log.Error().Err(err).Msg("Error storing MFA settings on database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error confirming MFA", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		data := map[string]interface{}{"recoveryCodes": recoveryCodes}
+
+		// Users that were forced to enroll get their full session now
+		if session := CurrentSession(c); session.Scope == models.SessionScopeMFAEnrollment {
+			if err := Sessions.DeleteSession(ctx, session.Id); err != nil {
+				log.Error().Err(err).Msg("Error deleting enrollment session")
+			}
+			token, fullSession, err := createSession(ctx, c, user, "", sessionTTL)
+			if err != nil {
+				log.Error().Err(err).Msg("Error storing session on database")
+				c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error confirming MFA", Data: map[string]interface{}{"data": err.Error()}})
+				return
+			}
+			data["token"] = token
+			data["expiresAt"] = fullSession.ExpiresAt
+		}
+
+		log.Info().Msg("MFA enabled for user: " + user.Id.Hex())
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: data})
+	}
+}
+
+// DisableMFA turns MFA off with a valid code, unless the company of the user requires it
+func DisableMFA() gin.HandlerFunc {
+	log.Info().Msg("Disable MFA endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		user := CurrentUser(c)
+		var request models.MFACodeRequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		if err := validate.Struct(&request); err != nil {
+			log.Error().Err(err).Msg("Error validating request")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		if !user.MFAEnabled() {
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "MFA is not enabled", Data: nil})
+			return
+		}
+
+		required, err := companyRequiresMFA(ctx, user.Company)
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting company settings from database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error disabling MFA", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		if required {
+			log.Error().Msg("MFA can not be disabled, it is required by company: " + user.Company.Hex())
+			c.JSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "MFA is required by your company", Data: nil})
+			return
+		}
+
+		if valid, err := verifyTOTP(ctx, user, request.Code); err != nil || !valid {
+			log.Error().Err(err).Msg("Invalid MFA code for user: " + user.Id.Hex())
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "Invalid MFA code", Data: nil})
+			return
+		}
+
+		if err := DB.UpdateUserMFA(ctx, user.Id, nil); err != nil {
+			log.Error().Err(err).Msg("Error removing MFA settings from database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error disabling MFA", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		log.Info().Msg("MFA disabled for user: " + user.Id.Hex())
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: nil})
+	}
+}
+
+// LoginMFA is the second step of the login of users with MFA, it accepts a TOTP code or a recovery code
+func LoginMFA() gin.HandlerFunc {
+	log.Info().Msg("Login MFA endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		var request models.MFALoginRequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		if err := validate.Struct(&request); err != nil {
+			log.Error().Err(err).Msg("Error validating request")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		challenge, err := Sessions.FindSession(ctx, auth.HashToken(request.MFAToken))
+		if err != nil || challenge == nil || challenge.Scope != models.SessionScopeMFAChallenge {
+			log.Error().Err(err).Msg("Invalid or expired MFA token")
+			c.JSON(http.StatusUnauthorized, responses.UserResponse{Status: http.StatusUnauthorized, Message: "Invalid or expired MFA token", Data: nil})
+			return
+		}
+
+		user, err := DB.FindUserByID(configs.WithSystemScope(ctx), challenge.UserId)
+		if err != nil || user == nil || !user.MFAEnabled() {
+			log.Error().Err(err).Msg("Error getting the user of an MFA token")
+			c.JSON(http.StatusUnauthorized, responses.UserResponse{Status: http.StatusUnauthorized, Message: "Invalid or expired MFA token", Data: nil})
+			return
+		}
+		ctx = withAuthenticatedUser(ctx, user)
+
+		// Codes only have a million values, so they share the lockout of the password
+		accountKey := accountLoginKey(user.Email)
+		ipKey := "ip:" + c.ClientIP()
+		if wait := loginRetryAfter(ctx, accountKey, ipKey); wait > 0 {
+			log.Error().Msg("MFA login rejected, too many failed attempts")
+			c.Header("Retry-After", retryAfterSeconds(wait))
+			c.JSON(http.StatusTooManyRequests, responses.UserResponse{Status: http.StatusTooManyRequests, Message: "Too many failed login attempts, try again later", Data: nil})
+			return
+		}
+
+		var valid bool
+		if request.Code != "" {
+			valid, err = verifyTOTP(ctx, user, request.Code)
+		} else {
+			valid, err = DB.UseRecoveryCode(ctx, user.Id, auth.HashToken(auth.NormalizeRecoveryCode(request.RecoveryCode)))
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Error verifying MFA code")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error logging in", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		if !valid {
+			recordLoginFailure(ctx, c, user, accountKey, ipKey)
+			c.JSON(http.StatusUnauthorized, responses.UserResponse{Status: http.StatusUnauthorized, Message: "Invalid MFA code", Data: nil})
+			return
+		}
+
+		if err := LoginAttempts.ResetLoginAttempts(ctx, accountKey); err != nil {
+			log.Error().Err(err).Msg("Error resetting login attempts")
+		}
+		if err := Sessions.DeleteSession(ctx, challenge.Id); err != nil {
+			log.Error().Err(err).Msg("Error deleting MFA challenge session")
+		}
+
+		token, session, err := createSession(ctx, c, user, "", sessionTTL)
+		if err != nil {
+			log.Error().Err(err).Msg("Error storing session on database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error logging in", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		log.Info().Msg("User: " + user.Id.Hex() + " logged in successfully with MFA")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"token": token, "expiresAt": session.ExpiresAt}})
+	}
+}
+
+// UpdateRequireMFA lets an admin require MFA for every user of its company
+func UpdateRequireMFA() gin.HandlerFunc {
+	log.Info().Msg("Update company MFA requirement endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		companyId := c.Param("companyId")
+		admin := CurrentUser(c)
+		var request models.RequireMFARequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		if err := validate.Struct(&request); err != nil {
+			log.Error().Err(err).Msg("Error validating request")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		companyIdObject, err := primitive.ObjectIDFromHex(companyId)
+		if err != nil || companyIdObject != admin.Company {
+			log.Error().Msg("Admin: " + admin.Id.Hex() + " tried to change the settings of company: " + companyId)
+			c.JSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "You are not allowed to perform this action", Data: nil})
+			return
+		}
+
+		if err := CompanySettings.UpdateRequireMFA(ctx, companyIdObject, *request.Required); err != nil {
+			log.Error().Err(err).Msg("Error updating company settings on database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error updating company settings on database", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		log.Info().Msg("MFA requirement of company: " + companyId + " updated successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"requireMfa": *request.Required}})
+	}
+}
+
+// verifyTOTP checks a TOTP code of the user and marks its time step as used, so a code is only accepted once
+func verifyTOTP(ctx context.Context, user *models.UserWithCompanyAsObject, code string) (bool, error) {
+	secret, err := auth.Decrypt(MFAEncryptionKey, user.MFA.Secret)
+	if err != nil {
+		return false, err
+	}
+	step, valid := auth.MatchTOTP(secret, code, time.Now())
+	if !valid {
+		return false, nil
+	}
+	return DB.UseTOTPStep(ctx, user.Id, step)
+}
+
+func companyRequiresMFA(ctx context.Context, companyId primitive.ObjectID) (bool, error) {
+	settings, err := CompanySettings.FindCompanySettings(ctx, companyId)
+	if err != nil {
+		return false, err
+	}
+	return settings != nil && settings.RequireMFA, nil
+}
@@ -0,0 +1,284 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func init() {
+	MFAEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+}
+
+// memorySessions returns a mock session store that keeps the sessions in a map
+func memorySessions() *MockSessionStore {
+	sessions := map[string]models.Session{}
+	return &MockSessionStore{
+		CreateSessionFunc: func(ctx context.Context, session models.Session) (primitive.ObjectID, error) {
+			session.Id = primitive.NewObjectID()
+			sessions[session.TokenHash] = session
+			return session.Id, nil
+		},
+		FindSessionFunc: func(ctx context.Context, tokenHash string) (*models.Session, error) {
+			if session, found := sessions[tokenHash]; found {
+				return &session, nil
+			}
+			return nil, nil
+		},
+		DeleteSessionFunc: func(ctx context.Context, id primitive.ObjectID) error {
+			for hash, session := range sessions {
+				if session.Id == id {
+					delete(sessions, hash)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// mfaTestUser returns a user with MFA enabled, its TOTP secret and a mock database that stores MFA changes
+func mfaTestUser(t *testing.T) (*models.UserWithCompanyAsObject, string, *MockDB) {
+	user, mockDB := loginTestUser("Tr0ub4dor&3x")
+	secret, _ := auth.GenerateTOTPSecret()
+	encryptedSecret, err := auth.Encrypt(MFAEncryptionKey, secret)
+	assert.NoError(t, err)
+	user.MFA = &models.MFA{Enabled: true, Secret: encryptedSecret, RecoveryCodes: []string{auth.HashToken("abcde-fghij")}}
+	mockDB.UpdateUserMFAFunc = func(ctx context.Context, id primitive.ObjectID, mfa *models.MFA) error {
+		user.MFA = mfa
+		return nil
+	}
+	mockDB.UseTOTPStepFunc = func(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+		if step <= user.MFA.LastTOTPStep {
+			return false, nil
+		}
+		user.MFA.LastTOTPStep = step
+		return true, nil
+	}
+	return user, secret, mockDB
+}
+
+// jsonRequest performs a POST with the payload and an optional bearer token, returning the parsed response
+func jsonRequest(router *gin.Engine, path, token string, payload interface{}) (int, responses.UserResponse) {
+	body, _ := json.Marshal(payload)
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	var response responses.UserResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	return resp.Code, response
+}
+
+func TestEnrollAndConfirmMFA(t *testing.T) {
+	router := gin.Default()
+	LoginAttempts = configs.NewMemoryLoginAttemptStore()
+	Sessions = memorySessions()
+
+	user, mockDB := loginTestUser("Tr0ub4dor&3x")
+	var storedMFA *models.MFA
+	mockDB.UpdateUserMFAFunc = func(ctx context.Context, id primitive.ObjectID, mfa *models.MFA) error {
+		storedMFA = mfa
+		user.MFA = mfa
+		return nil
+	}
+	DB = mockDB
+
+	// Set up the routes
+	router.POST("/auth/login", Login())
+	router.POST("/auth/mfa/enroll", RequireSession(), EnrollMFA())
+	router.POST("/auth/mfa/confirm", RequireSession(), ConfirmMFA())
+
+	_, login := jsonRequest(router, "/auth/login", "", models.LoginRequest{Email: user.Email, Password: "Tr0ub4dor&3x"})
+	token := login.Data["token"].(string)
+
+	code, response := jsonRequest(router, "/auth/mfa/enroll", token, nil)
+	assert.Equal(t, http.StatusOK, code)
+	secret := response.Data["secret"].(string)
+	assert.Contains(t, response.Data["otpauthUri"], "otpauth://totp/")
+
+	// The secret is stored encrypted and not enabled yet
+	assert.NotContains(t, storedMFA.PendingSecret, secret)
+	assert.False(t, storedMFA.Enabled)
+
+	// A wrong code does not enable it
+	code, _ = jsonRequest(router, "/auth/mfa/confirm", token, models.MFACodeRequest{Code: "000000"})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	totp, _ := auth.TOTPCode(secret, time.Now())
+	code, response = jsonRequest(router, "/auth/mfa/confirm", token, models.MFACodeRequest{Code: totp})
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, storedMFA.Enabled)
+	assert.NotZero(t, storedMFA.LastTOTPStep)
+
+	// Recovery codes are returned once and only their hashes are stored
+	recoveryCodes := response.Data["recoveryCodes"].([]interface{})
+	assert.Len(t, recoveryCodes, recoveryCodesCount)
+	assert.Equal(t, auth.HashToken(recoveryCodes[0].(string)), storedMFA.RecoveryCodes[0])
+}
+
+func TestLoginWithMFA(t *testing.T) {
+	router := gin.Default()
+	LoginAttempts = configs.NewMemoryLoginAttemptStore()
+	Sessions = memorySessions()
+
+	user, secret, mockDB := mfaTestUser(t)
+	DB = mockDB
+
+	// Set up the routes
+	router.POST("/auth/login", Login())
+	router.POST("/auth/login/mfa", LoginMFA())
+	router.POST("/auth/logout", RequireSession(), Logout())
+
+	// The password alone only gives an MFA token
+	code, response := jsonRequest(router, "/auth/login", "", models.LoginRequest{Email: user.Email, Password: "Tr0ub4dor&3x"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, response.Data["mfaRequired"])
+	assert.Nil(t, response.Data["token"])
+	mfaToken := response.Data["mfaToken"].(string)
+
+	// The MFA token is not a session
+	code, _ = jsonRequest(router, "/auth/logout", mfaToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = jsonRequest(router, "/auth/login/mfa", "", models.MFALoginRequest{MFAToken: mfaToken, Code: "000000"})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// Wait for the progressive delay of the failed code
+	time.Sleep(auth.AccountLockoutPolicy.Delay(1))
+
+	totp, _ := auth.TOTPCode(secret, time.Now())
+	code, response = jsonRequest(router, "/auth/login/mfa", "", models.MFALoginRequest{MFAToken: mfaToken, Code: totp})
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = jsonRequest(router, "/auth/logout", response.Data["token"].(string), nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	// A code that was already used can not log in again, even with a new MFA token
+	_, response = jsonRequest(router, "/auth/login", "", models.LoginRequest{Email: user.Email, Password: "Tr0ub4dor&3x"})
+	code, _ = jsonRequest(router, "/auth/login/mfa", "", models.MFALoginRequest{MFAToken: response.Data["mfaToken"].(string), Code: totp})
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestLoginWithRecoveryCode(t *testing.T) {
+	router := gin.Default()
+	LoginAttempts = configs.NewMemoryLoginAttemptStore()
+	Sessions = memorySessions()
+
+	user, _, mockDB := mfaTestUser(t)
+	mockDB.UseRecoveryCodeFunc = func(ctx context.Context, id primitive.ObjectID, codeHash string) (bool, error) {
+		for i, hash := range user.MFA.RecoveryCodes {
+			if hash == codeHash {
+				user.MFA.RecoveryCodes = append(user.MFA.RecoveryCodes[:i], user.MFA.RecoveryCodes[i+1:]...)
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	DB = mockDB
+
+	// Set up the routes
+	router.POST("/auth/login", Login())
+	router.POST("/auth/login/mfa", LoginMFA())
+
+	_, response := jsonRequest(router, "/auth/login", "", models.LoginRequest{Email: user.Email, Password: "Tr0ub4dor&3x"})
+	mfaToken := response.Data["mfaToken"].(string)
+
+	// Recovery codes are accepted without dash and in upper case
+	code, _ := jsonRequest(router, "/auth/login/mfa", "", models.MFALoginRequest{MFAToken: mfaToken, RecoveryCode: "ABCDEFGHIJ"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, user.MFA.RecoveryCodes)
+
+	// The code can not be used twice
+	_, response = jsonRequest(router, "/auth/login", "", models.LoginRequest{Email: user.Email, Password: "Tr0ub4dor&3x"})
+	mfaToken = response.Data["mfaToken"].(string)
+	code, _ = jsonRequest(router, "/auth/login/mfa", "", models.MFALoginRequest{MFAToken: mfaToken, RecoveryCode: "abcde-fghij"})
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestLoginCompanyRequiresMFA(t *testing.T) {
+	router := gin.Default()
+	LoginAttempts = configs.NewMemoryLoginAttemptStore()
+	Sessions = memorySessions()
+
+	user, mockDB := loginTestUser("Tr0ub4dor&3x")
+	DB = mockDB
+	CompanySettings = &MockCompanySettingsStore{
+		FindCompanySettingsFunc: func(ctx context.Context, id primitive.ObjectID) (*models.CompanySettings, error) {
+			return &models.CompanySettings{Company: id, RequireMFA: true}, nil
+		},
+	}
+	defer func() { CompanySettings = &MockCompanySettingsStore{} }()
+
+	// Set up the routes
+	router.POST("/auth/login", Login())
+	router.POST("/auth/mfa/enroll", RequireSession(models.SessionScopeMFAEnrollment), EnrollMFA())
+	router.POST("/auth/mfa/disable", RequireSession(), DisableMFA())
+
+	code, response := jsonRequest(router, "/auth/login", "", models.LoginRequest{Email: user.Email, Password: "Tr0ub4dor&3x"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, response.Data["mfaEnrollmentRequired"])
+	token := response.Data["token"].(string)
+
+	// The session can only be used to enroll
+	code, _ = jsonRequest(router, "/auth/mfa/disable", token, models.MFACodeRequest{Code: "000000"})
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = jsonRequest(router, "/auth/mfa/enroll", token, nil)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestUpdateRequireMFA(t *testing.T) {
+	router := gin.Default()
+	Sessions = memorySessions()
+
+	admin, mockDB := loginTestUser("Tr0ub4dor&3x")
+	DB = mockDB
+	ginContext, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginContext.Request = httptest.NewRequest("POST", "/", nil)
+	token, _, _ := createSession(context.Background(), ginContext, admin, "", sessionTTL)
+
+	var updatedCompany primitive.ObjectID
+	CompanySettings = &MockCompanySettingsStore{
+		UpdateRequireMFAFunc: func(ctx context.Context, companyId primitive.ObjectID, required bool) error {
+			updatedCompany = companyId
+			assert.True(t, required)
+			return nil
+		},
+	}
+	defer func() { CompanySettings = &MockCompanySettingsStore{} }()
+
+	// Set up the route
+	router.PUT("/companies/:companyId/settings/mfa", RequireSession(), RequireRole(models.RoleAdmin), UpdateRequireMFA())
+
+	required := true
+	body, _ := json.Marshal(models.RequireMFARequest{Required: &required})
+
+	// Admins can only change their own company
+	req, _ := http.NewRequest("PUT", "/companies/"+primitive.NewObjectID().Hex()+"/settings/mfa", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	req, _ = http.NewRequest("PUT", "/companies/"+admin.Company.Hex()+"/settings/mfa", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, admin.Company, updatedCompany)
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CreateOIDCClient registers an application of the company of the admin, the secret of confidential clients is
+// only returned on this response
+func CreateOIDCClient() gin.HandlerFunc {
+	log.Info().Msg("Create OIDC client endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		var request models.CreateOIDCClientRequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		if err := validate.Struct(&request); err != nil {
+			log.Error().Err(err).Msg("Error validating request")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		admin := CurrentUser(c)
+		companyId, ok := adminCompany(c, admin)
+		if !ok {
+			return
+		}
+
+		client := models.OIDCClient{
+			Company:      companyId,
+			Name:         request.Name,
+			RedirectURIs: request.RedirectURIs,
+			Confidential: request.Confidential,
+			CreatedBy:    admin.Id,
+			CreatedAt:    time.Now(),
+		}
+		data := map[string]interface{}{"client": &client}
+		if request.Confidential {
+			secret, err := auth.GenerateToken()
+			if err != nil {
+				log.Error().Err(err).Msg("Error generating OIDC client secret")
+				c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error creating OIDC client", Data: map[string]interface{}{"data": err.Error()}})
+				return
+			}
+			client.SecretHash = auth.HashToken(secret)
+			data["secret"] = secret
+		}
+
+		var err error
+		client.Id, err = OIDC.CreateOIDCClient(ctx, client)
+		if err != nil {
+			log.Error().Err(err).Msg("Error storing OIDC client on database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error creating OIDC client", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		recordOIDCClientEvent(ctx, c, models.AuditActionOIDCClientCreated, admin, &client)
+
+		log.Info().Msg("OIDC client: " + client.Id.Hex() + " created for company: " + companyId.Hex())
+		c.JSON(http.StatusCreated, responses.UserResponse{Status: http.StatusCreated, Message: "success", Data: data})
+	}
+}
+
+// GetOIDCClients lists the clients of the company of the admin, without their secrets
+func GetOIDCClients() gin.HandlerFunc {
+	log.Info().Msg("Get OIDC clients endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		clients, err := OIDC.FindCompanyOIDCClients(ctx, companyId)
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting OIDC clients from database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error getting OIDC clients from database", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		log.Info().Msg("OIDC clients of company: " + companyId.Hex() + " retrieved successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"clients": clients}})
+	}
+}
+
+// DeleteOIDCClient deletes a client of the company of the admin, its codes can no longer be exchanged.
+// Tokens that were already issued stay valid until they expire
+func DeleteOIDCClient() gin.HandlerFunc {
+	log.Info().Msg("Delete OIDC client endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+
+		admin := CurrentUser(c)
+		companyId, ok := adminCompany(c, admin)
+		if !ok {
+			return
+		}
+
+		clientId, _ := primitive.ObjectIDFromHex(c.Param("clientId"))
+		err := OIDC.DeleteOIDCClient(ctx, companyId, clientId)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			log.Error().Msg("OIDC client: " + c.Param("clientId") + " not found")
+			c.JSON(http.StatusNotFound, responses.UserResponse{Status: http.StatusNotFound, Message: "OIDC client not found", Data: nil})
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Error deleting OIDC client on database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error deleting OIDC client", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		recordOIDCClientEvent(ctx, c, models.AuditActionOIDCClientDeleted, admin, &models.OIDCClient{Id: clientId, Company: companyId})
+
+		log.Info().Msg("OIDC client: " + clientId.Hex() + " deleted successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: nil})
+	}
+}
+
+func recordOIDCClientEvent(ctx context.Context, c *gin.Context, action string, admin *models.UserWithCompanyAsObject, client *models.OIDCClient) {
+	recordAuditEvent(ctx, models.AuditEvent{
+		Action:   action,
+		Actor:    &admin.Id,
+		Company:  &client.Company,
+		IP:       c.ClientIP(),
+		Metadata: map[string]interface{}{"clientId": client.Id},
+	})
+}
@@ -0,0 +1,339 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+	"user-service/internal/oidc"
+	"user-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OIDCPrefix is the path the endpoints of the OpenID Connect provider are served under, outside of the versions of the API
+const OIDCPrefix = "/oauth2"
+
+const (
+	// authorizationCodeTTL is how long a client has to exchange a code for tokens
+	authorizationCodeTTL = time.Minute
+	// oidcTokenTTL is how long the ID and access tokens are valid
+	oidcTokenTTL = time.Hour
+)
+
+var (
+	OIDC       configs.OIDCStore
+	OIDCSigner *auth.Signer
+	// OIDCIssuer is the URL of the provider, the provider is only served when it is set. OIDCAuthorizationURL
+	// is the authorize endpoint itself when it is empty
+	OIDCIssuer           string
+	OIDCAuthorizationURL string
+)
+
+func init() {
+	OIDC = configs.NewMongoOIDCStore(configs.DB)
+	OIDCIssuer = configs.EnvOIDCIssuer()
+	OIDCAuthorizationURL = configs.EnvOIDCAuthorizationURL()
+	OIDCSigner = oidcSigner(configs.EnvOIDCSigningKeyFile())
+}
+
+// oidcSigner returns a signer with the key of the file. Without a file a key is generated, its tokens can not be
+// verified by other replicas nor after a restart
+func oidcSigner(keyFile string) *auth.Signer {
+	if keyFile == "" {
+		log.Warn().Msg("No OIDC_SIGNING_KEY_FILE environment variable detected, OpenID Connect tokens are signed with a temporary key")
+		key, err := auth.GenerateSigningKey()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error generating the OpenID Connect signing key")
+		}
+		return auth.NewSigner(key)
+	}
+	pemKey, err := os.ReadFile(keyFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error reading the OpenID Connect signing key")
+	}
+	key, err := auth.ParseSigningKey(pemKey)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error parsing the OpenID Connect signing key")
+	}
+	return auth.NewSigner(key)
+}
+
+// GetOpenIDConfiguration serves the discovery document clients configure themselves with
+func GetOpenIDConfiguration() gin.HandlerFunc {
+	log.Info().Msg("OpenID configuration endpoint reached")
+	return func(c *gin.Context) {
+		configuration := oidc.NewConfiguration(OIDCIssuer, OIDCIssuer+OIDCPrefix)
+		if OIDCAuthorizationURL != "" {
+			configuration.AuthorizationEndpoint = OIDCAuthorizationURL
+		}
+		c.JSON(http.StatusOK, configuration)
+	}
+}
+
+// GetJWKS serves the public key the tokens can be verified with
+func GetJWKS() gin.HandlerFunc {
+	log.Info().Msg("JWKS endpoint reached")
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, OIDCSigner.JWKS())
+	}
+}
+
+// AuthorizeOIDC authorizes a client to sign in the user of the session. It redirects the user back to the client
+// with a 302, with the code or the error of the request like RFC 6749 section 4.1.2. Unknown clients and redirect
+// URIs get a 400 instead, so the endpoint can not send users anywhere
+func AuthorizeOIDC() gin.HandlerFunc {
+	log.Info().Msg("OIDC authorize endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		clientId, _ := primitive.ObjectIDFromHex(c.Query("client_id"))
+		client, err := OIDC.FindOIDCClient(ctx, clientId)
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting OIDC client from database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error getting OIDC client from database", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+		redirectURI := c.Query("redirect_uri")
+		if client == nil || !client.AllowsRedirect(redirectURI) {
+			log.Error().Msg("Unknown OIDC client: " + c.Query("client_id") + " or redirect URI: " + redirectURI)
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "Unknown client or redirect URI", Data: nil})
+			return
+		}
+
+		params := url.Values{}
+		code, err := newAuthorizationCode(ctx, c, client, CurrentUser(c))
+		var oidcErr *oidc.Error
+		switch {
+		case errors.As(err, &oidcErr):
+			log.Error().Msg("OIDC authorization error: " + oidcErr.Error())
+			params.Set("error", oidcErr.Code)
+			params.Set("error_description", oidcErr.Description)
+		case err != nil:
+			log.Error().Err(err).Msg("Error storing authorization code on database")
+			params.Set("error", oidc.ErrorServerError)
+		default:
+			params.Set("code", code)
+		}
+		if state := c.Query("state"); state != "" {
+			params.Set("state", state)
+		}
+
+		location, _ := url.Parse(redirectURI)
+		query := location.Query()
+		for name, values := range params {
+			query[name] = values
+		}
+		location.RawQuery = query.Encode()
+
+		log.Info().Msg("User: " + CurrentUser(c).Id.Hex() + " sent back to OIDC client: " + client.Id.Hex())
+		c.Redirect(http.StatusFound, location.String())
+	}
+}
+
+// newAuthorizationCode checks the request and stores a code for the user, an oidc.Error when it can not be authorized
+func newAuthorizationCode(ctx context.Context, c *gin.Context, client *models.OIDCClient, user *models.UserWithCompanyAsObject) (string, error) {
+	if c.Query("response_type") != oidc.ResponseTypeCode {
+		return "", oidc.NewError(http.StatusBadRequest, oidc.ErrorUnsupportedResponseType, "Only the code response type is supported")
+	}
+	scope, err := oidc.GrantedScope(c.Query("scope"))
+	if err != nil {
+		return "", err
+	}
+	// PKCE is required from every client, confidential ones included
+	challenge := c.Query("code_challenge")
+	if c.Query("code_challenge_method") != auth.PKCEMethodS256 || len(challenge) != 43 {
+		return "", oidc.NewError(http.StatusBadRequest, oidc.ErrorInvalidRequest, "A code_challenge with the S256 method is required")
+	}
+	if user.Company != client.Company {
+		return "", oidc.NewError(http.StatusForbidden, oidc.ErrorAccessDenied, "The user is not a member of the company of the client")
+	}
+
+	code, err := auth.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	err = OIDC.CreateAuthorizationCode(ctx, models.AuthorizationCode{
+		CodeHash:      auth.HashToken(code),
+		ClientId:      client.Id,
+		UserId:        user.Id,
+		Company:       user.Company,
+		RedirectURI:   c.Query("redirect_uri"),
+		Scope:         scope,
+		Nonce:         c.Query("nonce"),
+		CodeChallenge: challenge,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// ExchangeOIDCToken exchanges an authorization code for an ID token and an access token
+func ExchangeOIDCToken() gin.HandlerFunc {
+	log.Info().Msg("OIDC token endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		// Responses with tokens must not be cached
+		c.Header("Cache-Control", "no-store")
+		c.Header("Pragma", "no-cache")
+
+		client, err := authenticateOIDCClient(ctx, c)
+		if err != nil {
+			oidcError(c, err)
+			return
+		}
+		if c.PostForm("grant_type") != oidc.GrantTypeAuthorizationCode {
+			oidcError(c, oidc.NewError(http.StatusBadRequest, oidc.ErrorUnsupportedGrantType, "Only the authorization_code grant type is supported"))
+			return
+		}
+
+		// The code is used up even when the request is wrong, so a leaked code can only be tried once
+		code, err := OIDC.ConsumeAuthorizationCode(ctx, auth.HashToken(c.PostForm("code")))
+		if err != nil {
+			oidcError(c, err)
+			return
+		}
+		if code == nil || code.ClientId != client.Id || code.RedirectURI != c.PostForm("redirect_uri") || !auth.VerifyCodeChallenge(c.PostForm("code_verifier"), code.CodeChallenge) {
+			oidcError(c, oidc.NewError(http.StatusBadRequest, oidc.ErrorInvalidGrant, "The code is invalid, expired, was issued to another client or the code_verifier does not match"))
+			return
+		}
+
+		user, err := userService().Get(configs.WithTenant(ctx, code.Company), code.UserId)
+		if errors.Is(err, services.ErrUserNotFound) || err == nil && (!user.IsActive() || user.Company != code.Company) {
+			oidcError(c, oidc.NewError(http.StatusBadRequest, oidc.ErrorInvalidGrant, "The user can no longer sign in to the client"))
+			return
+		}
+		if err != nil {
+			oidcError(c, err)
+			return
+		}
+
+		jti, err := auth.GenerateToken()
+		if err != nil {
+			oidcError(c, err)
+			return
+		}
+		grant := oidc.Grant{Issuer: OIDCIssuer, ClientId: client.Id.Hex(), Scope: code.Scope, Nonce: code.Nonce, IssuedAt: time.Now(), TTL: oidcTokenTTL}
+		idToken, err := OIDCSigner.Sign(oidc.IDTokenClaims(user, grant), oidc.IDTokenType)
+		if err != nil {
+			oidcError(c, err)
+			return
+		}
+		accessToken, err := OIDCSigner.Sign(oidc.AccessTokenClaims(user, grant, jti), oidc.AccessTokenType)
+		if err != nil {
+			oidcError(c, err)
+			return
+		}
+		recordAuditEvent(ctx, models.AuditEvent{
+			Action:   models.AuditActionOIDCTokenIssued,
+			Actor:    &user.Id,
+			Target:   &user.Id,
+			Company:  &user.Company,
+			IP:       c.ClientIP(),
+			Metadata: map[string]interface{}{"clientId": client.Id},
+		})
+
+		log.Info().Msg("OIDC tokens of user: " + user.Id.Hex() + " issued to client: " + client.Id.Hex())
+		c.JSON(http.StatusOK, oidc.TokenResponse{
+			AccessToken: accessToken,
+			TokenType:   "Bearer",
+			ExpiresIn:   int64(oidcTokenTTL.Seconds()),
+			IDToken:     idToken,
+			Scope:       code.Scope,
+		})
+	}
+}
+
+// authenticateOIDCClient returns the client of a token request. Confidential clients authenticate with their secret,
+// with HTTP basic authentication or on the form, public clients only send their client_id
+func authenticateOIDCClient(ctx context.Context, c *gin.Context) (*models.OIDCClient, error) {
+	clientId, secret, basic := c.Request.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 form encodes the credentials before they are sent
+		clientId, _ = url.QueryUnescape(clientId)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientId, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	invalid := func() (*models.OIDCClient, error) {
+		if basic {
+			c.Header("WWW-Authenticate", `Basic realm="`+OIDCPrefix+`"`)
+		}
+		return nil, oidc.NewError(http.StatusUnauthorized, oidc.ErrorInvalidClient, "Client authentication failed")
+	}
+
+	id, err := primitive.ObjectIDFromHex(clientId)
+	if err != nil {
+		return invalid()
+	}
+	client, err := OIDC.FindOIDCClient(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil || client.Confidential && subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return invalid()
+	}
+	return client, nil
+}
+
+// GetOIDCUserInfo returns the claims of the user of an access token, as the scope of the token allows
+func GetOIDCUserInfo() gin.HandlerFunc {
+	log.Info().Msg("OIDC userinfo endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		invalid := oidc.NewError(http.StatusUnauthorized, oidc.ErrorInvalidToken, "Invalid or expired access token")
+
+		claims, err := OIDCSigner.Verify(bearerToken(c), oidc.AccessTokenType)
+		if err != nil || claims["iss"] != OIDCIssuer {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			oidcError(c, invalid)
+			return
+		}
+
+		subject, _ := claims["sub"].(string)
+		company, _ := claims["company"].(string)
+		userId, _ := primitive.ObjectIDFromHex(subject)
+		companyId, _ := primitive.ObjectIDFromHex(company)
+		user, err := userService().Get(configs.WithTenant(ctx, companyId), userId)
+		if errors.Is(err, services.ErrUserNotFound) || err == nil && (!user.IsActive() || user.Company != companyId) {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			oidcError(c, invalid)
+			return
+		}
+		if err != nil {
+			oidcError(c, err)
+			return
+		}
+
+		scope, _ := claims["scope"].(string)
+		c.JSON(http.StatusOK, oidc.UserInfo(user, scope))
+	}
+}
+
+// oidcError answers an error of the OAuth protocol, other errors are answered as server_error
+func oidcError(c *gin.Context, err error) {
+	var oidcErr *oidc.Error
+	if !errors.As(err, &oidcErr) {
+		log.Error().Err(err).Msg("Error of the OpenID Connect provider")
+		oidcErr = oidc.NewError(http.StatusInternalServerError, oidc.ErrorServerError, "Internal error")
+	} else {
+		log.Error().Msg("OIDC error: " + oidcErr.Error())
+	}
+	c.AbortWithStatusJSON(oidcErr.StatusCode(), oidcErr)
+}
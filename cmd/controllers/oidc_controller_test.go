@@ -0,0 +1,437 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/models"
+	"user-service/internal/oidc"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MockOIDCStore is a mock implementation of the OIDC operations that keeps the clients and codes in maps
+type MockOIDCStore struct {
+	Clients map[primitive.ObjectID]*models.OIDCClient
+	Codes   map[string]models.AuthorizationCode
+}
+
+func NewMockOIDCStore() *MockOIDCStore {
+	return &MockOIDCStore{Clients: map[primitive.ObjectID]*models.OIDCClient{}, Codes: map[string]models.AuthorizationCode{}}
+}
+
+// CreateOIDCClient mocks the creation of a client
+func (s *MockOIDCStore) CreateOIDCClient(ctx context.Context, client models.OIDCClient) (primitive.ObjectID, error) {
+	client.Id = primitive.NewObjectID()
+	s.Clients[client.Id] = &client
+	return client.Id, nil
+}
+
+// FindOIDCClient mocks the retrieval of a client by id
+func (s *MockOIDCStore) FindOIDCClient(ctx context.Context, id primitive.ObjectID) (*models.OIDCClient, error) {
+	return s.Clients[id], nil
+}
+
+// FindCompanyOIDCClients mocks the retrieval of the clients of a company
+func (s *MockOIDCStore) FindCompanyOIDCClients(ctx context.Context, companyId primitive.ObjectID) ([]*models.OIDCClient, error) {
+	clients := []*models.OIDCClient{}
+	for _, client := range s.Clients {
+		if client.Company == companyId {
+			clients = append(clients, client)
+		}
+	}
+	return clients, nil
+}
+
+// DeleteOIDCClient mocks the deletion of a client
+func (s *MockOIDCStore) DeleteOIDCClient(ctx context.Context, companyId, id primitive.ObjectID) error {
+	client, found := s.Clients[id]
+	if !found || client.Company != companyId {
+		return mongo.ErrNoDocuments
+	}
+	delete(s.Clients, id)
+	return nil
+}
+
+// CreateAuthorizationCode mocks the storage of a code
+func (s *MockOIDCStore) CreateAuthorizationCode(ctx context.Context, code models.AuthorizationCode) error {
+	s.Codes[code.CodeHash] = code
+	return nil
+}
+
+// ConsumeAuthorizationCode mocks using up a code that did not expire
+func (s *MockOIDCStore) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*models.AuthorizationCode, error) {
+	code, found := s.Codes[codeHash]
+	delete(s.Codes, codeHash)
+	if !found || !code.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	return &code, nil
+}
+
+var testOIDCSigner *auth.Signer
+
+func init() {
+	key, _ := auth.GenerateSigningKey()
+	testOIDCSigner = auth.NewSigner(key)
+}
+
+// oidcTestServer serves the provider and the client management routes, with an admin of a company and a user of another one
+func oidcTestServer(t *testing.T) (*httptest.Server, *models.UserWithCompanyAsObject, *models.UserWithCompanyAsObject) {
+	admin := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Jane Smith", Email: "jane@example.com", Role: models.RoleAdmin, Company: primitive.NewObjectID(), Version: 1}
+	other := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Other Company", Email: "other@example.com", Role: models.RoleUser, Company: primitive.NewObjectID(), Version: 1}
+	DB = memoryUsers(admin, other)
+	Sessions = memorySessions()
+	Audit = &MockAuditLog{}
+	OIDC = NewMockOIDCStore()
+	OIDCSigner = testOIDCSigner
+	OIDCAuthorizationURL = ""
+
+	router := gin.Default()
+	clients := router.Group("/companies/:companyId/oidc-clients", RequireSession(), RequireRole(models.RoleAdmin))
+	clients.POST("", CreateOIDCClient())
+	clients.GET("", GetOIDCClients())
+	clients.DELETE("/:clientId", DeleteOIDCClient())
+	router.GET("/.well-known/openid-configuration", GetOpenIDConfiguration())
+	oauth := router.Group(OIDCPrefix)
+	oauth.GET("/authorize", RequireSession(), AuthorizeOIDC())
+	oauth.POST("/token", ExchangeOIDCToken())
+	oauth.GET("/userinfo", GetOIDCUserInfo())
+	oauth.GET("/jwks", GetJWKS())
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	OIDCIssuer = server.URL
+	return server, admin, other
+}
+
+// registerOIDCClient registers a client of the company of the admin, returning its id and secret
+func registerOIDCClient(t *testing.T, server *httptest.Server, admin *models.UserWithCompanyAsObject, confidential bool) (string, string) {
+	body, _ := json.Marshal(models.CreateOIDCClientRequest{Name: "Dashboard", RedirectURIs: []string{"https://app.example.com/callback"}, Confidential: confidential})
+	req, _ := http.NewRequest("POST", server.URL+"/companies/"+admin.Company.Hex()+"/oidc-clients", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testSessionToken(admin))
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var response responses.UserResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	data := response.Data
+	secret, _ := data["secret"].(string)
+	return data["client"].(map[string]interface{})["_id"].(string), secret
+}
+
+// oidcTestClient is a relying party that signs users in like an application of a company would,
+// it only knows the URL of the provider and verifies the tokens with the published keys
+type oidcTestClient struct {
+	t            *testing.T
+	provider     string
+	clientId     string
+	secret       string
+	redirectURI  string
+	verifier     string
+	state        string
+	nonce        string
+	discovery    oidc.Configuration
+	lastResponse *http.Response
+}
+
+func newOIDCTestClient(t *testing.T, provider, clientId, secret string) *oidcTestClient {
+	verifier, _ := auth.GenerateToken()
+	state, _ := auth.GenerateToken()
+	client := &oidcTestClient{t: t, provider: provider, clientId: clientId, secret: secret, redirectURI: "https://app.example.com/callback", verifier: verifier, state: state, nonce: "n-0S6_WzA2Mj"}
+	client.getJSON(provider+"/.well-known/openid-configuration", "", &client.discovery)
+	return client
+}
+
+func (o *oidcTestClient) getJSON(target, bearer string, value interface{}) int {
+	req, _ := http.NewRequest("GET", target, nil)
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(o.t, err)
+	defer resp.Body.Close()
+	o.lastResponse = resp
+	json.NewDecoder(resp.Body).Decode(value)
+	return resp.StatusCode
+}
+
+// authorize sends the user of the session to the authorization endpoint, and returns where the user is sent back to
+func (o *oidcTestClient) authorize(session string, params url.Values) (int, *url.URL) {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.clientId},
+		"redirect_uri":          {o.redirectURI},
+		"scope":                 {"openid profile email"},
+		"state":                 {o.state},
+		"nonce":                 {o.nonce},
+		"code_challenge":        {auth.CodeChallenge(o.verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	for name, values := range params {
+		query[name] = values
+	}
+	req, _ := http.NewRequest("GET", o.discovery.AuthorizationEndpoint+"?"+query.Encode(), nil)
+	req.Header.Set("Authorization", "Bearer "+session)
+	// The redirect is read instead of followed, the redirect URI is not served
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Do(req)
+	assert.NoError(o.t, err)
+	defer resp.Body.Close()
+	o.lastResponse = resp
+	redirect, _ := url.Parse(resp.Header.Get("Location"))
+	return resp.StatusCode, redirect
+}
+
+// exchange sends the code to the token endpoint, confidential clients authenticate with HTTP basic authentication
+func (o *oidcTestClient) exchange(form url.Values) (int, map[string]interface{}) {
+	for name, value := range map[string]string{"grant_type": "authorization_code", "redirect_uri": o.redirectURI, "code_verifier": o.verifier} {
+		if form.Get(name) == "" {
+			form.Set(name, value)
+		}
+	}
+	if o.secret == "" {
+		form.Set("client_id", o.clientId)
+	}
+	req, _ := http.NewRequest("POST", o.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if o.secret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.clientId), url.QueryEscape(o.secret))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(o.t, err)
+	defer resp.Body.Close()
+	o.lastResponse = resp
+	var body map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+// verify checks the signature of a token with the key of the JWKS, and returns its claims
+func (o *oidcTestClient) verify(token string) map[string]interface{} {
+	var jwks auth.JWKS
+	o.getJSON(o.discovery.JWKSURI, "", &jwks)
+	parts := strings.Split(token, ".")
+	if !assert.Len(o.t, parts, 3) {
+		return nil
+	}
+	var header map[string]string
+	decoded, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	json.Unmarshal(decoded, &header)
+	assert.Equal(o.t, "RS256", header["alg"])
+
+	for _, jwk := range jwks.Keys {
+		if jwk.Kid != header["kid"] {
+			continue
+		}
+		n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		assert.NoError(o.t, rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature))
+
+		var claims map[string]interface{}
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		json.Unmarshal(payload, &claims)
+		return claims
+	}
+	o.t.Errorf("no key %s in the JWKS", header["kid"])
+	return nil
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	server, admin, _ := oidcTestServer(t)
+	clientId, secret := registerOIDCClient(t, server, admin, true)
+	assert.NotEmpty(t, secret)
+	client := newOIDCTestClient(t, server.URL, clientId, secret)
+
+	assert.Equal(t, server.URL, client.discovery.Issuer)
+	assert.Equal(t, server.URL+"/oauth2/token", client.discovery.TokenEndpoint)
+	assert.Equal(t, []string{"S256"}, client.discovery.CodeChallengeMethodsSupported)
+
+	// The issuer is the configured one whatever Host the request is sent with
+	req, _ := http.NewRequest("GET", server.URL+"/.well-known/openid-configuration", nil)
+	req.Host = "evil.example.com"
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	var forged oidc.Configuration
+	json.NewDecoder(resp.Body).Decode(&forged)
+	resp.Body.Close()
+	assert.Equal(t, server.URL, forged.Issuer)
+
+	// The user signed in to the service authorizes the client, and is sent back with a code and the state
+	status, redirect := client.authorize(testSessionToken(admin), nil)
+	assert.Equal(t, http.StatusFound, status)
+	assert.Equal(t, "app.example.com", redirect.Host)
+	assert.Equal(t, client.state, redirect.Query().Get("state"))
+	code := redirect.Query().Get("code")
+	assert.NotEmpty(t, code)
+
+	status, tokens := client.exchange(url.Values{"code": {code}})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "no-store", client.lastResponse.Header.Get("Cache-Control"))
+	assert.Equal(t, "Bearer", tokens["token_type"])
+	assert.Equal(t, float64(3600), tokens["expires_in"])
+	assert.Equal(t, "openid profile email", tokens["scope"])
+
+	idToken := client.verify(tokens["id_token"].(string))
+	assert.Equal(t, server.URL, idToken["iss"])
+	assert.Equal(t, clientId, idToken["aud"])
+	assert.Equal(t, admin.Id.Hex(), idToken["sub"])
+	assert.Equal(t, client.nonce, idToken["nonce"])
+	assert.Equal(t, admin.Company.Hex(), idToken["company"])
+	assert.Equal(t, models.RoleAdmin, idToken["role"])
+	assert.Equal(t, admin.Email, idToken["email"])
+	assert.Equal(t, admin.Name, idToken["name"])
+
+	accessToken := client.verify(tokens["access_token"].(string))
+	assert.Equal(t, admin.Company.Hex(), accessToken["company"])
+	assert.Equal(t, models.RoleAdmin, accessToken["role"])
+
+	var userInfo map[string]interface{}
+	assert.Equal(t, http.StatusOK, client.getJSON(client.discovery.UserinfoEndpoint, tokens["access_token"].(string), &userInfo))
+	assert.Equal(t, map[string]interface{}{"sub": admin.Id.Hex(), "name": admin.Name, "email": admin.Email, "company": admin.Company.Hex(), "role": models.RoleAdmin}, userInfo)
+
+	// The ID token is not an access token, and a code can only be exchanged once
+	assert.Equal(t, http.StatusUnauthorized, client.getJSON(client.discovery.UserinfoEndpoint, tokens["id_token"].(string), &userInfo))
+	assert.Equal(t, `Bearer error="invalid_token"`, client.lastResponse.Header.Get("WWW-Authenticate"))
+	status, body := client.exchange(url.Values{"code": {code}})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, oidc.ErrorInvalidGrant, body["error"])
+
+	audit := Audit.(*MockAuditLog)
+	assert.Equal(t, models.AuditActionOIDCTokenIssued, audit.Events[len(audit.Events)-1].Action)
+}
+
+func TestOIDCPublicClient(t *testing.T) {
+	server, admin, _ := oidcTestServer(t)
+	clientId, secret := registerOIDCClient(t, server, admin, false)
+	assert.Empty(t, secret)
+	client := newOIDCTestClient(t, server.URL, clientId, "")
+
+	_, redirect := client.authorize(testSessionToken(admin), url.Values{"scope": {"openid"}})
+	status, body := client.exchange(url.Values{"code": {redirect.Query().Get("code")}})
+	assert.Equal(t, http.StatusOK, status)
+	idToken := client.verify(body["id_token"].(string))
+	assert.NotContains(t, idToken, "email")
+	assert.Equal(t, admin.Company.Hex(), idToken["company"])
+
+	// Without the verifier the code is useless to whoever intercepted it
+	_, redirect = client.authorize(testSessionToken(admin), nil)
+	other, _ := auth.GenerateToken()
+	status, body = client.exchange(url.Values{"code": {redirect.Query().Get("code")}, "code_verifier": {other + "x"}})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, oidc.ErrorInvalidGrant, body["error"])
+}
+
+func TestOIDCAuthorizationErrors(t *testing.T) {
+	server, admin, other := oidcTestServer(t)
+	clientId, secret := registerOIDCClient(t, server, admin, true)
+	client := newOIDCTestClient(t, server.URL, clientId, secret)
+	session := testSessionToken(admin)
+
+	// Unknown clients and redirect URIs are never redirected to
+	var response responses.UserResponse
+	query := url.Values{"client_id": {clientId}, "redirect_uri": {"https://evil.example.com/callback"}}
+	assert.Equal(t, http.StatusBadRequest, client.getJSON(client.discovery.AuthorizationEndpoint+"?"+query.Encode(), session, &response))
+	query = url.Values{"client_id": {primitive.NewObjectID().Hex()}, "redirect_uri": {client.redirectURI}}
+	assert.Equal(t, http.StatusBadRequest, client.getJSON(client.discovery.AuthorizationEndpoint+"?"+query.Encode(), session, &response))
+	assert.Equal(t, http.StatusUnauthorized, client.getJSON(client.discovery.AuthorizationEndpoint, "", &response))
+
+	// Other errors go back to the client with the state
+	redirectErrors := map[string]url.Values{
+		oidc.ErrorInvalidRequest:          {"code_challenge_method": {"plain"}},
+		oidc.ErrorInvalidScope:            {"scope": {"profile"}},
+		oidc.ErrorUnsupportedResponseType: {"response_type": {"token"}},
+	}
+	for code, params := range redirectErrors {
+		status, redirect := client.authorize(session, params)
+		assert.Equal(t, http.StatusFound, status)
+		assert.Equal(t, code, redirect.Query().Get("error"))
+		assert.Equal(t, client.state, redirect.Query().Get("state"))
+		assert.Empty(t, redirect.Query().Get("code"))
+	}
+
+	// Users of other companies can not sign in to the applications of the company
+	_, redirect := client.authorize(testSessionToken(other), nil)
+	assert.Equal(t, oidc.ErrorAccessDenied, redirect.Query().Get("error"))
+
+	// Confidential clients need their secret
+	_, redirect = client.authorize(session, nil)
+	code := redirect.Query().Get("code")
+	wrongSecret := newOIDCTestClient(t, server.URL, clientId, "wrong")
+	status, body := wrongSecret.exchange(url.Values{"code": {code}})
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, oidc.ErrorInvalidClient, body["error"])
+	assert.NotEmpty(t, wrongSecret.lastResponse.Header.Get("WWW-Authenticate"))
+	status, body = newOIDCTestClient(t, server.URL, clientId, "").exchange(url.Values{"code": {code}})
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, oidc.ErrorInvalidClient, body["error"])
+
+	status, body = client.exchange(url.Values{"code": {code}, "grant_type": {"password"}})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, oidc.ErrorUnsupportedGrantType, body["error"])
+	status, body = client.exchange(url.Values{"code": {"unknown"}})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, oidc.ErrorInvalidGrant, body["error"])
+
+	// Deactivated users can no longer exchange their codes
+	_, redirect = client.authorize(session, nil)
+	inactive := false
+	DB.UpdateUser(context.Background(), admin.Id, 1, models.UserUpdate{Active: &inactive})
+	status, body = client.exchange(url.Values{"code": {redirect.Query().Get("code")}})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, oidc.ErrorInvalidGrant, body["error"])
+}
+
+func TestOIDCClients(t *testing.T) {
+	server, admin, other := oidcTestServer(t)
+	clientId, _ := registerOIDCClient(t, server, admin, false)
+	session := testSessionToken(admin)
+	clients := server.URL + "/companies/" + admin.Company.Hex() + "/oidc-clients"
+
+	var response responses.UserResponse
+	client := &oidcTestClient{t: t}
+	assert.Equal(t, http.StatusOK, client.getJSON(clients, session, &response))
+	listed := response.Data["clients"].([]interface{})
+	if assert.Len(t, listed, 1) {
+		assert.Equal(t, clientId, listed[0].(map[string]interface{})["_id"])
+		assert.NotContains(t, listed[0], "secretHash")
+	}
+	assert.Equal(t, http.StatusForbidden, client.getJSON(server.URL+"/companies/"+other.Company.Hex()+"/oidc-clients", session, &response))
+
+	body, _ := json.Marshal(map[string]interface{}{"name": "Dashboard", "redirectUris": []string{"not a url"}})
+	req, _ := http.NewRequest("POST", clients, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+session)
+	resp, _ := http.DefaultClient.Do(req)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	for _, expected := range []int{http.StatusOK, http.StatusNotFound} {
+		req, _ = http.NewRequest("DELETE", clients+"/"+clientId, nil)
+		req.Header.Set("Authorization", "Bearer "+session)
+		resp, _ = http.DefaultClient.Do(req)
+		resp.Body.Close()
+		assert.Equal(t, expected, resp.StatusCode)
+	}
+	assert.Equal(t, models.AuditActionOIDCClientDeleted, Audit.(*MockAuditLog).Events[len(Audit.(*MockAuditLog).Events)-1].Action)
+}
@@ -0,0 +1,25 @@
+package controllers
+
+import (
+	"net/http"
+	"user-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// GetOpenAPIDocument serves the OpenAPI document clients are generated from
+func GetOpenAPIDocument(document *openapi.Document) gin.HandlerFunc {
+	log.Info().Msg("Get OpenAPI document endpoint reached")
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, document)
+	}
+}
+
+// GetAPIReference serves the page that renders the OpenAPI document
+func GetAPIReference() gin.HandlerFunc {
+	log.Info().Msg("Get API reference endpoint reached")
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", openapi.ReferencePage)
+	}
+}
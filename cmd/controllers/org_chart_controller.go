@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+	"user-service/cmd/responses"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// GetUserReports returns the users that report to a user of the company of the caller. The depth query parameter
+// is how many levels below the user are returned, 1 by default for its direct reports
+func GetUserReports() gin.HandlerFunc {
+	log.Info().Msg("Get user reports endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+		userId := c.Param("userId")
+
+		// Depths that are not numbers are rejected by the service like the ones out of range
+		depth, err := strconv.Atoi(c.DefaultQuery("depth", "1"))
+		if err != nil {
+			depth = 0
+		}
+		reports, err := userService().Reports(ctx, callerCompany(c), userId, depth)
+		if err != nil {
+			userServiceError(c, err, "Error getting user reports from database")
+			return
+		}
+
+		log.Info().Msg("Reports of user: " + userId + " retrieved successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"users": reports}})
+	}
+}
+
+// GetUserChain returns the managers of a user of the company of the caller, from its own manager up to the top
+func GetUserChain() gin.HandlerFunc {
+	log.Info().Msg("Get user manager chain endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+		userId := c.Param("userId")
+
+		managers, err := userService().ManagerChain(ctx, callerCompany(c), userId)
+		if err != nil {
+			userServiceError(c, err, "Error getting user managers from database")
+			return
+		}
+
+		log.Info().Msg("Managers of user: " + userId + " retrieved successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"users": managers}})
+	}
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// setUpOrgChart returns the user routes with an admin at the top of its company, two other users of the company
+// and a user of another company, nobody reports to anybody yet
+func setUpOrgChart() (*gin.Engine, string, []*models.UserWithCompanyAsObject, *models.UserWithCompanyAsObject) {
+	Groups = NewMockGroupStore()
+	Sessions = memorySessions()
+	CompanySettings = &MockCompanySettingsStore{}
+	companyId := primitive.NewObjectID()
+	admin := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Ada Chief", Email: "ada@example.com", Role: models.RoleAdmin, Company: companyId, Version: 1}
+	vp := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Vera Lead", Email: "vera@example.com", Role: models.RoleUser, Company: companyId, Version: 1}
+	engineer := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Eli Engineer", Email: "eli@example.com", Role: models.RoleUser, Company: companyId, Version: 1}
+	other := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Other Company", Email: "other@example.com", Role: models.RoleUser, Company: primitive.NewObjectID(), Version: 1}
+	DB = memoryUsers(admin, vp, engineer, other)
+
+	router := gin.Default()
+	router.POST("/users", RequireSession(), RequireRole(models.RoleAdmin), CreateUser())
+	router.PATCH("/users/:userId", RequireSession(), UpdateUser())
+	router.DELETE("/users/:userId", RequireSession(), RequireRole(models.RoleAdmin), DeleteUser())
+	router.GET("/users/:userId/reports", RequireSession(), GetUserReports())
+	router.GET("/users/:userId/chain", RequireSession(), GetUserChain())
+	return router, testSessionToken(admin), []*models.UserWithCompanyAsObject{admin, vp, engineer}, other
+}
+
+// userNames returns the names of the users of a response
+func userNames(response map[string]interface{}) []string {
+	names := []string{}
+	for _, user := range response["users"].([]interface{}) {
+		names = append(names, user.(map[string]interface{})["name"].(string))
+	}
+	return names
+}
+
+func TestOrgChart(t *testing.T) {
+	router, token, users, other := setUpOrgChart()
+	admin, vp, engineer := users[0], users[1], users[2]
+
+	resp, response := patchUser(router, "/users/"+vp.Id.Hex(), token, map[string]string{"managerId": admin.Id.Hex()})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, admin.Id.Hex(), response.Data["user"].(map[string]interface{})["managerId"])
+	resp, _ = patchUser(router, "/users/"+engineer.Id.Hex(), token, map[string]string{"managerId": vp.Id.Hex()})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp, response = authRequest(router, "POST", "/users", token, models.User{Name: "Nia New", Email: "nia@example.com", Password: "Tr0ub4dor&3x", Role: models.RoleUser, Company: admin.Company.Hex(), ManagerId: vp.Id.Hex()})
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, vp.Id.Hex(), response.Data["user"].(map[string]interface{})["managerId"])
+
+	resp, response = authRequest(router, "GET", "/users/"+admin.Id.Hex()+"/reports", token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, []string{"Vera Lead"}, userNames(response.Data))
+	_, response = authRequest(router, "GET", "/users/"+admin.Id.Hex()+"/reports?depth=2", token, nil)
+	assert.Equal(t, []string{"Vera Lead", "Eli Engineer", "Nia New"}, userNames(response.Data))
+	_, response = authRequest(router, "GET", "/users/"+engineer.Id.Hex()+"/reports?depth=20", token, nil)
+	assert.Empty(t, response.Data["users"])
+	for _, depth := range []string{"0", "21", "all"} {
+		resp, _ = authRequest(router, "GET", "/users/"+admin.Id.Hex()+"/reports?depth="+depth, token, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.Code, depth)
+	}
+
+	resp, response = authRequest(router, "GET", "/users/"+engineer.Id.Hex()+"/chain", token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, []string{"Vera Lead", "Ada Chief"}, userNames(response.Data))
+	_, response = authRequest(router, "GET", "/users/"+admin.Id.Hex()+"/chain", token, nil)
+	assert.Empty(t, response.Data["users"])
+
+	// An empty managerId removes the manager
+	resp, response = patchUser(router, "/users/"+engineer.Id.Hex(), token, map[string]string{"managerId": ""})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotContains(t, response.Data["user"], "managerId")
+	_, response = authRequest(router, "GET", "/users/"+engineer.Id.Hex()+"/chain", token, nil)
+	assert.Empty(t, response.Data["users"])
+
+	// Deleting a manager leaves its reports without one, before the database deletes it
+	mockDB := DB.(*MockDB)
+	deleteUser := mockDB.DeleteUserFunc
+	mockDB.DeleteUserFunc = func(ctx context.Context, id primitive.ObjectID) error {
+		reports, _ := mockDB.FindReports(ctx, admin.Company, id, 1)
+		assert.Empty(t, reports)
+		return deleteUser(ctx, id)
+	}
+	resp, _ = authRequest(router, "DELETE", "/users/"+vp.Id.Hex(), token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	_, response = authRequest(router, "GET", "/users/"+admin.Id.Hex()+"/reports?depth=2", token, nil)
+	assert.Empty(t, response.Data["users"])
+
+	// Users of other companies are not found
+	resp, _ = authRequest(router, "GET", "/users/"+other.Id.Hex()+"/reports", token, nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp, _ = authRequest(router, "GET", "/users/"+other.Id.Hex()+"/chain", token, nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestUpdateManagerRejectsCycles(t *testing.T) {
+	router, token, users, other := setUpOrgChart()
+	admin, vp, engineer := users[0], users[1], users[2]
+	patchUser(router, "/users/"+vp.Id.Hex(), token, map[string]string{"managerId": admin.Id.Hex()})
+	patchUser(router, "/users/"+engineer.Id.Hex(), token, map[string]string{"managerId": vp.Id.Hex()})
+
+	resp, _ := patchUser(router, "/users/"+admin.Id.Hex(), token, map[string]string{"managerId": engineer.Id.Hex()})
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	resp, _ = patchUser(router, "/users/"+vp.Id.Hex(), token, map[string]string{"managerId": vp.Id.Hex()})
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	// Managers have to be users of the same company
+	resp, _ = patchUser(router, "/users/"+vp.Id.Hex(), token, map[string]string{"managerId": other.Id.Hex()})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp, _ = patchUser(router, "/users/"+vp.Id.Hex(), token, map[string]string{"managerId": "not-an-id"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp, _ = authRequest(router, "POST", "/users", token, models.User{Name: "Nia New", Email: "nia@example.com", Password: "Tr0ub4dor&3x", Role: models.RoleUser, Company: admin.Company.Hex(), ManagerId: other.Id.Hex()})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// Only admins change managers
+	resp, _ = patchUser(router, "/users/"+engineer.Id.Hex(), testSessionToken(engineer), map[string]string{"managerId": admin.Id.Hex()})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	_, response := authRequest(router, "GET", "/users/"+engineer.Id.Hex()+"/chain", token, nil)
+	assert.Equal(t, []string{"Vera Lead", "Ada Chief"}, userNames(response.Data))
+}
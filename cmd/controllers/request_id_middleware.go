@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"context"
+	"regexp"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	requestIdHeader = "X-Request-ID"
+	requestIdKey    = "requestId"
+)
+
+// validRequestId limits the ids taken from clients, so they can not write anything on the logs
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID keeps the X-Request-ID of the request, or generates one, and returns it on the response
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestId := c.GetHeader(requestIdHeader)
+		if !validRequestId.MatchString(requestId) {
+			requestId, _ = auth.GenerateToken()
+		}
+		c.Set(requestIdKey, requestId)
+		c.Header(requestIdHeader, requestId)
+		c.Next()
+	}
+}
+
+// tenantContext returns a background context scoped to the company of the API key or the user of the request,
+// the database only reaches the users of that company. Requests without either have no scope
+func tenantContext(c *gin.Context) context.Context {
+	if apiKey := CurrentAPIKey(c); apiKey != nil {
+		return configs.WithTenant(context.Background(), apiKey.Company)
+	}
+	if user := CurrentUser(c); user != nil {
+		return configs.WithTenant(context.Background(), user.Company)
+	}
+	return context.Background()
+}
+
+// callerCompany returns the company of the API key or the user of the request
+func callerCompany(c *gin.Context) primitive.ObjectID {
+	if apiKey := CurrentAPIKey(c); apiKey != nil {
+		return apiKey.Company
+	}
+	if user := CurrentUser(c); user != nil {
+		return user.Company
+	}
+	return primitive.NilObjectID
+}
+
+// auditContext returns the tenant context carrying who makes the request, so the changes it makes are audited with it
+func auditContext(c *gin.Context) context.Context {
+	info := configs.AuditInfo{RequestId: c.GetString(requestIdKey), IP: c.ClientIP()}
+	if user := CurrentUser(c); user != nil {
+		info.Actor = &user.Id
+	}
+	if apiKey := CurrentAPIKey(c); apiKey != nil {
+		info.APIKey = &apiKey.Id
+	}
+	return configs.WithAuditInfo(tenantContext(c), info)
+}
+
+// withAuthenticatedUser scopes the context to the company of the user and makes it the actor of the changes, for
+// handlers that authenticate the user themselves like the login
+func withAuthenticatedUser(ctx context.Context, user *models.UserWithCompanyAsObject) context.Context {
+	info := configs.AuditInfoFromContext(ctx)
+	info.Actor = &user.Id
+	return configs.WithAuditInfo(configs.WithTenant(ctx, user.Company), info)
+}
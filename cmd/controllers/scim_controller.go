@@ -0,0 +1,301 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"user-service/internal/models"
+	"user-service/internal/scim"
+	"user-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// SCIMPrefix is the path the SCIM 2.0 endpoints are served under, outside of the versions of the API
+const SCIMPrefix = "/scim/v2"
+
+// RequireSCIMToken lets through the identity provider of a company, authenticated by an API key of the company
+// with the scim scope sent as a bearer token. Errors are answered as SCIM errors
+func RequireSCIMToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if !strings.HasPrefix(token, models.APIKeyPrefix) {
+			scimError(c, scim.NewError(http.StatusUnauthorized, "", "An API key with the scim scope is required as bearer token"))
+			return
+		}
+		apiKey, status, message := authenticateAPIKey(c.ClientIP(), token, models.APIKeyScopeSCIM)
+		if apiKey == nil {
+			scimError(c, scim.NewError(status, "", message))
+			return
+		}
+		c.Set(currentAPIKeyKey, apiKey)
+		c.Next()
+	}
+}
+
+func GetSCIMServiceProviderConfig() gin.HandlerFunc {
+	log.Info().Msg("SCIM service provider config endpoint reached")
+	return func(c *gin.Context) {
+		scimJSON(c, http.StatusOK, scim.NewServiceProviderConfig(scimLocation(c, "/ServiceProviderConfig")))
+	}
+}
+
+func GetSCIMResourceTypes() gin.HandlerFunc {
+	log.Info().Msg("SCIM resource types endpoint reached")
+	return func(c *gin.Context) {
+		resourceTypes := []scim.ResourceType{scim.UserResourceType(scimLocation(c, "/ResourceTypes/User"))}
+		scimJSON(c, http.StatusOK, scim.NewListResponse(resourceTypes, 1, len(resourceTypes)))
+	}
+}
+
+func GetSCIMResourceType() gin.HandlerFunc {
+	log.Info().Msg("SCIM resource type endpoint reached")
+	return func(c *gin.Context) {
+		if c.Param("resourceTypeId") != "User" {
+			scimError(c, scim.NewError(http.StatusNotFound, "", "Resource type "+c.Param("resourceTypeId")+" not found"))
+			return
+		}
+		scimJSON(c, http.StatusOK, scim.UserResourceType(scimLocation(c, "/ResourceTypes/User")))
+	}
+}
+
+func GetSCIMSchemas() gin.HandlerFunc {
+	log.Info().Msg("SCIM schemas endpoint reached")
+	return func(c *gin.Context) {
+		schemas := []scim.Schema{scim.UserSchemaDefinition(scimLocation(c, "/Schemas/"+scim.UserSchema))}
+		scimJSON(c, http.StatusOK, scim.NewListResponse(schemas, 1, len(schemas)))
+	}
+}
+
+func GetSCIMSchema() gin.HandlerFunc {
+	log.Info().Msg("SCIM schema endpoint reached")
+	return func(c *gin.Context) {
+		if c.Param("schemaId") != scim.UserSchema {
+			scimError(c, scim.NewError(http.StatusNotFound, "", "Schema "+c.Param("schemaId")+" not found"))
+			return
+		}
+		scimJSON(c, http.StatusOK, scim.UserSchemaDefinition(scimLocation(c, "/Schemas/"+scim.UserSchema)))
+	}
+}
+
+// GetSCIMUsers lists the users of the company that match the filter parameter, a page at a time
+func GetSCIMUsers() gin.HandlerFunc {
+	log.Info().Msg("SCIM get users endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+
+		var filter scim.Filter
+		if expression := c.Query("filter"); expression != "" {
+			var err error
+			if filter, err = scim.ParseFilter(expression); err != nil {
+				scimServiceError(c, err)
+				return
+			}
+		}
+		startIndex, count := scim.Pagination(c.Query("startIndex"), c.Query("count"))
+
+		// Users are filtered while they are read, so only the matching ones are kept in memory
+		matching := []scim.User{}
+		err := userService().StreamByCompany(ctx, CurrentAPIKey(c).Company, func(user *models.UserWithCompanyAsObject) error {
+			resource := scim.FromUser(user, scimLocation(c, "/Users/"+user.Id.Hex()))
+			if filter == nil || filter.Matches(resource) {
+				matching = append(matching, resource)
+			}
+			return nil
+		})
+		if err != nil {
+			scimServiceError(c, err)
+			return
+		}
+
+		scimJSON(c, http.StatusOK, scim.NewListResponse(matching, startIndex, count))
+	}
+}
+
+func GetSCIMUser() gin.HandlerFunc {
+	log.Info().Msg("SCIM get user endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
+		defer cancel()
+
+		user, err := userService().UserOfCompany(ctx, CurrentAPIKey(c).Company, c.Param("userId"))
+		if err != nil {
+			scimServiceError(c, err)
+			return
+		}
+		scimJSON(c, http.StatusOK, scim.FromUser(user, scimLocation(c, "/Users/"+user.Id.Hex())))
+	}
+}
+
+// CreateSCIMUser creates a user in the company of the identity provider. Users created without a password
+// set it with the forgot password flow
+func CreateSCIMUser() gin.HandlerFunc {
+	log.Info().Msg("SCIM create user endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+
+		var resource scim.User
+		if err := c.ShouldBindJSON(&resource); err != nil {
+			scimError(c, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidSyntax, err.Error()))
+			return
+		}
+		name := resource.FullName()
+		if name == "" {
+			name = resource.UserName
+		}
+
+		created, err := userService().Create(ctx, services.CreateUserInput{
+			Name:                  name,
+			Email:                 resource.UserName,
+			Password:              resource.Password,
+			Role:                  resource.Role(models.RoleUser),
+			Company:               CurrentAPIKey(c).Company.Hex(),
+			ExternalId:            resource.ExternalId,
+			Active:                resource.Active,
+			PasswordResetRequired: resource.Password == "",
+			// Identity providers do not know the attribute schema, admins set the required attributes afterwards
+			WithoutRequiredAttributes: true,
+		})
+		if err != nil {
+			scimServiceError(c, err)
+			return
+		}
+
+		log.Info().Msg("User: " + created.Id.Hex() + " provisioned over SCIM")
+		location := scimLocation(c, "/Users/"+created.Id.Hex())
+		c.Header("Location", location)
+		scimJSON(c, http.StatusCreated, scim.FromUser(created, location))
+	}
+}
+
+// ReplaceSCIMUser replaces the attributes of a user with the ones of the request
+func ReplaceSCIMUser() gin.HandlerFunc {
+	log.Info().Msg("SCIM replace user endpoint reached")
+	return func(c *gin.Context) {
+		var resource scim.User
+		if err := c.ShouldBindJSON(&resource); err != nil {
+			scimError(c, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidSyntax, err.Error()))
+			return
+		}
+		if resource.UserName == "" {
+			scimError(c, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "userName is required"))
+			return
+		}
+		provisionSCIMUser(c, func(scim.User) (scim.User, error) {
+			return resource, nil
+		})
+	}
+}
+
+// PatchSCIMUser applies the operations of the request to a user
+func PatchSCIMUser() gin.HandlerFunc {
+	log.Info().Msg("SCIM patch user endpoint reached")
+	return func(c *gin.Context) {
+		var request scim.PatchRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			scimError(c, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidSyntax, err.Error()))
+			return
+		}
+		provisionSCIMUser(c, func(user scim.User) (scim.User, error) {
+			err := scim.ApplyPatch(&user, request)
+			return user, err
+		})
+	}
+}
+
+// provisionSCIMUser stores the changes between the user and the version of it that change returns
+func provisionSCIMUser(c *gin.Context, change func(user scim.User) (scim.User, error)) {
+	ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+	defer cancel()
+	companyId := CurrentAPIKey(c).Company
+
+	user, err := userService().UserOfCompany(ctx, companyId, c.Param("userId"))
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+	location := scimLocation(c, "/Users/"+user.Id.Hex())
+	// The user is converted twice, so the changes do not share its name with the current version
+	current := scim.FromUser(user, location)
+	changed, err := change(scim.FromUser(user, location))
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+
+	updated, err := userService().Provision(ctx, companyId, user.Id.Hex(), scim.Update(current, changed))
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+	log.Info().Msg("User: " + user.Id.Hex() + " changed over SCIM")
+	scimJSON(c, http.StatusOK, scim.FromUser(updated, location))
+}
+
+func DeleteSCIMUser() gin.HandlerFunc {
+	log.Info().Msg("SCIM delete user endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+
+		if err := userService().Deprovision(ctx, CurrentAPIKey(c).Company, c.Param("userId")); err != nil {
+			scimServiceError(c, err)
+			return
+		}
+		log.Info().Msg("User: " + c.Param("userId") + " deprovisioned over SCIM")
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// scimServiceError answers an error of the user service or of the SCIM package as a SCIM error
+func scimServiceError(c *gin.Context, err error) {
+	var scimErr *scim.Error
+	var validationErr *services.ValidationError
+	var exists *services.UserExistsError
+	var conflict *services.VersionConflictError
+	switch {
+	case errors.As(err, &scimErr):
+		scimError(c, scimErr)
+	case errors.As(err, &validationErr), errors.Is(err, services.ErrInvalidCompany):
+		scimError(c, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, err.Error()))
+	case errors.As(err, &exists):
+		scimError(c, scim.NewError(http.StatusConflict, scim.ErrorUniqueness, exists.Error()))
+	case errors.As(err, &conflict):
+		scimError(c, scim.NewError(http.StatusConflict, "", "The user was changed by another request, retry"))
+	case errors.Is(err, services.ErrUserNotFound):
+		scimError(c, scim.NewError(http.StatusNotFound, "", "User "+c.Param("userId")+" not found"))
+	default:
+		log.Error().Err(err).Msg("Error provisioning users over SCIM")
+		scimError(c, scim.NewError(http.StatusInternalServerError, "", "Internal error"))
+	}
+}
+
+func scimError(c *gin.Context, err *scim.Error) {
+	log.Error().Msg("SCIM error: " + err.Detail)
+	c.Header("Content-Type", scim.ContentType)
+	c.AbortWithStatusJSON(err.StatusCode(), err)
+}
+
+func scimJSON(c *gin.Context, status int, value interface{}) {
+	c.Header("Content-Type", scim.ContentType)
+	c.JSON(status, value)
+}
+
+// scimLocation is the URL of a SCIM endpoint, with the host the request was sent to
+func scimLocation(c *gin.Context, path string) string {
+	return externalURL(c, SCIMPrefix+path)
+}
+
+// externalURL is the URL of a path of the service, with the scheme and host the request was sent to
+func externalURL(c *gin.Context, path string) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + path
+}
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"user-service/internal/auth"
+	"user-service/internal/models"
+	"user-service/internal/scim"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// scimTestRouter returns the SCIM routes with a key of a new company, and a user of another company
+func scimTestRouter() (*gin.Engine, string, *models.UserWithCompanyAsObject) {
+	store := NewMockAPIKeyStore()
+	APIKeys = store
+	companyId := primitive.NewObjectID()
+	key := models.APIKeyPrefix + "scimtestkey"
+	store.CreateAPIKey(context.Background(), models.APIKey{Company: companyId, KeyHash: auth.HashToken(key), Scopes: []string{models.APIKeyScopeSCIM}, CreatedAt: time.Now()})
+	store.CreateAPIKey(context.Background(), models.APIKey{Company: companyId, KeyHash: auth.HashToken(models.APIKeyPrefix + "readkey"), Scopes: []string{models.APIKeyScopeUsersRead}, CreatedAt: time.Now()})
+
+	other := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Other Company", Email: "other@example.com", Role: "user", Company: primitive.NewObjectID(), Version: 1}
+	DB = memoryUsers(other)
+	CompanySettings = &MockCompanySettingsStore{}
+
+	router := gin.Default()
+	scimRoutes := router.Group(SCIMPrefix, RequireSCIMToken())
+	scimRoutes.GET("/ServiceProviderConfig", GetSCIMServiceProviderConfig())
+	scimRoutes.GET("/ResourceTypes", GetSCIMResourceTypes())
+	scimRoutes.GET("/ResourceTypes/:resourceTypeId", GetSCIMResourceType())
+	scimRoutes.GET("/Schemas", GetSCIMSchemas())
+	scimRoutes.GET("/Schemas/:schemaId", GetSCIMSchema())
+	scimRoutes.GET("/Users", GetSCIMUsers())
+	scimRoutes.POST("/Users", CreateSCIMUser())
+	scimRoutes.GET("/Users/:userId", GetSCIMUser())
+	scimRoutes.PUT("/Users/:userId", ReplaceSCIMUser())
+	scimRoutes.PATCH("/Users/:userId", PatchSCIMUser())
+	scimRoutes.DELETE("/Users/:userId", DeleteSCIMUser())
+	return router, key, other
+}
+
+// scimRequest sends a SCIM request with the key as bearer token and decodes the JSON response
+func scimRequest(router *gin.Engine, method, path, key, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req, _ := http.NewRequest(method, SCIMPrefix+path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", scim.ContentType)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	var decoded map[string]interface{}
+	json.Unmarshal(resp.Body.Bytes(), &decoded)
+	return resp, decoded
+}
+
+// assertSCIMError checks the status and the SCIM error body of a response
+func assertSCIMError(t *testing.T, resp *httptest.ResponseRecorder, body map[string]interface{}, status int, scimType string) {
+	t.Helper()
+	assert.Equal(t, status, resp.Code)
+	assert.Equal(t, scim.ContentType, resp.Header().Get("Content-Type"))
+	assert.Equal(t, []interface{}{scim.ErrorSchema}, body["schemas"])
+	assert.Equal(t, strconv.Itoa(status), body["status"])
+	if scimType != "" {
+		assert.Equal(t, scimType, body["scimType"])
+	}
+}
+
+func TestSCIMAuthentication(t *testing.T) {
+	router, _, _ := scimTestRouter()
+
+	resp, body := scimRequest(router, "GET", "/Users", "", "")
+	assertSCIMError(t, resp, body, http.StatusUnauthorized, "")
+	resp, body = scimRequest(router, "GET", "/Users", models.APIKeyPrefix+"unknown", "")
+	assertSCIMError(t, resp, body, http.StatusUnauthorized, "")
+
+	// Keys need the scim scope
+	resp, body = scimRequest(router, "GET", "/Users", models.APIKeyPrefix+"readkey", "")
+	assertSCIMError(t, resp, body, http.StatusForbidden, "")
+}
+
+func TestSCIMDiscovery(t *testing.T) {
+	router, key, _ := scimTestRouter()
+
+	resp, body := scimRequest(router, "GET", "/ServiceProviderConfig", key, "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, scim.ContentType, resp.Header().Get("Content-Type"))
+	assert.Equal(t, []interface{}{scim.ServiceProviderConfigSchema}, body["schemas"])
+	assert.Equal(t, true, body["patch"].(map[string]interface{})["supported"])
+	assert.Equal(t, true, body["filter"].(map[string]interface{})["supported"])
+	assert.Equal(t, false, body["bulk"].(map[string]interface{})["supported"])
+	assert.Equal(t, "oauthbearertoken", body["authenticationSchemes"].([]interface{})[0].(map[string]interface{})["type"])
+
+	resp, body = scimRequest(router, "GET", "/ResourceTypes", key, "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, float64(1), body["totalResults"])
+	userType := body["Resources"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "/Users", userType["endpoint"])
+	assert.Equal(t, scim.UserSchema, userType["schema"])
+
+	resp, _ = scimRequest(router, "GET", "/ResourceTypes/User", key, "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp, body = scimRequest(router, "GET", "/ResourceTypes/Group", key, "")
+	assertSCIMError(t, resp, body, http.StatusNotFound, "")
+
+	resp, body = scimRequest(router, "GET", "/Schemas", key, "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	userSchema := body["Resources"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, scim.UserSchema, userSchema["id"])
+	attributes := map[string]bool{}
+	for _, attribute := range userSchema["attributes"].([]interface{}) {
+		attributes[attribute.(map[string]interface{})["name"].(string)] = true
+	}
+	for _, name := range []string{"userName", "name", "displayName", "emails", "roles", "active", "password", "externalId"} {
+		assert.True(t, attributes[name], name)
+	}
+
+	resp, _ = scimRequest(router, "GET", "/Schemas/"+scim.UserSchema, key, "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+// TestSCIMUserLifecycle follows the checks of the SCIM compliance suites of the identity providers:
+// create, read, filter, page, replace, patch, deactivate and delete a user
+func TestSCIMUserLifecycle(t *testing.T) {
+	router, key, other := scimTestRouter()
+
+	create := `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"userName":"jane.doe@example.com","externalId":"00u1",
+		"name":{"givenName":"Jane","familyName":"Doe"},"emails":[{"value":"jane.doe@example.com","type":"work","primary":true}],"active":true}`
+	resp, user := scimRequest(router, "POST", "/Users", key, create)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, scim.ContentType, resp.Header().Get("Content-Type"))
+	id := user["id"].(string)
+	assert.NotEmpty(t, id)
+	assert.True(t, strings.HasSuffix(resp.Header().Get("Location"), SCIMPrefix+"/Users/"+id))
+	assert.Equal(t, "jane.doe@example.com", user["userName"])
+	assert.Equal(t, "Jane Doe", user["displayName"])
+	assert.Equal(t, "00u1", user["externalId"])
+	assert.Equal(t, true, user["active"])
+	assert.Equal(t, "User", user["meta"].(map[string]interface{})["resourceType"])
+	assert.NotContains(t, user, "password")
+
+	// Users are provisioned in the company of the key, without a password until they reset it
+	stored, _ := DB.FindUserByEmail(context.Background(), apiKeyCompany(t, key), "jane.doe@example.com")
+	assert.Equal(t, apiKeyCompany(t, key), stored.Company)
+	assert.True(t, stored.PasswordResetRequired)
+	assert.Equal(t, models.RoleUser, stored.Role)
+
+	resp, body := scimRequest(router, "POST", "/Users", key, create)
+	assertSCIMError(t, resp, body, http.StatusConflict, scim.ErrorUniqueness)
+	resp, body = scimRequest(router, "POST", "/Users", key, `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"]}`)
+	assertSCIMError(t, resp, body, http.StatusBadRequest, scim.ErrorInvalidValue)
+	resp, body = scimRequest(router, "POST", "/Users", key, `{"userName":`)
+	assertSCIMError(t, resp, body, http.StatusBadRequest, scim.ErrorInvalidSyntax)
+
+	resp, body = scimRequest(router, "GET", "/Users/"+id, key, "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, user, body)
+	resp, body = scimRequest(router, "GET", "/Users/"+primitive.NewObjectID().Hex(), key, "")
+	assertSCIMError(t, resp, body, http.StatusNotFound, "")
+
+	// Users of other companies do not exist for the key
+	resp, body = scimRequest(router, "GET", "/Users/"+other.Id.Hex(), key, "")
+	assertSCIMError(t, resp, body, http.StatusNotFound, "")
+	resp, body = scimRequest(router, "DELETE", "/Users/"+other.Id.Hex(), key, "")
+	assertSCIMError(t, resp, body, http.StatusNotFound, "")
+
+	resp, body = scimRequest(router, "GET", `/Users?filter=userName+eq+%22JANE.DOE@example.com%22`, key, "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, []interface{}{scim.ListResponseSchema}, body["schemas"])
+	assert.Equal(t, float64(1), body["totalResults"])
+	assert.Equal(t, id, body["Resources"].([]interface{})[0].(map[string]interface{})["id"])
+
+	resp, body = scimRequest(router, "GET", `/Users?filter=userName+eq+%22other@example.com%22`, key, "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, float64(0), body["totalResults"])
+	assert.Equal(t, []interface{}{}, body["Resources"])
+
+	resp, body = scimRequest(router, "GET", `/Users?filter=nickName+eq+%22x%22`, key, "")
+	assertSCIMError(t, resp, body, http.StatusBadRequest, scim.ErrorInvalidFilter)
+
+	_, second := scimRequest(router, "POST", "/Users", key, `{"userName":"john@example.com","displayName":"John Roe","roles":[{"value":"admin","primary":true}],"password":"Tr0ub4dor&3x-Horse"}`)
+	resp, body = scimRequest(router, "GET", "/Users?startIndex=2&count=1", key, "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, float64(2), body["totalResults"])
+	assert.Equal(t, float64(2), body["startIndex"])
+	assert.Equal(t, float64(1), body["itemsPerPage"])
+	assert.Equal(t, second["id"], body["Resources"].([]interface{})[0].(map[string]interface{})["id"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"value": "admin", "primary": true}}, second["roles"])
+
+	replace := `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"userName":"jane.roe@example.com","externalId":"00u1",
+		"name":{"givenName":"Jane","familyName":"Roe"},"active":true}`
+	resp, body = scimRequest(router, "PUT", "/Users/"+id, key, replace)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "jane.roe@example.com", body["userName"])
+	assert.Equal(t, "Jane Roe", body["displayName"])
+	assert.Equal(t, map[string]interface{}{"formatted": "Jane Roe", "givenName": "Jane", "familyName": "Roe"}, body["name"])
+	resp, body = scimRequest(router, "PUT", "/Users/"+id, key, `{"userName":"john@example.com"}`)
+	assertSCIMError(t, resp, body, http.StatusConflict, scim.ErrorUniqueness)
+
+	// Azure AD sends capitalized operations and booleans as strings
+	patch := `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+		{"op":"Replace","path":"name.givenName","value":"Janet"},{"op":"Replace","path":"active","value":"False"}]}`
+	resp, body = scimRequest(router, "PATCH", "/Users/"+id, key, patch)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "Janet Roe", body["displayName"])
+	assert.Equal(t, false, body["active"])
+	assert.Equal(t, `W/"3"`, body["meta"].(map[string]interface{})["version"])
+	resp, body = scimRequest(router, "GET", `/Users?filter=active+eq+false`, key, "")
+	assert.Equal(t, float64(1), body["totalResults"])
+
+	// Okta sends the attributes without a path
+	resp, body = scimRequest(router, "PATCH", "/Users/"+id, key, `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"replace","value":{"active":true}}]}`)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, true, body["active"])
+
+	resp, body = scimRequest(router, "PATCH", "/Users/"+id, key, `{"Operations":[{"op":"remove","path":"userName"}]}`)
+	assertSCIMError(t, resp, body, http.StatusBadRequest, scim.ErrorMutability)
+
+	resp, _ = scimRequest(router, "DELETE", "/Users/"+id, key, "")
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	resp, body = scimRequest(router, "GET", "/Users/"+id, key, "")
+	assertSCIMError(t, resp, body, http.StatusNotFound, "")
+}
+
+// apiKeyCompany returns the company of a stored API key
+func TestSCIMUserRequiredAttributes(t *testing.T) {
+	router, key, _ := scimTestRouter()
+	CompanySettings = memoryAttributeSchemas()
+	defer func() { CompanySettings = &MockCompanySettingsStore{} }()
+	CompanySettings.UpdateAttributeSchema(context.Background(), apiKeyCompany(t, key), testAttributeSchema.Attributes)
+
+	// Identity providers do not send the attributes of the company, so the required ones are left to the admins
+	create := `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"userName":"jane.doe@example.com",
+		"name":{"givenName":"Jane","familyName":"Doe"},"active":true}`
+	resp, _ := scimRequest(router, "POST", "/Users", key, create)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	stored, _ := DB.FindUserByEmail(context.Background(), apiKeyCompany(t, key), "jane.doe@example.com")
+	assert.Empty(t, stored.Attributes)
+}
+
+func apiKeyCompany(t *testing.T, key string) primitive.ObjectID {
+	apiKey, err := APIKeys.FindAPIKey(context.Background(), auth.HashToken(key))
+	assert.NoError(t, err)
+	return apiKey.Company
+}
+
+func TestLoginDeactivatedUser(t *testing.T) {
+	router := gin.Default()
+	router.POST("/auth/login", Login())
+	Sessions = memorySessions()
+
+	user, mockDB := loginTestUser("Tr0ub4dor&3x")
+	inactive := false
+	user.Active = &inactive
+	DB = mockDB
+
+	resp := loginRequest(router, user.Email, "Tr0ub4dor&3x")
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	// Sessions created before the deactivation stop working
+	ginContext, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginContext.Request = httptest.NewRequest("POST", "/", nil)
+	user.Active = nil
+	token, _, _ := createSession(context.Background(), ginContext, user, "", sessionTTL)
+	user.Active = &inactive
+	router.GET("/me", RequireSession(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	resp, _ = authRequest(router, "GET", "/me", token, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
@@ -0,0 +1,232 @@
+package controllers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"user-service/cmd/controllers"
+	"user-service/cmd/routes"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var routeParam = regexp.MustCompile(`:[A-Za-z]+`)
+
+// tenantTestServer serves every route over the tenant database, with an admin and an API key of the attacker
+// company and a victim user of another company
+func tenantTestServer() (*gin.Engine, configs.Database, *models.UserWithCompanyAsObject, *models.UserWithCompanyAsObject, string) {
+	admin := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Mallory Admin", Email: "mallory@example.com", Role: models.RoleAdmin, Company: primitive.NewObjectID(), Version: 1}
+	victim := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Victoria Victim", Email: "victoria@example.com", Role: models.RoleAdmin, Company: primitive.NewObjectID(), Version: 1}
+	users := controllers.MemoryUsers(admin, victim)
+	controllers.DB = configs.NewTenantDatabase(users)
+	controllers.Sessions = controllers.MemorySessions()
+	controllers.Audit = &controllers.MockAuditLog{}
+	controllers.CompanySettings = &controllers.MockCompanySettingsStore{}
+	controllers.LoginAttempts = configs.NewMemoryLoginAttemptStore()
+	controllers.IdempotencyKeys = configs.NewMemoryIdempotencyStore()
+	controllers.ImportJobs = controllers.NewMockImportJobStore()
+	controllers.Webhooks = controllers.NewMockWebhookStore()
+	controllers.OIDC = controllers.NewMockOIDCStore()
+	controllers.Groups = controllers.NewMockGroupStore()
+	controllers.ResetTokens = &controllers.MockPasswordResetStore{}
+	controllers.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusAccepted, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}
+
+	apiKeys := controllers.NewMockAPIKeyStore()
+	controllers.APIKeys = apiKeys
+	key := models.APIKeyPrefix + "attackerkey"
+	apiKeys.CreateAPIKey(context.Background(), models.APIKey{Company: admin.Company, KeyHash: auth.HashToken(key), Scopes: []string{models.APIKeyScopeUsersRead, models.APIKeyScopeSCIM}, CreatedAt: time.Now()})
+
+	router := gin.New()
+	routes.Versions(router)
+	routes.SCIMRoute(router)
+	routes.OIDCRoute(router)
+	return router, users, admin, victim, key
+}
+
+// ownRoutes act on the caller, its own company or nobody whatever the ids of their path, so they can succeed
+var ownRoutes = map[string]bool{
+	"POST /auth/login":                           true,
+	"POST /auth/login/mfa":                       true,
+	"POST /auth/logout":                          true,
+	"POST /auth/mfa/enroll":                      true,
+	"POST /auth/mfa/confirm":                     true,
+	"POST /auth/mfa/disable":                     true,
+	"POST /auth/password/forgot":                 true,
+	"POST /auth/password/reset":                  true,
+	"POST /auth/invitations/accept":              true,
+	"GET /audit":                                 true,
+	"GET /audit/export":                          true,
+	"GET /companies/:companyId/password-policy":  true,
+	"GET /oauth2/authorize":                      true,
+	"POST /oauth2/token":                         true,
+	"GET /oauth2/userinfo":                       true,
+	"POST /oauth2/userinfo":                      true,
+	"GET /oauth2/jwks":                           true,
+	"GET /.well-known/openid-configuration":      true,
+	"GET /scim/v2/Schemas":                       true,
+	"GET /scim/v2/Schemas/:schemaId":             true,
+	"GET /scim/v2/ServiceProviderConfig":         true,
+	"GET /scim/v2/ResourceTypes":                 true,
+	"GET /scim/v2/ResourceTypes/:resourceTypeId": true,
+	"GET /scim/v2/Users":                         true,
+	"POST /scim/v2/Users":                        true,
+}
+
+// nullUserRoutes answer the users they do not find with a null user instead of a 404, clients of /v1 rely on it
+var nullUserRoutes = map[string]bool{
+	"GET /users":         true,
+	"GET /users/:userId": true,
+}
+
+// TestCrossTenantAccess sends a request to every route with the admin session and the API key of a company,
+// aimed at a user and the company of another one. The body is valid for every route, so only the authorization
+// can refuse it: none may read or change the other company
+func TestCrossTenantAccess(t *testing.T) {
+	router, users, admin, victim, key := tenantTestServer()
+	before, _ := users.FindUserByID(context.Background(), victim.Id)
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":         "Mallory",
+		"email":        "mallory.new@example.com",
+		"password":     "Correct-Horse-Battery-9",
+		"role":         models.RoleAdmin,
+		"company":      victim.Company.Hex(),
+		"userId":       victim.Id.Hex(),
+		"groupId":      primitive.NewObjectID().Hex(),
+		"url":          "https://hooks.example.com/users",
+		"events":       []string{"UserCreated"},
+		"scopes":       []string{models.APIKeyScopeUsersRead},
+		"redirectUris": []string{"https://app.example.com/callback"},
+		"required":     true,
+	})
+	// The name of a SCIM user is an object, so the SCIM routes get a body of their own
+	scimBody, _ := json.Marshal(map[string]interface{}{
+		"schemas":    []string{"urn:ietf:params:scim:schemas:core:2.0:User"},
+		"userName":   "mallory.new@example.com",
+		"name":       map[string]string{"givenName": "Mallory"},
+		"password":   "Correct-Horse-Battery-9",
+		"active":     false,
+		"Operations": []map[string]interface{}{{"op": "replace", "path": "active", "value": false}},
+	})
+	queries := []string{"", "?company=" + victim.Company.Hex(), "?company=" + admin.Company.Hex() + "&email=" + victim.Email}
+	// Every request gets its own session, so logging out does not end the session of the next ones
+	credentials := map[string]func() map[string]string{
+		"session": func() map[string]string {
+			return map[string]string{"Authorization": "Bearer " + controllers.TestSessionToken(admin)}
+		},
+		"api key": func() map[string]string {
+			return map[string]string{"Authorization": "Bearer " + key, "X-API-Key": key}
+		},
+	}
+
+	for _, route := range router.Routes() {
+		path := routeParam.ReplaceAllStringFunc(route.Path, func(param string) string {
+			switch param {
+			case ":userId":
+				return victim.Id.Hex()
+			case ":companyId":
+				return victim.Company.Hex()
+			}
+			return primitive.NewObjectID().Hex()
+		})
+		name := route.Method + " " + strings.TrimPrefix(route.Path, "/v1")
+		routeBody := body
+		if strings.HasPrefix(route.Path, controllers.SCIMPrefix) {
+			routeBody = scimBody
+		}
+		for credential, headers := range credentials {
+			for _, query := range queries {
+				req, _ := http.NewRequest(route.Method, path+query, bytes.NewReader(routeBody))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("If-Match", "*")
+				for header, value := range headers() {
+					req.Header.Set(header, value)
+				}
+				resp := httptest.NewRecorder()
+				router.ServeHTTP(resp, req)
+
+				request := route.Method + " " + path + query + " with the " + credential
+				assert.NotContains(t, resp.Body.String(), victim.Name, request+" returned the victim")
+				assert.NotContains(t, resp.Body.String(), victim.Email, request+" returned the victim")
+				switch {
+				case ownRoutes[name]:
+				case name == "GET /users" && query == "":
+					// Without the company parameter there is nothing to list, and API keys have to send it
+					assert.Contains(t, []int{http.StatusBadRequest, http.StatusForbidden}, resp.Code, request)
+				case nullUserRoutes[name] && resp.Code == http.StatusOK:
+					var response map[string]interface{}
+					json.Unmarshal(resp.Body.Bytes(), &response)
+					assert.Nil(t, response["data"].(map[string]interface{})["user"], request+" returned a user")
+				default:
+					assert.Contains(t, []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound}, resp.Code, request+" was not refused")
+				}
+			}
+		}
+	}
+
+	after, err := users.FindUserByID(context.Background(), victim.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+	companyUsers, _ := users.FindAllUsers(context.Background(), victim.Company)
+	assert.Len(t, companyUsers, 1)
+	created, _ := users.FindUsersByEmail(context.Background(), "mallory.new@example.com")
+	for _, user := range created {
+		assert.Equal(t, admin.Company, user.Company)
+	}
+}
+
+func TestTenantDatabase(t *testing.T) {
+	own := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Jane Smith", Email: "jane@example.com", Role: models.RoleUser, Company: primitive.NewObjectID()}
+	other := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Other Company", Email: "other@example.com", Role: models.RoleUser, Company: primitive.NewObjectID()}
+	db := configs.NewTenantDatabase(controllers.MemoryUsers(own, other))
+
+	// A context without scope can not reach any user
+	_, err := db.FindUserByID(context.Background(), own.Id)
+	assert.ErrorIs(t, err, configs.ErrNoTenantScope)
+	assert.ErrorIs(t, db.DeleteUser(context.Background(), own.Id), configs.ErrNoTenantScope)
+
+	ctx := configs.WithTenant(context.Background(), own.Company)
+	user, err := db.FindUserByID(ctx, own.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, own.Email, user.Email)
+
+	// Users of other companies are answered as missing
+	user, err = db.FindUserByID(ctx, other.Id)
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+	assert.Nil(t, user)
+	user, err = db.FindUserByEmail(ctx, other.Company, other.Email)
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+	assert.Nil(t, user)
+	byEmail, err := db.FindUsersByEmail(ctx, other.Email)
+	assert.NoError(t, err)
+	assert.Empty(t, byEmail)
+	users, err := db.FindAllUsers(ctx, other.Company)
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+	name := "Mallory"
+	_, err = db.UpdateUser(ctx, other.Id, 0, models.UserUpdate{Name: &name})
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+	assert.ErrorIs(t, db.DeleteUser(ctx, other.Id), mongo.ErrNoDocuments)
+	assert.ErrorIs(t, db.UpdateUserPassword(ctx, other.Id, "hash"), mongo.ErrNoDocuments)
+	_, err = db.CreateUser(ctx, models.UserWithCompanyAsObject{Name: "Mallory", Email: "mallory@example.com", Company: other.Company})
+	assert.ErrorIs(t, err, configs.ErrCrossTenant)
+
+	// The system scope reaches every company
+	user, err = db.FindUserByID(configs.WithSystemScope(context.Background()), other.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, other.Name, user.Name)
+}
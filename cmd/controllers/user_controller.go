@@ -2,11 +2,16 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 	"user-service/cmd/responses"
+	"user-service/internal/auth"
 	"user-service/internal/configs"
 	"user-service/internal/models"
+	"user-service/internal/services"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
@@ -20,105 +25,111 @@ type HTTPClient interface {
 }
 
 var (
-	Client HTTPClient
-	DB     configs.Database
+	Client          HTTPClient
+	DB              configs.Database
+	CompanySettings configs.CompanySettingsStore
 )
 
 var validate = validator.New()
 
 func init() {
 	Client = &http.Client{}
-	DB = configs.NewMongoDB(configs.ConnectDB())
+	// Every change to a user goes through the audited database so none can skip the audit log,
+	Audit = configs.NewMongoAuditLog(configs.DB)
+	// and through the tenant database so none can reach the users of another company. Both use the same client
+	// so the events join the transactions of the changes
+	DB = configs.NewTenantDatabase(configs.NewAuditedDatabase(configs.NewMongoDB(configs.DB), Audit))
+	CompanySettings = configs.NewMongoCompanySettingsStore(configs.DB)
+
+	if err := auth.RegisterPasswordValidation(validate); err != nil {
+		log.Fatal().Err(err).Msg("Error registering password validation")
+	}
+	auth.DefaultPasswordPolicy = configs.EnvPasswordPolicy(auth.DefaultPasswordPolicy)
+	if path := configs.EnvBreachedPasswordsFile(); path != "" {
+		if err := auth.LoadPasswordList(path); err != nil {
+			log.Error().Err(err).Msg("Error loading breached passwords file")
+		}
+	}
+}
+
+// userService returns the user service over the current stores, which tests replace
+func userService() *services.UserService {
+	return services.NewUserService(DB, CompanySettings, Groups, emailInviter{}, validate)
 }
 
 func CreateUser() gin.HandlerFunc {
 	log.Info().Msg("Create user endpoint reached")
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
 		var user models.User
 		defer cancel()
-		log.Info().Msg("User being created:" + user.Password)
 		if err := c.BindJSON(&user); err != nil {
 			log.Error().Err(err).Msg("error wrong json format")
 			return
 		}
-
-		err := ValidateRequest(user, c)
-		if err != nil {
-			log.Error().Err(err).Msg("Error validating request")
-			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+		// Users are created in the company of the caller, which the body can only repeat. A company that is not an id
+		// is left to the service, which refuses it once the rest of the user is validated
+		company := callerCompany(c).Hex()
+		if primitive.IsValidObjectID(user.Company) && user.Company != company {
+			log.Error().Msg("Caller of company: " + company + " tried to create a user in company: " + user.Company)
+			c.JSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "You are not allowed to perform this action", Data: nil})
 			return
 		}
-
-		if user, _ := DB.FindUserByEmail(ctx, user.Email); user != nil {
-			log.Error().Msg("User already exists with email: " + user.Email)
-			c.JSON(http.StatusBadRequest, responses.UserResponse{
-				Status:  http.StatusBadRequest,
-				Message: "User already exists with email: " + user.Email,
-				Data: map[string]interface{}{
-					"data": "User already exists with email: " + user.Email,
-				},
-			})
-			return
+		if user.Company == "" {
+			user.Company = company
 		}
 
-		companyIdObject, err2 := primitive.ObjectIDFromHex(user.Company)
-
-		if err2 != nil {
-			log.Error().Err(err2).Msg("Error converting company ID to object")
-			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "error on companyId as an object", Data: map[string]interface{}{"data": err2.Error()}})
+		created, err := userService().Create(ctx, services.CreateUserInput{Name: user.Name, Email: user.Email, Password: user.Password, Role: user.Role, Company: user.Company, ManagerId: user.ManagerId, Attributes: user.Attributes})
+		if errors.Is(err, services.ErrInvalidCompany) {
+			log.Error().Err(err).Msg("Error converting company ID to object")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "error on companyId as an object", Data: map[string]interface{}{"data": err.Error()}})
 			return
 		}
-		userWithCompany := models.UserWithCompanyAsObject{
-			Name:     user.Name,
-			Email:    user.Email,
-			Password: user.Password,
-			Role:     user.Role,
-			Company:  companyIdObject,
-		}
-
-		// Call the CreateUser method on the DB interface
-		userId, err := DB.CreateUser(ctx, userWithCompany)
 		if err != nil {
-			log.Error().Err(err).Msg("Error storing a user on database")
-			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "error storing user on database", Data: map[string]interface{}{"data": err.Error()}})
+			userServiceError(c, err, "error storing user on database")
 			return
 		}
 
 		log.Info().Msg("User created successfully")
-		user.Id = userId.Hex()
-
-		c.JSON(http.StatusCreated, responses.UserResponse{Status: http.StatusCreated, Message: "success", Data: map[string]interface{}{"user": user}})
+		created.Password = ""
+		c.JSON(http.StatusCreated, responses.UserResponse{Status: http.StatusCreated, Message: "success", Data: map[string]interface{}{"user": created}})
 	}
 }
 
-func ValidateRequest(user models.User, c *gin.Context) error {
-
-	//use the validator library to validate required fields
-	if validationErr := validate.Struct(&user); validationErr != nil {
-		log.Error().Err(validationErr).Msg("error validating request fields")
-		return validationErr
+func validationErrorData(err error) map[string]interface{} {
+	data := map[string]interface{}{"data": err.Error()}
+	var policyErr *auth.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		data["password"] = policyErr.Reasons
 	}
-
-	return nil
+	return data
 }
 
 func FindById() gin.HandlerFunc {
 	log.Info().Msg("Get a specific user endpoint by id reached")
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
 		userId := c.Param("userId")
 		defer cancel()
 
+		// Users that are not found are answered with a null user, clients of /v1 rely on it
 		objId, _ := primitive.ObjectIDFromHex(userId)
-
-		userWithCompany, err := DB.FindUserByID(ctx, objId)
-		if err != nil {
+		userWithCompany, err := userService().Get(ctx, objId)
+		if err != nil && !errors.Is(err, services.ErrUserNotFound) {
 			log.Error().Err(err).Msg("Error getting a user from database")
 			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error getting a user from database", Data: map[string]interface{}{"data": err.Error()}})
 			return
 		}
 
+		if userWithCompany != nil {
+			etag := userETag(userWithCompany)
+			c.Header("ETag", etag)
+			if etagMatches(c.GetHeader("If-None-Match"), etag) {
+				c.Status(http.StatusNotModified)
+				return
+			}
+		}
+
 		log.Info().Msg("User: " + userId + " retrieved successfully")
 		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"user": userWithCompany}})
 	}
@@ -127,7 +138,7 @@ func FindById() gin.HandlerFunc {
 func GetUsers() gin.HandlerFunc {
 	log.Info().Msg("Get all users endpoint reached")
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
 		defer cancel()
 		email := c.Query("email")
 		if email != "" {
@@ -143,7 +154,32 @@ func GetUsers() gin.HandlerFunc {
 		}
 
 		objId, _ := primitive.ObjectIDFromHex(companyId)
-		usersList, err := DB.FindAllUsers(ctx, objId)
+		var filter models.UserFilter
+		if group := c.Query("group"); group != "" {
+			// The members of the groups nested in the group are members too
+			ids, err := groupService().MemberIds(ctx, objId, group)
+			if err != nil {
+				groupServiceError(c, err, "Error getting group from database")
+				return
+			}
+			filter.Ids = ids
+		}
+		if query := attributeQuery(c); len(query) > 0 {
+			attributes, err := userService().AttributeFilter(ctx, objId, query)
+			if err != nil {
+				userServiceError(c, err, "Error getting company settings from database")
+				return
+			}
+			filter.Attributes = attributes
+		}
+
+		var usersList []*models.UserWithCompanyAsObject
+		var err error
+		if filter.Ids != nil || filter.Attributes != nil {
+			usersList, err = userService().List(ctx, objId, filter)
+		} else {
+			usersList, err = userService().ListByCompany(ctx, objId)
+		}
 		if err != nil {
 			log.Error().Err(err).Msg("There was a problem trying to find users on database with this compnay Id: " + companyId)
 			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "There was a problem trying to find users on database", Data: nil})
@@ -155,13 +191,26 @@ func GetUsers() gin.HandlerFunc {
 	}
 }
 
+// attributeQuery returns the values of the attributes.<name> query parameters by attribute name
+func attributeQuery(c *gin.Context) map[string]string {
+	query := map[string]string{}
+	for key, values := range c.Request.URL.Query() {
+		if strings.HasPrefix(key, models.AttributeFilterPrefix) && len(values) > 0 {
+			query[strings.TrimPrefix(key, models.AttributeFilterPrefix)] = values[0]
+		}
+	}
+	return query
+}
+
 func FindByEmail(c *gin.Context, email string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(tenantContext(c), 10*time.Second)
 	defer cancel()
 
+	// The same email can be in several companies, RequireCompanyQuery checked the company is the one of the caller
+	companyId, _ := primitive.ObjectIDFromHex(c.Query("company"))
 	log.Info().Msg("Looking for user: " + email)
-	userWithCompany, err := DB.FindUserByEmail(ctx, email)
-	if err != nil {
+	userWithCompany, err := userService().GetByEmail(ctx, companyId, email)
+	if err != nil && !errors.Is(err, services.ErrUserNotFound) {
 		log.Error().Err(err).Msg("Error getting a user from database with email: " + email)
 		c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error getting a user from database with provided email", Data: map[string]interface{}{"data": err.Error()}})
 		return
@@ -170,3 +219,133 @@ func FindByEmail(c *gin.Context, email string) {
 	log.Info().Msg("User: " + email + " retrieved successfully")
 	c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"user": userWithCompany}})
 }
+
+// UpdateUser changes the name, role, manager or attributes of a user. Users can change their own name and admins any user of their company
+func UpdateUser() gin.HandlerFunc {
+	log.Info().Msg("Update user endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		userId := c.Param("userId")
+		var update models.UserUpdate
+		if err := c.BindJSON(&update); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		ifMatch := c.GetHeader("If-Match")
+		if ifMatch == "" {
+			log.Error().Msg("Update without If-Match for user: " + userId)
+			c.JSON(http.StatusPreconditionRequired, responses.UserResponse{Status: http.StatusPreconditionRequired, Message: "The If-Match header with the ETag of the user is required", Data: nil})
+			return
+		}
+
+		updated, err := userService().Update(ctx, CurrentUser(c), services.UpdateUserInput{Id: userId, Update: update, Versions: ifMatchVersions(ifMatch)})
+		if err != nil {
+			userServiceError(c, err, "Error updating user on database")
+			return
+		}
+		updated.Password = ""
+		c.Header("ETag", userETag(updated))
+
+		log.Info().Msg("User: " + userId + " updated successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"user": updated}})
+	}
+}
+
+// DeleteUser removes a user of the company of the admin
+func DeleteUser() gin.HandlerFunc {
+	log.Info().Msg("Delete user endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		userId := c.Param("userId")
+
+		if err := userService().Delete(ctx, CurrentUser(c), userId); err != nil {
+			userServiceError(c, err, "Error deleting user from database")
+			return
+		}
+
+		log.Info().Msg("User: " + userId + " deleted successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: nil})
+	}
+}
+
+// userServiceError answers an error of the user service with its status, message is the one of unexpected errors
+func userServiceError(c *gin.Context, err error, message string) {
+	var validationErr *services.ValidationError
+	var exists *services.UserExistsError
+	var conflict *services.VersionConflictError
+	userId := c.Param("userId")
+	switch {
+	case errors.As(err, &validationErr), errors.Is(err, services.ErrInvalidCompany):
+		log.Error().Err(err).Msg("Error validating request")
+		c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: validationErrorData(err)})
+	case errors.As(err, &exists):
+		log.Error().Msg(exists.Error())
+		c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: exists.Error(), Data: map[string]interface{}{"data": exists.Error()}})
+	case errors.As(err, &conflict):
+		userVersionConflict(c, conflict.Current)
+	case errors.Is(err, services.ErrUserNotFound):
+		log.Error().Msg("User: " + userId + " not found")
+		c.JSON(http.StatusNotFound, responses.UserResponse{Status: http.StatusNotFound, Message: "User not found", Data: nil})
+	case errors.Is(err, services.ErrForbidden):
+		log.Error().Msg("User: " + CurrentUser(c).Id.Hex() + " is not allowed to change user: " + userId)
+		c.JSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "You are not allowed to perform this action", Data: nil})
+	case errors.Is(err, services.ErrOwnRole):
+		log.Error().Msg("User: " + userId + " tried to change its own role")
+		c.JSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "You can not change your own role", Data: nil})
+	case errors.Is(err, services.ErrManagerCycle):
+		log.Error().Msg("User: " + userId + " can not report to the users that report to it")
+		c.JSON(http.StatusConflict, responses.UserResponse{Status: http.StatusConflict, Message: services.ErrManagerCycle.Error(), Data: nil})
+	case errors.Is(err, services.ErrDeleteSelf):
+		log.Error().Msg("Admin: " + userId + " tried to delete its own user")
+		c.JSON(http.StatusForbidden, responses.UserResponse{Status: http.StatusForbidden, Message: "You can not delete your own user", Data: nil})
+	default:
+		log.Error().Err(err).Msg(message)
+		c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: message, Data: map[string]interface{}{"data": err.Error()}})
+	}
+}
+
+// userETag is the entity tag of the current version of a user
+func userETag(user *models.UserWithCompanyAsObject) string {
+	return `"` + strconv.FormatInt(user.Version, 10) + `"`
+}
+
+// etagMatches reports whether the If-None-Match header lists the entity tag, or is *.
+// Weak tags are compared by their value
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersions returns the versions listed by the If-Match header, nil for * which matches any version.
+// Tags that are not versions are skipped, so they match none. Weak tags are skipped too, If-Match compares
+// tags strongly and an update must be based on the exact version
+func ifMatchVersions(header string) []int64 {
+	versions := []int64{}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return nil
+		}
+		if version, err := strconv.ParseInt(strings.Trim(candidate, `"`), 10, 64); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+// userVersionConflict answers 412 with the current ETag of the user when it is known
+func userVersionConflict(c *gin.Context, current *models.UserWithCompanyAsObject) {
+	log.Error().Msg("User: " + c.Param("userId") + " was changed by another request")
+	if current != nil {
+		c.Header("ETag", userETag(current))
+	}
+	c.JSON(http.StatusPreconditionFailed, responses.UserResponse{Status: http.StatusPreconditionFailed, Message: "The user was changed by another request, get it again and retry", Data: nil})
+}
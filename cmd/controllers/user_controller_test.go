@@ -12,6 +12,8 @@ import (
 	"strings"
 	"testing"
 	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
 	"user-service/internal/models"
 
 	"github.com/gin-gonic/gin"
@@ -36,10 +38,26 @@ func (m *MockClient) Do(req *http.Request) (*http.Response, error) {
 
 // MockDB is a mock implementation of the database operations
 type MockDB struct {
-	CreateUserFunc      func(ctx context.Context, user models.UserWithCompanyAsObject) (primitive.ObjectID, error)
-	FindUserByIDFunc    func(ctx context.Context, id primitive.ObjectID) (*models.UserWithCompanyAsObject, error)
-	FindUserByEmailFunc func(ctx context.Context, email string) (*models.UserWithCompanyAsObject, error)
-	FindAllUsersFunc    func(ctx context.Context, companyId primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error)
+	CreateUserFunc       func(ctx context.Context, user models.UserWithCompanyAsObject) (primitive.ObjectID, error)
+	CreateUsersFunc      func(ctx context.Context, users []models.UserWithCompanyAsObject) ([]primitive.ObjectID, error)
+	FindUserByIDFunc     func(ctx context.Context, id primitive.ObjectID) (*models.UserWithCompanyAsObject, error)
+	FindUserByEmailFunc  func(ctx context.Context, companyId primitive.ObjectID, email string) (*models.UserWithCompanyAsObject, error)
+	FindUsersByEmailFunc func(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error)
+	// FindCredentialsByEmailFunc defaults to FindUsersByEmail, whose users are given with their password
+	FindCredentialsByEmailFunc func(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error)
+	FindAllUsersFunc           func(ctx context.Context, companyId primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error)
+	FindUsersFunc              func(ctx context.Context, companyId primitive.ObjectID, filter models.UserFilter) ([]*models.UserWithCompanyAsObject, error)
+	StreamUsersFunc            func(ctx context.Context, companyId primitive.ObjectID, fn func(user *models.UserWithCompanyAsObject) error) error
+	FindReportsFunc            func(ctx context.Context, companyId, managerId primitive.ObjectID, depth int) ([]*models.UserWithCompanyAsObject, error)
+	FindManagerChainFunc       func(ctx context.Context, companyId, id primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error)
+	UpdateUserFunc             func(ctx context.Context, id primitive.ObjectID, version int64, update models.UserUpdate) (*models.UserWithCompanyAsObject, error)
+	DeleteUserFunc             func(ctx context.Context, id primitive.ObjectID) error
+
+	UpdateUserPasswordFunc     func(ctx context.Context, id primitive.ObjectID, passwordHash string) error
+	UpdateUserPasswordHashFunc func(ctx context.Context, id primitive.ObjectID, oldHash, newHash string) error
+	UpdateUserMFAFunc          func(ctx context.Context, id primitive.ObjectID, mfa *models.MFA) error
+	UseRecoveryCodeFunc        func(ctx context.Context, id primitive.ObjectID, codeHash string) (bool, error)
+	UseTOTPStepFunc            func(ctx context.Context, id primitive.ObjectID, step int64) (bool, error)
 }
 
 // CreateUser mocks the creation of a user in the database
@@ -50,6 +68,18 @@ func (db *MockDB) CreateUser(ctx context.Context, user models.UserWithCompanyAsO
 	return primitive.NilObjectID, nil
 }
 
+// CreateUsers mocks the creation of a batch of users in the database
+func (db *MockDB) CreateUsers(ctx context.Context, users []models.UserWithCompanyAsObject) ([]primitive.ObjectID, error) {
+	if db.CreateUsersFunc != nil {
+		return db.CreateUsersFunc(ctx, users)
+	}
+	ids := make([]primitive.ObjectID, len(users))
+	for i := range users {
+		ids[i] = primitive.NewObjectID()
+	}
+	return ids, nil
+}
+
 // FindUserByID mocks the retrieval of a user by ID from the database
 func (db *MockDB) FindUserByID(ctx context.Context, id primitive.ObjectID) (*models.UserWithCompanyAsObject, error) {
 	if db.FindUserByIDFunc != nil {
@@ -58,14 +88,30 @@ func (db *MockDB) FindUserByID(ctx context.Context, id primitive.ObjectID) (*mod
 	return nil, nil
 }
 
-// FindUserByEmail mocks the retrieval of a user by email from the database
-func (db *MockDB) FindUserByEmail(ctx context.Context, email string) (*models.UserWithCompanyAsObject, error) {
+// FindUserByEmail mocks the retrieval of the user of a company by email from the database
+func (db *MockDB) FindUserByEmail(ctx context.Context, companyId primitive.ObjectID, email string) (*models.UserWithCompanyAsObject, error) {
 	if db.FindUserByEmailFunc != nil {
-		return db.FindUserByEmailFunc(ctx, email)
+		return db.FindUserByEmailFunc(ctx, companyId, email)
 	}
 	return nil, nil
 }
 
+// FindUsersByEmail mocks the retrieval of the users of every company by email from the database
+func (db *MockDB) FindUsersByEmail(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+	if db.FindUsersByEmailFunc != nil {
+		return db.FindUsersByEmailFunc(ctx, email)
+	}
+	return nil, nil
+}
+
+// FindCredentialsByEmail mocks the retrieval of the users of an email with their password hash
+func (db *MockDB) FindCredentialsByEmail(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+	if db.FindCredentialsByEmailFunc != nil {
+		return db.FindCredentialsByEmailFunc(ctx, email)
+	}
+	return db.FindUsersByEmail(ctx, email)
+}
+
 // FindAllUsers mocks the retrieval of all users for a company from the database
 func (db *MockDB) FindAllUsers(ctx context.Context, companyId primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error) {
 	if db.FindAllUsersFunc != nil {
@@ -74,10 +120,105 @@ func (db *MockDB) FindAllUsers(ctx context.Context, companyId primitive.ObjectID
 	return nil, nil
 }
 
+// FindUsers mocks the retrieval of the users of a company that match a filter from the database
+func (db *MockDB) FindUsers(ctx context.Context, companyId primitive.ObjectID, filter models.UserFilter) ([]*models.UserWithCompanyAsObject, error) {
+	if db.FindUsersFunc != nil {
+		return db.FindUsersFunc(ctx, companyId, filter)
+	}
+	return nil, nil
+}
+
+// StreamUsers mocks the iteration over the users of a company in the database
+func (db *MockDB) StreamUsers(ctx context.Context, companyId primitive.ObjectID, fn func(user *models.UserWithCompanyAsObject) error) error {
+	if db.StreamUsersFunc != nil {
+		return db.StreamUsersFunc(ctx, companyId, fn)
+	}
+	return nil
+}
+
+// FindReports mocks the retrieval of the users that report to a manager from the database
+func (db *MockDB) FindReports(ctx context.Context, companyId, managerId primitive.ObjectID, depth int) ([]*models.UserWithCompanyAsObject, error) {
+	if db.FindReportsFunc != nil {
+		return db.FindReportsFunc(ctx, companyId, managerId, depth)
+	}
+	return nil, nil
+}
+
+// FindManagerChain mocks the retrieval of the managers of a user from the database
+func (db *MockDB) FindManagerChain(ctx context.Context, companyId, id primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error) {
+	if db.FindManagerChainFunc != nil {
+		return db.FindManagerChainFunc(ctx, companyId, id)
+	}
+	return nil, nil
+}
+
+// UpdateUser mocks the update of the name or role of a user in the database
+func (db *MockDB) UpdateUser(ctx context.Context, id primitive.ObjectID, version int64, update models.UserUpdate) (*models.UserWithCompanyAsObject, error) {
+	if db.UpdateUserFunc != nil {
+		return db.UpdateUserFunc(ctx, id, version, update)
+	}
+	return nil, nil
+}
+
+// DeleteUser mocks the deletion of a user from the database
+func (db *MockDB) DeleteUser(ctx context.Context, id primitive.ObjectID) error {
+	if db.DeleteUserFunc != nil {
+		return db.DeleteUserFunc(ctx, id)
+	}
+	return nil
+}
+
+// UpdateUserPassword mocks the update of a user password in the database
+func (db *MockDB) UpdateUserPassword(ctx context.Context, id primitive.ObjectID, passwordHash string) error {
+	if db.UpdateUserPasswordFunc != nil {
+		return db.UpdateUserPasswordFunc(ctx, id, passwordHash)
+	}
+	return nil
+}
+
+// UpdateUserPasswordHash mocks the rehash of a user password in the database
+func (db *MockDB) UpdateUserPasswordHash(ctx context.Context, id primitive.ObjectID, oldHash, newHash string) error {
+	if db.UpdateUserPasswordHashFunc != nil {
+		return db.UpdateUserPasswordHashFunc(ctx, id, oldHash, newHash)
+	}
+	return nil
+}
+
+// UpdateUserMFA mocks the update of the MFA settings of a user in the database
+func (db *MockDB) UpdateUserMFA(ctx context.Context, id primitive.ObjectID, mfa *models.MFA) error {
+	if db.UpdateUserMFAFunc != nil {
+		return db.UpdateUserMFAFunc(ctx, id, mfa)
+	}
+	return nil
+}
+
+// UseRecoveryCode mocks removing a recovery code of a user in the database
+func (db *MockDB) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, codeHash string) (bool, error) {
+	if db.UseRecoveryCodeFunc != nil {
+		return db.UseRecoveryCodeFunc(ctx, id, codeHash)
+	}
+	return false, nil
+}
+
+// UseTOTPStep mocks storing the last time step of a TOTP code used by a user in the database
+func (db *MockDB) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	if db.UseTOTPStepFunc != nil {
+		return db.UseTOTPStepFunc(ctx, id, step)
+	}
+	return false, nil
+}
+
 func init() {
 	Client = &MockClient{}
 }
 
+// asAdmin authenticates the requests as an admin of the company, like RequireSession does
+func asAdmin(company primitive.ObjectID) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(currentUserKey, &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Ada Admin", Email: "ada@example.com", Role: models.RoleAdmin, Company: company})
+	}
+}
+
 func TestCreateNewUser(t *testing.T) {
 	router := gin.Default()
 
@@ -123,7 +264,7 @@ func TestCreateNewUser(t *testing.T) {
 	Client = mockClient
 
 	mockDB := &MockDB{}
-	mockDB.FindUserByEmailFunc = func(ctx context.Context, email string) (*models.UserWithCompanyAsObject, error) {
+	mockDB.FindUsersByEmailFunc = func(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
 		return nil, nil
 	}
 	mockDB.CreateUserFunc = func(ctx context.Context, user models.UserWithCompanyAsObject) (primitive.ObjectID, error) {
@@ -134,13 +275,13 @@ func TestCreateNewUser(t *testing.T) {
 	DB = mockDB
 
 	// Set up the route
-	router.POST("/users", CreateUser())
+	router.POST("/users", asAdmin(mustObjectId("649060d540e3b169621e9629")), CreateUser())
 
 	// Create a custom request payload
 	requestPayload := models.User{
 		Name:     "Test User",
 		Email:    "test@example.com",
-		Password: "password",
+		Password: "Tr0ub4dor&3x",
 		Role:     "admin",
 		Company:  "649060d540e3b169621e9629",
 	}
@@ -170,7 +311,7 @@ func TestCreateNewUser(t *testing.T) {
 	userData := response.Data["user"].(map[string]interface{})
 	assert.Equal(t, "648a26b07c0d535bb1526e1a", userData["_id"])
 	assert.Equal(t, "Test User", userData["name"])
-	assert.Equal(t, "password", userData["password"])
+	assert.NotContains(t, userData, "password")
 	assert.Equal(t, "test@example.com", userData["email"])
 	assert.Equal(t, "admin", userData["role"])
 	assert.Equal(t, "649060d540e3b169621e9629", userData["company"])
@@ -183,14 +324,14 @@ func TestUserAlreadyExist(t *testing.T) {
 		Id:       primitive.NewObjectID(),
 		Name:     "Test User",
 		Email:    "test@gmail.com",
-		Password: "password",
+		Password: "Tr0ub4dor&3x",
 		Role:     "admin",
 		Company:  primitive.NewObjectID(),
 	}
 	mockDB := &MockDB{}
-	mockDB.FindUserByEmailFunc = func(ctx context.Context, email string) (*models.UserWithCompanyAsObject, error) {
+	mockDB.FindUsersByEmailFunc = func(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
 
-		return &user, nil
+		return []*models.UserWithCompanyAsObject{&user}, nil
 	}
 
 	// Set up the mock client response JSON for creating a user
@@ -227,20 +368,20 @@ func TestUserAlreadyExist(t *testing.T) {
 	// Assign the mock client to the controller
 	Client = mockClient
 
-	mockDB.FindUserByEmailFunc = func(ctx context.Context, email string) (*models.UserWithCompanyAsObject, error) {
-		return &user, nil
+	mockDB.FindUsersByEmailFunc = func(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+		return []*models.UserWithCompanyAsObject{&user}, nil
 	}
 
 	DB = mockDB
 
 	// Set up the route
-	router.POST("/users", CreateUser())
+	router.POST("/users", asAdmin(user.Company), CreateUser())
 
 	// Create a custom request payload
 	requestPayload := models.User{
 		Name:     "Test User",
 		Email:    "test@example.com",
-		Password: "password",
+		Password: "Tr0ub4dor&3x",
 		Role:     "admin",
 		Company:  user.Company.Hex(),
 	}
@@ -267,17 +408,108 @@ func TestUserAlreadyExist(t *testing.T) {
 	assert.Equal(t, "User already exists with email: "+user.Email, response.Message)
 }
 
+func TestCreateUserInAnotherCompany(t *testing.T) {
+	CompanySettings = &MockCompanySettingsStore{}
+	invitations := NewMockInvitationStore()
+	Invitations = invitations
+	NotificationsURL = ""
+
+	hash, _ := auth.HashPassword("Tr0ub4dor&3x")
+	user := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Test User", Email: "test@example.com", Password: hash, Role: "admin", Company: primitive.NewObjectID()}
+	DB = memoryUsers(user)
+
+	// Each company creates the user with its own admin
+	create := func(email, password string, company primitive.ObjectID) *httptest.ResponseRecorder {
+		router := gin.Default()
+		router.POST("/users", asAdmin(company), CreateUser())
+		payload, _ := json.Marshal(models.User{Name: "Test User", Email: email, Password: password, Role: "user", Company: company.Hex()})
+		req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// Another company gets the same answer for a registered email as for a new one, whatever the password
+	other := primitive.NewObjectID()
+	resp := create(user.Email, "An0ther-Passw0rd!", other)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	fresh := create("new@example.com", "An0ther-Passw0rd!", other)
+	assert.Equal(t, http.StatusCreated, fresh.Code)
+	assert.NotContains(t, resp.Body.String(), "invitation")
+
+	// The membership waits for the person of the email to accept the invitation, the credentials do not change
+	users, _ := DB.FindCredentialsByEmail(context.Background(), user.Email)
+	assert.Len(t, users, 2)
+	assert.False(t, users[0].InvitationPending)
+	assert.Equal(t, hash, users[0].Password)
+	assert.True(t, users[1].InvitationPending)
+	assert.Len(t, invitations.userInvitations(users[1].Id), 1)
+	created, _ := DB.FindUsersByEmail(context.Background(), "new@example.com")
+	assert.False(t, created[0].InvitationPending)
+	assert.Empty(t, invitations.userInvitations(created[0].Id))
+
+	// Each company has one user per email
+	resp = create(user.Email, "Tr0ub4dor&3x", other)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = create(user.Email, "Tr0ub4dor&3x", user.Company)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestCreateUserRequiresAdminOrAPIKey(t *testing.T) {
+	CompanySettings = &MockCompanySettingsStore{}
+	Sessions = memorySessions()
+	apiKeys := NewMockAPIKeyStore()
+	APIKeys = apiKeys
+	admin := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Ada Admin", Email: "ada@example.com", Role: models.RoleAdmin, Company: primitive.NewObjectID(), Version: 1}
+	member := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Max Member", Email: "max@example.com", Role: models.RoleUser, Company: admin.Company, Version: 1}
+	other := primitive.NewObjectID()
+	DB = memoryUsers(admin, member)
+	readKey, writeKey := models.APIKeyPrefix+"readkey", models.APIKeyPrefix+"writekey"
+	apiKeys.CreateAPIKey(context.Background(), models.APIKey{Company: admin.Company, KeyHash: auth.HashToken(readKey), Scopes: []string{models.APIKeyScopeUsersRead}})
+	apiKeys.CreateAPIKey(context.Background(), models.APIKey{Company: admin.Company, KeyHash: auth.HashToken(writeKey), Scopes: []string{models.APIKeyScopeUsersWrite}})
+
+	router := gin.Default()
+	router.POST("/users", RequireSessionOrAPIKey(models.APIKeyScopeUsersWrite), RequireAdminOrAPIKey(), CreateUser())
+	newUser := func(email, company string) models.User {
+		return models.User{Name: "Nia New", Email: email, Password: "Tr0ub4dor&3x", Role: models.RoleUser, Company: company}
+	}
+
+	// Anonymous callers, users that are not admins and keys without the scope can not create users
+	resp, _ := authRequest(router, "POST", "/users", "", newUser("anonymous@example.com", admin.Company.Hex()))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp, _ = authRequest(router, "POST", "/users", testSessionToken(member), newUser("member@example.com", admin.Company.Hex()))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp, _ = authRequest(router, "POST", "/users", readKey, newUser("read@example.com", admin.Company.Hex()))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	// Admins and keys with the scope only create users of their company
+	resp, _ = authRequest(router, "POST", "/users", testSessionToken(admin), newUser("elsewhere@example.com", other.Hex()))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp, _ = authRequest(router, "POST", "/users", writeKey, newUser("elsewhere@example.com", other.Hex()))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	otherUsers, _ := DB.FindAllUsers(context.Background(), other)
+	assert.Empty(t, otherUsers)
+
+	resp, response := authRequest(router, "POST", "/users", testSessionToken(admin), newUser("session@example.com", admin.Company.Hex()))
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, admin.Company.Hex(), response.Data["user"].(map[string]interface{})["company"])
+	resp, response = authRequest(router, "POST", "/users", writeKey, newUser("key@example.com", ""))
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, admin.Company.Hex(), response.Data["user"].(map[string]interface{})["company"])
+}
+
 func TestCreateUserMissingUserNameField(t *testing.T) {
 	// Create a new Gin router
 	router := gin.Default()
 
 	// Set up the route
-	router.POST("/users", CreateUser())
+	router.POST("/users", asAdmin(primitive.NewObjectID()), CreateUser())
 
 	// Create a sample user payload with no name
 	user := models.User{
 		Email:    "john.doe@example.com",
-		Password: "password",
+		Password: "Tr0ub4dor&3x",
 		Role:     "user",
 		Company:  "Company XYZ",
 	}
@@ -342,6 +574,7 @@ func TestGetUserByID(t *testing.T) {
 				"password": mockUser.Password,
 				"role":     mockUser.Role,
 				"company":  mockUser.Company.Hex(),
+				"version":  float64(mockUser.Version),
 			},
 		},
 	}
@@ -379,7 +612,7 @@ func TestFindByEmail(t *testing.T) {
 
 	// Set up the mock database
 	mockDB := &MockDB{
-		FindUserByEmailFunc: func(ctx context.Context, email string) (*models.UserWithCompanyAsObject, error) {
+		FindUserByEmailFunc: func(ctx context.Context, companyId primitive.ObjectID, email string) (*models.UserWithCompanyAsObject, error) {
 			assert.Equal(t, mockUser.Email, email, "expected email to match")
 			return mockUser, nil
 		},
@@ -400,6 +633,7 @@ func TestFindByEmail(t *testing.T) {
 				"password": mockUser.Password,
 				"role":     mockUser.Role,
 				"company":  mockUser.Company.Hex(),
+				"version":  float64(mockUser.Version),
 			},
 		},
 	}
@@ -588,13 +822,13 @@ func TestInvitewUser(t *testing.T) {
 	DB = mockDB
 
 	// Set up the route
-	router.POST("/users", CreateUser())
+	router.POST("/users", asAdmin(mustObjectId("606d97b4c1bea43ce49be6dc")), CreateUser())
 
 	// Create a custom request payload
 	requestPayload := models.User{
 		Name:     "Test User",
 		Email:    "test@example.com",
-		Password: "password",
+		Password: "Tr0ub4dor&3x",
 		Role:     "admin",
 		Company:  "606d97b4c1bea43ce49be6dc", // Replace with a valid company ID
 	}
@@ -624,7 +858,7 @@ func TestInvitewUser(t *testing.T) {
 	userData := response.Data["user"].(map[string]interface{})
 	assert.Equal(t, "648a26b07c0d535bb1526e1a", userData["_id"])
 	assert.Equal(t, "Test User", userData["name"])
-	assert.Equal(t, "password", userData["password"])
+	assert.NotContains(t, userData, "password")
 	assert.Equal(t, "test@example.com", userData["email"])
 	assert.Equal(t, "admin", userData["role"])
 	assert.Equal(t, "606d97b4c1bea43ce49be6dc", userData["company"])
@@ -668,13 +902,13 @@ func TestErrorWrongObjectId(t *testing.T) {
 	Client = mockClient
 
 	// Set up the route
-	router.POST("/users", CreateUser())
+	router.POST("/users", asAdmin(primitive.NewObjectID()), CreateUser())
 
 	// Create a custom request payload
 	requestPayload := models.User{
 		Name:     "Test User",
 		Email:    "test@example.com",
-		Password: "password",
+		Password: "Tr0ub4dor&3x",
 		Role:     "admin",
 		Company:  "606d97b4c1bea43ce49be6dc_!WorngId",
 	}
@@ -763,13 +997,13 @@ func TestErrorDatabase(t *testing.T) {
 	DB = mockDB
 
 	// Set up the route
-	router.POST("/users", CreateUser())
+	router.POST("/users", asAdmin(mustObjectId("606d97b4c1bea43ce49be6dc")), CreateUser())
 
 	// Create a custom request payload
 	requestPayload := models.User{
 		Name:     "Test User",
 		Email:    "test@example.com",
-		Password: "password",
+		Password: "Tr0ub4dor&3x",
 		Role:     "admin",
 		Company:  "606d97b4c1bea43ce49be6dc",
 	}
@@ -849,7 +1083,7 @@ func TestErrorFindUserByEmail(t *testing.T) {
 	Client = mockClient
 
 	mockDB := &MockDB{}
-	mockDB.FindUserByEmailFunc = func(ctx context.Context, email string) (*models.UserWithCompanyAsObject, error) {
+	mockDB.FindUserByEmailFunc = func(ctx context.Context, companyId primitive.ObjectID, email string) (*models.UserWithCompanyAsObject, error) {
 		return nil, errors.New("mock find user by email error")
 	}
 	DB = mockDB
@@ -947,3 +1181,108 @@ func TestErrorFindUsersDatabase(t *testing.T) {
 	// Check the response message
 	assert.Equal(t, "There was a problem trying to find users on database", response.Message)
 }
+
+func TestUpdateUserAuthorization(t *testing.T) {
+	Sessions = memorySessions()
+	Audit = &MockAuditLog{}
+	admin, other, mockDB := auditedUsers(Audit)
+	DB = mockDB
+	router := auditTestRouter()
+	userToken := testSessionToken(other)
+	adminToken := testSessionToken(admin)
+
+	// Users can rename themselves but not change their role or other users
+	resp, _ := patchUser(router, "/users/"+other.Id.Hex(), userToken, map[string]string{"name": "Jane Doe"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp, _ = patchUser(router, "/users/"+other.Id.Hex(), userToken, map[string]string{"role": "admin"})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp, _ = patchUser(router, "/users/"+admin.Id.Hex(), userToken, map[string]string{"name": "Someone"})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	// Admins can not demote themselves
+	resp, _ = patchUser(router, "/users/"+admin.Id.Hex(), adminToken, map[string]string{"role": "user"})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	// An update needs a field
+	resp, _ = patchUser(router, "/users/"+other.Id.Hex(), adminToken, map[string]string{})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// Users of other companies are not found
+	resp, _ = patchUser(router, "/users/"+primitive.NewObjectID().Hex(), adminToken, map[string]string{"name": "Someone"})
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestUpdateUserVersion(t *testing.T) {
+	Sessions = memorySessions()
+	Audit = &MockAuditLog{}
+	admin, other, mockDB := auditedUsers(Audit)
+	DB = mockDB
+	router := auditTestRouter()
+	router.GET("/users/:userId", FindById())
+	token := testSessionToken(admin)
+	path := "/users/" + other.Id.Hex()
+
+	resp, _ := authRequest(router, "GET", path, token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	etag := resp.Header().Get("ETag")
+	assert.Equal(t, `"0"`, etag)
+
+	resp, _ = headerRequest(router, "GET", path, token, map[string]string{"If-None-Match": etag}, nil)
+	assert.Equal(t, http.StatusNotModified, resp.Code)
+	assert.Empty(t, resp.Body.String())
+
+	// Updates need the ETag of the version they are based on
+	resp, _ = authRequest(router, "PATCH", path, token, map[string]string{"name": "Jane Doe"})
+	assert.Equal(t, http.StatusPreconditionRequired, resp.Code)
+
+	resp, response := headerRequest(router, "PATCH", path, token, map[string]string{"If-Match": etag}, map[string]string{"name": "Jane Doe"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, `"1"`, resp.Header().Get("ETag"))
+	assert.Equal(t, float64(1), response.Data["user"].(map[string]interface{})["version"])
+
+	// The second writer with the same ETag loses instead of overwriting the first
+	resp, _ = headerRequest(router, "PATCH", path, token, map[string]string{"If-Match": etag}, map[string]string{"name": "Janet Smith"})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Code)
+	assert.Equal(t, `"1"`, resp.Header().Get("ETag"))
+	assert.Equal(t, "Jane Doe", other.Name)
+
+	resp, _ = headerRequest(router, "GET", path, token, map[string]string{"If-None-Match": etag}, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// Weak tags never match, an update needs the exact version
+	resp, _ = headerRequest(router, "PATCH", path, token, map[string]string{"If-Match": `W/"1"`}, map[string]string{"name": "Janet Smith"})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Code)
+	assert.Equal(t, "Jane Doe", other.Name)
+
+	// A write between the read and the update is detected by the database
+	mockDB.UpdateUserFunc = func(ctx context.Context, id primitive.ObjectID, version int64, update models.UserUpdate) (*models.UserWithCompanyAsObject, error) {
+		return nil, configs.ErrVersionConflict
+	}
+	resp, _ = headerRequest(router, "PATCH", path, token, map[string]string{"If-Match": `"1"`}, map[string]string{"name": "Janet Smith"})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Code)
+}
+
+func TestDeleteUser(t *testing.T) {
+	Sessions = memorySessions()
+	audit := &MockAuditLog{}
+	Audit = audit
+	admin, other, mockDB := auditedUsers(audit)
+	DB = configs.NewAuditedDatabase(mockDB, audit)
+	router := auditTestRouter()
+	adminToken := testSessionToken(admin)
+
+	resp, _ := authRequest(router, "DELETE", "/users/"+other.Id.Hex(), testSessionToken(other), nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp, _ = authRequest(router, "DELETE", "/users/"+admin.Id.Hex(), adminToken, nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp, _ = authRequest(router, "DELETE", "/users/"+other.Id.Hex(), adminToken, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	event := audit.Events[len(audit.Events)-1]
+	assert.Equal(t, models.AuditActionUserDeleted, event.Action)
+	assert.Equal(t, other.Id, *event.Target)
+	assert.Equal(t, "jane@example.com", event.Changes["email"].Before)
+
+	resp, _ = authRequest(router, "DELETE", "/users/"+other.Id.Hex(), adminToken, nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
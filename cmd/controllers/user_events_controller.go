@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/configs"
+	"user-service/internal/events"
+	"user-service/internal/models"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// userEventsHeartbeat is how often an idle stream sends a comment so proxies keep the connection open,
+// the session is checked again on each one
+var userEventsHeartbeat = 15 * time.Second
+
+var (
+	// UserEvents is where the event streams read the domain events from
+	UserEvents events.Source
+	// Broadcaster receives the events published by the outbox relay of this replica
+	Broadcaster = events.NewBroadcaster()
+)
+
+func init() {
+	if configs.EnvUserEventsSource() == "memory" {
+		UserEvents = Broadcaster
+	} else {
+		UserEvents = configs.NewMongoOutbox(configs.DB)
+	}
+}
+
+// StreamUserEvents sends the user events of the company of the admin as Server-Sent Events. Clients resume
+// after a disconnection with the Last-Event-ID header, or the lastEventId query parameter
+func StreamUserEvents() gin.HandlerFunc {
+	log.Info().Msg("Stream user events endpoint reached")
+	return func(c *gin.Context) {
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		lastEventId := c.GetHeader("Last-Event-ID")
+		if lastEventId == "" {
+			lastEventId = c.Query("lastEventId")
+		}
+		var after primitive.ObjectID
+		if lastEventId != "" {
+			var err error
+			if after, err = primitive.ObjectIDFromHex(lastEventId); err != nil {
+				log.Error().Err(err).Msg("Invalid Last-Event-ID: " + lastEventId)
+				c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": "invalid Last-Event-ID"}})
+				return
+			}
+		}
+
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+		userEvents, err := UserEvents.StreamCompanyEvents(ctx, companyId, after)
+		if err != nil {
+			log.Error().Err(err).Msg("Error streaming user events")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error streaming user events", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		c.Header("Content-Type", sse.ContentType)
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		io.WriteString(c.Writer, ": connected\n\n")
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(userEventsHeartbeat)
+		defer heartbeat.Stop()
+		session := CurrentSession(c)
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case event, ok := <-userEvents:
+				if !ok {
+					return false
+				}
+				c.Render(-1, sse.Event{Id: event.Id.Hex(), Event: event.Type, Data: event})
+				return true
+			case <-heartbeat.C:
+				if !sessionActive(ctx, session) {
+					log.Info().Msg("Closing user events stream of an ended session")
+					return false
+				}
+				_, err := io.WriteString(w, ": heartbeat\n\n")
+				return err == nil
+			}
+		})
+	}
+}
+
+// sessionActive reports whether the session of a long running request was not revoked or expired
+func sessionActive(ctx context.Context, session *models.Session) bool {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	current, err := Sessions.FindSession(ctx, session.TokenHash)
+	return err == nil && current != nil
+}
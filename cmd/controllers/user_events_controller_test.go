@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"user-service/internal/events"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sseMessage is an event or comment read from a stream
+type sseMessage struct {
+	Id, Event, Data, Comment string
+}
+
+// openUserEvents connects to the event stream of the company and sends what it reads on the returned channel
+func openUserEvents(t *testing.T, server *httptest.Server, companyId primitive.ObjectID, token, lastEventId string) (*http.Response, <-chan sseMessage) {
+	req, _ := http.NewRequest("GET", server.URL+"/companies/"+companyId.Hex()+"/users/events", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	resp, err := server.Client().Do(req)
+	assert.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	messages := make(chan sseMessage, 16)
+	go func() {
+		defer close(messages)
+		scanner := bufio.NewScanner(resp.Body)
+		var message sseMessage
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				messages <- message
+				message = sseMessage{}
+			case strings.HasPrefix(line, ":"):
+				message.Comment = strings.TrimSpace(line[1:])
+			case strings.HasPrefix(line, "id:"):
+				message.Id = line[len("id:"):]
+			case strings.HasPrefix(line, "event:"):
+				message.Event = line[len("event:"):]
+			case strings.HasPrefix(line, "data:"):
+				message.Data = line[len("data:"):]
+			}
+		}
+	}()
+	return resp, messages
+}
+
+// nextSSEEvent returns the next event of the stream, skipping comments
+func nextSSEEvent(t *testing.T, messages <-chan sseMessage) sseMessage {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				t.Fatal("stream closed")
+			}
+			if message.Comment == "" {
+				return message
+			}
+		case <-timeout:
+			t.Fatal("no event received")
+		}
+	}
+}
+
+func userEventsServer(t *testing.T, revoked *atomic.Bool) (*httptest.Server, *models.UserWithCompanyAsObject, string, *events.Broadcaster) {
+	sessions := memorySessions()
+	findSession := sessions.FindSessionFunc
+	sessions.FindSessionFunc = func(ctx context.Context, tokenHash string) (*models.Session, error) {
+		if revoked.Load() {
+			return nil, nil
+		}
+		return findSession(ctx, tokenHash)
+	}
+	Sessions = sessions
+	Audit = &MockAuditLog{}
+	broadcaster := events.NewBroadcaster()
+	UserEvents = broadcaster
+
+	admin, mockDB := loginTestUser("Tr0ub4dor&3x")
+	DB = mockDB
+	token := testSessionToken(admin)
+
+	router := gin.New()
+	router.GET("/companies/:companyId/users/events", RequireSession(), RequireRole(models.RoleAdmin), StreamUserEvents())
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server, admin, token, broadcaster
+}
+
+func TestStreamUserEvents(t *testing.T) {
+	var revoked atomic.Bool
+	server, admin, token, broadcaster := userEventsServer(t, &revoked)
+
+	resp, messages := openUserEvents(t, server, admin.Company, token, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "connected", (<-messages).Comment)
+
+	other := *admin
+	other.Company = primitive.NewObjectID()
+	created := models.NewUserEvent(models.EventUserCreated, *admin)
+	roleChanged := models.NewUserEvent(models.EventRoleChanged, *admin)
+	broadcaster.Publish(context.Background(), models.NewUserEvent(models.EventUserCreated, other))
+	broadcaster.Publish(context.Background(), created)
+	broadcaster.Publish(context.Background(), roleChanged)
+
+	// Only the events of the company are sent
+	message := nextSSEEvent(t, messages)
+	assert.Equal(t, created.Id.Hex(), message.Id)
+	assert.Equal(t, models.EventUserCreated, message.Event)
+	var event models.OutboxEvent
+	assert.NoError(t, json.Unmarshal([]byte(message.Data), &event))
+	assert.Equal(t, admin.Email, event.User.Email)
+	assert.Equal(t, roleChanged.Id.Hex(), nextSSEEvent(t, messages).Id)
+
+	// A new connection resumes after the last event it received
+	deleted := models.NewUserEvent(models.EventUserDeleted, *admin)
+	broadcaster.Publish(context.Background(), deleted)
+	_, resumed := openUserEvents(t, server, admin.Company, token, created.Id.Hex())
+	assert.Equal(t, roleChanged.Id.Hex(), nextSSEEvent(t, resumed).Id)
+	assert.Equal(t, deleted.Id.Hex(), nextSSEEvent(t, resumed).Id)
+}
+
+func TestStreamUserEventsHeartbeatAndRevocation(t *testing.T) {
+	defaultHeartbeat := userEventsHeartbeat
+	userEventsHeartbeat = 20 * time.Millisecond
+	defer func() { userEventsHeartbeat = defaultHeartbeat }()
+	var revoked atomic.Bool
+	server, admin, token, _ := userEventsServer(t, &revoked)
+
+	_, messages := openUserEvents(t, server, admin.Company, token, "")
+	assert.Equal(t, "connected", (<-messages).Comment)
+	assert.Equal(t, "heartbeat", (<-messages).Comment)
+
+	// The stream ends once the session is revoked
+	revoked.Store(true)
+	timeout := time.After(2 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-messages:
+		case <-timeout:
+			t.Fatal("stream was not closed")
+		}
+	}
+}
+
+func TestStreamUserEventsAuthorization(t *testing.T) {
+	var revoked atomic.Bool
+	server, admin, token, _ := userEventsServer(t, &revoked)
+
+	resp, _ := openUserEvents(t, server, primitive.NewObjectID(), token, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, _ = openUserEvents(t, server, admin.Company, token, "not-an-id")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = openUserEvents(t, server, admin.Company, "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	user := *admin
+	user.Id, user.Role = primitive.NewObjectID(), "user"
+	DB.(*MockDB).FindUserByIDFunc = func(ctx context.Context, id primitive.ObjectID) (*models.UserWithCompanyAsObject, error) {
+		return &user, nil
+	}
+	resp, _ = openUserEvents(t, server, admin.Company, testSessionToken(&user), "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
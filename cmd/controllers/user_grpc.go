@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+	userv1 "user-service/internal/pb/user/v1"
+	"user-service/internal/services"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// UserGRPCServer serves user.v1.UserService for the internal services of a company, with the same logic as the REST
+// handlers. Calls are authenticated by GRPCAuthUnaryInterceptor and GRPCAuthStreamInterceptor with an API key, and
+// only reach the users of its company
+type UserGRPCServer struct {
+	userv1.UnimplementedUserServiceServer
+}
+
+func (UserGRPCServer) CreateUser(ctx context.Context, request *userv1.CreateUserRequest) (*userv1.CreateUserResponse, error) {
+	companyId, err := grpcCompany(ctx, request.GetCompanyId())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(grpcAuditContext(ctx), 10*time.Second)
+	defer cancel()
+
+	created, err := userService().Create(ctx, services.CreateUserInput{
+		Name:     request.GetName(),
+		Email:    request.GetEmail(),
+		Password: request.GetPassword(),
+		Role:     request.GetRole(),
+		Company:  companyId.Hex(),
+	})
+	if err != nil {
+		return nil, userStatus(err, "error storing user on database")
+	}
+
+	log.Info().Msg("User: " + created.Id.Hex() + " created over gRPC")
+	return &userv1.CreateUserResponse{User: userMessage(created)}, nil
+}
+
+func (UserGRPCServer) GetUser(ctx context.Context, request *userv1.GetUserRequest) (*userv1.GetUserResponse, error) {
+	companyId, err := grpcCompany(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(configs.WithTenant(ctx, companyId), 10*time.Second)
+	defer cancel()
+
+	if _, err := primitive.ObjectIDFromHex(request.GetId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "id is not a valid id")
+	}
+	user, err := userService().UserOfCompany(ctx, companyId, request.GetId())
+	if err != nil {
+		return nil, userStatus(err, "error getting a user from database")
+	}
+	return &userv1.GetUserResponse{User: userMessage(user)}, nil
+}
+
+func (UserGRPCServer) GetUserByEmail(ctx context.Context, request *userv1.GetUserByEmailRequest) (*userv1.GetUserByEmailResponse, error) {
+	companyId, err := grpcCompany(ctx, request.GetCompanyId())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(configs.WithTenant(ctx, companyId), 10*time.Second)
+	defer cancel()
+
+	if request.GetEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+	user, err := userService().GetByEmail(ctx, companyId, request.GetEmail())
+	if err != nil {
+		return nil, userStatus(err, "error getting a user from database")
+	}
+	return &userv1.GetUserByEmailResponse{User: userMessage(user)}, nil
+}
+
+// ListUsersByCompany sends the users while they are read from the database, so large companies are not kept in memory
+func (UserGRPCServer) ListUsersByCompany(request *userv1.ListUsersByCompanyRequest, stream userv1.UserService_ListUsersByCompanyServer) error {
+	companyId, err := grpcCompany(stream.Context(), request.GetCompanyId())
+	if err != nil {
+		return err
+	}
+
+	err = userService().StreamByCompany(configs.WithTenant(stream.Context(), companyId), companyId, func(user *models.UserWithCompanyAsObject) error {
+		return stream.Send(userMessage(user))
+	})
+	if err != nil {
+		if s, ok := status.FromError(err); ok && s.Code() != codes.Unknown {
+			return err
+		}
+		log.Error().Err(err).Msg("Error streaming the users of company: " + companyId.Hex())
+		return status.Error(codes.Internal, "error getting the users from database")
+	}
+	return nil
+}
+
+// grpcCompany returns the company of the API key of the call. A company_id of the request can only repeat it
+func grpcCompany(ctx context.Context, requested string) (primitive.ObjectID, error) {
+	apiKey := grpcAPIKey(ctx)
+	if apiKey == nil {
+		return primitive.NilObjectID, status.Error(codes.Unauthenticated, "An API key is required")
+	}
+	if requested == "" {
+		return apiKey.Company, nil
+	}
+	companyId, err := primitive.ObjectIDFromHex(requested)
+	if err != nil {
+		return primitive.NilObjectID, status.Error(codes.InvalidArgument, "company_id is not a valid id")
+	}
+	if companyId != apiKey.Company {
+		log.Error().Msg("API key: " + apiKey.Id.Hex() + " tried to access company: " + requested + " over gRPC")
+		return primitive.NilObjectID, status.Error(codes.PermissionDenied, "You are not allowed to perform this action")
+	}
+	return companyId, nil
+}
+
+// userStatus translates an error of the user service to its gRPC status, message is the one of unexpected errors
+func userStatus(err error, message string) error {
+	var validationErr *services.ValidationError
+	var exists *services.UserExistsError
+	switch {
+	case errors.As(err, &validationErr), errors.Is(err, services.ErrInvalidCompany):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.As(err, &exists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, services.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	}
+	log.Error().Err(err).Msg(message)
+	return status.Error(codes.Internal, message)
+}
+
+// userMessage converts a user to its protobuf message, which never has the password
+func userMessage(user *models.UserWithCompanyAsObject) *userv1.User {
+	message := &userv1.User{
+		Id:                    user.Id.Hex(),
+		Name:                  user.Name,
+		Email:                 user.Email,
+		Role:                  user.Role,
+		CompanyId:             user.Company.Hex(),
+		Version:               user.Version,
+		MfaEnabled:            user.MFAEnabled(),
+		PasswordResetRequired: user.PasswordResetRequired,
+	}
+	if user.PasswordChangedAt != nil {
+		message.PasswordChangedAt = timestamppb.New(*user.PasswordChangedAt)
+	}
+	return message
+}
+
+// grpcAuditContext scopes the context to the company of the API key and carries the x-request-id metadata, the
+// address of the caller and the key, so the changes are audited with them
+func grpcAuditContext(ctx context.Context) context.Context {
+	apiKey := grpcAPIKey(ctx)
+	info := configs.AuditInfo{IP: grpcPeerIP(ctx), APIKey: &apiKey.Id}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get("x-request-id"); len(ids) > 0 && validRequestId.MatchString(ids[0]) {
+			info.RequestId = ids[0]
+		}
+	}
+	return configs.WithAuditInfo(configs.WithTenant(ctx, apiKey.Company), info)
+}
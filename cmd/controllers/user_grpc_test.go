@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+	userv1 "user-service/internal/pb/user/v1"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// grpcTestClient serves UserGRPCServer in memory behind the API key interceptors and returns a client connected to it
+func grpcTestClient(t *testing.T) userv1.UserServiceClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.UnaryInterceptor(GRPCAuthUnaryInterceptor()), grpc.StreamInterceptor(GRPCAuthStreamInterceptor()))
+	userv1.RegisterUserServiceServer(server, UserGRPCServer{})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return userv1.NewUserServiceClient(conn)
+}
+
+// grpcKeyContext stores an API key of the company with the scopes and returns a context that sends it on its calls
+func grpcKeyContext(company primitive.ObjectID, scopes ...string) context.Context {
+	apiKeys, ok := APIKeys.(*MockAPIKeyStore)
+	if !ok {
+		apiKeys = NewMockAPIKeyStore()
+		APIKeys = apiKeys
+	}
+	if len(scopes) == 0 {
+		scopes = []string{models.APIKeyScopeUsersRead, models.APIKeyScopeUsersWrite}
+	}
+	key := models.APIKeyPrefix + primitive.NewObjectID().Hex()
+	apiKeys.CreateAPIKey(context.Background(), models.APIKey{Company: company, KeyHash: auth.HashToken(key), Scopes: scopes})
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+key)
+}
+
+func TestGRPCCreateUser(t *testing.T) {
+	CompanySettings = &MockCompanySettingsStore{}
+	audit := &MockAuditLog{}
+	var stored models.UserWithCompanyAsObject
+	DB = configs.NewAuditedDatabase(&MockDB{
+		CreateUserFunc: func(ctx context.Context, user models.UserWithCompanyAsObject) (primitive.ObjectID, error) {
+			stored = user
+			return primitive.NewObjectID(), nil
+		},
+	}, audit)
+	client := grpcTestClient(t)
+	company, _ := primitive.ObjectIDFromHex("649060d540e3b169621e9629")
+	ctx := metadata.AppendToOutgoingContext(grpcKeyContext(company), "x-request-id", "grpc-request")
+
+	request := &userv1.CreateUserRequest{Name: "Jane Smith", Email: "jane@example.com", Password: "Tr0ub4dor&3x", Role: "user", CompanyId: "649060d540e3b169621e9629"}
+	response, err := client.CreateUser(ctx, request)
+	assert.NoError(t, err)
+	assert.Equal(t, "jane@example.com", response.User.Email)
+	assert.Equal(t, "649060d540e3b169621e9629", response.User.CompanyId)
+	assert.NotEmpty(t, response.User.Id)
+	assert.NotEqual(t, "Tr0ub4dor&3x", stored.Password)
+	assert.Equal(t, "grpc-request", audit.Events[0].RequestId)
+	assert.NotNil(t, audit.Events[0].APIKey)
+
+	// The password policy of the REST API applies
+	request.Password = "short"
+	_, err = client.CreateUser(ctx, request)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Without company_id the user is created in the company of the key
+	request = &userv1.CreateUserRequest{Name: "John Doe", Email: "john@example.com", Password: "Tr0ub4dor&3x", Role: "user"}
+	response, err = client.CreateUser(ctx, request)
+	assert.NoError(t, err)
+	assert.Equal(t, company.Hex(), response.User.CompanyId)
+}
+
+func TestGRPCCreateUserInAnotherCompany(t *testing.T) {
+	CompanySettings = &MockCompanySettingsStore{}
+	created := false
+	DB = &MockDB{
+		CreateUserFunc: func(ctx context.Context, user models.UserWithCompanyAsObject) (primitive.ObjectID, error) {
+			created = true
+			return primitive.NewObjectID(), nil
+		},
+	}
+	client := grpcTestClient(t)
+
+	request := &userv1.CreateUserRequest{Name: "Mallory", Email: "mallory@example.com", Password: "Tr0ub4dor&3x", Role: models.RoleAdmin, CompanyId: primitive.NewObjectID().Hex()}
+	_, err := client.CreateUser(grpcKeyContext(primitive.NewObjectID()), request)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.False(t, created)
+}
+
+func TestGRPCCreateUserThatExists(t *testing.T) {
+	CompanySettings = &MockCompanySettingsStore{}
+	companyId, _ := primitive.ObjectIDFromHex("649060d540e3b169621e9629")
+	DB = &MockDB{
+		FindUsersByEmailFunc: func(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+			return []*models.UserWithCompanyAsObject{{Id: primitive.NewObjectID(), Email: email, Company: companyId}}, nil
+		},
+	}
+	client := grpcTestClient(t)
+
+	_, err := client.CreateUser(grpcKeyContext(companyId), &userv1.CreateUserRequest{Name: "Jane Smith", Email: "jane@example.com", Password: "Tr0ub4dor&3x", Role: "user", CompanyId: "649060d540e3b169621e9629"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+}
+
+func TestGRPCGetUserByEmailOfSeveralCompanies(t *testing.T) {
+	user := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Jane Smith", Email: "jane@example.com", Role: "user", Company: primitive.NewObjectID(), Version: 1}
+	member := *user
+	member.Id = primitive.NewObjectID()
+	member.Company = primitive.NewObjectID()
+	DB = memoryUsers(user, &member)
+	client := grpcTestClient(t)
+	ctx := grpcKeyContext(member.Company)
+
+	// The email is looked up in the company of the key
+	response, err := client.GetUserByEmail(ctx, &userv1.GetUserByEmailRequest{Email: user.Email})
+	assert.NoError(t, err)
+	assert.Equal(t, member.Id.Hex(), response.User.Id)
+
+	response, err = client.GetUserByEmail(ctx, &userv1.GetUserByEmailRequest{Email: user.Email, CompanyId: member.Company.Hex()})
+	assert.NoError(t, err)
+	assert.Equal(t, member.Id.Hex(), response.User.Id)
+
+	_, err = client.GetUserByEmail(ctx, &userv1.GetUserByEmailRequest{Email: user.Email, CompanyId: user.Company.Hex()})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.GetUserByEmail(ctx, &userv1.GetUserByEmailRequest{Email: user.Email, CompanyId: "not-an-id"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCGetUser(t *testing.T) {
+	user := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Jane Smith", Email: "jane@example.com", Password: "$argon2id$hash", Role: "user", Company: primitive.NewObjectID(), Version: 3}
+	other := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Other Company", Email: "other@example.com", Role: "user", Company: primitive.NewObjectID(), Version: 1}
+	DB = memoryUsers(user, other)
+	client := grpcTestClient(t)
+	ctx := grpcKeyContext(user.Company)
+
+	response, err := client.GetUser(ctx, &userv1.GetUserRequest{Id: user.Id.Hex()})
+	assert.NoError(t, err)
+	assert.Equal(t, "Jane Smith", response.User.Name)
+	assert.Equal(t, int64(3), response.User.Version)
+
+	byEmail, err := client.GetUserByEmail(ctx, &userv1.GetUserByEmailRequest{Email: user.Email})
+	assert.NoError(t, err)
+	assert.Equal(t, user.Id.Hex(), byEmail.User.Id)
+
+	// Users of other companies are not found
+	_, err = client.GetUser(ctx, &userv1.GetUserRequest{Id: other.Id.Hex()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.GetUserByEmail(ctx, &userv1.GetUserByEmailRequest{Email: other.Email})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.GetUser(ctx, &userv1.GetUserRequest{Id: primitive.NewObjectID().Hex()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.GetUser(ctx, &userv1.GetUserRequest{Id: "not-an-id"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.GetUserByEmail(ctx, &userv1.GetUserByEmailRequest{Email: "someone@example.com"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGRPCListUsersByCompany(t *testing.T) {
+	company := primitive.NewObjectID()
+	DB = &MockDB{
+		StreamUsersFunc: func(ctx context.Context, companyId primitive.ObjectID, fn func(user *models.UserWithCompanyAsObject) error) error {
+			assert.Equal(t, company, companyId)
+			for _, name := range []string{"Jane Smith", "John Doe", "Ana Lopez"} {
+				if err := fn(&models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: name, Company: companyId}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	client := grpcTestClient(t)
+	ctx := grpcKeyContext(company, models.APIKeyScopeUsersRead)
+
+	stream, err := client.ListUsersByCompany(ctx, &userv1.ListUsersByCompanyRequest{CompanyId: company.Hex()})
+	assert.NoError(t, err)
+	var names []string
+	for {
+		user, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		names = append(names, user.Name)
+	}
+	assert.Equal(t, []string{"Jane Smith", "John Doe", "Ana Lopez"}, names)
+
+	stream, err = client.ListUsersByCompany(ctx, &userv1.ListUsersByCompanyRequest{CompanyId: "not-an-id"})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	stream, err = client.ListUsersByCompany(ctx, &userv1.ListUsersByCompanyRequest{CompanyId: primitive.NewObjectID().Hex()})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestGRPCRequiresAPIKey(t *testing.T) {
+	CompanySettings = &MockCompanySettingsStore{}
+	DB = memoryUsers()
+	client := grpcTestClient(t)
+	company := primitive.NewObjectID()
+
+	_, err := client.GetUser(context.Background(), &userv1.GetUserRequest{Id: primitive.NewObjectID().Hex()})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	invalid := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", models.APIKeyPrefix+"unknown")
+	_, err = client.GetUser(invalid, &userv1.GetUserRequest{Id: primitive.NewObjectID().Hex()})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	stream, err := client.ListUsersByCompany(context.Background(), &userv1.ListUsersByCompanyRequest{CompanyId: company.Hex()})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Creating users needs the users:write scope
+	request := &userv1.CreateUserRequest{Name: "Jane Smith", Email: "jane@example.com", Password: "Tr0ub4dor&3x", Role: "user"}
+	_, err = client.CreateUser(grpcKeyContext(company, models.APIKeyScopeUsersRead), request)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+	"user-service/cmd/responses"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// webhookDeliveriesLimit is how many deliveries the delivery log shows
+const webhookDeliveriesLimit = 100
+
+var Webhooks configs.WebhookStore
+
+func init() {
+	Webhooks = configs.NewMongoWebhookStore(configs.DB)
+}
+
+// CreateWebhook subscribes a URL to domain events of the company of the admin, the signing secret is only returned on this response
+func CreateWebhook() gin.HandlerFunc {
+	log.Info().Msg("Create webhook endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+		var request models.CreateWebhookRequest
+		if err := c.BindJSON(&request); err != nil {
+			log.Error().Err(err).Msg("error wrong json format")
+			return
+		}
+
+		if err := validate.Struct(&request); err != nil {
+			log.Error().Err(err).Msg("Error validating request")
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "validation error", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		admin := CurrentUser(c)
+		companyId, ok := adminCompany(c, admin)
+		if !ok {
+			return
+		}
+
+		token, err := auth.GenerateToken()
+		if err != nil {
+			log.Error().Err(err).Msg("Error generating webhook secret")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error creating webhook", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		webhook := models.Webhook{
+			Company:   companyId,
+			URL:       request.URL,
+			Events:    request.Events,
+			Secret:    models.WebhookSecretPrefix + token,
+			CreatedBy: admin.Id,
+			CreatedAt: time.Now(),
+		}
+		webhook.Id, err = Webhooks.CreateWebhook(ctx, webhook)
+		if err != nil {
+			log.Error().Err(err).Msg("Error storing webhook on database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error creating webhook", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		log.Info().Msg("Webhook: " + webhook.Id.Hex() + " created for company: " + companyId.Hex())
+		c.JSON(http.StatusCreated, responses.UserResponse{Status: http.StatusCreated, Message: "success", Data: map[string]interface{}{"webhook": webhook, "secret": webhook.Secret}})
+	}
+}
+
+// GetWebhooks lists the webhooks of the company of the admin, without their secrets
+func GetWebhooks() gin.HandlerFunc {
+	log.Info().Msg("Get webhooks endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		webhooks, err := Webhooks.FindCompanyWebhooks(ctx, companyId)
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting webhooks from database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error getting webhooks from database", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"webhooks": webhooks}})
+	}
+}
+
+// DeleteWebhook removes a webhook of the company of the admin, its pending deliveries are dead-lettered
+func DeleteWebhook() gin.HandlerFunc {
+	log.Info().Msg("Delete webhook endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), 10*time.Second)
+		defer cancel()
+
+		companyId, ok := adminCompany(c, CurrentUser(c))
+		if !ok {
+			return
+		}
+
+		webhookId, _ := primitive.ObjectIDFromHex(c.Param("webhookId"))
+		err := Webhooks.DeleteWebhook(ctx, companyId, webhookId)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			log.Error().Msg("Webhook: " + c.Param("webhookId") + " not found")
+			c.JSON(http.StatusNotFound, responses.UserResponse{Status: http.StatusNotFound, Message: "Webhook not found", Data: nil})
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Error deleting webhook from database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error deleting webhook", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		log.Info().Msg("Webhook: " + webhookId.Hex() + " deleted successfully")
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: nil})
+	}
+}
+
+// GetWebhookDeliveries returns the latest deliveries of a webhook with the log of their attempts
+func GetWebhookDeliveries() gin.HandlerFunc {
+	log.Info().Msg("Get webhook deliveries endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		webhook, ok := companyWebhook(ctx, c)
+		if !ok {
+			return
+		}
+
+		deliveries, err := Webhooks.FindWebhookDeliveries(ctx, webhook.Id, webhookDeliveriesLimit)
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting webhook deliveries from database")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error getting webhook deliveries from database", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"deliveries": deliveries}})
+	}
+}
+
+// RedeliverWebhook sends a delivery again right away, whatever its status, and returns the result
+func RedeliverWebhook() gin.HandlerFunc {
+	log.Info().Msg("Redeliver webhook endpoint reached")
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(auditContext(c), webhookTimeout+5*time.Second)
+		defer cancel()
+
+		webhook, ok := companyWebhook(ctx, c)
+		if !ok {
+			return
+		}
+
+		deliveryId, _ := primitive.ObjectIDFromHex(c.Param("deliveryId"))
+		delivery, err := Webhooks.RedeliverWebhookDelivery(ctx, webhook.Id, deliveryId, webhookLease)
+		if err == nil && delivery == nil {
+			log.Error().Msg("Webhook delivery: " + c.Param("deliveryId") + " not found")
+			c.JSON(http.StatusNotFound, responses.UserResponse{Status: http.StatusNotFound, Message: "Webhook delivery not found", Data: nil})
+			return
+		}
+		if err == nil {
+			delivery, err = deliverWebhook(ctx, delivery)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Error redelivering webhook")
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error redelivering webhook", Data: map[string]interface{}{"data": err.Error()}})
+			return
+		}
+
+		log.Info().Msg("Webhook delivery: " + deliveryId.Hex() + " redelivered with status: " + delivery.Status)
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"delivery": delivery}})
+	}
+}
+
+// companyWebhook returns the webhook of the path when it belongs to the company of the admin, answering 403 or 404 otherwise
+func companyWebhook(ctx context.Context, c *gin.Context) (*models.Webhook, bool) {
+	companyId, ok := adminCompany(c, CurrentUser(c))
+	if !ok {
+		return nil, false
+	}
+	webhookId, _ := primitive.ObjectIDFromHex(c.Param("webhookId"))
+	webhook, err := Webhooks.FindWebhook(ctx, companyId, webhookId)
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting webhook from database")
+		c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "Error getting webhook from database", Data: map[string]interface{}{"data": err.Error()}})
+		return nil, false
+	}
+	if webhook == nil {
+		log.Error().Msg("Webhook: " + c.Param("webhookId") + " not found")
+		c.JSON(http.StatusNotFound, responses.UserResponse{Status: http.StatusNotFound, Message: "Webhook not found", Data: nil})
+		return nil, false
+	}
+	return webhook, true
+}
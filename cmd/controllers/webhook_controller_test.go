@@ -0,0 +1,421 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MockWebhookStore is a mock implementation of the webhook operations that keeps webhooks and deliveries in maps
+type MockWebhookStore struct {
+	mu         sync.Mutex
+	Hooks      map[primitive.ObjectID]*models.Webhook
+	Deliveries map[primitive.ObjectID]*models.WebhookDelivery
+}
+
+func NewMockWebhookStore() *MockWebhookStore {
+	return &MockWebhookStore{Hooks: map[primitive.ObjectID]*models.Webhook{}, Deliveries: map[primitive.ObjectID]*models.WebhookDelivery{}}
+}
+
+// CreateWebhook mocks the creation of a webhook
+func (s *MockWebhookStore) CreateWebhook(ctx context.Context, webhook models.Webhook) (primitive.ObjectID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	webhook.Id = primitive.NewObjectID()
+	s.Hooks[webhook.Id] = &webhook
+	return webhook.Id, nil
+}
+
+// FindWebhook mocks the retrieval of a webhook of a company
+func (s *MockWebhookStore) FindWebhook(ctx context.Context, companyId, id primitive.ObjectID) (*models.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if webhook, found := s.Hooks[id]; found && webhook.Company == companyId {
+		return webhook, nil
+	}
+	return nil, nil
+}
+
+// FindCompanyWebhooks mocks the retrieval of the webhooks of a company
+func (s *MockWebhookStore) FindCompanyWebhooks(ctx context.Context, companyId primitive.ObjectID) ([]*models.Webhook, error) {
+	return s.FindEventWebhooks(ctx, companyId, "")
+}
+
+// FindEventWebhooks mocks the retrieval of the webhooks of a company subscribed to an event type, any type when it is empty
+func (s *MockWebhookStore) FindEventWebhooks(ctx context.Context, companyId primitive.ObjectID, eventType string) ([]*models.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	webhooks := []*models.Webhook{}
+	for _, webhook := range s.Hooks {
+		if webhook.Company != companyId {
+			continue
+		}
+		for _, event := range webhook.Events {
+			if eventType == "" || event == eventType {
+				webhooks = append(webhooks, webhook)
+				break
+			}
+		}
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook mocks the deletion of a webhook
+func (s *MockWebhookStore) DeleteWebhook(ctx context.Context, companyId, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if webhook, found := s.Hooks[id]; !found || webhook.Company != companyId {
+		return mongo.ErrNoDocuments
+	}
+	delete(s.Hooks, id)
+	return nil
+}
+
+// CreateWebhookDeliveries mocks the creation of deliveries, skipping the events already stored for a webhook
+func (s *MockWebhookStore) CreateWebhookDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, delivery := range deliveries {
+		duplicate := false
+		for _, other := range s.Deliveries {
+			duplicate = duplicate || (other.Webhook == delivery.Webhook && other.EventId == delivery.EventId)
+		}
+		if !duplicate {
+			delivery := delivery
+			delivery.Id = primitive.NewObjectID()
+			s.Deliveries[delivery.Id] = &delivery
+		}
+	}
+	return nil
+}
+
+// FindWebhookDeliveries mocks the retrieval of the deliveries of a webhook
+func (s *MockWebhookStore) FindWebhookDeliveries(ctx context.Context, webhookId primitive.ObjectID, limit int) ([]*models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deliveries := []*models.WebhookDelivery{}
+	for _, delivery := range s.Deliveries {
+		if delivery.Webhook == webhookId {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt) })
+	return deliveries, nil
+}
+
+// ClaimWebhookDeliveries mocks claiming the pending deliveries that are due
+func (s *MockWebhookStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var deliveries []*models.WebhookDelivery
+	for _, delivery := range s.Deliveries {
+		if delivery.Status == models.WebhookDeliveryPending && !delivery.NextAttemptAt.After(now) && len(deliveries) < limit {
+			next := now.Add(lease)
+			delivery.NextAttemptAt = &next
+			copied := *delivery
+			deliveries = append(deliveries, &copied)
+		}
+	}
+	return deliveries, nil
+}
+
+// RedeliverWebhookDelivery mocks claiming a delivery of a webhook whatever its status
+func (s *MockWebhookStore) RedeliverWebhookDelivery(ctx context.Context, webhookId, id primitive.ObjectID, lease time.Duration) (*models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delivery, found := s.Deliveries[id]
+	if !found || delivery.Webhook != webhookId {
+		return nil, nil
+	}
+	next := time.Now().Add(lease)
+	delivery.NextAttemptAt = &next
+	copied := *delivery
+	return &copied, nil
+}
+
+// RecordWebhookAttempt mocks appending an attempt to the log of a delivery
+func (s *MockWebhookStore) RecordWebhookAttempt(ctx context.Context, id primitive.ObjectID, attempt models.WebhookAttempt, status string, nextAttempt *time.Time) (*models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delivery := s.Deliveries[id]
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.Status, delivery.NextAttemptAt = status, nextAttempt
+	if status == models.WebhookDeliveryDelivered {
+		delivery.DeliveredAt = &attempt.At
+	}
+	copied := *delivery
+	return &copied, nil
+}
+
+// webhookReceiver is an https httptest server that checks the signature of every request and answers with the given status codes in order
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	secret   string
+	statuses []int
+	bodies   []models.OutboxEvent
+	headers  []http.Header
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	receiver := &webhookReceiver{statuses: statuses}
+	receiver.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		timestamp, err := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+		assert.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), time.Unix(timestamp, 0), time.Minute)
+		assert.Equal(t, SignWebhook(receiver.secret, timestamp, body), r.Header.Get(WebhookSignatureHeader))
+
+		var event models.OutboxEvent
+		assert.NoError(t, json.Unmarshal(body, &event))
+		receiver.bodies = append(receiver.bodies, event)
+		receiver.headers = append(receiver.headers, r.Header.Clone())
+
+		status := http.StatusOK
+		if len(receiver.statuses) > 0 {
+			status, receiver.statuses = receiver.statuses[0], receiver.statuses[1:]
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("received"))
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+// setUpWebhooks creates a webhook of the admin company through the API pointing to the receiver
+func setUpWebhooks(t *testing.T, receiver *webhookReceiver, events ...string) (*gin.Engine, *models.UserWithCompanyAsObject, string, primitive.ObjectID) {
+	// The receiver listens on the loopback interface, which the webhook client refuses
+	defaultClient := WebhookClient
+	WebhookClient = receiver.Client()
+	t.Cleanup(func() { WebhookClient = defaultClient })
+	Sessions = memorySessions()
+	Audit = &MockAuditLog{}
+	Webhooks = NewMockWebhookStore()
+
+	admin, mockDB := loginTestUser("Tr0ub4dor&3x")
+	DB = mockDB
+	token := testSessionToken(admin)
+
+	router := gin.Default()
+	webhooks := router.Group("/companies/:companyId/webhooks", RequireSession(), RequireRole(models.RoleAdmin))
+	webhooks.POST("", CreateWebhook())
+	webhooks.GET("", GetWebhooks())
+	webhooks.DELETE("/:webhookId", DeleteWebhook())
+	webhooks.GET("/:webhookId/deliveries", GetWebhookDeliveries())
+	webhooks.POST("/:webhookId/deliveries/:deliveryId/redeliver", RedeliverWebhook())
+
+	status, response := jsonRequest(router, "/companies/"+admin.Company.Hex()+"/webhooks", token, models.CreateWebhookRequest{URL: receiver.URL, Events: events})
+	assert.Equal(t, http.StatusCreated, status)
+	receiver.secret = response.Data["secret"].(string)
+	assert.Contains(t, receiver.secret, models.WebhookSecretPrefix)
+	webhookId, _ := primitive.ObjectIDFromHex(response.Data["webhook"].(map[string]interface{})["_id"].(string))
+	return router, admin, token, webhookId
+}
+
+func TestWebhookDeliversSignedEvents(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	_, admin, _, webhookId := setUpWebhooks(t, receiver, models.EventUserCreated, models.EventRoleChanged)
+
+	created := models.NewUserEvent(models.EventUserCreated, *admin)
+	// Not subscribed to, and of another company
+	updated := models.NewUserEvent(models.EventUserUpdated, *admin)
+	other := *admin
+	other.Company = primitive.NewObjectID()
+	otherCompany := models.NewUserEvent(models.EventUserCreated, other)
+	for _, event := range []models.OutboxEvent{created, updated, otherCompany, created} {
+		assert.NoError(t, WebhookPublisher{}.Publish(context.Background(), event))
+	}
+
+	// The redelivered event of the outbox is only sent once
+	attempted, err := DeliverPendingWebhooks(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, attempted)
+	assert.Len(t, receiver.bodies, 1)
+	assert.Equal(t, created.Id, receiver.bodies[0].Id)
+	assert.Equal(t, admin.Email, receiver.bodies[0].User.Email)
+	assert.Equal(t, models.EventUserCreated, receiver.headers[0].Get("X-Webhook-Event"))
+	assert.Equal(t, created.Id.Hex(), receiver.headers[0].Get("X-Webhook-Event-Id"))
+
+	deliveries, _ := Webhooks.FindWebhookDeliveries(context.Background(), webhookId, 10)
+	assert.Equal(t, models.WebhookDeliveryDelivered, deliveries[0].Status)
+	assert.Equal(t, http.StatusOK, deliveries[0].Attempts[0].StatusCode)
+	assert.Equal(t, "received", deliveries[0].Attempts[0].Response)
+	assert.NotNil(t, deliveries[0].DeliveredAt)
+}
+
+func TestWebhookRetriesUntilDeadLetter(t *testing.T) {
+	statuses := make([]int, webhookMaxAttempts)
+	for i := range statuses {
+		statuses[i] = http.StatusServiceUnavailable
+	}
+	receiver := newWebhookReceiver(t, statuses...)
+	router, admin, token, webhookId := setUpWebhooks(t, receiver, models.EventUserDeleted)
+	assert.NoError(t, WebhookPublisher{}.Publish(context.Background(), models.NewUserEvent(models.EventUserDeleted, *admin)))
+	store := Webhooks.(*MockWebhookStore)
+
+	var delivery *models.WebhookDelivery
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		before := time.Now()
+		attempted, err := DeliverPendingWebhooks(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted)
+		for _, stored := range store.Deliveries {
+			delivery = stored
+		}
+		assert.Len(t, delivery.Attempts, attempt)
+		assert.Equal(t, "unexpected status code 503", delivery.Attempts[attempt-1].Error)
+		if attempt < webhookMaxAttempts {
+			// Each wait doubles the previous one
+			assert.Equal(t, models.WebhookDeliveryPending, delivery.Status)
+			assert.WithinDuration(t, before.Add(webhookFirstBackoff<<(attempt-1)), *delivery.NextAttemptAt, time.Second)
+			now := time.Now()
+			delivery.NextAttemptAt = &now
+		}
+	}
+	assert.Equal(t, models.WebhookDeliveryDeadLetter, delivery.Status)
+	assert.Nil(t, delivery.NextAttemptAt)
+
+	// Dead letters are not tried again by the worker
+	attempted, _ := DeliverPendingWebhooks(context.Background())
+	assert.Equal(t, 0, attempted)
+
+	// But they can be redelivered by hand once the receiver works again
+	path := "/companies/" + admin.Company.Hex() + "/webhooks/" + webhookId.Hex() + "/deliveries/" + delivery.Id.Hex() + "/redeliver"
+	status, response := jsonRequest(router, path, token, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, models.WebhookDeliveryDelivered, response.Data["delivery"].(map[string]interface{})["status"])
+	assert.Len(t, receiver.bodies, webhookMaxAttempts+1)
+
+	// The delivery log shows every attempt
+	resp, response := authRequest(router, "GET", "/companies/"+admin.Company.Hex()+"/webhooks/"+webhookId.Hex()+"/deliveries", token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	deliveries := response.Data["deliveries"].([]interface{})
+	assert.Len(t, deliveries[0].(map[string]interface{})["attempts"], webhookMaxAttempts+1)
+}
+
+// failingWebhookStore fails to read one of the webhooks of the store
+type failingWebhookStore struct {
+	*MockWebhookStore
+	failing primitive.ObjectID
+}
+
+func (s failingWebhookStore) FindWebhook(ctx context.Context, companyId, id primitive.ObjectID) (*models.Webhook, error) {
+	if id == s.failing {
+		return nil, errors.New("connection reset")
+	}
+	return s.MockWebhookStore.FindWebhook(ctx, companyId, id)
+}
+
+func TestWebhookDeliveryFailureDoesNotStopBatch(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	_, admin, _, webhookId := setUpWebhooks(t, receiver, models.EventUserCreated)
+	store := Webhooks.(*MockWebhookStore)
+	failing := primitive.NewObjectID()
+	store.Hooks[failing] = &models.Webhook{Id: failing, Company: admin.Company, URL: receiver.URL, Events: []string{models.EventUserCreated}}
+	assert.NoError(t, WebhookPublisher{}.Publish(context.Background(), models.NewUserEvent(models.EventUserCreated, *admin)))
+	Webhooks = failingWebhookStore{MockWebhookStore: store, failing: failing}
+
+	// The delivery of the other webhook is still sent and the error is returned
+	attempted, err := DeliverPendingWebhooks(context.Background())
+	assert.ErrorContains(t, err, "connection reset")
+	assert.Equal(t, 2, attempted)
+	assert.Len(t, receiver.bodies, 1)
+	deliveries, _ := Webhooks.FindWebhookDeliveries(context.Background(), webhookId, 10)
+	assert.Equal(t, models.WebhookDeliveryDelivered, deliveries[0].Status)
+}
+
+func TestWebhookManagement(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	router, admin, token, webhookId := setUpWebhooks(t, receiver, models.EventUserCreated)
+
+	// The secret is never listed
+	resp, response := authRequest(router, "GET", "/companies/"+admin.Company.Hex()+"/webhooks", token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Len(t, response.Data["webhooks"], 1)
+	assert.NotContains(t, resp.Body.String(), receiver.secret)
+
+	// Only known events and https URLs are accepted
+	status, _ := jsonRequest(router, "/companies/"+admin.Company.Hex()+"/webhooks", token, models.CreateWebhookRequest{URL: receiver.URL, Events: []string{"PasswordChanged"}})
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = jsonRequest(router, "/companies/"+admin.Company.Hex()+"/webhooks", token, models.CreateWebhookRequest{URL: "ftp://example.com", Events: []string{models.EventUserCreated}})
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = jsonRequest(router, "/companies/"+admin.Company.Hex()+"/webhooks", token, models.CreateWebhookRequest{URL: "http://169.254.169.254/latest/meta-data", Events: []string{models.EventUserCreated}})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// Admins can not manage the webhooks of other companies
+	status, _ = jsonRequest(router, "/companies/"+primitive.NewObjectID().Hex()+"/webhooks", token, models.CreateWebhookRequest{URL: receiver.URL, Events: []string{models.EventUserCreated}})
+	assert.Equal(t, http.StatusForbidden, status)
+
+	// Deliveries of deleted webhooks are dead-lettered
+	assert.NoError(t, WebhookPublisher{}.Publish(context.Background(), models.NewUserEvent(models.EventUserCreated, *admin)))
+	resp, _ = authRequest(router, "DELETE", "/companies/"+admin.Company.Hex()+"/webhooks/"+webhookId.Hex(), token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	DeliverPendingWebhooks(context.Background())
+	for _, delivery := range Webhooks.(*MockWebhookStore).Deliveries {
+		assert.Equal(t, models.WebhookDeliveryDeadLetter, delivery.Status)
+	}
+	assert.Empty(t, receiver.bodies)
+
+	resp, _ = authRequest(router, "DELETE", "/companies/"+admin.Company.Hex()+"/webhooks/"+webhookId.Hex(), token, nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestWebhookClientRefusesInternalAddresses(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+
+	// The receiver is on the loopback interface
+	_, err := NewWebhookHTTPClient().Post(receiver.URL, "application/json", strings.NewReader("{}"))
+	assert.ErrorIs(t, err, errWebhookAddress)
+	assert.Empty(t, receiver.bodies)
+
+	for ip, public := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.0.0.5":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.100.100.200":  false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"::ffff:127.0.0.1": false,
+		"fd00:ec2::254":    false,
+		"fe80::1":          false,
+	} {
+		assert.Equal(t, public, publicWebhookIP(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestWebhookRedirectsAreNotFollowed(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusFound)
+	_, admin, _, webhookId := setUpWebhooks(t, receiver, models.EventUserCreated)
+	assert.NoError(t, WebhookPublisher{}.Publish(context.Background(), models.NewUserEvent(models.EventUserCreated, *admin)))
+
+	_, err := DeliverPendingWebhooks(context.Background())
+	assert.NoError(t, err)
+	deliveries, _ := Webhooks.FindWebhookDeliveries(context.Background(), webhookId, 10)
+	assert.Equal(t, models.WebhookDeliveryPending, deliveries[0].Status)
+	assert.Equal(t, http.StatusFound, deliveries[0].Attempts[0].StatusCode)
+	assert.Contains(t, deliveries[0].Attempts[0].Error, "redirects are not followed")
+	assert.Empty(t, deliveries[0].Attempts[0].Response)
+}
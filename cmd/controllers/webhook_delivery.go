@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// webhookTimeout is how long a webhook has to answer
+	webhookTimeout = 10 * time.Second
+	// webhookMaxAttempts is how many times a delivery is tried before it is dead-lettered
+	webhookMaxAttempts = 8
+	// webhookFirstBackoff is the wait after the first failed attempt, it doubles after each one
+	webhookFirstBackoff = 30 * time.Second
+	// webhookLease hides a delivery from other workers while it is sent
+	webhookLease = time.Minute
+	// webhookBatchSize is the most deliveries sent on each round of the worker
+	webhookBatchSize = 50
+	// webhookResponseBytes is how much of the response body is kept on the delivery log
+	webhookResponseBytes = 1024
+
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+)
+
+// WebhookClient sends the webhooks. The URLs are chosen by the companies, so it only connects to public addresses
+// and does not follow redirects, see NewWebhookHTTPClient
+var WebhookClient HTTPClient = NewWebhookHTTPClient()
+
+// errWebhookAddress is the error of the connections to addresses webhooks can not reach
+var errWebhookAddress = errors.New("webhook address is not public")
+
+// blockedWebhookNetworks are the ranges, besides loopback, private, link-local and unspecified addresses, that webhooks can
+// not reach: shared address space, where some clouds serve their metadata, and the IPv6 ones that embed IPv4 addresses
+var blockedWebhookNetworks = []*net.IPNet{
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("192.0.0.0/24"),
+	mustParseCIDR("198.18.0.0/15"),
+	mustParseCIDR("64:ff9b::/96"),
+	mustParseCIDR("2002::/16"),
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// NewWebhookHTTPClient returns a client that checks the address of every connection after the host is resolved, so a
+// host that resolves to an internal address is refused even when it changed since the webhook was created. Proxies
+// from the environment are not used, since the address checked would be the one of the proxy
+func NewWebhookHTTPClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicWebhookIP(net.ParseIP(host)) {
+				return errWebhookAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// publicWebhookIP reports whether webhooks can connect to the ip
+func publicWebhookIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range blockedWebhookNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// WebhookPublisher turns the domain events into deliveries for the webhooks subscribed to them,
+// it is one of the publishers of the outbox relay
+type WebhookPublisher struct{}
+
+func (p WebhookPublisher) Publish(ctx context.Context, event models.OutboxEvent) error {
+	webhooks, err := Webhooks.FindEventWebhooks(ctx, event.Company, event.Type)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	deliveries := make([]models.WebhookDelivery, len(webhooks))
+	for i, webhook := range webhooks {
+		deliveries[i] = models.WebhookDelivery{
+			Webhook:       webhook.Id,
+			Company:       webhook.Company,
+			EventId:       event.Id,
+			EventType:     event.Type,
+			Body:          string(body),
+			Status:        models.WebhookDeliveryPending,
+			Attempts:      []models.WebhookAttempt{},
+			NextAttemptAt: &now,
+			CreatedAt:     now,
+		}
+	}
+	return Webhooks.CreateWebhookDeliveries(ctx, deliveries)
+}
+
+// RunWebhookDeliveries sends the pending deliveries until the context is cancelled
+func RunWebhookDeliveries(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		if _, err := DeliverPendingWebhooks(ctx); err != nil {
+			log.Error().Err(err).Msg("Error delivering webhooks")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverPendingWebhooks sends the deliveries that are due and returns how many were attempted. A delivery that
+// fails does not stop the others of the batch, which would stay leased until webhookLease expires
+func DeliverPendingWebhooks(ctx context.Context) (int, error) {
+	deliveries, err := Webhooks.ClaimWebhookDeliveries(ctx, webhookBatchSize, webhookLease)
+	errs := []error{err}
+	for _, delivery := range deliveries {
+		if _, err := deliverWebhook(ctx, delivery); err != nil {
+			log.Error().Err(err).Msg("Error delivering webhook delivery: " + delivery.Id.Hex())
+			errs = append(errs, err)
+		}
+	}
+	return len(deliveries), errors.Join(errs...)
+}
+
+// deliverWebhook makes one attempt of a delivery and records it. A failed delivery is tried again with
+// exponential backoff until it reaches webhookMaxAttempts, then it is dead-lettered
+func deliverWebhook(ctx context.Context, delivery *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	webhook, err := Webhooks.FindWebhook(ctx, delivery.Company, delivery.Webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	var attempt models.WebhookAttempt
+	if webhook == nil {
+		attempt = models.WebhookAttempt{At: time.Now(), Error: "webhook was deleted"}
+	} else {
+		attempt = sendWebhook(ctx, webhook, delivery)
+	}
+
+	status, nextAttempt := models.WebhookDeliveryDelivered, (*time.Time)(nil)
+	if attempt.Error != "" {
+		status = models.WebhookDeliveryDeadLetter
+		if attempts := len(delivery.Attempts) + 1; webhook != nil && attempts < webhookMaxAttempts {
+			next := attempt.At.Add(webhookBackoff(attempts))
+			status, nextAttempt = models.WebhookDeliveryPending, &next
+		}
+		log.Error().Msg("Webhook delivery: " + delivery.Id.Hex() + " failed: " + attempt.Error)
+	}
+	return Webhooks.RecordWebhookAttempt(ctx, delivery.Id, attempt, status, nextAttempt)
+}
+
+// sendWebhook posts the body of the delivery, signed with the secret of the webhook. Redirects are answered as
+// failures, the receiver has to be at the URL of the webhook
+func sendWebhook(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) (attempt models.WebhookAttempt) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	attempt.At = time.Now()
+	defer func() { attempt.DurationMs = time.Since(attempt.At).Milliseconds() }()
+
+	// Webhooks created before https was required are not sent either
+	if !strings.HasPrefix(webhook.URL, "https://") {
+		attempt.Error = "webhook URL must be https"
+		return attempt
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, strings.NewReader(delivery.Body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := attempt.At.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "user-service-webhooks")
+	req.Header.Set("X-Webhook-Id", delivery.Id.Hex())
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Event-Id", delivery.EventId.Hex())
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, timestamp, []byte(delivery.Body)))
+
+	resp, err := WebhookClient.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 300 && resp.StatusCode <= 399 {
+		// The body of a redirect may come from anywhere, only the status is kept
+		attempt.Error = "redirects are not followed, status code " + strconv.Itoa(resp.StatusCode)
+		return attempt
+	}
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseBytes))
+	attempt.Response = string(response)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = "unexpected status code " + strconv.Itoa(resp.StatusCode)
+	}
+	return attempt
+}
+
+// SignWebhook returns the signature receivers check: sha256= followed by the hex HMAC-SHA256, keyed with the
+// secret of the webhook, of the timestamp, a dot and the body
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the wait after a number of failed attempts
+func webhookBackoff(attempts int) time.Duration {
+	return webhookFirstBackoff << (attempts - 1)
+}
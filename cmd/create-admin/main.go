@@ -0,0 +1,81 @@
+// Command create-admin creates the first admin of a company, which then creates the other users with POST /users.
+// POST /users needs the session of an admin or an API key of the company, so a new company starts here.
+// The password is read from the first line of the standard input so it is not left in the shell history:
+//
+//	echo "$ADMIN_PASSWORD" | go run ./cmd/create-admin -company <id> -name "Ada Admin" -email ada@example.com
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"os"
+	"strings"
+	"time"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+	"user-service/internal/services"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func main() {
+	company := flag.String("company", "", "id of the company")
+	name := flag.String("name", "", "name of the admin")
+	email := flag.String("email", "", "email of the admin")
+	flag.Parse()
+
+	companyId, err := primitive.ObjectIDFromHex(*company)
+	if err != nil {
+		log.Fatal().Msg("-company must be the id of the company")
+	}
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		log.Fatal().Err(err).Msg("Error reading the password from the standard input")
+	}
+	password = strings.TrimRight(password, "\r\n")
+
+	validate := validator.New()
+	if err := auth.RegisterPasswordValidation(validate); err != nil {
+		log.Fatal().Err(err).Msg("Error registering password validation")
+	}
+	auth.DefaultPasswordPolicy = configs.EnvPasswordPolicy(auth.DefaultPasswordPolicy)
+	if path := configs.EnvBreachedPasswordsFile(); path != "" {
+		if err := auth.LoadPasswordList(path); err != nil {
+			log.Error().Err(err).Msg("Error loading breached passwords file")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ctx = configs.WithAuditInfo(configs.WithTenant(ctx, companyId), configs.AuditInfo{RequestId: "create-admin"})
+	db := configs.NewTenantDatabase(configs.NewAuditedDatabase(configs.NewMongoDB(configs.DB), configs.NewMongoAuditLog(configs.DB)))
+	// Without an inviter an email of other companies would wait for an invitation nobody sends, so it is refused
+	service := services.NewUserService(db, configs.NewMongoCompanySettingsStore(configs.DB), configs.NewMongoGroupStore(configs.DB), nil, validate)
+
+	users, err := service.ListByCompany(ctx, companyId)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error getting the users of the company")
+	}
+	for _, user := range users {
+		if user.Role == models.RoleAdmin {
+			log.Fatal().Msg("The company already has an admin, it creates the other users with POST /users")
+		}
+	}
+	invite, err := service.NeedsInvitation(ctx, companyId, *email)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error checking the email")
+	}
+	if invite {
+		log.Fatal().Msg("The email is already in other companies, use another one and add it later with POST /users")
+	}
+
+	created, err := service.Create(ctx, services.CreateUserInput{Name: *name, Email: *email, Password: password, Role: models.RoleAdmin, Company: companyId.Hex()})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error creating the admin")
+	}
+	log.Info().Str("user", created.Id.Hex()).Msg("Admin created, it can log in with POST /auth/login")
+}
@@ -0,0 +1,37 @@
+// Command migrate-identities moves the credentials of the users stored before the same email could be in several
+// companies to the identities collection, so each user becomes the membership of an identity in its company.
+// It has to run before the service is deployed with identities and before migrate-passwords.
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+	"user-service/internal/configs"
+
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only count the users without an identity")
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	db := configs.NewMongoDB(configs.DB)
+
+	if *dryRun {
+		count, err := db.CountUsersWithoutIdentity(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error counting users without an identity")
+		}
+		log.Info().Int64("users", count).Msg("Users without an identity")
+		return
+	}
+
+	count, err := db.MigrateIdentities(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Int64("users", count).Msg("Error moving users to identities, the command can be run again")
+	}
+	log.Info().Int64("users", count).Msg("Users moved to identities")
+}
@@ -0,0 +1,36 @@
+// Command migrate-passwords removes the passwords stored in plain text before they were hashed.
+// Their users are flagged with passwordResetRequired and have to choose a new one through the forgot password flow.
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+	"user-service/internal/configs"
+
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only count the users with a plain text password")
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	db := configs.NewMongoDB(configs.DB)
+
+	if *dryRun {
+		count, err := db.CountPlaintextPasswords(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error counting plain text passwords")
+		}
+		log.Info().Int64("users", count).Msg("Users with a plain text password")
+		return
+	}
+
+	count, err := db.MarkPlaintextPasswordsForReset(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error removing plain text passwords")
+	}
+	log.Info().Int64("users", count).Msg("Plain text passwords removed, their users have to reset them")
+}
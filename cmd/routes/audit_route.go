@@ -0,0 +1,49 @@
+package routes
+
+import (
+	"net/http"
+	"user-service/cmd/controllers"
+	"user-service/internal/models"
+	"user-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AuditRoute(router gin.IRouter) {
+	audit := router.Group("/audit", controllers.RequireSession(), controllers.RequireRole(models.RoleAdmin))
+	audit.GET("", controllers.GetAuditEvents())
+	audit.GET("/export", controllers.ExportAuditEvents())
+}
+
+// auditFilterQuery has the filters of the audit log, the company is always the one of the admin
+var auditFilterQuery = []openapi.Parameter{
+	{Name: "action"},
+	{Name: "actor", Schema: openapi.ObjectIdSchema()},
+	{Name: "target", Schema: openapi.ObjectIdSchema()},
+	{Name: "from", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+	{Name: "to", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+}
+
+var auditRouteDocs = []openapi.Route{
+	{
+		Method:   http.MethodGet,
+		Path:     "/audit",
+		Summary:  "Search the audit log of your company (admin)",
+		Security: []string{securitySession},
+		Query: append([]openapi.Parameter{
+			{Name: "page", Schema: &openapi.Schema{Type: "integer"}},
+			{Name: "limit", Schema: &openapi.Schema{Type: "integer"}},
+		}, auditFilterQuery...),
+		Data:   map[string]interface{}{"events": []models.AuditEvent{}, "page": 0, "limit": 0, "total": int64(0)},
+		Errors: []int{http.StatusBadRequest, http.StatusForbidden},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/audit/export",
+		Summary:  "Download the audit log of your company as CSV, NDJSON or XLSX (admin)",
+		Security: []string{securitySession},
+		Query:    append([]openapi.Parameter{{Name: "format", Schema: exportFormatSchema}}, auditFilterQuery...),
+		Content:  exportContent,
+		Errors:   []int{http.StatusBadRequest, http.StatusForbidden},
+	},
+}
@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"net/http"
+	"time"
+	"user-service/cmd/controllers"
+	"user-service/internal/models"
+	"user-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AuthRoute(router gin.IRouter) {
+	router.POST("/auth/login", controllers.Login())
+	router.POST("/auth/login/mfa", controllers.LoginMFA())
+	router.POST("/auth/logout", controllers.RequireSession(models.SessionScopeMFAEnrollment), controllers.Logout())
+	router.POST("/auth/mfa/enroll", controllers.RequireSession(models.SessionScopeMFAEnrollment), controllers.EnrollMFA())
+	router.POST("/auth/mfa/confirm", controllers.RequireSession(models.SessionScopeMFAEnrollment), controllers.ConfirmMFA())
+	router.POST("/auth/mfa/disable", controllers.RequireSession(), controllers.DisableMFA())
+	router.POST("/auth/password/forgot", controllers.ForgotPassword())
+	router.POST("/auth/password/reset", controllers.ResetPassword())
+	router.POST("/auth/invitations/accept", controllers.AcceptInvitation())
+}
+
+var authRouteDocs = []openapi.Route{
+	{
+		Method:      http.MethodPost,
+		Path:        "/auth/login",
+		Summary:     "Log in with email and password",
+		Description: "Users with MFA get an mfaToken for /auth/login/mfa, and users that have to enroll MFA a token limited to the enrollment. Users of several companies choose one with company, without it they get 409 with the companies",
+		Request:     models.LoginRequest{},
+		Data: map[string]interface{}{
+			"token":                 "",
+			"expiresAt":             time.Time{},
+			"mfaRequired":           true,
+			"mfaToken":              "",
+			"mfaEnrollmentRequired": true,
+			"companies":             []string{},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusConflict, http.StatusTooManyRequests},
+	},
+	{
+		Method:  http.MethodPost,
+		Path:    "/auth/login/mfa",
+		Summary: "Second login step with a TOTP or recovery code",
+		Request: models.MFALoginRequest{},
+		Data:    map[string]interface{}{"token": "", "expiresAt": time.Time{}},
+		Errors:  []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusTooManyRequests},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/auth/logout",
+		Summary:  "End the current session",
+		Security: []string{securitySession},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/auth/mfa/enroll",
+		Summary:  "Start the MFA enrollment",
+		Security: []string{securitySession},
+		Data:     map[string]interface{}{"secret": "", "otpauthUri": ""},
+		Errors:   []int{http.StatusConflict},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/auth/mfa/confirm",
+		Summary:     "Enable MFA with a code from the authenticator app",
+		Description: "Returns the recovery codes, and a full session to users that were forced to enroll",
+		Security:    []string{securitySession},
+		Request:     models.MFACodeRequest{},
+		Data:        map[string]interface{}{"recoveryCodes": []string{}, "token": "", "expiresAt": time.Time{}},
+		Errors:      []int{http.StatusBadRequest},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/auth/mfa/disable",
+		Summary:  "Disable MFA",
+		Security: []string{securitySession},
+		Request:  models.MFACodeRequest{},
+		Errors:   []int{http.StatusBadRequest, http.StatusForbidden},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/auth/password/forgot",
+		Summary:     "Request a password reset link",
+		Description: "Answers the same whether the email is registered or not",
+		Request:     models.ForgotPasswordRequest{},
+		Status:      http.StatusAccepted,
+		Errors:      []int{http.StatusBadRequest},
+	},
+	{
+		Method:  http.MethodPost,
+		Path:    "/auth/password/reset",
+		Summary: "Set a new password with a reset token",
+		Request: models.ResetPasswordRequest{},
+		Errors:  []int{http.StatusBadRequest},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/auth/invitations/accept",
+		Summary:     "Join the company that invited your email, with the token of the invitation email",
+		Description: "Users created with an email that is already in other companies can not log in to the new company until its person accepts",
+		Request:     models.AcceptInvitationRequest{},
+		Data:        map[string]interface{}{"company": ""},
+		Errors:      []int{http.StatusBadRequest},
+	},
+}
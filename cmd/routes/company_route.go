@@ -0,0 +1,310 @@
+package routes
+
+import (
+	"net/http"
+	"user-service/cmd/controllers"
+	"user-service/internal/models"
+	"user-service/internal/openapi"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+func CompanyRoute(router gin.IRouter) {
+	router.GET("/companies/:companyId/password-policy", controllers.GetPasswordPolicy())
+	router.PUT("/companies/:companyId/settings/mfa", controllers.RequireSession(), controllers.RequireRole(models.RoleAdmin), controllers.UpdateRequireMFA())
+	router.GET("/companies/:companyId/settings/attributes", controllers.RequireSessionOrAPIKey(models.APIKeyScopeUsersRead), controllers.GetAttributeSchema())
+	router.PUT("/companies/:companyId/settings/attributes", controllers.RequireSession(), controllers.RequireRole(models.RoleAdmin), controllers.UpdateAttributeSchema())
+
+	imports := router.Group("/companies/:companyId/users/import", controllers.RequireSession(), controllers.RequireRole(models.RoleAdmin))
+	imports.POST("", controllers.ImportUsers())
+	imports.GET("/:jobId", controllers.GetImportJob())
+
+	router.GET("/companies/:companyId/users/events", controllers.RequireSession(), controllers.RequireRole(models.RoleAdmin), controllers.StreamUserEvents())
+	router.GET("/companies/:companyId/users/export", controllers.RequireSession(), controllers.RequireRole(models.RoleAdmin), controllers.ExportUsers())
+
+	apiKeys := router.Group("/companies/:companyId/api-keys", controllers.RequireSession(), controllers.RequireRole(models.RoleAdmin))
+	apiKeys.POST("", controllers.CreateAPIKey())
+	apiKeys.GET("", controllers.GetAPIKeys())
+	apiKeys.DELETE("/:keyId", controllers.RevokeAPIKey())
+	apiKeys.POST("/:keyId/rotate", controllers.RotateAPIKey())
+
+	webhooks := router.Group("/companies/:companyId/webhooks", controllers.RequireSession(), controllers.RequireRole(models.RoleAdmin))
+	webhooks.POST("", controllers.CreateWebhook())
+	webhooks.GET("", controllers.GetWebhooks())
+	webhooks.DELETE("/:webhookId", controllers.DeleteWebhook())
+	webhooks.GET("/:webhookId/deliveries", controllers.GetWebhookDeliveries())
+	webhooks.POST("/:webhookId/deliveries/:deliveryId/redeliver", controllers.RedeliverWebhook())
+
+	groups := router.Group("/companies/:companyId/groups", controllers.RequireSession(), controllers.RequireRole(models.RoleAdmin))
+	groups.POST("", controllers.CreateGroup())
+	groups.GET("", controllers.GetGroups())
+	groups.GET("/:groupId", controllers.GetGroup())
+	groups.PATCH("/:groupId", controllers.RenameGroup())
+	groups.DELETE("/:groupId", controllers.DeleteGroup())
+	groups.POST("/:groupId/members", controllers.AddGroupMember())
+	groups.DELETE("/:groupId/members/:userId", controllers.RemoveGroupMember())
+	groups.POST("/:groupId/groups", controllers.NestGroup())
+	groups.DELETE("/:groupId/groups/:nestedGroupId", controllers.UnnestGroup())
+
+	oidcClients := router.Group("/companies/:companyId/oidc-clients", controllers.RequireSession(), controllers.RequireRole(models.RoleAdmin))
+	oidcClients.POST("", controllers.CreateOIDCClient())
+	oidcClients.GET("", controllers.GetOIDCClients())
+	oidcClients.DELETE("/:clientId", controllers.DeleteOIDCClient())
+}
+
+var companyRouteDocs = []openapi.Route{
+	{
+		Method:  http.MethodGet,
+		Path:    "/companies/:companyId/password-policy",
+		Summary: "Get the password rules of a company",
+		Data:    map[string]interface{}{"passwordPolicy": models.PasswordPolicy{}},
+		Errors:  []int{http.StatusBadRequest},
+	},
+	{
+		Method:   http.MethodPut,
+		Path:     "/companies/:companyId/settings/mfa",
+		Summary:  "Require MFA for every user of the company (admin)",
+		Security: []string{securitySession},
+		Request:  models.RequireMFARequest{},
+		Data:     map[string]interface{}{"requireMfa": true},
+		Errors:   []int{http.StatusBadRequest, http.StatusForbidden},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/companies/:companyId/settings/attributes",
+		Summary:  "Get the custom attributes of the users of your company",
+		Security: []string{securitySession, securityAPIKey},
+		Data:     map[string]interface{}{"attributes": []models.AttributeDefinition{}},
+		Errors:   []int{http.StatusForbidden},
+	},
+	{
+		Method:      http.MethodPut,
+		Path:        "/companies/:companyId/settings/attributes",
+		Summary:     "Replace the custom attributes of the users of the company (admin)",
+		Description: "Values users already have are kept and checked against the new schema when their attributes change",
+		Security:    []string{securitySession},
+		Request:     models.AttributeSchemaRequest{},
+		Data:        map[string]interface{}{"attributes": []models.AttributeDefinition{}},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden},
+	},
+	{
+		Method:         http.MethodPost,
+		Path:           "/companies/:companyId/users/import",
+		Summary:        "Import users from a CSV or NDJSON file (admin)",
+		Description:    "Small files are answered with the report, larger ones with a job to poll and 202",
+		Security:       []string{securitySession},
+		Query:          []openapi.Parameter{{Name: "dryRun", Schema: &openapi.Schema{Type: "boolean"}}, {Name: "format", Schema: &openapi.Schema{Type: "string", Enum: []interface{}{"csv", "ndjson"}}}},
+		RequestContent: []string{"text/csv", "application/x-ndjson"},
+		Data:           map[string]interface{}{"report": models.ImportReport{}, "job": models.ImportJob{}},
+		Errors:         []int{http.StatusBadRequest, http.StatusForbidden, http.StatusUnsupportedMediaType},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/companies/:companyId/users/import/:jobId",
+		Summary:  "Get the progress of a background import (admin)",
+		Security: []string{securitySession},
+		Data:     map[string]interface{}{"job": models.ImportJob{}},
+		Errors:   []int{http.StatusForbidden, http.StatusNotFound},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/companies/:companyId/users/events",
+		Summary:     "Server-Sent Events stream of the user changes of the company (admin)",
+		Description: "Every event has the id of the domain event, send it back as Last-Event-ID to resume the stream",
+		Security:    []string{securitySession},
+		Query:       []openapi.Parameter{{Name: "lastEventId", Schema: openapi.ObjectIdSchema()}},
+		Headers:     []openapi.Parameter{{Name: "Last-Event-ID", Schema: openapi.ObjectIdSchema()}},
+		Content:     []string{sse.ContentType},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/companies/:companyId/users/export",
+		Summary:  "Download the users of the company as CSV, NDJSON or XLSX (admin)",
+		Security: []string{securitySession},
+		Query:    []openapi.Parameter{{Name: "format", Schema: exportFormatSchema}, {Name: "columns", Description: "Comma separated columns of the export"}},
+		Content:  exportContent,
+		Errors:   []int{http.StatusBadRequest, http.StatusForbidden},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/companies/:companyId/api-keys",
+		Summary:     "Create an API key (admin)",
+		Description: "The key is only returned once",
+		Security:    []string{securitySession},
+		Request:     models.CreateAPIKeyRequest{},
+		Status:      http.StatusCreated,
+		Data:        map[string]interface{}{"apiKey": models.APIKey{}, "key": ""},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/companies/:companyId/api-keys",
+		Summary:  "List the API keys of the company (admin)",
+		Security: []string{securitySession},
+		Data:     map[string]interface{}{"apiKeys": []models.APIKey{}},
+		Errors:   []int{http.StatusForbidden},
+	},
+	{
+		Method:   http.MethodDelete,
+		Path:     "/companies/:companyId/api-keys/:keyId",
+		Summary:  "Revoke an API key (admin)",
+		Security: []string{securitySession},
+		Errors:   []int{http.StatusForbidden, http.StatusNotFound},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/companies/:companyId/api-keys/:keyId/rotate",
+		Summary:  "Replace the secret of an API key (admin)",
+		Security: []string{securitySession},
+		Data:     map[string]interface{}{"apiKey": models.APIKey{}, "key": ""},
+		Errors:   []int{http.StatusForbidden, http.StatusNotFound},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/companies/:companyId/webhooks",
+		Summary:     "Subscribe a URL to user events (admin)",
+		Description: "The secret the deliveries are signed with is only returned once",
+		Security:    []string{securitySession},
+		Request:     models.CreateWebhookRequest{},
+		Status:      http.StatusCreated,
+		Data:        map[string]interface{}{"webhook": models.Webhook{}, "secret": ""},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/companies/:companyId/webhooks",
+		Summary:  "List the webhooks of the company (admin)",
+		Security: []string{securitySession},
+		Data:     map[string]interface{}{"webhooks": []models.Webhook{}},
+		Errors:   []int{http.StatusForbidden},
+	},
+	{
+		Method:   http.MethodDelete,
+		Path:     "/companies/:companyId/webhooks/:webhookId",
+		Summary:  "Delete a webhook (admin)",
+		Security: []string{securitySession},
+		Errors:   []int{http.StatusForbidden, http.StatusNotFound},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/companies/:companyId/webhooks/:webhookId/deliveries",
+		Summary:  "Get the latest deliveries of a webhook with their attempts (admin)",
+		Security: []string{securitySession},
+		Data:     map[string]interface{}{"deliveries": []models.WebhookDelivery{}},
+		Errors:   []int{http.StatusForbidden, http.StatusNotFound},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/companies/:companyId/webhooks/:webhookId/deliveries/:deliveryId/redeliver",
+		Summary:  "Send a delivery again (admin)",
+		Security: []string{securitySession},
+		Data:     map[string]interface{}{"delivery": models.WebhookDelivery{}},
+		Errors:   []int{http.StatusForbidden, http.StatusNotFound},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/companies/:companyId/groups",
+		Summary:  "Create a group of users (admin)",
+		Security: []string{securitySession},
+		Request:  models.GroupRequest{},
+		Status:   http.StatusCreated,
+		Data:     map[string]interface{}{"group": models.Group{}},
+		Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusConflict},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/companies/:companyId/groups",
+		Summary:  "List the groups of the company (admin)",
+		Security: []string{securitySession},
+		Data:     map[string]interface{}{"groups": []models.Group{}},
+		Errors:   []int{http.StatusForbidden},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/companies/:companyId/groups/:groupId",
+		Summary:  "Get a group with its direct members and nested groups (admin)",
+		Security: []string{securitySession},
+		Data:     map[string]interface{}{"group": models.Group{}},
+		Errors:   []int{http.StatusForbidden, http.StatusNotFound},
+	},
+	{
+		Method:   http.MethodPatch,
+		Path:     "/companies/:companyId/groups/:groupId",
+		Summary:  "Rename a group (admin)",
+		Security: []string{securitySession},
+		Request:  models.GroupRequest{},
+		Data:     map[string]interface{}{"group": models.Group{}},
+		Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict},
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/companies/:companyId/groups/:groupId",
+		Summary:     "Delete a group (admin)",
+		Description: "The members are kept, and the group is taken out of the groups it was nested in",
+		Security:    []string{securitySession},
+		Errors:      []int{http.StatusForbidden, http.StatusNotFound},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/companies/:companyId/groups/:groupId/members",
+		Summary:  "Add a user of the company to a group (admin)",
+		Security: []string{securitySession},
+		Request:  models.GroupMemberRequest{},
+		Data:     map[string]interface{}{"group": models.Group{}},
+		Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound},
+	},
+	{
+		Method:   http.MethodDelete,
+		Path:     "/companies/:companyId/groups/:groupId/members/:userId",
+		Summary:  "Remove a user from a group (admin)",
+		Security: []string{securitySession},
+		Data:     map[string]interface{}{"group": models.Group{}},
+		Errors:   []int{http.StatusForbidden, http.StatusNotFound},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/companies/:companyId/groups/:groupId/groups",
+		Summary:     "Nest a group in another one (admin)",
+		Description: "The members of the nested group become members of the group. A group can not be nested in itself nor in the groups nested in it",
+		Security:    []string{securitySession},
+		Request:     models.NestedGroupRequest{},
+		Data:        map[string]interface{}{"group": models.Group{}},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict},
+	},
+	{
+		Method:   http.MethodDelete,
+		Path:     "/companies/:companyId/groups/:groupId/groups/:nestedGroupId",
+		Summary:  "Take a nested group out of a group (admin)",
+		Security: []string{securitySession},
+		Data:     map[string]interface{}{"group": models.Group{}},
+		Errors:   []int{http.StatusForbidden, http.StatusNotFound},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/companies/:companyId/oidc-clients",
+		Summary:     "Register an application that signs users in with OpenID Connect (admin)",
+		Description: "The _id of the client is its client_id, the secret of confidential clients is only returned once",
+		Security:    []string{securitySession},
+		Request:     models.CreateOIDCClientRequest{},
+		Status:      http.StatusCreated,
+		Data:        map[string]interface{}{"client": models.OIDCClient{}, "secret": ""},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/companies/:companyId/oidc-clients",
+		Summary:  "List the OpenID Connect clients of the company (admin)",
+		Security: []string{securitySession},
+		Data:     map[string]interface{}{"clients": []models.OIDCClient{}},
+		Errors:   []int{http.StatusForbidden},
+	},
+	{
+		Method:   http.MethodDelete,
+		Path:     "/companies/:companyId/oidc-clients/:clientId",
+		Summary:  "Delete an OpenID Connect client (admin)",
+		Security: []string{securitySession},
+		Errors:   []int{http.StatusForbidden, http.StatusNotFound},
+	},
+}
@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"user-service/cmd/controllers"
+	"user-service/internal/configs"
+	userv1 "user-service/internal/pb/user/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// GRPCServer registers the gRPC services behind the API key interceptors, with health checking. Reflection, so tools
+// like grpcurl can list the services, is only registered when GRPC_REFLECTION is enabled
+func GRPCServer(options ...grpc.ServerOption) *grpc.Server {
+	options = append(options,
+		grpc.ChainUnaryInterceptor(controllers.GRPCAuthUnaryInterceptor()),
+		grpc.ChainStreamInterceptor(controllers.GRPCAuthStreamInterceptor()),
+	)
+	server := grpc.NewServer(options...)
+	userv1.RegisterUserServiceServer(server, controllers.UserGRPCServer{})
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(userv1.UserService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	if configs.EnvGRPCReflection() {
+		reflection.Register(server)
+	}
+	return server
+}
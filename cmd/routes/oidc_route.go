@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"user-service/cmd/controllers"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// OIDCRoute registers the OpenID Connect provider the applications of the companies sign their users in with.
+// The protocol defines its own paths and errors, so it is not part of the versions of the API nor of its OpenAPI document.
+// It is only served when OIDC_ISSUER is set
+func OIDCRoute(router gin.IRouter) {
+	if controllers.OIDCIssuer == "" {
+		log.Warn().Msg("No OIDC_ISSUER environment variable detected, the OpenID Connect provider is disabled")
+		return
+	}
+	router.GET("/.well-known/openid-configuration", controllers.GetOpenIDConfiguration())
+
+	oauth := router.Group(controllers.OIDCPrefix)
+	oauth.GET("/authorize", controllers.RequireSession(), controllers.AuthorizeOIDC())
+	oauth.POST("/token", controllers.ExchangeOIDCToken())
+	oauth.GET("/userinfo", controllers.GetOIDCUserInfo())
+	oauth.POST("/userinfo", controllers.GetOIDCUserInfo())
+	oauth.GET("/jwks", controllers.GetJWKS())
+}
@@ -0,0 +1,52 @@
+package routes
+
+import (
+	"user-service/cmd/controllers"
+	"user-service/internal/export"
+	"user-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	securitySession = "session"
+	securityAPIKey  = "apiKey"
+)
+
+var (
+	exportFormatSchema = &openapi.Schema{Type: "string", Enum: []interface{}{export.FormatCSV, export.FormatNDJSON, export.FormatXLSX}}
+	exportContent      = []string{export.ContentTypes[export.FormatCSV], export.ContentTypes[export.FormatNDJSON], export.ContentTypes[export.FormatXLSX]}
+)
+
+// V1Docs documents the routes of V1, every route registered by V1 has to be here
+func V1Docs() []openapi.Route {
+	var docs []openapi.Route
+	for _, routes := range [][]openapi.Route{userRouteDocs, authRouteDocs, companyRouteDocs, auditRouteDocs} {
+		docs = append(docs, routes...)
+	}
+	return docs
+}
+
+// V1Document generates the OpenAPI document of the routes registered under /v1
+func V1Document(registered gin.RoutesInfo) *openapi.Document {
+	document := openapi.New(
+		openapi.Info{Title: "User service", Version: "1", Description: "Users, authentication and the settings of their companies"},
+		openapi.Server{URL: "/v1"},
+	)
+	document.Components.SecuritySchemes[securitySession] = openapi.SecurityScheme{Type: "http", Scheme: "bearer", Description: "Session token of POST /auth/login"}
+	document.Components.SecuritySchemes[securityAPIKey] = openapi.SecurityScheme{Type: "apiKey", In: "header", Name: "X-API-Key", Description: "API key of the company, it can also be sent as a bearer token"}
+	document.Generate(registered, "/v1", V1Docs())
+	return document
+}
+
+// OpenAPIRoute serves the OpenAPI document of the routes registered so far and its reference page,
+// it has to be registered after the versions
+func OpenAPIRoute(router *gin.Engine) {
+	document := V1Document(router.Routes())
+	for _, route := range document.Missing(router.Routes(), "/v1") {
+		log.Warn().Msg("Route " + route + " is missing from the OpenAPI document")
+	}
+	router.GET("/openapi.json", controllers.GetOpenAPIDocument(document))
+	router.GET("/docs", controllers.GetAPIReference())
+}
@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"user-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserRouteIsDocumented(t *testing.T) {
+	router := gin.New()
+	UserRoute(router.Group("/v1"))
+	document := V1Document(router.Routes())
+
+	for _, route := range router.Routes() {
+		path := openapi.PathOf(strings.TrimPrefix(route.Path, "/v1"))
+		assert.NotNil(t, document.Paths[path][strings.ToLower(route.Method)], route.Method+" "+route.Path+" is missing from the OpenAPI document")
+	}
+	assert.Empty(t, document.Missing(router.Routes(), "/v1"))
+}
+
+func TestV1IsDocumented(t *testing.T) {
+	router := gin.New()
+	V1(router.Group("/v1"))
+	document := V1Document(router.Routes())
+	assert.Empty(t, document.Missing(router.Routes(), "/v1"))
+
+	// Every documented route is registered, so the documentation does not keep removed routes
+	operations := 0
+	for _, item := range document.Paths {
+		operations += len(item)
+	}
+	assert.Equal(t, len(V1Docs()), operations)
+}
+
+func TestOpenAPIRoute(t *testing.T) {
+	router := gin.New()
+	Versions(router)
+	OpenAPIRoute(router)
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var document map[string]interface{}
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &document))
+	assert.Equal(t, openapi.Version, document["openapi"])
+	user := document["components"].(map[string]interface{})["schemas"].(map[string]interface{})["User"].(map[string]interface{})
+	assert.ElementsMatch(t, []interface{}{"name", "password", "email", "role", "company"}, user["required"])
+
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/docs", nil)
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `spec-url="openapi.json"`)
+}
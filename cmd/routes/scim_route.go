@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"user-service/cmd/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SCIMRoute registers the SCIM 2.0 endpoints the identity providers of the companies provision users with.
+// SCIM has its own versions and error format, so it is not part of the versions of the API nor of its OpenAPI document
+func SCIMRoute(router gin.IRouter) {
+	scim := router.Group(controllers.SCIMPrefix, controllers.RequireSCIMToken())
+	scim.GET("/ServiceProviderConfig", controllers.GetSCIMServiceProviderConfig())
+	scim.GET("/ResourceTypes", controllers.GetSCIMResourceTypes())
+	scim.GET("/ResourceTypes/:resourceTypeId", controllers.GetSCIMResourceType())
+	scim.GET("/Schemas", controllers.GetSCIMSchemas())
+	scim.GET("/Schemas/:schemaId", controllers.GetSCIMSchema())
+
+	scim.GET("/Users", controllers.GetSCIMUsers())
+	scim.POST("/Users", controllers.CreateSCIMUser())
+	scim.GET("/Users/:userId", controllers.GetSCIMUser())
+	scim.PUT("/Users/:userId", controllers.ReplaceSCIMUser())
+	scim.PATCH("/Users/:userId", controllers.PatchSCIMUser())
+	scim.DELETE("/Users/:userId", controllers.DeleteSCIMUser())
+}
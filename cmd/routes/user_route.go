@@ -1,13 +1,114 @@
 package routes
 
 import (
+	"net/http"
 	"user-service/cmd/controllers"
+	"user-service/internal/models"
+	"user-service/internal/openapi"
 
 	"github.com/gin-gonic/gin"
 )
 
-func UserRoute(router *gin.Engine) {
-	router.POST("/users", controllers.CreateUser())
-	router.GET("/users/:userId", controllers.FindById())
-	router.GET("/users", controllers.GetUsers())
+func UserRoute(router gin.IRouter) {
+	router.POST("/users", controllers.RequireSessionOrAPIKey(models.APIKeyScopeUsersWrite), controllers.RequireAdminOrAPIKey(), controllers.Idempotent(), controllers.CreateUser())
+	router.GET("/users/:userId", controllers.RequireSessionOrAPIKey(models.APIKeyScopeUsersRead), controllers.FindById())
+	router.GET("/users", controllers.RequireSessionOrAPIKey(models.APIKeyScopeUsersRead), controllers.RequireCompanyQuery(), controllers.GetUsers())
+	router.PATCH("/users/:userId", controllers.RequireSession(), controllers.UpdateUser())
+	router.DELETE("/users/:userId", controllers.RequireSession(), controllers.RequireRole(models.RoleAdmin), controllers.DeleteUser())
+	router.POST("/users/:userId/unlock", controllers.RequireSession(), controllers.RequireRole(models.RoleAdmin), controllers.UnlockUser())
+	router.GET("/users/:userId/groups", controllers.RequireSessionOrAPIKey(models.APIKeyScopeUsersRead), controllers.GetUserGroups())
+	router.GET("/users/:userId/reports", controllers.RequireSessionOrAPIKey(models.APIKeyScopeUsersRead), controllers.GetUserReports())
+	router.GET("/users/:userId/chain", controllers.RequireSessionOrAPIKey(models.APIKeyScopeUsersRead), controllers.GetUserChain())
+}
+
+var userRouteDocs = []openapi.Route{
+	{
+		Method:      http.MethodPost,
+		Path:        "/users",
+		Summary:     "Create a user in your company",
+		Description: "Admins and API keys with the users:write scope create users of their own company, the company of the body defaults to it",
+		Security:    []string{securitySession, securityAPIKey},
+		Headers:     []openapi.Parameter{{Name: controllers.IdempotencyKeyHeader, Description: "Retries with the same key get the first response instead of creating the user again"}},
+		Request:     models.User{},
+		Status:      http.StatusCreated,
+		Data:        map[string]interface{}{"user": models.UserWithCompanyAsObject{}},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusConflict, http.StatusUnprocessableEntity},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/users/:userId",
+		Summary:     "Get a user of your company by id",
+		Description: "The ETag header has the version of the user, an If-None-Match with it answers 304. Users of other companies are answered as not found, with a null user",
+		Security:    []string{securitySession, securityAPIKey},
+		Headers:     []openapi.Parameter{{Name: "If-None-Match"}},
+		Data:        map[string]interface{}{"user": models.UserWithCompanyAsObject{}},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/users",
+		Summary:     "Get the users of a company, or a user by email",
+		Description: "Returns users with the company parameter, or user with the email parameter. The group parameter keeps the members of the group and of the groups nested in it, and attributes.{name} the users with the value of an indexed attribute",
+		Security:    []string{securitySession, securityAPIKey},
+		Query: []openapi.Parameter{
+			{Name: "company", Description: "Id of the company, required for API keys", Schema: openapi.ObjectIdSchema()},
+			{Name: "email"},
+			{Name: "group", Description: "Id of a group of the company", Schema: openapi.ObjectIdSchema()},
+			{Name: "attributes.{name}", Description: "Value of an indexed custom attribute of the company, repeatable with other attributes"},
+		},
+		Data:   map[string]interface{}{"users": []models.UserWithCompanyAsObject{}, "user": models.UserWithCompanyAsObject{}},
+		Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound},
+	},
+	{
+		Method:      http.MethodPatch,
+		Path:        "/users/:userId",
+		Summary:     "Update the name of your user, or the name, role, manager and attributes of a user of your company (admin)",
+		Description: "If-Match must have the ETag of the version the update is based on, or *. An empty managerId removes the manager, and a manager that reports to the user is a conflict. Attributes are merged with the current ones, null removes one",
+		Security:    []string{securitySession},
+		Headers:     []openapi.Parameter{{Name: "If-Match", Required: true}},
+		Request:     models.UserUpdate{},
+		Data:        map[string]interface{}{"user": models.UserWithCompanyAsObject{}},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed, http.StatusPreconditionRequired},
+	},
+	{
+		Method:   http.MethodDelete,
+		Path:     "/users/:userId",
+		Summary:  "Delete a user of your company (admin)",
+		Security: []string{securitySession},
+		Errors:   []int{http.StatusForbidden, http.StatusNotFound},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/users/:userId/unlock",
+		Summary:  "Clear the failed logins of a user (admin)",
+		Security: []string{securitySession},
+		Errors:   []int{http.StatusForbidden, http.StatusNotFound},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/users/:userId/groups",
+		Summary:     "Get the groups of a user of your company",
+		Description: "Returns the groups the user is a member of and the groups they are nested in",
+		Security:    []string{securitySession, securityAPIKey},
+		Data:        map[string]interface{}{"groups": []models.Group{}},
+		Errors:      []int{http.StatusNotFound},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/users/:userId/reports",
+		Summary:     "Get the users that report to a user of your company",
+		Description: "Returns the direct reports of the user, and the users below them down to the depth, closest levels first",
+		Security:    []string{securitySession, securityAPIKey},
+		Query:       []openapi.Parameter{{Name: "depth", Description: "Levels below the user, 1 by default and 20 at most", Schema: &openapi.Schema{Type: "integer"}}},
+		Data:        map[string]interface{}{"users": []models.UserWithCompanyAsObject{}},
+		Errors:      []int{http.StatusBadRequest, http.StatusNotFound},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/users/:userId/chain",
+		Summary:     "Get the managers of a user of your company",
+		Description: "Returns the manager of the user, its manager and so on up to the top",
+		Security:    []string{securitySession, securityAPIKey},
+		Data:        map[string]interface{}{"users": []models.UserWithCompanyAsObject{}},
+		Errors:      []int{http.StatusNotFound},
+	},
 }
@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"time"
+	"user-service/cmd/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The unversioned paths are the routes of /v1 from before the API was versioned.
+// They answer like /v1 with Deprecation and Sunset headers, and are removed at the sunset
+var (
+	legacyDeprecation = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	legacySunset      = time.Date(2027, time.April, 18, 0, 0, 0, 0, time.UTC)
+)
+
+// Versions registers every version of the API under its own prefix, and the deprecated unversioned aliases of /v1.
+// A new version gets its own function like V1, with its own handlers and responses, next to the versions it replaces
+func Versions(router *gin.Engine) {
+	V1(router.Group("/v1"))
+	V1(router.Group("", controllers.Deprecated(legacyDeprecation, legacySunset, "/v1")))
+}
+
+// V1 registers the routes of version 1 of the API
+func V1(router gin.IRouter) {
+	UserRoute(router)
+	AuthRoute(router)
+	CompanyRoute(router)
+	AuditRoute(router)
+}
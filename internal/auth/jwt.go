@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// signingKeyBits is the size of the RSA keys generated when none is configured
+const signingKeyBits = 2048
+
+// ErrInvalidJWT is returned for tokens that are malformed, signed by another key, of another type or expired
+var ErrInvalidJWT = errors.New("invalid or expired token")
+
+// Signer signs JWTs with RS256, the key id lets verifiers pick its public key from the JWKS
+type Signer struct {
+	key   *rsa.PrivateKey
+	KeyId string
+}
+
+// JWK is the public part of an RSA signing key
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is the set of keys tokens can be verified with
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// NewSigner returns a signer for the key, its id is the RFC 7638 thumbprint so it only changes with the key
+func NewSigner(key *rsa.PrivateKey) *Signer {
+	signer := &Signer{key: key}
+	jwk := signer.jwk()
+	thumbprint, _ := json.Marshal(map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N})
+	sum := sha256.Sum256(thumbprint)
+	signer.KeyId = base64.RawURLEncoding.EncodeToString(sum[:])
+	return signer
+}
+
+// GenerateSigningKey returns a new RSA key, tokens signed with it can not be verified once the process stops
+func GenerateSigningKey() (*rsa.PrivateKey, error) {
+	return rsa.GenerateKey(rand.Reader, signingKeyBits)
+}
+
+// ParseSigningKey reads a PEM encoded RSA private key, in PKCS #1 or PKCS #8 form
+func ParseSigningKey(pemKey []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the signing key is not an RSA key")
+	}
+	return key, nil
+}
+
+// Sign returns the claims as a JWT of the type, like "JWT" for ID tokens or "at+jwt" for access tokens
+func (s *Signer) Sign(claims map[string]interface{}, typ string) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "RS256", Typ: typ, Kid: s.KeyId})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verify returns the claims of a token signed by the signer with the type, ErrInvalidJWT when it can not be
+// trusted or its exp claim is in the past
+func (s *Signer) Verify(token, typ string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidJWT
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidJWT
+	}
+	// The algorithm is fixed, so a token can not choose how it is verified
+	if header.Alg != "RS256" || header.Kid != s.KeyId || !strings.EqualFold(header.Typ, typ) {
+		return nil, ErrInvalidJWT
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidJWT
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&s.key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, ErrInvalidJWT
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidJWT
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || time.Now().Unix() >= int64(exp) {
+		return nil, ErrInvalidJWT
+	}
+	return claims, nil
+}
+
+// JWKS returns the public key of the signer
+func (s *Signer) JWKS() JWKS {
+	return JWKS{Keys: []JWK{s.jwk()}}
+}
+
+func (s *Signer) jwk() JWK {
+	return JWK{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: s.KeyId,
+		N:   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+	}
+}
+
+func decodeSegment(segment string, target interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, target)
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSigner(t *testing.T) *Signer {
+	key, err := GenerateSigningKey()
+	assert.NoError(t, err)
+	return NewSigner(key)
+}
+
+func TestSignAndVerify(t *testing.T) {
+	signer := testSigner(t)
+	token, err := signer.Sign(map[string]interface{}{"sub": "user", "exp": time.Now().Add(time.Minute).Unix()}, "at+jwt")
+	assert.NoError(t, err)
+
+	claims, err := signer.Verify(token, "at+jwt")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", claims["sub"])
+
+	// Tokens of another type, of another key, tampered with or expired are rejected
+	_, err = signer.Verify(token, "JWT")
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+	_, err = testSigner(t).Verify(token, "at+jwt")
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","exp":9999999999}`)) + "." + parts[2]
+	_, err = signer.Verify(tampered, "at+jwt")
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"at+jwt","kid":"`+signer.KeyId+`"}`)) + "." + parts[1] + "."
+	_, err = signer.Verify(unsigned, "at+jwt")
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+	expired, _ := signer.Sign(map[string]interface{}{"sub": "user", "exp": time.Now().Add(-time.Second).Unix()}, "at+jwt")
+	_, err = signer.Verify(expired, "at+jwt")
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+}
+
+func TestJWKS(t *testing.T) {
+	key, _ := GenerateSigningKey()
+	signer := NewSigner(key)
+	jwks := signer.JWKS()
+	if assert.Len(t, jwks.Keys, 1) {
+		jwk := jwks.Keys[0]
+		assert.Equal(t, "RSA", jwk.Kty)
+		assert.Equal(t, "RS256", jwk.Alg)
+		assert.Equal(t, signer.KeyId, jwk.Kid)
+		n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+		assert.Equal(t, 0, new(big.Int).SetBytes(n).Cmp(key.N))
+		assert.Equal(t, "AQAB", jwk.E)
+	}
+	// The key id only depends on the key
+	assert.Equal(t, signer.KeyId, NewSigner(key).KeyId)
+}
+
+func TestParseSigningKey(t *testing.T) {
+	key, _ := GenerateSigningKey()
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	pkcs8Bytes, _ := x509.MarshalPKCS8PrivateKey(key)
+	pkcs8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Bytes})
+
+	for _, encoded := range [][]byte{pkcs1, pkcs8} {
+		parsed, err := ParseSigningKey(encoded)
+		if assert.NoError(t, err) {
+			assert.True(t, parsed.Equal(key))
+		}
+	}
+	_, err := ParseSigningKey([]byte("not a key"))
+	assert.Error(t, err)
+}
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	// Example of RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", CodeChallenge(verifier))
+	assert.True(t, VerifyCodeChallenge(verifier, CodeChallenge(verifier)))
+
+	assert.False(t, VerifyCodeChallenge(verifier+"x", CodeChallenge(verifier)))
+	assert.False(t, VerifyCodeChallenge("short", CodeChallenge("short")))
+	invalid := strings.Repeat("a", 42) + "!"
+	assert.False(t, VerifyCodeChallenge(invalid, CodeChallenge(invalid)))
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"time"
+	"user-service/internal/models"
+)
+
+// LockoutPolicy decides how failed logins slow down and lock the next attempts
+type LockoutPolicy struct {
+	// MaxFailures before the key gets locked
+	MaxFailures int
+	// LockoutDuration is how long a locked key stays locked
+	LockoutDuration time.Duration
+	// Window is how long failures are remembered after the last one
+	Window time.Duration
+	// BaseDelay is the wait after the first failure, it doubles with every new failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// AccountLockoutPolicy applies to the failures of a single email
+var AccountLockoutPolicy = LockoutPolicy{
+	MaxFailures:     5,
+	LockoutDuration: 15 * time.Minute,
+	Window:          15 * time.Minute,
+	BaseDelay:       time.Second,
+	MaxDelay:        30 * time.Second,
+}
+
+// IPLockoutPolicy applies to the failures coming from a single IP, whatever the email, to stop password spraying
+var IPLockoutPolicy = LockoutPolicy{
+	MaxFailures:     20,
+	LockoutDuration: 15 * time.Minute,
+	Window:          15 * time.Minute,
+	BaseDelay:       0,
+	MaxDelay:        0,
+}
+
+// RetryAfter returns how long the key has to wait before trying to log in again, zero when it can try now
+func (p LockoutPolicy) RetryAfter(attempts *models.LoginAttempts, now time.Time) time.Duration {
+	if attempts == nil {
+		return 0
+	}
+	if attempts.LockedUntil != nil && attempts.LockedUntil.After(now) {
+		return attempts.LockedUntil.Sub(now)
+	}
+	if wait := attempts.LastFailureAt.Add(p.Delay(attempts.Failures)).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Delay returns the progressive delay that follows the given amount of failures
+func (p LockoutPolicy) Delay(failures int) time.Duration {
+	if failures <= 0 || p.BaseDelay <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return delay
+}
+
+// ShouldLock reports whether the failures reached the limit and the key is not locked yet
+func (p LockoutPolicy) ShouldLock(attempts *models.LoginAttempts, now time.Time) bool {
+	if attempts == nil || p.MaxFailures <= 0 || attempts.Failures < p.MaxFailures {
+		return false
+	}
+	return attempts.LockedUntil == nil || !attempts.LockedUntil.After(now)
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"testing"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockoutPolicyDelay(t *testing.T) {
+	policy := LockoutPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	assert.Equal(t, time.Duration(0), policy.Delay(0))
+	assert.Equal(t, time.Second, policy.Delay(1))
+	assert.Equal(t, 4*time.Second, policy.Delay(3))
+	assert.Equal(t, 10*time.Second, policy.Delay(5))
+}
+
+func TestLockoutPolicyRetryAfter(t *testing.T) {
+	policy := LockoutPolicy{MaxFailures: 3, BaseDelay: time.Second, MaxDelay: time.Minute}
+	now := time.Now()
+
+	assert.Equal(t, time.Duration(0), policy.RetryAfter(nil, now))
+
+	attempts := &models.LoginAttempts{Failures: 2, LastFailureAt: now}
+	assert.Equal(t, 2*time.Second, policy.RetryAfter(attempts, now))
+	assert.Equal(t, time.Duration(0), policy.RetryAfter(attempts, now.Add(3*time.Second)))
+	assert.False(t, policy.ShouldLock(attempts, now))
+
+	attempts.Failures = 3
+	assert.True(t, policy.ShouldLock(attempts, now))
+
+	lockedUntil := now.Add(time.Hour)
+	attempts.LockedUntil = &lockedUntil
+	assert.Equal(t, time.Hour, policy.RetryAfter(attempts, now))
+	assert.False(t, policy.ShouldLock(attempts, now))
+}
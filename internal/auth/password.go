@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// PasswordHasher hashes passwords with one algorithm. Hashes follow the PHC string format,
+// $<id>$..., so the algorithm that made a stored hash can be found from its prefix
+type PasswordHasher interface {
+	// IDs returns the prefixes of the hashes the hasher can verify, the first one is used for new hashes
+	IDs() []string
+	Hash(password string) (string, error)
+	Verify(hash, password string) bool
+	// NeedsRehash reports whether the hash was made with parameters older than the current ones
+	NeedsRehash(hash string) bool
+}
+
+var (
+	hashersMutex  sync.RWMutex
+	hashers       = map[string]PasswordHasher{}
+	defaultHasher PasswordHasher
+)
+
+func init() {
+	RegisterHasher(NewBcryptHasher(BcryptCost))
+	RegisterHasher(NewArgon2idHasher(DefaultArgon2idParams))
+	defaultHasher = hashers[Argon2idID]
+}
+
+// RegisterHasher makes the hasher available to verify hashes with any of its prefixes
+func RegisterHasher(hasher PasswordHasher) {
+	hashersMutex.Lock()
+	defer hashersMutex.Unlock()
+	for _, id := range hasher.IDs() {
+		hashers[id] = hasher
+	}
+}
+
+// SetDefaultHasher selects the registered algorithm used to hash new passwords
+func SetDefaultHasher(id string) error {
+	hashersMutex.Lock()
+	defer hashersMutex.Unlock()
+	hasher, found := hashers[id]
+	if !found {
+		return fmt.Errorf("unknown password hasher %q", id)
+	}
+	defaultHasher = hasher
+	return nil
+}
+
+// DefaultHasher returns the hasher used for new passwords
+func DefaultHasher() PasswordHasher {
+	hashersMutex.RLock()
+	defer hashersMutex.RUnlock()
+	return defaultHasher
+}
+
+// hasherFor returns the hasher of a stored hash, nil when the format is unknown, for example a plain text password
+func hasherFor(hash string) PasswordHasher {
+	if !strings.HasPrefix(hash, "$") {
+		return nil
+	}
+	id, _, _ := strings.Cut(hash[1:], "$")
+	hashersMutex.RLock()
+	defer hashersMutex.RUnlock()
+	return hashers[id]
+}
+
+// HashPassword hashes a plain text password with the default hasher
+func HashPassword(password string) (string, error) {
+	return DefaultHasher().Hash(password)
+}
+
+// CheckPassword reports whether password matches the stored hash, whichever algorithm made it
+func CheckPassword(hash, password string) bool {
+	hasher := hasherFor(hash)
+	if hasher == nil {
+		return false
+	}
+	return hasher.Verify(hash, password)
+}
+
+// NeedsRehash reports whether a stored hash should be replaced after a successful login,
+// because it was made by another algorithm than the default one or with outdated parameters
+func NeedsRehash(hash string) bool {
+	hasher := hasherFor(hash)
+	current := DefaultHasher()
+	if hasher != current {
+		return true
+	}
+	return current.NeedsRehash(hash)
+}
+
+// IsPasswordHash reports whether the value was made by one of the registered hashers
+func IsPasswordHash(value string) bool {
+	return hasherFor(value) != nil
+}
@@ -0,0 +1,37 @@
+package auth
+
+import "golang.org/x/crypto/bcrypt"
+
+// BcryptCost is the cost of new bcrypt hashes
+const BcryptCost = bcrypt.DefaultCost
+
+// BcryptHasher hashes passwords with bcrypt, its hashes already start with $2a$, $2b$ or $2y$
+type BcryptHasher struct {
+	cost int
+}
+
+// NewBcryptHasher creates a bcrypt hasher with the given cost
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{cost: cost}
+}
+
+func (h *BcryptHasher) IDs() []string {
+	return []string{"2a", "2b", "2y"}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.cost
+}
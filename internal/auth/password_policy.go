@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"unicode"
+	"user-service/internal/models"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// PasswordTag is the validator tag that checks a password against the policy found on the context
+const PasswordTag = "password"
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+var commonPasswords = map[string]struct{}{}
+
+func init() {
+	addPasswords(bufio.NewScanner(strings.NewReader(commonPasswordsFile)))
+}
+
+func addPasswords(scanner *bufio.Scanner) {
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			commonPasswords[strings.ToLower(password)] = struct{}{}
+		}
+	}
+}
+
+// LoadPasswordList adds the passwords of a file, one per line, to the common passwords list.
+// It is meant for bigger breached passwords lists that are not worth embedding.
+func LoadPasswordList(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	addPasswords(scanner)
+	return scanner.Err()
+}
+
+// DefaultPasswordPolicy is used when a company does not override it
+var DefaultPasswordPolicy = models.PasswordPolicy{
+	MinLength:          10,
+	MaxLength:          64,
+	RequireLower:       true,
+	RequireUpper:       true,
+	RequireDigit:       true,
+	RejectCommon:       true,
+	RejectPersonalInfo: true,
+}
+
+// ApplyOverride returns the policy with the rules set on the override replaced
+func ApplyOverride(policy models.PasswordPolicy, override *models.PasswordPolicyOverride) models.PasswordPolicy {
+	if override == nil {
+		return policy
+	}
+	if override.MinLength != nil {
+		policy.MinLength = *override.MinLength
+	}
+	if override.MaxLength != nil {
+		policy.MaxLength = *override.MaxLength
+	}
+	if override.RequireLower != nil {
+		policy.RequireLower = *override.RequireLower
+	}
+	if override.RequireUpper != nil {
+		policy.RequireUpper = *override.RequireUpper
+	}
+	if override.RequireDigit != nil {
+		policy.RequireDigit = *override.RequireDigit
+	}
+	if override.RequireSymbol != nil {
+		policy.RequireSymbol = *override.RequireSymbol
+	}
+	if override.MinCharacterClasses != nil {
+		policy.MinCharacterClasses = *override.MinCharacterClasses
+	}
+	if override.RejectCommon != nil {
+		policy.RejectCommon = *override.RejectCommon
+	}
+	if override.RejectPersonalInfo != nil {
+		policy.RejectPersonalInfo = *override.RejectPersonalInfo
+	}
+	return policy
+}
+
+// CheckPasswordPolicy returns the reasons why the password does not follow the policy.
+// personalInfo are values like the user email or name that the password must not contain.
+func CheckPasswordPolicy(policy models.PasswordPolicy, password string, personalInfo ...string) []string {
+	var reasons []string
+	length := len([]rune(password))
+	if policy.MinLength > 0 && length < policy.MinLength {
+		reasons = append(reasons, fmt.Sprintf("must be at least %d characters long", policy.MinLength))
+	}
+	if policy.MaxLength > 0 && length > policy.MaxLength {
+		reasons = append(reasons, fmt.Sprintf("must be at most %d characters long", policy.MaxLength))
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	if policy.RequireLower && !lower {
+		reasons = append(reasons, "must contain a lowercase letter")
+	}
+	if policy.RequireUpper && !upper {
+		reasons = append(reasons, "must contain an uppercase letter")
+	}
+	if policy.RequireDigit && !digit {
+		reasons = append(reasons, "must contain a digit")
+	}
+	if policy.RequireSymbol && !symbol {
+		reasons = append(reasons, "must contain a symbol")
+	}
+	if classes := countTrue(lower, upper, digit, symbol); classes < policy.MinCharacterClasses {
+		reasons = append(reasons, fmt.Sprintf("must contain at least %d of lowercase letters, uppercase letters, digits and symbols", policy.MinCharacterClasses))
+	}
+
+	lowered := strings.ToLower(password)
+	if policy.RejectCommon {
+		if _, found := commonPasswords[lowered]; found {
+			reasons = append(reasons, "is too common")
+		}
+	}
+	if policy.RejectPersonalInfo {
+		for _, info := range personalInfo {
+			if containsPersonalInfo(lowered, info) {
+				reasons = append(reasons, "must not contain your email or name")
+				break
+			}
+		}
+	}
+	return reasons
+}
+
+func countTrue(values ...bool) int {
+	count := 0
+	for _, value := range values {
+		if value {
+			count++
+		}
+	}
+	return count
+}
+
+// containsPersonalInfo checks the whole value and its parts, so "john.doe@example.com" rejects "john" and "doe"
+func containsPersonalInfo(password, info string) bool {
+	info = strings.ToLower(strings.TrimSpace(info))
+	if info == "" {
+		return false
+	}
+	if local, _, found := strings.Cut(info, "@"); found {
+		info = local
+	}
+	parts := strings.FieldsFunc(info, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, part := range append(parts, info) {
+		// Very short parts like initials would reject too many passwords
+		if len([]rune(part)) >= 3 && strings.Contains(password, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// PasswordPolicyError wraps a validation error adding the reasons why the password was rejected
+type PasswordPolicyError struct {
+	Reasons []string
+	Err     error
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PasswordPolicyError) Unwrap() error {
+	return e.Err
+}
+
+type passwordPolicyKey struct{}
+
+type passwordPolicyContext struct {
+	policy       models.PasswordPolicy
+	personalInfo []string
+}
+
+// WithPasswordPolicy returns a context the password validator reads the policy and extra personal info from
+func WithPasswordPolicy(ctx context.Context, policy models.PasswordPolicy, personalInfo ...string) context.Context {
+	return context.WithValue(ctx, passwordPolicyKey{}, passwordPolicyContext{policy: policy, personalInfo: personalInfo})
+}
+
+// PasswordPolicyFromContext returns the policy and personal info stored on the context, or the default policy
+func PasswordPolicyFromContext(ctx context.Context) (models.PasswordPolicy, []string) {
+	if value, ok := ctx.Value(passwordPolicyKey{}).(passwordPolicyContext); ok {
+		return value.policy, value.personalInfo
+	}
+	return DefaultPasswordPolicy, nil
+}
+
+// RegisterPasswordValidation registers the password tag on a validator instance. The Email and Name fields
+// of the validated struct, when present, are also considered personal info.
+func RegisterPasswordValidation(validate *validator.Validate) error {
+	return validate.RegisterValidationCtx(PasswordTag, func(ctx context.Context, fl validator.FieldLevel) bool {
+		policy, personalInfo := PasswordPolicyFromContext(ctx)
+		info := append(append([]string{}, personalInfo...), structPersonalInfo(fl)...)
+		return len(CheckPasswordPolicy(policy, fl.Field().String(), info...)) == 0
+	})
+}
+
+// PasswordPolicyReasons checks the password against the policy on the context the same way the validator does
+func PasswordPolicyReasons(ctx context.Context, password string, personalInfo ...string) []string {
+	policy, contextInfo := PasswordPolicyFromContext(ctx)
+	info := append(append([]string{}, contextInfo...), personalInfo...)
+	return CheckPasswordPolicy(policy, password, info...)
+}
+
+func structPersonalInfo(fl validator.FieldLevel) []string {
+	var info []string
+	parent := fl.Parent()
+	if parent.Kind() == reflect.Ptr {
+		parent = parent.Elem()
+	}
+	if parent.Kind() != reflect.Struct {
+		return nil
+	}
+	for _, name := range []string{"Email", "Name"} {
+		if field := parent.FieldByName(name); field.IsValid() && field.Kind() == reflect.String {
+			info = append(info, field.String())
+		}
+	}
+	return info
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"testing"
+	"user-service/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	policy := models.PasswordPolicy{
+		MinLength:           8,
+		MaxLength:           20,
+		MinCharacterClasses: 3,
+		RejectCommon:        true,
+		RejectPersonalInfo:  true,
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		reasons  []string
+	}{
+		{"valid", "Blue-Horse-42", nil},
+		{"too short", "Ab1!", []string{"must be at least 8 characters long"}},
+		{"too long", "Abcdefghijklmnopqrst1!", []string{"must be at most 20 characters long"}},
+		{"not enough classes", "abcdefghij", []string{"must contain at least 3 of lowercase letters, uppercase letters, digits and symbols"}},
+		{"common", "Password123", []string{"is too common"}},
+		{"email", "Jdoe-2024!x", []string{"must not contain your email or name"}},
+		{"name", "Smith-2024!x", []string{"must not contain your email or name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.reasons, CheckPasswordPolicy(policy, tt.password, "jdoe@example.com", "Anna Smith"))
+		})
+	}
+}
+
+func TestApplyOverride(t *testing.T) {
+	minLength := 14
+	requireSymbol := true
+	policy := ApplyOverride(DefaultPasswordPolicy, &models.PasswordPolicyOverride{MinLength: &minLength, RequireSymbol: &requireSymbol})
+
+	assert.Equal(t, 14, policy.MinLength)
+	assert.True(t, policy.RequireSymbol)
+	assert.Equal(t, DefaultPasswordPolicy.MaxLength, policy.MaxLength)
+	assert.Equal(t, DefaultPasswordPolicy, ApplyOverride(DefaultPasswordPolicy, nil))
+}
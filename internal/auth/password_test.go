@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPasswordUsesPHCFormat(t *testing.T) {
+	hash, err := HashPassword("Tr0ub4dor&3x")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=2$"))
+
+	assert.True(t, CheckPassword(hash, "Tr0ub4dor&3x"))
+	assert.False(t, CheckPassword(hash, "Tr0ub4dor&3y"))
+	assert.False(t, NeedsRehash(hash))
+}
+
+func TestCheckPasswordPicksAlgorithmFromPrefix(t *testing.T) {
+	bcryptHash, err := NewBcryptHasher(bcrypt.MinCost).Hash("Tr0ub4dor&3x")
+	assert.NoError(t, err)
+	assert.True(t, CheckPassword(bcryptHash, "Tr0ub4dor&3x"))
+	assert.False(t, CheckPassword(bcryptHash, "wrong"))
+
+	// Plain text and unknown formats never match, not even themselves
+	assert.False(t, CheckPassword("Tr0ub4dor&3x", "Tr0ub4dor&3x"))
+	assert.False(t, CheckPassword("$scrypt$abc", "abc"))
+	assert.False(t, IsPasswordHash("Tr0ub4dor&3x"))
+	assert.True(t, IsPasswordHash(bcryptHash))
+}
+
+func TestNeedsRehash(t *testing.T) {
+	// Another algorithm than the default one
+	bcryptHash, _ := NewBcryptHasher(BcryptCost).Hash("Tr0ub4dor&3x")
+	assert.True(t, NeedsRehash(bcryptHash))
+
+	// Outdated parameters
+	weakParams := DefaultArgon2idParams
+	weakParams.Iterations = 1
+	weakHash, _ := NewArgon2idHasher(weakParams).Hash("Tr0ub4dor&3x")
+	assert.True(t, CheckPassword(weakHash, "Tr0ub4dor&3x"))
+	assert.True(t, NeedsRehash(weakHash))
+
+	// A lower bcrypt cost when bcrypt is the default
+	assert.NoError(t, SetDefaultHasher("2a"))
+	defer SetDefaultHasher(Argon2idID)
+	assert.False(t, NeedsRehash(bcryptHash))
+	cheapHash, _ := NewBcryptHasher(bcrypt.MinCost).Hash("Tr0ub4dor&3x")
+	assert.True(t, NeedsRehash(cheapHash))
+}
+
+func TestSetDefaultHasherUnknown(t *testing.T) {
+	assert.Error(t, SetDefaultHasher("md5"))
+	assert.Equal(t, DefaultHasher(), hasherFor("$argon2id$"))
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// Test vectors of RFC 6238 truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range tests {
+		code, err := TOTPCode(secret, time.Unix(unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, code)
+	}
+}
+
+func TestValidateTOTPAllowsClockDrift(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+	now := time.Now()
+
+	code, _ := TOTPCode(secret, now.Add(-totpPeriod))
+	assert.True(t, ValidateTOTP(secret, code, now))
+
+	code, _ = TOTPCode(secret, now.Add(-3*totpPeriod))
+	assert.False(t, ValidateTOTP(secret, code, now))
+	assert.False(t, ValidateTOTP(secret, "12345", now))
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	ciphertext, err := Encrypt(key, "secret")
+	assert.NoError(t, err)
+	assert.NotContains(t, ciphertext, "secret")
+
+	plaintext, err := Decrypt(key, ciphertext)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", plaintext)
+
+	_, err = Encrypt(nil, "secret")
+	assert.ErrorIs(t, err, ErrMissingEncryptionKey)
+}
+
+func TestMatchTOTPReturnsTheStepOfTheCode(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+
+	code, _ := TOTPCode(secret, now.Add(-totpPeriod))
+	step, valid := MatchTOTP(secret, code, now)
+	assert.True(t, valid)
+	assert.Equal(t, now.Unix()/int64(totpPeriod.Seconds())-1, step)
+
+	_, valid = MatchTOTP(secret, "000000x", now)
+	assert.False(t, valid)
+}
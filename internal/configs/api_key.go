@@ -0,0 +1,117 @@
+package configs
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// APIKeyStore interface
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key models.APIKey) (primitive.ObjectID, error)
+	FindAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error)
+	FindCompanyAPIKeys(ctx context.Context, companyId primitive.ObjectID) ([]*models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, companyId, id primitive.ObjectID) error
+	RotateAPIKey(ctx context.Context, companyId, id primitive.ObjectID, keyHash, hint string) (*models.APIKey, error)
+	TouchAPIKey(ctx context.Context, id primitive.ObjectID) error
+}
+
+// MongoAPIKeyStore implements the APIKeyStore interface
+type MongoAPIKeyStore struct {
+	apiKeyCollection *mongo.Collection
+}
+
+// NewMongoAPIKeyStore creates a new MongoAPIKeyStore instance
+func NewMongoAPIKeyStore(client *mongo.Client) *MongoAPIKeyStore {
+	store := &MongoAPIKeyStore{
+		apiKeyCollection: GetCollection(client, "api_keys"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := store.apiKeyCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "keyHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "company", Value: 1}}},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating api key indexes")
+	}
+	return store
+}
+
+// CreateAPIKey stores a new API key
+func (s *MongoAPIKeyStore) CreateAPIKey(ctx context.Context, key models.APIKey) (primitive.ObjectID, error) {
+	result, err := s.apiKeyCollection.InsertOne(ctx, key)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+// FindAPIKey returns the key matching the hash, nil when it does not exist or was revoked
+func (s *MongoAPIKeyStore) FindAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	var key models.APIKey
+	filter := bson.M{"keyHash": keyHash, "revokedAt": bson.M{"$exists": false}}
+	err := s.apiKeyCollection.FindOne(ctx, filter).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// FindCompanyAPIKeys returns every key of a company, revoked ones included
+func (s *MongoAPIKeyStore) FindCompanyAPIKeys(ctx context.Context, companyId primitive.ObjectID) ([]*models.APIKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := s.apiKeyCollection.Find(ctx, bson.M{"company": companyId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []*models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey disables a key of the company, it returns mongo.ErrNoDocuments when there is no active key with that id
+func (s *MongoAPIKeyStore) RevokeAPIKey(ctx context.Context, companyId, id primitive.ObjectID) error {
+	filter := bson.M{"_id": id, "company": companyId, "revokedAt": bson.M{"$exists": false}}
+	result, err := s.apiKeyCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revokedAt": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// RotateAPIKey replaces the secret of an active key of the company, the old one stops working right away
+func (s *MongoAPIKeyStore) RotateAPIKey(ctx context.Context, companyId, id primitive.ObjectID, keyHash, hint string) (*models.APIKey, error) {
+	filter := bson.M{"_id": id, "company": companyId, "revokedAt": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"keyHash": keyHash, "hint": hint, "rotatedAt": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var key models.APIKey
+	if err := s.apiKeyCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// TouchAPIKey records when the key was last used
+func (s *MongoAPIKeyStore) TouchAPIKey(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.apiKeyCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"lastUsedAt": time.Now()}})
+	return err
+}
@@ -0,0 +1,165 @@
+package configs
+
+import (
+	"context"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditLog interface
+type AuditLog interface {
+	RecordEvent(ctx context.Context, event models.AuditEvent) error
+	FindEvents(ctx context.Context, filter models.AuditFilter, page, limit int) ([]*models.AuditEvent, int64, error)
+	StreamEvents(ctx context.Context, filter models.AuditFilter, fn func(event *models.AuditEvent) error) error
+}
+
+// AuditInfo is who makes a request, it travels on the context so every audit event can record it
+type AuditInfo struct {
+	Actor     *primitive.ObjectID
+	APIKey    *primitive.ObjectID
+	RequestId string
+	IP        string
+}
+
+type auditInfoKey struct{}
+
+// WithAuditInfo returns a context carrying who makes the request
+func WithAuditInfo(ctx context.Context, info AuditInfo) context.Context {
+	return context.WithValue(ctx, auditInfoKey{}, info)
+}
+
+// AuditInfoFromContext returns who makes the request, empty when it is not known
+func AuditInfoFromContext(ctx context.Context) AuditInfo {
+	info, _ := ctx.Value(auditInfoKey{}).(AuditInfo)
+	return info
+}
+
+// WithRequestInfo fills the fields of the event that were not set with the ones of the context
+func WithRequestInfo(ctx context.Context, event models.AuditEvent) models.AuditEvent {
+	info := AuditInfoFromContext(ctx)
+	if event.Actor == nil {
+		event.Actor = info.Actor
+	}
+	if event.APIKey == nil {
+		event.APIKey = info.APIKey
+	}
+	if event.RequestId == "" {
+		event.RequestId = info.RequestId
+	}
+	if event.IP == "" {
+		event.IP = info.IP
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	return event
+}
+
+// MongoAuditLog implements the AuditLog interface, events are only ever inserted
+type MongoAuditLog struct {
+	eventCollection *mongo.Collection
+}
+
+// NewMongoAuditLog creates a new MongoAuditLog instance
+func NewMongoAuditLog(client *mongo.Client) *MongoAuditLog {
+	audit := &MongoAuditLog{
+		eventCollection: GetCollection(client, "audit_events"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := audit.eventCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "company", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "target", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "createdAt", Value: -1}}},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating audit event indexes")
+	}
+	return audit
+}
+
+// RecordEvent appends an event to the audit log
+func (a *MongoAuditLog) RecordEvent(ctx context.Context, event models.AuditEvent) error {
+	_, err := a.eventCollection.InsertOne(ctx, event)
+	return err
+}
+
+// FindEvents returns a page of the events matching the filter, newest first, and how many match in total
+func (a *MongoAuditLog) FindEvents(ctx context.Context, filter models.AuditFilter, page, limit int) ([]*models.AuditEvent, int64, error) {
+	query := auditQuery(filter)
+	total, err := a.eventCollection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := a.eventCollection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []*models.AuditEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// StreamEvents calls fn with each event matching the filter as they are read from the cursor, newest first
+func (a *MongoAuditLog) StreamEvents(ctx context.Context, filter models.AuditFilter, fn func(event *models.AuditEvent) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := a.eventCollection.Find(ctx, auditQuery(filter), opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var event models.AuditEvent
+		if err := cursor.Decode(&event); err != nil {
+			return err
+		}
+		if err := fn(&event); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func auditQuery(filter models.AuditFilter) bson.M {
+	query := bson.M{}
+	if filter.Company != nil {
+		query["company"] = *filter.Company
+	}
+	if filter.Actor != nil {
+		query["actor"] = *filter.Actor
+	}
+	if filter.Target != nil {
+		query["target"] = *filter.Target
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	createdAt := bson.M{}
+	if filter.From != nil {
+		createdAt["$gte"] = *filter.From
+	}
+	if filter.To != nil {
+		createdAt["$lte"] = *filter.To
+	}
+	if len(createdAt) > 0 {
+		query["createdAt"] = createdAt
+	}
+	return query
+}
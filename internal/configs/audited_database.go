@@ -0,0 +1,310 @@
+package configs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"user-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// auditRedactedFields are never written on the changes of an event, only that they changed
+var auditRedactedFields = map[string]bool{
+	"password":      true,
+	"secret":        true,
+	"pendingSecret": true,
+	"recoveryCodes": true,
+	"tokenHash":     true,
+	"keyHash":       true,
+}
+
+// auditIgnoredFields change on every write, so they are left out of the changes
+var auditIgnoredFields = []string{"version"}
+
+// AuditedDatabase decorates a Database recording an audit event for every change to a user, with who made it
+// taken from the context. When the Database is Transactional the event is written in the transaction of the change,
+// which needs the AuditLog on the same client, so a change is never stored without its event. Every method is
+// written out instead of embedding the Database, so a new method on the interface does not compile until it is
+// decided how it is audited
+type AuditedDatabase struct {
+	db    Database
+	audit AuditLog
+}
+
+// NewAuditedDatabase creates a new AuditedDatabase instance
+func NewAuditedDatabase(db Database, audit AuditLog) *AuditedDatabase {
+	return &AuditedDatabase{db: db, audit: audit}
+}
+
+func (a *AuditedDatabase) CreateUser(ctx context.Context, user models.UserWithCompanyAsObject) (primitive.ObjectID, error) {
+	return a.createUser(ctx, user, nil)
+}
+
+// CreateUsers creates every user in a transaction of its own with its event, so a user that can not be stored
+// does not abort the others. The ids follow the order of users, with primitive.NilObjectID for the ones that failed
+func (a *AuditedDatabase) CreateUsers(ctx context.Context, users []models.UserWithCompanyAsObject) ([]primitive.ObjectID, error) {
+	ids := make([]primitive.ObjectID, len(users))
+	var createErr error
+	for i, user := range users {
+		id, err := a.createUser(ctx, user, map[string]interface{}{"bulk": true})
+		if err != nil {
+			createErr = err
+			continue
+		}
+		ids[i] = id
+	}
+	return ids, createErr
+}
+
+func (a *AuditedDatabase) createUser(ctx context.Context, user models.UserWithCompanyAsObject, metadata map[string]interface{}) (primitive.ObjectID, error) {
+	var id primitive.ObjectID
+	err := a.transaction(ctx, func(ctx context.Context) error {
+		var err error
+		if id, err = a.db.CreateUser(ctx, user); err != nil {
+			return err
+		}
+		created := user
+		created.Id = id
+		return a.record(ctx, models.AuditActionUserCreated, &created, AuditChanges(nil, &created), metadata)
+	})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return id, nil
+}
+
+func (a *AuditedDatabase) FindUserByID(ctx context.Context, id primitive.ObjectID) (*models.UserWithCompanyAsObject, error) {
+	return a.db.FindUserByID(ctx, id)
+}
+
+func (a *AuditedDatabase) FindUserByEmail(ctx context.Context, companyId primitive.ObjectID, email string) (*models.UserWithCompanyAsObject, error) {
+	return a.db.FindUserByEmail(ctx, companyId, email)
+}
+
+func (a *AuditedDatabase) FindUsersByEmail(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+	return a.db.FindUsersByEmail(ctx, email)
+}
+
+func (a *AuditedDatabase) FindCredentialsByEmail(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+	return a.db.FindCredentialsByEmail(ctx, email)
+}
+
+func (a *AuditedDatabase) FindAllUsers(ctx context.Context, companyId primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error) {
+	return a.db.FindAllUsers(ctx, companyId)
+}
+
+func (a *AuditedDatabase) FindUsers(ctx context.Context, companyId primitive.ObjectID, filter models.UserFilter) ([]*models.UserWithCompanyAsObject, error) {
+	return a.db.FindUsers(ctx, companyId, filter)
+}
+
+func (a *AuditedDatabase) StreamUsers(ctx context.Context, companyId primitive.ObjectID, fn func(user *models.UserWithCompanyAsObject) error) error {
+	return a.db.StreamUsers(ctx, companyId, fn)
+}
+
+func (a *AuditedDatabase) FindReports(ctx context.Context, companyId, managerId primitive.ObjectID, depth int) ([]*models.UserWithCompanyAsObject, error) {
+	return a.db.FindReports(ctx, companyId, managerId, depth)
+}
+
+func (a *AuditedDatabase) FindManagerChain(ctx context.Context, companyId, id primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error) {
+	return a.db.FindManagerChain(ctx, companyId, id)
+}
+
+func (a *AuditedDatabase) UpdateUser(ctx context.Context, id primitive.ObjectID, version int64, update models.UserUpdate) (*models.UserWithCompanyAsObject, error) {
+	var after *models.UserWithCompanyAsObject
+	err := a.transaction(ctx, func(ctx context.Context) error {
+		before, err := a.db.FindUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if after, err = a.db.UpdateUser(ctx, id, version, update); err != nil {
+			return err
+		}
+
+		changes := AuditChanges(before, after)
+		if len(changes) == 0 {
+			return nil
+		}
+		action := models.AuditActionUserUpdated
+		if _, found := changes["role"]; found {
+			action = models.AuditActionUserRoleChanged
+		}
+		return a.record(ctx, action, after, changes, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return after, nil
+}
+
+func (a *AuditedDatabase) DeleteUser(ctx context.Context, id primitive.ObjectID) error {
+	return a.transaction(ctx, func(ctx context.Context) error {
+		before, err := a.db.FindUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := a.db.DeleteUser(ctx, id); err != nil {
+			return err
+		}
+		return a.record(ctx, models.AuditActionUserDeleted, before, AuditChanges(before, nil), nil)
+	})
+}
+
+func (a *AuditedDatabase) UpdateUserPassword(ctx context.Context, id primitive.ObjectID, passwordHash string) error {
+	return a.transaction(ctx, func(ctx context.Context) error {
+		before, err := a.db.FindUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := a.db.UpdateUserPassword(ctx, id, passwordHash); err != nil {
+			return err
+		}
+		changes := map[string]models.AuditChange{"password": {Before: models.AuditRedacted, After: models.AuditRedacted}}
+		return a.record(ctx, models.AuditActionPasswordChanged, before, changes, nil)
+	})
+}
+
+func (a *AuditedDatabase) UpdateUserPasswordHash(ctx context.Context, id primitive.ObjectID, oldHash, newHash string) error {
+	return a.transaction(ctx, func(ctx context.Context) error {
+		before, err := a.db.FindUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := a.db.UpdateUserPasswordHash(ctx, id, oldHash, newHash); err != nil {
+			return err
+		}
+		return a.record(ctx, models.AuditActionPasswordRehashed, before, nil, nil)
+	})
+}
+
+func (a *AuditedDatabase) UpdateUserMFA(ctx context.Context, id primitive.ObjectID, mfa *models.MFA) error {
+	return a.transaction(ctx, func(ctx context.Context) error {
+		before, err := a.db.FindUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := a.db.UpdateUserMFA(ctx, id, mfa); err != nil {
+			return err
+		}
+
+		after := *before
+		after.MFA = mfa
+		action := models.AuditActionMFAUpdated
+		if !before.MFAEnabled() && after.MFAEnabled() {
+			action = models.AuditActionMFAEnabled
+		} else if before.MFAEnabled() && !after.MFAEnabled() {
+			action = models.AuditActionMFADisabled
+		}
+		return a.record(ctx, action, before, AuditChanges(before, &after), nil)
+	})
+}
+
+func (a *AuditedDatabase) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, codeHash string) (bool, error) {
+	var used bool
+	err := a.transaction(ctx, func(ctx context.Context) error {
+		var err error
+		if used, err = a.db.UseRecoveryCode(ctx, id, codeHash); err != nil || !used {
+			return err
+		}
+		user, err := a.db.FindUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		return a.record(ctx, models.AuditActionRecoveryCodeUsed, user, nil, nil)
+	})
+	if err != nil {
+		return false, err
+	}
+	return used, nil
+}
+
+// UseTOTPStep is not audited, the login or the MFA change the code is used for is
+func (a *AuditedDatabase) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	return a.db.UseTOTPStep(ctx, id, step)
+}
+
+// transaction runs fn in a transaction of the database when it has them, so a change is stored if and only if
+// its event is. Otherwise fn runs as it is and the change is still answered as failed when its event is not stored
+func (a *AuditedDatabase) transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if db, ok := a.db.(Transactional); ok {
+		return db.WithTransaction(ctx, fn)
+	}
+	return fn(ctx)
+}
+
+// record appends an event about the user, it has to be called in the transaction of the change
+func (a *AuditedDatabase) record(ctx context.Context, action string, user *models.UserWithCompanyAsObject, changes map[string]models.AuditChange, metadata map[string]interface{}) error {
+	event := models.AuditEvent{Action: action, Changes: changes, Metadata: metadata}
+	if user != nil {
+		event.Target = &user.Id
+		event.Company = &user.Company
+	}
+	if err := a.audit.RecordEvent(ctx, WithRequestInfo(ctx, event)); err != nil {
+		return fmt.Errorf("recording audit event %s: %w", action, err)
+	}
+	return nil
+}
+
+// AuditChanges returns the fields that are different between two versions of a document, keyed by their
+// dotted path, with nil for a missing version. Secrets only show that they changed
+func AuditChanges(before, after interface{}) map[string]models.AuditChange {
+	beforeFields, afterFields := flattenAuditFields(before), flattenAuditFields(after)
+	changes := map[string]models.AuditChange{}
+	for field, value := range beforeFields {
+		if other, found := afterFields[field]; !found || !reflect.DeepEqual(value, other) {
+			changes[field] = models.AuditChange{Before: value, After: other}
+		}
+	}
+	for field, value := range afterFields {
+		if _, found := beforeFields[field]; !found {
+			changes[field] = models.AuditChange{After: value}
+		}
+	}
+	for _, field := range auditIgnoredFields {
+		delete(changes, field)
+	}
+	for field, change := range changes {
+		path := strings.Split(field, ".")
+		if auditRedactedFields[path[len(path)-1]] {
+			if change.Before != nil {
+				change.Before = models.AuditRedacted
+			}
+			if change.After != nil {
+				change.After = models.AuditRedacted
+			}
+			changes[field] = change
+		}
+	}
+	return changes
+}
+
+// flattenAuditFields returns the JSON fields of a document with nested objects flattened
+func flattenAuditFields(document interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if document == nil || reflect.ValueOf(document).Kind() == reflect.Ptr && reflect.ValueOf(document).IsNil() {
+		return fields
+	}
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return fields
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return fields
+	}
+
+	var flatten func(prefix string, values map[string]interface{})
+	flatten = func(prefix string, values map[string]interface{}) {
+		for key, value := range values {
+			if nested, ok := value.(map[string]interface{}); ok {
+				flatten(prefix+key+".", nested)
+				continue
+			}
+			fields[prefix+key] = value
+		}
+	}
+	flatten("", decoded)
+	return fields
+}
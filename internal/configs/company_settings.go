@@ -0,0 +1,58 @@
+package configs
+
+import (
+	"context"
+	"errors"
+	"user-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CompanySettingsStore interface
+type CompanySettingsStore interface {
+	FindCompanySettings(ctx context.Context, companyId primitive.ObjectID) (*models.CompanySettings, error)
+	UpdateRequireMFA(ctx context.Context, companyId primitive.ObjectID, required bool) error
+	UpdateAttributeSchema(ctx context.Context, companyId primitive.ObjectID, attributes []models.AttributeDefinition) error
+}
+
+// MongoCompanySettingsStore implements the CompanySettingsStore interface
+type MongoCompanySettingsStore struct {
+	settingsCollection *mongo.Collection
+}
+
+// NewMongoCompanySettingsStore creates a new MongoCompanySettingsStore instance
+func NewMongoCompanySettingsStore(client *mongo.Client) *MongoCompanySettingsStore {
+	return &MongoCompanySettingsStore{
+		settingsCollection: GetCollection(client, "company_settings"),
+	}
+}
+
+// FindCompanySettings returns nil when the company did not override anything
+func (s *MongoCompanySettingsStore) FindCompanySettings(ctx context.Context, companyId primitive.ObjectID) (*models.CompanySettings, error) {
+	var settings models.CompanySettings
+	err := s.settingsCollection.FindOne(ctx, bson.M{"_id": companyId}).Decode(&settings)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdateRequireMFA sets whether every user of the company has to use MFA
+func (s *MongoCompanySettingsStore) UpdateRequireMFA(ctx context.Context, companyId primitive.ObjectID, required bool) error {
+	update := bson.M{"$set": bson.M{"requireMfa": required}}
+	_, err := s.settingsCollection.UpdateOne(ctx, bson.M{"_id": companyId}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// UpdateAttributeSchema replaces the custom attributes the users of the company can have
+func (s *MongoCompanySettingsStore) UpdateAttributeSchema(ctx context.Context, companyId primitive.ObjectID, attributes []models.AttributeDefinition) error {
+	update := bson.M{"$set": bson.M{"attributeSchema": attributes}}
+	_, err := s.settingsCollection.UpdateOne(ctx, bson.M{"_id": companyId}, update, options.Update().SetUpsert(true))
+	return err
+}
@@ -2,82 +2,729 @@ package configs
 
 import (
 	"context"
+	"errors"
+	"time"
 	"user-service/internal/models"
 
+	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Database interface
 type Database interface {
 	CreateUser(ctx context.Context, user models.UserWithCompanyAsObject) (primitive.ObjectID, error)
+	CreateUsers(ctx context.Context, users []models.UserWithCompanyAsObject) ([]primitive.ObjectID, error)
 	FindUserByID(ctx context.Context, id primitive.ObjectID) (*models.UserWithCompanyAsObject, error)
-	FindUserByEmail(ctx context.Context, email string) (*models.UserWithCompanyAsObject, error)
+	// FindUserByEmail returns the user of the email in the company, mongo.ErrNoDocuments when it is not a member
+	FindUserByEmail(ctx context.Context, companyId primitive.ObjectID, email string) (*models.UserWithCompanyAsObject, error)
+	// FindUsersByEmail returns the users of the identity of the email, one for each company it belongs to
+	FindUsersByEmail(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error)
+	// FindCredentialsByEmail returns the users of the email like FindUsersByEmail with the password hash of the
+	// identity, which no other read returns. It is only meant for checking a password
+	FindCredentialsByEmail(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error)
 	FindAllUsers(ctx context.Context, companyId primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error)
+	// FindUsers returns the users of the company that match the filter
+	FindUsers(ctx context.Context, companyId primitive.ObjectID, filter models.UserFilter) ([]*models.UserWithCompanyAsObject, error)
+	StreamUsers(ctx context.Context, companyId primitive.ObjectID, fn func(user *models.UserWithCompanyAsObject) error) error
+	// FindReports returns the users of the company that report to the manager up to depth levels below it,
+	// 1 being its direct reports, closest levels first
+	FindReports(ctx context.Context, companyId, managerId primitive.ObjectID, depth int) ([]*models.UserWithCompanyAsObject, error)
+	// FindManagerChain returns the managers of a user of the company from its own manager up to the top
+	FindManagerChain(ctx context.Context, companyId, id primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error)
+	// UpdateUser only updates the user while it is still at the given version, ErrVersionConflict otherwise
+	UpdateUser(ctx context.Context, id primitive.ObjectID, version int64, update models.UserUpdate) (*models.UserWithCompanyAsObject, error)
+	DeleteUser(ctx context.Context, id primitive.ObjectID) error
+	UpdateUserPassword(ctx context.Context, id primitive.ObjectID, passwordHash string) error
+	UpdateUserPasswordHash(ctx context.Context, id primitive.ObjectID, oldHash, newHash string) error
+	UpdateUserMFA(ctx context.Context, id primitive.ObjectID, mfa *models.MFA) error
+	UseRecoveryCode(ctx context.Context, id primitive.ObjectID, codeHash string) (bool, error)
+	// UseTOTPStep marks the time step of a TOTP code as used, it reports false when that step or a later one was
+	// already used
+	UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error)
 }
 
-// MongoDB implements the Database interface
+// Transactional is implemented by the databases that can run several writes in one transaction
+type Transactional interface {
+	// WithTransaction runs fn in a transaction, which the writes made with the context given to fn join
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// ErrVersionConflict is returned when a user was changed since the version an update was based on
+var ErrVersionConflict = errors.New("user was changed by another request")
+
+// ErrNoIdentity is returned when the credentials of a user stored before identities are changed,
+// cmd/migrate-identities has to be run first
+var ErrNoIdentity = errors.New("the user has no identity")
+
+// identityCollection is where MongoDB stores the identities of the users
+const identityCollection = "identities"
+
+// MongoDB implements the Database interface. Creating, updating and deleting users writes their domain events
+// to the outbox in the same transaction, so an event is stored if and only if the change is.
+// The users collection has the memberships, with a copy of the email of their identity so they can be found by
+// it, and the identities collection the credentials. Users are read with a $lookup of their identity
 type MongoDB struct {
-	client         *mongo.Client
-	userCollection *mongo.Collection
+	client             *mongo.Client
+	userCollection     *mongo.Collection
+	identityCollection *mongo.Collection
+	outboxCollection   *mongo.Collection
 }
 
-// NewMongoDB creates a new MongoDB instance
+// NewMongoDB creates a new MongoDB instance, an email can only have one identity and one user per company
 func NewMongoDB(client *mongo.Client) *MongoDB {
-	return &MongoDB{
-		client:         client,
-		userCollection: GetCollection(client, "users"),
+	db := &MongoDB{
+		client:             client,
+		userCollection:     GetCollection(client, "users"),
+		identityCollection: GetCollection(client, identityCollection),
+		outboxCollection:   GetCollection(client, outboxCollection),
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := db.userCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "company", Value: 1}, {Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "email", Value: 1}}},
+		{Keys: bson.D{{Key: "identity", Value: 1}}},
+		{Keys: bson.D{{Key: "managerId", Value: 1}}, Options: options.Index().SetSparse(true)},
+		// Serves the filters on the custom attributes, whichever the company defines
+		{Keys: bson.D{{Key: "attributes.$**", Value: 1}}},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating user indexes")
+	}
+	_, err = db.identityCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating identity indexes")
+	}
+	return db
 }
 
-// CreateUser creates a new user in the database
+// WithTransaction runs fn in a transaction. When the context is already in one fn joins it, so the writes of a
+// decorator and the ones of the database commit together
+func (db *MongoDB) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+	session, err := db.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
+// withOutbox runs fn in a transaction and stores the events it returns in the same transaction
+func (db *MongoDB) withOutbox(ctx context.Context, fn func(ctx mongo.SessionContext) ([]models.OutboxEvent, error)) error {
+	return db.WithTransaction(ctx, func(ctx context.Context) error {
+		events, err := fn(mongo.NewSessionContext(ctx, mongo.SessionFromContext(ctx)))
+		if err != nil || len(events) == 0 {
+			return err
+		}
+		documents := make([]interface{}, len(events))
+		for i, event := range events {
+			documents[i] = event
+		}
+		_, err = db.outboxCollection.InsertMany(ctx, documents)
+		return err
+	})
+}
+
+// CreateUser creates a new user in the database, with the identity of its email. The credentials of the user are
+// only stored when the email has no identity yet, otherwise the user shares the ones of the identity
 func (db *MongoDB) CreateUser(ctx context.Context, user models.UserWithCompanyAsObject) (primitive.ObjectID, error) {
-	result, err := db.userCollection.InsertOne(ctx, user)
+	if user.Id.IsZero() {
+		user.Id = primitive.NewObjectID()
+	}
+	user.Version = 1
+	err := db.withOutbox(ctx, func(ctx mongo.SessionContext) ([]models.OutboxEvent, error) {
+		identity, err := db.identityFor(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		user.Identity = identity.Id
+		if _, err := db.userCollection.InsertOne(ctx, membershipOf(user)); err != nil {
+			return nil, err
+		}
+		return []models.OutboxEvent{models.NewUserEvent(models.EventUserCreated, user)}, nil
+	})
 	if err != nil {
 		return primitive.NilObjectID, err
 	}
-	return result.InsertedID.(primitive.ObjectID), nil
+	return user.Id, nil
+}
+
+// CreateUsers inserts the users in a single unordered batch. The returned ids follow the order of users,
+// with primitive.NilObjectID for the ones that could not be inserted when there is an error.
+// A failed write aborts the transaction, so the batch is retried without the users that failed. That is why it
+// can not run in a transaction of the caller
+func (db *MongoDB) CreateUsers(ctx context.Context, users []models.UserWithCompanyAsObject) ([]primitive.ObjectID, error) {
+	ids := make([]primitive.ObjectID, len(users))
+	pending := make([]int, len(users))
+	for i := range users {
+		if users[i].Id.IsZero() {
+			users[i].Id = primitive.NewObjectID()
+		}
+		users[i].Version = 1
+		pending[i] = i
+	}
+
+	var writeErr error
+	for len(pending) > 0 {
+		err := db.withOutbox(ctx, func(ctx mongo.SessionContext) ([]models.OutboxEvent, error) {
+			documents := make([]interface{}, len(pending))
+			events := make([]models.OutboxEvent, len(pending))
+			for i, index := range pending {
+				identity, err := db.identityFor(ctx, users[index])
+				if err != nil {
+					return nil, err
+				}
+				users[index].Identity = identity.Id
+				documents[i] = membershipOf(users[index])
+				events[i] = models.NewUserEvent(models.EventUserCreated, users[index])
+			}
+			_, err := db.userCollection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+			return events, err
+		})
+
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || len(bulkErr.WriteErrors) == 0 {
+			if err != nil {
+				return make([]primitive.ObjectID, len(users)), err
+			}
+			break
+		}
+		writeErr = err
+		failed := map[int]bool{}
+		for _, failedWrite := range bulkErr.WriteErrors {
+			failed[failedWrite.Index] = true
+		}
+		remaining := pending[:0]
+		for i, index := range pending {
+			if !failed[i] {
+				remaining = append(remaining, index)
+			}
+		}
+		pending = remaining
+	}
+
+	for _, index := range pending {
+		ids[index] = users[index].Id
+	}
+	return ids, writeErr
+}
+
+// identityFor returns the identity of the email of the user, creating it with the credentials of the user when
+// the email has none
+func (db *MongoDB) identityFor(ctx context.Context, user models.UserWithCompanyAsObject) (*models.Identity, error) {
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var identity models.Identity
+	err := db.identityCollection.FindOneAndUpdate(ctx, bson.M{"email": user.Email}, bson.M{"$setOnInsert": models.IdentityOf(user)}, opts).Decode(&identity)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// membershipOf returns the user without the credentials, which are stored on its identity
+func membershipOf(user models.UserWithCompanyAsObject) models.UserWithCompanyAsObject {
+	user.Password = ""
+	user.PasswordChangedAt = nil
+	user.PasswordResetRequired = false
+	user.MFA = nil
+	return user
+}
+
+// userDocument is a user read with the $lookup of its identity
+type userDocument struct {
+	models.UserWithCompanyAsObject `bson:",inline"`
+	Identities                     []models.Identity `bson:"identities"`
+}
+
+// merged returns the user with the email and the settings of its identity. The password hash is left out, only
+// FindCredentialsByEmail reads it
+func (d *userDocument) merged() *models.UserWithCompanyAsObject {
+	user := d.UserWithCompanyAsObject
+	user.Password = ""
+	if len(d.Identities) > 0 {
+		identity := d.Identities[0]
+		user.Email = identity.Email
+		user.PasswordChangedAt = identity.PasswordChangedAt
+		user.PasswordResetRequired = identity.PasswordResetRequired
+		user.MFA = identity.MFA
+	}
+	return &user
+}
+
+// findUsers calls fn with each user matching the filter merged with its identity, stages run before the $lookup
+// and project removes fields of the identity
+func (db *MongoDB) findUsers(ctx context.Context, filter bson.M, project bson.M, fn func(user *models.UserWithCompanyAsObject) error, stages ...bson.D) error {
+	return db.findDocuments(ctx, filter, project, func(document *userDocument) error {
+		return fn(document.merged())
+	}, stages...)
+}
+
+// findDocuments calls fn with each user matching the filter and its identity, see findUsers
+func (db *MongoDB) findDocuments(ctx context.Context, filter bson.M, project bson.M, fn func(document *userDocument) error, stages ...bson.D) error {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: scopedFilter(ctx, filter)}}}
+	pipeline = append(pipeline, stages...)
+	pipeline = append(pipeline, bson.D{{Key: "$lookup", Value: bson.M{"from": identityCollection, "localField": "identity", "foreignField": "_id", "as": "identities"}}})
+	if len(project) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: project}})
+	}
+	cursor, err := db.userCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var document userDocument
+		if err := cursor.Decode(&document); err != nil {
+			return err
+		}
+		if err := fn(&document); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// findUser returns the user matching the filter merged with its identity, mongo.ErrNoDocuments when there is none
+func (db *MongoDB) findUser(ctx context.Context, filter bson.M) (*models.UserWithCompanyAsObject, error) {
+	var found *models.UserWithCompanyAsObject
+	err := db.findUsers(ctx, filter, nil, func(user *models.UserWithCompanyAsObject) error {
+		found = user
+		return nil
+	}, bson.D{{Key: "$limit", Value: 1}})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return found, nil
 }
 
 func (db *MongoDB) FindUserByID(ctx context.Context, id primitive.ObjectID) (*models.UserWithCompanyAsObject, error) {
-	var user models.UserWithCompanyAsObject
-	err := db.userCollection.FindOne(ctx, primitive.M{"_id": id}).Decode(&user)
+	return db.findUser(ctx, bson.M{"_id": id})
+}
+
+func (db *MongoDB) FindUserByEmail(ctx context.Context, companyId primitive.ObjectID, email string) (*models.UserWithCompanyAsObject, error) {
+	return db.findUser(ctx, bson.M{"company": companyId, "email": email})
+}
+
+func (db *MongoDB) FindUsersByEmail(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+	users := []*models.UserWithCompanyAsObject{}
+	err := db.findUsers(ctx, bson.M{"email": email}, nil, func(user *models.UserWithCompanyAsObject) error {
+		users = append(users, user)
+		return nil
+	}, bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}})
 	if err != nil {
 		return nil, err
 	}
-	return &user, nil
+	return users, nil
 }
 
-func (db *MongoDB) FindUserByEmail(ctx context.Context, email string) (*models.UserWithCompanyAsObject, error) {
-	var user models.UserWithCompanyAsObject
-	err := db.userCollection.FindOne(ctx, primitive.M{"email": email}).Decode(&user)
+func (db *MongoDB) FindCredentialsByEmail(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+	users := []*models.UserWithCompanyAsObject{}
+	err := db.findDocuments(ctx, bson.M{"email": email}, nil, func(document *userDocument) error {
+		user := document.merged()
+		if len(document.Identities) > 0 {
+			user.Password = document.Identities[0].Password
+		} else {
+			// Users stored before identities keep the password on their own document
+			user.Password = document.UserWithCompanyAsObject.Password
+		}
+		users = append(users, user)
+		return nil
+	}, bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}})
 	if err != nil {
 		return nil, err
 	}
-	return &user, nil
+	return users, nil
 }
 
 func (db *MongoDB) FindAllUsers(ctx context.Context, companyId primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error) {
-	filter := bson.M{"company": companyId}
-	cursor, err := db.userCollection.Find(ctx, filter)
-	if err != nil || cursor == nil {
+	return db.FindUsers(ctx, companyId, models.UserFilter{})
+}
+
+func (db *MongoDB) FindUsers(ctx context.Context, companyId primitive.ObjectID, filter models.UserFilter) ([]*models.UserWithCompanyAsObject, error) {
+	query := bson.M{"company": companyId}
+	if filter.Ids != nil {
+		query["_id"] = bson.M{"$in": filter.Ids}
+	}
+	for name, value := range filter.Attributes {
+		query["attributes."+name] = value
+	}
+	var users []*models.UserWithCompanyAsObject
+	err := db.findUsers(ctx, query, nil, func(user *models.UserWithCompanyAsObject) error {
+		users = append(users, user)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
+	return users, nil
+}
+
+// StreamUsers calls fn with each user of the company as they are read from the cursor, stopping at the first error.
+// Passwords and MFA secrets are never read
+func (db *MongoDB) StreamUsers(ctx context.Context, companyId primitive.ObjectID, fn func(user *models.UserWithCompanyAsObject) error) error {
+	project := bson.M{"identities.password": 0, "identities.mfa.secret": 0, "identities.mfa.pendingSecret": 0, "identities.mfa.recoveryCodes": 0}
+	return db.findUsers(ctx, bson.M{"company": companyId}, project, fn, bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}})
+}
+
+// FindReports follows the managerId of the users down from the manager with a $graphLookup, which only walks the
+// users of the company and stops at the ones it already visited
+func (db *MongoDB) FindReports(ctx context.Context, companyId, managerId primitive.ObjectID, depth int) ([]*models.UserWithCompanyAsObject, error) {
+	return db.findRelatives(ctx, companyId, managerId, bson.M{
+		"startWith":        "$_id",
+		"connectFromField": "_id",
+		"connectToField":   "managerId",
+		"maxDepth":         depth - 1,
+	})
+}
+
+// FindManagerChain follows the managerId of the users up from the user with a $graphLookup, which only walks the
+// users of the company and stops at the ones it already visited
+func (db *MongoDB) FindManagerChain(ctx context.Context, companyId, id primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error) {
+	return db.findRelatives(ctx, companyId, id, bson.M{
+		"startWith":        "$managerId",
+		"connectFromField": "managerId",
+		"connectToField":   "_id",
+	})
+}
+
+// findRelatives returns the users of the company the $graphLookup from the user reaches, closest first
+func (db *MongoDB) findRelatives(ctx context.Context, companyId, id primitive.ObjectID, graphLookup bson.M) ([]*models.UserWithCompanyAsObject, error) {
+	graphLookup["from"] = db.userCollection.Name()
+	graphLookup["as"] = "relatives"
+	graphLookup["depthField"] = "depth"
+	graphLookup["restrictSearchWithMatch"] = bson.M{"company": companyId}
+	users := []*models.UserWithCompanyAsObject{}
+	err := db.findUsers(ctx, bson.M{"_id": id, "company": companyId}, nil, func(user *models.UserWithCompanyAsObject) error {
+		users = append(users, user)
+		return nil
+	},
+		bson.D{{Key: "$graphLookup", Value: graphLookup}},
+		bson.D{{Key: "$unwind", Value: "$relatives"}},
+		bson.D{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$relatives"}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "depth", Value: 1}, {Key: "name", Value: 1}, {Key: "_id", Value: 1}}}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// UpdateUser sets the fields of the update and returns the updated user, mongo.ErrNoDocuments when it does not exist.
+// The version is part of the filter so two concurrent updates of the same version can not both succeed
+func (db *MongoDB) UpdateUser(ctx context.Context, id primitive.ObjectID, version int64, update models.UserUpdate) (*models.UserWithCompanyAsObject, error) {
+	var user *models.UserWithCompanyAsObject
+	err := db.withOutbox(ctx, func(ctx mongo.SessionContext) ([]models.OutboxEvent, error) {
+		filter := scopedFilter(ctx, bson.M{"_id": id, "$or": versionFilter(version)})
+		var before models.UserWithCompanyAsObject
+		err := db.userCollection.FindOneAndUpdate(ctx, filter, userUpdateDocument(update)).Decode(&before)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			if count, countErr := db.userCollection.CountDocuments(ctx, scopedFilter(ctx, bson.M{"_id": id})); countErr == nil && count > 0 {
+				return nil, ErrVersionConflict
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if update.Email != nil && *update.Email != before.Email {
+			if err := db.changeEmail(ctx, before, *update.Email); err != nil {
+				return nil, err
+			}
+		}
+		if user, err = db.findUser(ctx, bson.M{"_id": id}); err != nil {
+			return nil, err
+		}
+		return userUpdateEvents(before, *user), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// userUpdateDocument returns the update operators of a user update, which increments its version
+func userUpdateDocument(update models.UserUpdate) bson.M {
+	document := bson.M{"$inc": bson.M{"version": 1}}
+	unset := bson.M{}
+	if update.RemovesManager() {
+		update.Manager = nil
+		unset["managerId"] = ""
+	}
+	// An empty map would be omitted from the $set and leave the attributes as they are
+	if update.Attributes != nil && len(update.Attributes) == 0 {
+		update.Attributes = nil
+		unset["attributes"] = ""
+	}
+	if len(unset) > 0 {
+		document["$unset"] = unset
+	}
+	if !update.IsEmpty() {
+		document["$set"] = update
+	}
+	return document
+}
+
+// changeEmail gives the user the identity of its new email. The identity is only renamed when the user is its
+// only one, otherwise the user moves to the identity of the email, created without a password when there is
+// none. That way a company can not change the email, and so where the password resets of the other companies
+// of the person are sent
+func (db *MongoDB) changeEmail(ctx context.Context, user models.UserWithCompanyAsObject, email string) error {
+	if user.Identity.IsZero() {
+		return ErrNoIdentity
+	}
+	shared, err := db.userCollection.CountDocuments(ctx, bson.M{"identity": user.Identity, "_id": bson.M{"$ne": user.Id}})
+	if err != nil {
+		return err
+	}
+	exists, err := db.identityCollection.CountDocuments(ctx, bson.M{"email": email})
+	if err != nil {
+		return err
+	}
+	if shared == 0 && exists == 0 {
+		_, err := db.identityCollection.UpdateOne(ctx, bson.M{"_id": user.Identity}, bson.M{"$set": bson.M{"email": email}})
+		return err
+	}
+
+	user.Email = email
+	user.Password = ""
+	user.PasswordChangedAt = nil
+	user.PasswordResetRequired = true
+	user.MFA = nil
+	identity, err := db.identityFor(ctx, user)
+	if err != nil {
+		return err
+	}
+	if _, err := db.userCollection.UpdateOne(ctx, bson.M{"_id": user.Id}, bson.M{"$set": bson.M{"identity": identity.Id}}); err != nil {
+		return err
+	}
+	if shared == 0 {
+		_, err = db.identityCollection.DeleteOne(ctx, bson.M{"_id": user.Identity})
+	}
+	return err
+}
+
+// scopedFilter restricts a filter of users to the company of the tenant scope of the context, see WithTenant, so the
+// users of other companies are neither read nor written. Contexts in the system scope or without one are not
+// restricted, the TenantDatabase refuses the ones without a scope
+func scopedFilter(ctx context.Context, filter bson.M) bson.M {
+	company, system, ok := TenantFromContext(ctx)
+	if !ok || system {
+		return filter
+	}
+	return bson.M{"$and": bson.A{filter, bson.M{"company": company}}}
+}
+
+// versionFilter matches a version, users stored before versions were added have none and are at version 0
+func versionFilter(version int64) bson.A {
+	filters := bson.A{bson.M{"version": version}}
+	if version == 0 {
+		filters = append(filters, bson.M{"version": bson.M{"$exists": false}})
+	}
+	return filters
+}
+
+// userUpdateEvents returns UserUpdated when the name, email or activation changed and RoleChanged when the role did
+func userUpdateEvents(before, after models.UserWithCompanyAsObject) []models.OutboxEvent {
+	var events []models.OutboxEvent
+	if before.Name != after.Name || before.Email != after.Email || before.IsActive() != after.IsActive() {
+		events = append(events, models.NewUserEvent(models.EventUserUpdated, after))
+	}
+	if before.Role != after.Role {
+		event := models.NewUserEvent(models.EventRoleChanged, after)
+		event.User.PreviousRole = before.Role
+		events = append(events, event)
+	}
+	return events
+}
+
+// DeleteUser removes a user, mongo.ErrNoDocuments when it does not exist. Its identity is removed with its last user,
+// and the users that reported to it are left without a manager
+func (db *MongoDB) DeleteUser(ctx context.Context, id primitive.ObjectID) error {
+	return db.withOutbox(ctx, func(ctx mongo.SessionContext) ([]models.OutboxEvent, error) {
+		var user models.UserWithCompanyAsObject
+		if err := db.userCollection.FindOneAndDelete(ctx, scopedFilter(ctx, bson.M{"_id": id})).Decode(&user); err != nil {
+			return nil, err
+		}
+		if _, err := db.userCollection.UpdateMany(ctx, bson.M{"managerId": id}, bson.M{"$unset": bson.M{"managerId": ""}, "$inc": bson.M{"version": 1}}); err != nil {
+			return nil, err
+		}
+		if !user.Identity.IsZero() {
+			remaining, err := db.userCollection.CountDocuments(ctx, bson.M{"identity": user.Identity})
+			if err != nil {
+				return nil, err
+			}
+			if remaining == 0 {
+				if _, err := db.identityCollection.DeleteOne(ctx, bson.M{"_id": user.Identity}); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return []models.OutboxEvent{models.NewUserEvent(models.EventUserDeleted, user)}, nil
+	})
+}
+
+// updateIdentity applies the update to the identity of the user when it also matches the filter, mongo.ErrNoDocuments
+// when the user does not exist or is of another company than the one of the scope. The credentials are part of every user of the identity, so all their versions go up
+func (db *MongoDB) updateIdentity(ctx context.Context, id primitive.ObjectID, filter bson.M, update bson.M) (*mongo.UpdateResult, error) {
+	var user models.UserWithCompanyAsObject
+	if err := db.userCollection.FindOne(ctx, scopedFilter(ctx, bson.M{"_id": id})).Decode(&user); err != nil {
+		return nil, err
+	}
+	if user.Identity.IsZero() {
+		return nil, ErrNoIdentity
+	}
+	filter["_id"] = user.Identity
+	result, err := db.identityCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	if result.ModifiedCount > 0 {
+		if _, err := db.userCollection.UpdateMany(ctx, bson.M{"identity": user.Identity}, bson.M{"$inc": bson.M{"version": 1}}); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// UpdateUserPassword replaces the password of a user, passwordChangedAt invalidates every session issued before it.
+// The password is the one of the identity, so it changes for every company of the user
+func (db *MongoDB) UpdateUserPassword(ctx context.Context, id primitive.ObjectID, passwordHash string) error {
+	update := bson.M{
+		"$set":   bson.M{"password": passwordHash, "passwordChangedAt": time.Now()},
+		"$unset": bson.M{"passwordResetRequired": ""},
+	}
+	result, err := db.updateIdentity(ctx, id, bson.M{}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// UpdateUserPasswordHash replaces the hash of the same password, like after a rehash with a newer algorithm,
+// so it keeps the sessions. It does nothing if the password was changed since oldHash was read
+func (db *MongoDB) UpdateUserPasswordHash(ctx context.Context, id primitive.ObjectID, oldHash, newHash string) error {
+	_, err := db.updateIdentity(ctx, id, bson.M{"password": oldHash}, bson.M{"$set": bson.M{"password": newHash}})
+	return err
+}
+
+// MarkPlaintextPasswordsForReset removes the passwords that are not hashes and flags their identities so they have
+// to reset them, it returns how many identities were updated
+func (db *MongoDB) MarkPlaintextPasswordsForReset(ctx context.Context) (int64, error) {
+	filter := plaintextPasswordFilter()
+	update := bson.M{"$set": bson.M{"passwordResetRequired": true}, "$unset": bson.M{"password": ""}}
+	result, err := db.identityCollection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// plaintextPasswordFilter matches the identities whose password does not start with the $ of a PHC hash
+func plaintextPasswordFilter() bson.M {
+	return bson.M{"password": bson.M{"$exists": true, "$not": primitive.Regex{Pattern: `^\$`}}}
+}
+
+// CountPlaintextPasswords returns how many identities have a password that is not a hash
+func (db *MongoDB) CountPlaintextPasswords(ctx context.Context) (int64, error) {
+	filter := plaintextPasswordFilter()
+	return db.identityCollection.CountDocuments(ctx, filter)
+}
+
+// usersWithoutIdentityFilter matches the users stored before identities, which still have their credentials
+func usersWithoutIdentityFilter() bson.M {
+	return bson.M{"identity": bson.M{"$exists": false}}
+}
+
+// CountUsersWithoutIdentity returns how many users were stored before identities
+func (db *MongoDB) CountUsersWithoutIdentity(ctx context.Context) (int64, error) {
+	return db.userCollection.CountDocuments(ctx, usersWithoutIdentityFilter())
+}
+
+// MigrateIdentities moves the credentials of the users stored before identities to the identity of their email,
+// created with them. Emails were unique then, so every user gets an identity of its own. It returns how many users
+// were migrated, and can be run again after a failure
+func (db *MongoDB) MigrateIdentities(ctx context.Context) (int64, error) {
+	cursor, err := db.userCollection.Find(ctx, usersWithoutIdentityFilter())
+	if err != nil {
+		return 0, err
+	}
 	defer cursor.Close(ctx)
 
-	var users []*models.UserWithCompanyAsObject
+	var migrated int64
 	for cursor.Next(ctx) {
 		var user models.UserWithCompanyAsObject
 		if err := cursor.Decode(&user); err != nil {
-			return nil, err
+			return migrated, err
+		}
+		identity, err := db.identityFor(ctx, user)
+		if err != nil {
+			return migrated, err
+		}
+		update := bson.M{
+			"$set":   bson.M{"identity": identity.Id},
+			"$unset": bson.M{"password": "", "passwordChangedAt": "", "passwordResetRequired": "", "mfa": ""},
 		}
-		user.Password = ""
-		users = append(users, &user)
+		if _, err := db.userCollection.UpdateOne(ctx, bson.M{"_id": user.Id}, update); err != nil {
+			return migrated, err
+		}
+		migrated++
 	}
-	if err := cursor.Err(); err != nil {
-		return nil, err
+	return migrated, cursor.Err()
+}
+
+// UpdateUserMFA replaces the MFA settings of a user, a nil mfa removes them. They are the ones of the identity,
+// so they change for every company of the user
+func (db *MongoDB) UpdateUserMFA(ctx context.Context, id primitive.ObjectID, mfa *models.MFA) error {
+	update := bson.M{"$set": bson.M{"mfa": mfa}}
+	if mfa == nil {
+		update = bson.M{"$unset": bson.M{"mfa": ""}}
+	}
+	result, err := db.updateIdentity(ctx, id, bson.M{}, update)
+	if err != nil {
+		return err
 	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
 
-	return users, nil
+// UseRecoveryCode removes the recovery code from the user, it reports false when the code was not there
+// so two concurrent logins can not use the same code
+func (db *MongoDB) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, codeHash string) (bool, error) {
+	result, err := db.updateIdentity(ctx, id, bson.M{"mfa.recoveryCodes": codeHash}, bson.M{"$pull": bson.M{"mfa.recoveryCodes": codeHash}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// UseTOTPStep stores the time step of a TOTP code as the last one used, it reports false when that step or a later
+// one was already used so the same code can not log in twice, not even by two concurrent requests
+func (db *MongoDB) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	filter := bson.M{"mfa.lastTotpStep": bson.M{"$not": bson.M{"$gte": step}}}
+	result, err := db.updateIdentity(ctx, id, filter, bson.M{"$set": bson.M{"mfa.lastTotpStep": step}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
 }
@@ -1,16 +1,170 @@
 package configs
 
 import (
+	"encoding/base64"
+	"errors"
+	"io/fs"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"user-service/internal/models"
 
 	"github.com/joho/godotenv"
 )
 
-func EnvMongoURI() string {
+// loadEnv reads the .env file of the working directory when there is one, otherwise the variables come from the
+// environment, like in the tests and in CI
+func loadEnv() {
 	err := godotenv.Load()
-	if err != nil {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Fatal("Error loading .env file")
 	}
+}
+
+func EnvMongoURI() string {
+	loadEnv()
 	return os.Getenv("MONGO_URI")
 }
+
+// EnvNotificationsURL returns the base url of the notifications service used to send emails
+func EnvNotificationsURL() string {
+	loadEnv()
+	return os.Getenv("NOTIFICATIONS_URL")
+}
+
+// EnvPasswordResetURL returns the frontend page users land on to choose a new password
+func EnvPasswordResetURL() string {
+	loadEnv()
+	return os.Getenv("PASSWORD_RESET_URL")
+}
+
+// EnvPasswordPolicy returns the defaults with the password rules set through environment variables
+func EnvPasswordPolicy(defaults models.PasswordPolicy) models.PasswordPolicy {
+	loadEnv()
+	policy := defaults
+	if value, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil {
+		policy.MinLength = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("PASSWORD_MAX_LENGTH")); err == nil {
+		policy.MaxLength = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_CHARACTER_CLASSES")); err == nil {
+		policy.MinCharacterClasses = value
+	}
+	if classes, found := os.LookupEnv("PASSWORD_REQUIRED_CLASSES"); found {
+		policy.RequireLower = strings.Contains(classes, "lower")
+		policy.RequireUpper = strings.Contains(classes, "upper")
+		policy.RequireDigit = strings.Contains(classes, "digit")
+		policy.RequireSymbol = strings.Contains(classes, "symbol")
+	}
+	return policy
+}
+
+// EnvBreachedPasswordsFile returns the path of an optional list of breached passwords, one per line
+func EnvBreachedPasswordsFile() string {
+	loadEnv()
+	return os.Getenv("BREACHED_PASSWORDS_FILE")
+}
+
+// EnvLoginAttemptsStore returns where failed logins are counted, "memory" or "mongo" (default) to share them between replicas
+func EnvLoginAttemptsStore() string {
+	loadEnv()
+	return os.Getenv("LOGIN_ATTEMPTS_STORE")
+}
+
+// EnvMFAEncryptionKey returns the AES key, base64 encoded on MFA_ENCRYPTION_KEY, used to encrypt TOTP secrets
+func EnvMFAEncryptionKey() []byte {
+	loadEnv()
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("MFA_ENCRYPTION_KEY"))
+	if err != nil {
+		log.Println("MFA_ENCRYPTION_KEY is not valid base64")
+		return nil
+	}
+	return key
+}
+
+// EnvMFAIssuer returns the name authenticator apps show next to the codes
+func EnvMFAIssuer() string {
+	loadEnv()
+	if issuer := os.Getenv("MFA_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "user-service"
+}
+
+// EnvPasswordHasher returns the algorithm used to hash new passwords, "argon2id" (default) or "bcrypt"
+func EnvPasswordHasher() string {
+	loadEnv()
+	return os.Getenv("PASSWORD_HASHER")
+}
+
+// EnvUserEventsSource returns where the user event streams read from, "memory" for the events published in the
+// replica or "mongo" (default) for change streams
+func EnvUserEventsSource() string {
+	loadEnv()
+	return os.Getenv("USER_EVENTS_SOURCE")
+}
+
+// EnvIdempotencyStore returns where the responses of idempotent requests are kept, "memory" or "mongo" (default) to share them between replicas
+func EnvIdempotencyStore() string {
+	loadEnv()
+	return os.Getenv("IDEMPOTENCY_STORE")
+}
+
+// EnvOIDCIssuer returns the URL of the OpenID Connect provider, the iss claim of its tokens. Without it the
+// provider is not served, an issuer made from the Host of the requests could be chosen by their senders
+func EnvOIDCIssuer() string {
+	loadEnv()
+	return strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+}
+
+// EnvOIDCSigningKeyFile returns the path of the PEM encoded RSA key the OpenID Connect tokens are signed with
+func EnvOIDCSigningKeyFile() string {
+	loadEnv()
+	return os.Getenv("OIDC_SIGNING_KEY_FILE")
+}
+
+// EnvOIDCAuthorizationURL returns the frontend page clients send users to for signing in, it calls the authorize
+// endpoint with the session of the user. Without it the authorize endpoint itself is advertised
+func EnvOIDCAuthorizationURL() string {
+	loadEnv()
+	return os.Getenv("OIDC_AUTHORIZATION_URL")
+}
+
+// EnvGRPCHost returns the interface the gRPC server listens on, the loopback one by default. It is only meant for the
+// internal services, so it should be a private interface and never a public one
+func EnvGRPCHost() string {
+	loadEnv()
+	if host := os.Getenv("GRPC_HOST"); host != "" {
+		return host
+	}
+	return "127.0.0.1"
+}
+
+// EnvGRPCReflection reports whether the gRPC server lists its services through reflection, only when GRPC_REFLECTION is true
+func EnvGRPCReflection() bool {
+	loadEnv()
+	enabled, _ := strconv.ParseBool(os.Getenv("GRPC_REFLECTION"))
+	return enabled
+}
+
+// EnvTrustedProxies returns the addresses or CIDR ranges of the proxies, like the load balancer, whose
+// X-Forwarded-For header is trusted, comma separated on TRUSTED_PROXIES. Without it no proxy is trusted and the
+// client IP is the address of the connection
+func EnvTrustedProxies() []string {
+	loadEnv()
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// EnvInvitationURL returns the frontend page users land on to accept the membership of a company
+func EnvInvitationURL() string {
+	loadEnv()
+	return os.Getenv("INVITATION_URL")
+}
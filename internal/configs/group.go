@@ -0,0 +1,225 @@
+package configs
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrGroupExists is returned when a company already has a group with the name
+var ErrGroupExists = errors.New("a group with the name already exists")
+
+// GroupStore interface, every method is scoped to a company and mongo.ErrNoDocuments is returned when the group
+// is not one of its groups
+type GroupStore interface {
+	CreateGroup(ctx context.Context, group models.Group) (primitive.ObjectID, error)
+	FindGroup(ctx context.Context, companyId, id primitive.ObjectID) (*models.Group, error)
+	FindCompanyGroups(ctx context.Context, companyId primitive.ObjectID) ([]*models.Group, error)
+	RenameGroup(ctx context.Context, companyId, id primitive.ObjectID, name string) error
+	// DeleteGroup removes the group and takes it out of the groups it was nested in
+	DeleteGroup(ctx context.Context, companyId, id primitive.ObjectID) error
+	AddGroupMember(ctx context.Context, companyId, id, userId primitive.ObjectID) error
+	RemoveGroupMember(ctx context.Context, companyId, id, userId primitive.ObjectID) error
+	AddNestedGroup(ctx context.Context, companyId, id, nestedId primitive.ObjectID) error
+	RemoveNestedGroup(ctx context.Context, companyId, id, nestedId primitive.ObjectID) error
+	// FindNestedGroups returns the groups nested in the group at any depth
+	FindNestedGroups(ctx context.Context, companyId, id primitive.ObjectID) ([]*models.Group, error)
+	// FindUserGroups returns the groups the user is a member of, directly or through a nested group
+	FindUserGroups(ctx context.Context, companyId, userId primitive.ObjectID) ([]*models.Group, error)
+	// RemoveUserFromGroups removes the user from every group, for when it is deleted
+	RemoveUserFromGroups(ctx context.Context, userId primitive.ObjectID) error
+}
+
+// MongoGroupStore implements the GroupStore interface, nested groups are followed with $graphLookup
+type MongoGroupStore struct {
+	groupCollection *mongo.Collection
+}
+
+// groupCollectionName is where MongoDB stores the groups, $graphLookup needs the name
+const groupCollectionName = "groups"
+
+// NewMongoGroupStore creates a new MongoGroupStore instance, group names are unique in a company
+func NewMongoGroupStore(client *mongo.Client) *MongoGroupStore {
+	store := &MongoGroupStore{
+		groupCollection: GetCollection(client, groupCollectionName),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := store.groupCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "company", Value: 1}, {Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "members", Value: 1}}},
+		{Keys: bson.D{{Key: "groups", Value: 1}}},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating group indexes")
+	}
+	return store
+}
+
+// CreateGroup stores a new group, ErrGroupExists when the company has one with the same name
+func (s *MongoGroupStore) CreateGroup(ctx context.Context, group models.Group) (primitive.ObjectID, error) {
+	result, err := s.groupCollection.InsertOne(ctx, group)
+	if mongo.IsDuplicateKeyError(err) {
+		return primitive.NilObjectID, ErrGroupExists
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+// FindGroup returns a group of the company, nil when it does not exist
+func (s *MongoGroupStore) FindGroup(ctx context.Context, companyId, id primitive.ObjectID) (*models.Group, error) {
+	var group models.Group
+	err := s.groupCollection.FindOne(ctx, bson.M{"_id": id, "company": companyId}).Decode(&group)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// FindCompanyGroups returns every group of a company sorted by name
+func (s *MongoGroupStore) FindCompanyGroups(ctx context.Context, companyId primitive.ObjectID) ([]*models.Group, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := s.groupCollection.Find(ctx, bson.M{"company": companyId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	groups := []*models.Group{}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// RenameGroup changes the name of a group, ErrGroupExists when the company has another group with the name
+func (s *MongoGroupStore) RenameGroup(ctx context.Context, companyId, id primitive.ObjectID, name string) error {
+	err := s.updateGroup(ctx, companyId, id, bson.M{"$set": bson.M{"name": name}})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrGroupExists
+	}
+	return err
+}
+
+func (s *MongoGroupStore) DeleteGroup(ctx context.Context, companyId, id primitive.ObjectID) error {
+	result, err := s.groupCollection.DeleteOne(ctx, bson.M{"_id": id, "company": companyId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	_, err = s.groupCollection.UpdateMany(ctx, bson.M{"company": companyId, "groups": id}, bson.M{"$pull": bson.M{"groups": id}})
+	return err
+}
+
+func (s *MongoGroupStore) AddGroupMember(ctx context.Context, companyId, id, userId primitive.ObjectID) error {
+	return s.updateGroup(ctx, companyId, id, bson.M{"$addToSet": bson.M{"members": userId}})
+}
+
+func (s *MongoGroupStore) RemoveGroupMember(ctx context.Context, companyId, id, userId primitive.ObjectID) error {
+	return s.updateGroup(ctx, companyId, id, bson.M{"$pull": bson.M{"members": userId}})
+}
+
+func (s *MongoGroupStore) AddNestedGroup(ctx context.Context, companyId, id, nestedId primitive.ObjectID) error {
+	return s.updateGroup(ctx, companyId, id, bson.M{"$addToSet": bson.M{"groups": nestedId}})
+}
+
+func (s *MongoGroupStore) RemoveNestedGroup(ctx context.Context, companyId, id, nestedId primitive.ObjectID) error {
+	return s.updateGroup(ctx, companyId, id, bson.M{"$pull": bson.M{"groups": nestedId}})
+}
+
+// updateGroup applies the update to a group of the company, mongo.ErrNoDocuments when it does not exist
+func (s *MongoGroupStore) updateGroup(ctx context.Context, companyId, id primitive.ObjectID, update bson.M) error {
+	result, err := s.groupCollection.UpdateOne(ctx, bson.M{"_id": id, "company": companyId}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// FindNestedGroups follows the groups field down from the group, $graphLookup stops on cycles by itself
+func (s *MongoGroupStore) FindNestedGroups(ctx context.Context, companyId, id primitive.ObjectID) ([]*models.Group, error) {
+	return s.graphLookup(ctx, bson.M{"_id": id, "company": companyId}, bson.M{
+		"from":                    groupCollectionName,
+		"startWith":               "$groups",
+		"connectFromField":        "groups",
+		"connectToField":          "_id",
+		"as":                      "related",
+		"restrictSearchWithMatch": bson.M{"company": companyId},
+	}, false)
+}
+
+// FindUserGroups follows the groups field up from the groups the user is a member of
+func (s *MongoGroupStore) FindUserGroups(ctx context.Context, companyId, userId primitive.ObjectID) ([]*models.Group, error) {
+	return s.graphLookup(ctx, bson.M{"company": companyId, "members": userId}, bson.M{
+		"from":                    groupCollectionName,
+		"startWith":               "$_id",
+		"connectFromField":        "_id",
+		"connectToField":          "groups",
+		"as":                      "related",
+		"restrictSearchWithMatch": bson.M{"company": companyId},
+	}, true)
+}
+
+// graphLookup returns the groups related to the ones matching the filter, with the matching ones when withMatched
+// is set. Groups reached more than once are returned once, sorted by name
+func (s *MongoGroupStore) graphLookup(ctx context.Context, filter bson.M, lookup bson.M, withMatched bool) ([]*models.Group, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$graphLookup", Value: lookup}},
+	}
+	cursor, err := s.groupCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var documents []struct {
+		models.Group `bson:",inline"`
+		Related      []models.Group `bson:"related"`
+	}
+	if err := cursor.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+	seen := map[primitive.ObjectID]bool{}
+	groups := []*models.Group{}
+	add := func(group models.Group) {
+		if !seen[group.Id] {
+			seen[group.Id] = true
+			groups = append(groups, &group)
+		}
+	}
+	for _, document := range documents {
+		if withMatched {
+			add(document.Group)
+		}
+		for _, related := range document.Related {
+			add(related)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+func (s *MongoGroupStore) RemoveUserFromGroups(ctx context.Context, userId primitive.ObjectID) error {
+	_, err := s.groupCollection.UpdateMany(ctx, bson.M{"members": userId}, bson.M{"$pull": bson.M{"members": userId}})
+	return err
+}
@@ -0,0 +1,81 @@
+package configs
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IdempotencyStore interface
+type IdempotencyStore interface {
+	// StartIdempotentRequest stores the record of a new request, when its id is already taken by a record that did
+	// not expire it stores nothing and returns that record instead
+	StartIdempotentRequest(ctx context.Context, record models.IdempotencyRecord) (*models.IdempotencyRecord, error)
+	// CompleteIdempotentRequest stores the response of the request, it is kept until expiresAt
+	CompleteIdempotentRequest(ctx context.Context, id string, status int, body []byte, contentType string, expiresAt time.Time) error
+	// DeleteIdempotentRequest forgets a request so it can be retried, like after a server error
+	DeleteIdempotentRequest(ctx context.Context, id string) error
+}
+
+// MongoIdempotencyStore implements the IdempotencyStore interface, it lets every replica replay the responses
+type MongoIdempotencyStore struct {
+	idempotencyCollection *mongo.Collection
+}
+
+// NewMongoIdempotencyStore creates a new MongoIdempotencyStore instance, expired records are removed through a TTL index
+func NewMongoIdempotencyStore(client *mongo.Client) *MongoIdempotencyStore {
+	store := &MongoIdempotencyStore{
+		idempotencyCollection: GetCollection(client, "idempotency_keys"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := store.idempotencyCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating idempotency key indexes")
+	}
+	return store
+}
+
+// StartIdempotentRequest relies on the unique _id so only one of two concurrent requests can start.
+// The TTL monitor only runs every minute, so expired records are replaced here
+func (s *MongoIdempotencyStore) StartIdempotentRequest(ctx context.Context, record models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	filter := bson.M{"_id": record.Id, "expiresAt": bson.M{"$lte": time.Now()}}
+	if _, err := s.idempotencyCollection.DeleteOne(ctx, filter); err != nil {
+		return nil, err
+	}
+
+	_, err := s.idempotencyCollection.InsertOne(ctx, record)
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+	var existing models.IdempotencyRecord
+	err = s.idempotencyCollection.FindOne(ctx, bson.M{"_id": record.Id}).Decode(&existing)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errors.New("idempotency key was released while it was being read")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+func (s *MongoIdempotencyStore) CompleteIdempotentRequest(ctx context.Context, id string, status int, body []byte, contentType string, expiresAt time.Time) error {
+	update := bson.M{"$set": bson.M{"status": status, "body": body, "contentType": contentType, "expiresAt": expiresAt}}
+	_, err := s.idempotencyCollection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (s *MongoIdempotencyStore) DeleteIdempotentRequest(ctx context.Context, id string) error {
+	_, err := s.idempotencyCollection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
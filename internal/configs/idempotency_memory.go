@@ -0,0 +1,68 @@
+package configs
+
+import (
+	"context"
+	"sync"
+	"time"
+	"user-service/internal/models"
+)
+
+// memoryIdempotencySweep is how often expired records are removed, like the TTL monitor of MongoDB
+const memoryIdempotencySweep = time.Minute
+
+// MemoryIdempotencyStore implements the IdempotencyStore interface in memory, responses are not shared between replicas
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]models.IdempotencyRecord
+	lastSweep time.Time
+}
+
+// NewMemoryIdempotencyStore creates a new MemoryIdempotencyStore instance
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records: map[string]models.IdempotencyRecord{},
+	}
+}
+
+func (s *MemoryIdempotencyStore) StartIdempotentRequest(ctx context.Context, record models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	if existing, found := s.records[record.Id]; found && existing.ExpiresAt.After(now) {
+		return &existing, nil
+	}
+	s.records[record.Id] = record
+	return nil, nil
+}
+
+// sweep removes the expired records when the last sweep is older than memoryIdempotencySweep, so keys that are
+// never retried do not stay in memory. The lock must be held
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memoryIdempotencySweep {
+		return
+	}
+	s.lastSweep = now
+	for id, record := range s.records {
+		if !record.ExpiresAt.After(now) {
+			delete(s.records, id)
+		}
+	}
+}
+
+func (s *MemoryIdempotencyStore) CompleteIdempotentRequest(ctx context.Context, id string, status int, body []byte, contentType string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, found := s.records[id]; found {
+		record.Status, record.Body, record.ContentType, record.ExpiresAt = status, body, contentType, expiresAt
+		s.records[id] = record
+	}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) DeleteIdempotentRequest(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, id)
+	return nil
+}
@@ -0,0 +1,29 @@
+package configs
+
+import (
+	"context"
+	"testing"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryIdempotencyStoreSweepsExpiredRecords(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	ctx := context.Background()
+	now := time.Now()
+	store.StartIdempotentRequest(ctx, models.IdempotencyRecord{Id: "expired", ExpiresAt: now.Add(-time.Second)})
+	store.StartIdempotentRequest(ctx, models.IdempotencyRecord{Id: "running", ExpiresAt: now.Add(time.Minute)})
+	assert.Len(t, store.records, 2)
+
+	// Records are only swept once a minute
+	store.StartIdempotentRequest(ctx, models.IdempotencyRecord{Id: "other", ExpiresAt: now.Add(time.Minute)})
+	assert.Contains(t, store.records, "expired")
+
+	store.lastSweep = now.Add(-memoryIdempotencySweep)
+	store.StartIdempotentRequest(ctx, models.IdempotencyRecord{Id: "other", ExpiresAt: now.Add(time.Minute)})
+	assert.NotContains(t, store.records, "expired")
+	assert.Contains(t, store.records, "running")
+	assert.Contains(t, store.records, "other")
+}
@@ -0,0 +1,87 @@
+package configs
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ImportJobStore interface
+type ImportJobStore interface {
+	CreateImportJob(ctx context.Context, job models.ImportJob) (primitive.ObjectID, error)
+	FindImportJob(ctx context.Context, companyId, id primitive.ObjectID) (*models.ImportJob, error)
+	UpdateImportJobProgress(ctx context.Context, id primitive.ObjectID, status string, processed int) error
+	FinishImportJob(ctx context.Context, id primitive.ObjectID, status string, report *models.ImportReport, jobErr string) error
+}
+
+// MongoImportJobStore implements the ImportJobStore interface
+type MongoImportJobStore struct {
+	jobCollection *mongo.Collection
+}
+
+// NewMongoImportJobStore creates a new MongoImportJobStore instance, old jobs are removed through a TTL index
+func NewMongoImportJobStore(client *mongo.Client) *MongoImportJobStore {
+	store := &MongoImportJobStore{
+		jobCollection: GetCollection(client, "import_jobs"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := store.jobCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating import job indexes")
+	}
+	return store
+}
+
+// CreateImportJob stores a new import job
+func (s *MongoImportJobStore) CreateImportJob(ctx context.Context, job models.ImportJob) (primitive.ObjectID, error) {
+	result, err := s.jobCollection.InsertOne(ctx, job)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+// FindImportJob returns a job of the company, nil when it does not exist
+func (s *MongoImportJobStore) FindImportJob(ctx context.Context, companyId, id primitive.ObjectID) (*models.ImportJob, error) {
+	var job models.ImportJob
+	err := s.jobCollection.FindOne(ctx, bson.M{"_id": id, "company": companyId}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// UpdateImportJobProgress records how many rows were processed
+func (s *MongoImportJobStore) UpdateImportJobProgress(ctx context.Context, id primitive.ObjectID, status string, processed int) error {
+	update := bson.M{"$set": bson.M{"status": status, "processed": processed}}
+	_, err := s.jobCollection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// FinishImportJob stores the report of a job, or the error that stopped it
+func (s *MongoImportJobStore) FinishImportJob(ctx context.Context, id primitive.ObjectID, status string, report *models.ImportReport, jobErr string) error {
+	set := bson.M{"status": status, "finishedAt": time.Now()}
+	if report != nil {
+		set["report"] = report
+		set["processed"] = report.Total
+	}
+	if jobErr != "" {
+		set["error"] = jobErr
+	}
+	_, err := s.jobCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
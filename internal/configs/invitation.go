@@ -0,0 +1,72 @@
+package configs
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InvitationStore interface
+type InvitationStore interface {
+	CreateInvitation(ctx context.Context, invitation models.Invitation) error
+	// ConsumeInvitation deletes and returns the invitation of the token if it did not expire, nil when there is none
+	ConsumeInvitation(ctx context.Context, tokenHash string) (*models.Invitation, error)
+	DeleteUserInvitations(ctx context.Context, userId primitive.ObjectID) error
+}
+
+// MongoInvitationStore implements the InvitationStore interface
+type MongoInvitationStore struct {
+	invitationCollection *mongo.Collection
+}
+
+// NewMongoInvitationStore creates a new MongoInvitationStore instance, expired invitations are removed by a TTL index
+func NewMongoInvitationStore(client *mongo.Client) *MongoInvitationStore {
+	store := &MongoInvitationStore{
+		invitationCollection: GetCollection(client, "invitations"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := store.invitationCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating invitation indexes")
+	}
+	return store
+}
+
+func (s *MongoInvitationStore) CreateInvitation(ctx context.Context, invitation models.Invitation) error {
+	_, err := s.invitationCollection.InsertOne(ctx, invitation)
+	return err
+}
+
+// ConsumeInvitation deletes the invitation so it can only be accepted once. The TTL monitor only runs every minute,
+// that is why expiration is also part of the filter
+func (s *MongoInvitationStore) ConsumeInvitation(ctx context.Context, tokenHash string) (*models.Invitation, error) {
+	var invitation models.Invitation
+	filter := bson.M{"tokenHash": tokenHash, "expiresAt": bson.M{"$gt": time.Now()}}
+	err := s.invitationCollection.FindOneAndDelete(ctx, filter).Decode(&invitation)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// DeleteUserInvitations removes every pending invitation of a user
+func (s *MongoInvitationStore) DeleteUserInvitations(ctx context.Context, userId primitive.ObjectID) error {
+	_, err := s.invitationCollection.DeleteMany(ctx, bson.M{"userId": userId})
+	return err
+}
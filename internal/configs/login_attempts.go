@@ -0,0 +1,96 @@
+package configs
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LoginAttemptStore interface, keys identify either an account or an IP
+type LoginAttemptStore interface {
+	FindLoginAttempts(ctx context.Context, key string) (*models.LoginAttempts, error)
+	// RecordLoginFailure counts a failure, failures older than window are forgotten
+	RecordLoginFailure(ctx context.Context, key string, window time.Duration) (*models.LoginAttempts, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ResetLoginAttempts(ctx context.Context, key string) error
+}
+
+// MongoLoginAttemptStore implements the LoginAttemptStore interface, it lets every replica share the counters
+type MongoLoginAttemptStore struct {
+	attemptCollection *mongo.Collection
+}
+
+// NewMongoLoginAttemptStore creates a new MongoLoginAttemptStore instance, forgotten counters are removed through a TTL index
+func NewMongoLoginAttemptStore(client *mongo.Client) *MongoLoginAttemptStore {
+	store := &MongoLoginAttemptStore{
+		attemptCollection: GetCollection(client, "login_attempts"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := store.attemptCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating login attempts indexes")
+	}
+	return store
+}
+
+// FindLoginAttempts returns nil when the key has no failures
+func (s *MongoLoginAttemptStore) FindLoginAttempts(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	var attempts models.LoginAttempts
+	err := s.attemptCollection.FindOne(ctx, bson.M{"_id": key, "expiresAt": bson.M{"$gt": time.Now()}}).Decode(&attempts)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+// RecordLoginFailure increments the counter atomically so concurrent failures on different replicas are all counted
+func (s *MongoLoginAttemptStore) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (*models.LoginAttempts, error) {
+	now := time.Now()
+	// The TTL monitor only runs every minute, so an expired document may still be there and has to start over
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"failures": bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{"$expiresAt", now}},
+			bson.M{"$add": bson.A{"$failures", 1}},
+			1,
+		}},
+		"lastFailureAt": now,
+		"expiresAt":     bson.M{"$max": bson.A{now.Add(window), bson.M{"$ifNull": bson.A{"$lockedUntil", now}}}},
+	}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var attempts models.LoginAttempts
+	if err := s.attemptCollection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&attempts); err != nil {
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+// LockLogin rejects every login of the key until the given time
+func (s *MongoLoginAttemptStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	update := bson.M{
+		"$set": bson.M{"lockedUntil": until},
+		"$max": bson.M{"expiresAt": until},
+	}
+	_, err := s.attemptCollection.UpdateOne(ctx, bson.M{"_id": key}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// ResetLoginAttempts forgets the failures and lock of the key
+func (s *MongoLoginAttemptStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	_, err := s.attemptCollection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
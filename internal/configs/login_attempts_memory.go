@@ -0,0 +1,76 @@
+package configs
+
+import (
+	"context"
+	"sync"
+	"time"
+	"user-service/internal/models"
+)
+
+// MemoryLoginAttemptStore implements the LoginAttemptStore interface in memory, counters are not shared between replicas
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]models.LoginAttempts
+}
+
+// NewMemoryLoginAttemptStore creates a new MemoryLoginAttemptStore instance
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		attempts: map[string]models.LoginAttempts{},
+	}
+}
+
+// FindLoginAttempts returns nil when the key has no failures
+func (s *MemoryLoginAttemptStore) FindLoginAttempts(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts, found := s.attempts[key]
+	if !found || !attempts.ExpiresAt.After(time.Now()) {
+		delete(s.attempts, key)
+		return nil, nil
+	}
+	return &attempts, nil
+}
+
+// RecordLoginFailure counts a failure, failures older than window are forgotten
+func (s *MemoryLoginAttemptStore) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (*models.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	attempts, found := s.attempts[key]
+	if !found || !attempts.ExpiresAt.After(now) {
+		attempts = models.LoginAttempts{Key: key}
+	}
+	attempts.Failures++
+	attempts.LastFailureAt = now
+	attempts.ExpiresAt = now.Add(window)
+	if attempts.LockedUntil != nil && attempts.LockedUntil.After(attempts.ExpiresAt) {
+		attempts.ExpiresAt = *attempts.LockedUntil
+	}
+	s.attempts[key] = attempts
+	return &attempts, nil
+}
+
+// LockLogin rejects every login of the key until the given time
+func (s *MemoryLoginAttemptStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts, found := s.attempts[key]
+	if !found {
+		attempts = models.LoginAttempts{Key: key}
+	}
+	attempts.LockedUntil = &until
+	if until.After(attempts.ExpiresAt) {
+		attempts.ExpiresAt = until
+	}
+	s.attempts[key] = attempts
+	return nil
+}
+
+// ResetLoginAttempts forgets the failures and lock of the key
+func (s *MemoryLoginAttemptStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
@@ -0,0 +1,129 @@
+package configs
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OIDCStore interface
+type OIDCStore interface {
+	CreateOIDCClient(ctx context.Context, client models.OIDCClient) (primitive.ObjectID, error)
+	// FindOIDCClient returns the client with the id whatever its company, nil when it does not exist
+	FindOIDCClient(ctx context.Context, id primitive.ObjectID) (*models.OIDCClient, error)
+	FindCompanyOIDCClients(ctx context.Context, companyId primitive.ObjectID) ([]*models.OIDCClient, error)
+	DeleteOIDCClient(ctx context.Context, companyId, id primitive.ObjectID) error
+
+	CreateAuthorizationCode(ctx context.Context, code models.AuthorizationCode) error
+	// ConsumeAuthorizationCode deletes and returns the code matching the hash, nil when it does not exist or expired
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*models.AuthorizationCode, error)
+}
+
+// MongoOIDCStore implements the OIDCStore interface
+type MongoOIDCStore struct {
+	clientCollection *mongo.Collection
+	codeCollection   *mongo.Collection
+}
+
+// NewMongoOIDCStore creates a new MongoOIDCStore instance, expired authorization codes are removed through a TTL index
+func NewMongoOIDCStore(client *mongo.Client) *MongoOIDCStore {
+	store := &MongoOIDCStore{
+		clientCollection: GetCollection(client, "oidc_clients"),
+		codeCollection:   GetCollection(client, "oidc_authorization_codes"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := store.clientCollection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "company", Value: 1}}})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating OIDC client indexes")
+	}
+	_, err = store.codeCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "codeHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating authorization code indexes")
+	}
+	return store
+}
+
+// CreateOIDCClient stores a new client
+func (s *MongoOIDCStore) CreateOIDCClient(ctx context.Context, client models.OIDCClient) (primitive.ObjectID, error) {
+	result, err := s.clientCollection.InsertOne(ctx, client)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+// FindOIDCClient returns the client with the id, nil when it does not exist
+func (s *MongoOIDCStore) FindOIDCClient(ctx context.Context, id primitive.ObjectID) (*models.OIDCClient, error) {
+	var client models.OIDCClient
+	err := s.clientCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&client)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// FindCompanyOIDCClients returns every client of a company
+func (s *MongoOIDCStore) FindCompanyOIDCClients(ctx context.Context, companyId primitive.ObjectID) ([]*models.OIDCClient, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := s.clientCollection.Find(ctx, bson.M{"company": companyId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	clients := []*models.OIDCClient{}
+	if err := cursor.All(ctx, &clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// DeleteOIDCClient deletes a client of the company and its pending codes, it returns mongo.ErrNoDocuments when
+// the company has no client with that id
+func (s *MongoOIDCStore) DeleteOIDCClient(ctx context.Context, companyId, id primitive.ObjectID) error {
+	result, err := s.clientCollection.DeleteOne(ctx, bson.M{"_id": id, "company": companyId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	_, err = s.codeCollection.DeleteMany(ctx, bson.M{"clientId": id})
+	return err
+}
+
+// CreateAuthorizationCode stores a new authorization code
+func (s *MongoOIDCStore) CreateAuthorizationCode(ctx context.Context, code models.AuthorizationCode) error {
+	_, err := s.codeCollection.InsertOne(ctx, code)
+	return err
+}
+
+// ConsumeAuthorizationCode deletes and returns the code matching the hash, so it can only be exchanged once.
+// The TTL monitor only runs every minute, that is why expiration is also part of the filter.
+func (s *MongoOIDCStore) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*models.AuthorizationCode, error) {
+	var code models.AuthorizationCode
+	filter := bson.M{"codeHash": codeHash, "expiresAt": bson.M{"$gt": time.Now()}}
+	err := s.codeCollection.FindOneAndDelete(ctx, filter).Decode(&code)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
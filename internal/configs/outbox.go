@@ -0,0 +1,147 @@
+package configs
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// outboxCollection is where MongoDB writes the domain events of the user changes
+const outboxCollection = "outbox"
+
+// outboxPublishedTTL is how long published events are kept
+const outboxPublishedTTL = 7 * 24 * time.Hour
+
+// MongoOutbox stores the outbox the relay of the events package publishes
+type MongoOutbox struct {
+	outboxCollection *mongo.Collection
+}
+
+// NewMongoOutbox creates a new MongoOutbox instance, published events are removed through a TTL index
+func NewMongoOutbox(client *mongo.Client) *MongoOutbox {
+	store := &MongoOutbox{
+		outboxCollection: GetCollection(client, outboxCollection),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := store.outboxCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "nextAttemptAt", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"publishedAt": bson.M{"$exists": false}}),
+		},
+		{
+			Keys:    bson.D{{Key: "publishedAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(outboxPublishedTTL.Seconds())),
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating outbox indexes")
+	}
+	return store
+}
+
+// ClaimOutboxEvents claims the events one by one, oldest first, so two relays never get the same event at once
+func (s *MongoOutbox) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxEvent, error) {
+	var events []*models.OutboxEvent
+	for len(events) < limit {
+		now := time.Now()
+		filter := bson.M{"publishedAt": bson.M{"$exists": false}, "nextAttemptAt": bson.M{"$lte": now}}
+		update := bson.M{"$set": bson.M{"nextAttemptAt": now.Add(lease)}}
+		opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).SetReturnDocument(options.After)
+
+		var event models.OutboxEvent
+		err := s.outboxCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&event)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, &event)
+	}
+	return events, nil
+}
+
+// MarkOutboxEventPublished stops the event from being published again
+func (s *MongoOutbox) MarkOutboxEventPublished(ctx context.Context, id primitive.ObjectID) error {
+	update := bson.M{"$set": bson.M{"publishedAt": time.Now()}, "$inc": bson.M{"attempts": 1}, "$unset": bson.M{"lastError": ""}}
+	_, err := s.outboxCollection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// MarkOutboxEventFailed keeps the event pending until nextAttempt
+func (s *MongoOutbox) MarkOutboxEventFailed(ctx context.Context, id primitive.ObjectID, eventErr string, nextAttempt time.Time) error {
+	update := bson.M{"$set": bson.M{"lastError": eventErr, "nextAttemptAt": nextAttempt}, "$inc": bson.M{"attempts": 1}}
+	_, err := s.outboxCollection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// StreamCompanyEvents watches the outbox with a change stream, so it sees the events of every replica as soon as
+// their transaction commits. The stream is opened before reading the stored events so none is missed in between
+func (s *MongoOutbox) StreamCompanyEvents(ctx context.Context, companyId, after primitive.ObjectID) (<-chan models.OutboxEvent, error) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert", "fullDocument.company": companyId}}}}
+	stream, err := s.outboxCollection.Watch(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan models.OutboxEvent)
+	go func() {
+		defer close(events)
+		defer stream.Close(context.Background())
+		send := func(event models.OutboxEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		replayed := map[primitive.ObjectID]bool{}
+		if !after.IsZero() {
+			opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+			cursor, err := s.outboxCollection.Find(ctx, bson.M{"company": companyId, "_id": bson.M{"$gt": after}}, opts)
+			if err != nil {
+				log.Error().Err(err).Msg("Error reading stored outbox events")
+				return
+			}
+			defer cursor.Close(context.Background())
+			for cursor.Next(ctx) {
+				var event models.OutboxEvent
+				if err := cursor.Decode(&event); err != nil || !send(event) {
+					return
+				}
+				replayed[event.Id] = true
+			}
+		}
+
+		for stream.Next(ctx) {
+			var change struct {
+				FullDocument models.OutboxEvent `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&change); err != nil {
+				log.Error().Err(err).Msg("Error decoding outbox change")
+				return
+			}
+			if replayed[change.FullDocument.Id] {
+				continue
+			}
+			if !send(change.FullDocument) {
+				return
+			}
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("Error watching outbox changes")
+		}
+	}()
+	return events, nil
+}
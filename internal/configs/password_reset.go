@@ -0,0 +1,87 @@
+package configs
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PasswordResetStore interface
+type PasswordResetStore interface {
+	CreateToken(ctx context.Context, token models.PasswordResetToken) error
+	FindToken(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error)
+	ConsumeToken(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error)
+	DeleteUserTokens(ctx context.Context, userId primitive.ObjectID) error
+}
+
+// MongoPasswordResetStore implements the PasswordResetStore interface
+type MongoPasswordResetStore struct {
+	tokenCollection *mongo.Collection
+}
+
+// NewMongoPasswordResetStore creates a new MongoPasswordResetStore instance and makes sure
+// expired tokens get removed by mongo through a TTL index
+func NewMongoPasswordResetStore(client *mongo.Client) *MongoPasswordResetStore {
+	store := &MongoPasswordResetStore{
+		tokenCollection: GetCollection(client, "password_reset_tokens"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := store.tokenCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating password reset token indexes")
+	}
+	return store
+}
+
+// CreateToken stores a new password reset token
+func (s *MongoPasswordResetStore) CreateToken(ctx context.Context, token models.PasswordResetToken) error {
+	_, err := s.tokenCollection.InsertOne(ctx, token)
+	return err
+}
+
+// FindToken returns the token matching the hash if it did not expire, without using it
+func (s *MongoPasswordResetStore) FindToken(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+	var token models.PasswordResetToken
+	filter := bson.M{"tokenHash": tokenHash, "expiresAt": bson.M{"$gt": time.Now()}}
+	err := s.tokenCollection.FindOne(ctx, filter).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ConsumeToken deletes and returns the token matching the hash, so it can only be used once.
+// The TTL monitor only runs every minute, that is why expiration is also part of the filter.
+func (s *MongoPasswordResetStore) ConsumeToken(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+	var token models.PasswordResetToken
+	filter := bson.M{"tokenHash": tokenHash, "expiresAt": bson.M{"$gt": time.Now()}}
+	err := s.tokenCollection.FindOneAndDelete(ctx, filter).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// DeleteUserTokens removes every pending token of a user
+func (s *MongoPasswordResetStore) DeleteUserTokens(ctx context.Context, userId primitive.ObjectID) error {
+	_, err := s.tokenCollection.DeleteMany(ctx, bson.M{"userId": userId})
+	return err
+}
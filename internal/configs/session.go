@@ -0,0 +1,73 @@
+package configs
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SessionStore interface
+type SessionStore interface {
+	CreateSession(ctx context.Context, session models.Session) (primitive.ObjectID, error)
+	FindSession(ctx context.Context, tokenHash string) (*models.Session, error)
+	DeleteSession(ctx context.Context, id primitive.ObjectID) error
+}
+
+// MongoSessionStore implements the SessionStore interface
+type MongoSessionStore struct {
+	sessionCollection *mongo.Collection
+}
+
+// NewMongoSessionStore creates a new MongoSessionStore instance, expired sessions are removed through a TTL index
+func NewMongoSessionStore(client *mongo.Client) *MongoSessionStore {
+	store := &MongoSessionStore{
+		sessionCollection: GetCollection(client, "sessions"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := store.sessionCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating session indexes")
+	}
+	return store
+}
+
+// CreateSession stores a new session
+func (s *MongoSessionStore) CreateSession(ctx context.Context, session models.Session) (primitive.ObjectID, error) {
+	result, err := s.sessionCollection.InsertOne(ctx, session)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+// FindSession returns the session matching the token hash, nil when it does not exist or expired
+func (s *MongoSessionStore) FindSession(ctx context.Context, tokenHash string) (*models.Session, error) {
+	var session models.Session
+	filter := bson.M{"tokenHash": tokenHash, "expiresAt": bson.M{"$gt": time.Now()}}
+	err := s.sessionCollection.FindOne(ctx, filter).Decode(&session)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// DeleteSession removes a session, used on logout
+func (s *MongoSessionStore) DeleteSession(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.sessionCollection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
@@ -13,7 +13,7 @@ import (
 func ConnectDB() *mongo.Client {
 	client, err := mongo.NewClient(options.Client().ApplyURI(EnvMongoURI()))
 	if err != nil {
-		log.Error().Err(err).Msg("Error creating mongo client")
+		log.Fatal().Err(err).Msg("Error creating mongo client, MONGO_URI has to be set")
 	}
 
 	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
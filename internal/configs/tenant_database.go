@@ -0,0 +1,202 @@
+package configs
+
+import (
+	"context"
+	"errors"
+	"user-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNoTenantScope is returned when the database is used without a company nor the system scope on the context,
+// so a request that forgot its scope fails instead of reaching every company
+var ErrNoTenantScope = errors.New("the database was used without a tenant scope")
+
+// ErrCrossTenant is returned when a user is created in another company than the one of the scope
+var ErrCrossTenant = errors.New("the user belongs to another company")
+
+type tenantScopeKey struct{}
+
+type tenantScope struct {
+	company primitive.ObjectID
+	system  bool
+}
+
+// WithTenant returns a context scoped to the company, the database only reads and writes its users
+func WithTenant(ctx context.Context, companyId primitive.ObjectID) context.Context {
+	return context.WithValue(ctx, tenantScopeKey{}, tenantScope{company: companyId})
+}
+
+// WithSystemScope returns a context that reaches the users of every company. It is only for lookups made before
+// the company of the caller is known, like the login, and for trusted internal callers
+func WithSystemScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantScopeKey{}, tenantScope{system: true})
+}
+
+// TenantFromContext returns the company the context is scoped to, system is true for the system scope and
+// ok is false when the context has no scope
+func TenantFromContext(ctx context.Context) (companyId primitive.ObjectID, system bool, ok bool) {
+	scope, ok := ctx.Value(tenantScopeKey{}).(tenantScope)
+	return scope.company, scope.system, ok
+}
+
+// TenantDatabase decorates a Database so it is only used with a tenant scope on the context, and users are only
+// created in the company of the scope. The database restricts its filters to the company of the scope, see
+// scopedFilter, so users of other companies are answered as if they did not exist without reading them first.
+// Like the AuditedDatabase every method is written out, so a new method does not compile until it is scoped
+type TenantDatabase struct {
+	db Database
+}
+
+// NewTenantDatabase creates a new TenantDatabase instance
+func NewTenantDatabase(db Database) *TenantDatabase {
+	return &TenantDatabase{db: db}
+}
+
+func (t *TenantDatabase) CreateUser(ctx context.Context, user models.UserWithCompanyAsObject) (primitive.ObjectID, error) {
+	if err := checkCompany(ctx, user.Company); err != nil {
+		return primitive.NilObjectID, err
+	}
+	return t.db.CreateUser(ctx, user)
+}
+
+func (t *TenantDatabase) CreateUsers(ctx context.Context, users []models.UserWithCompanyAsObject) ([]primitive.ObjectID, error) {
+	for _, user := range users {
+		if err := checkCompany(ctx, user.Company); err != nil {
+			return nil, err
+		}
+	}
+	return t.db.CreateUsers(ctx, users)
+}
+
+func (t *TenantDatabase) FindUserByID(ctx context.Context, id primitive.ObjectID) (*models.UserWithCompanyAsObject, error) {
+	if err := checkScope(ctx); err != nil {
+		return nil, err
+	}
+	return t.db.FindUserByID(ctx, id)
+}
+
+func (t *TenantDatabase) FindUserByEmail(ctx context.Context, companyId primitive.ObjectID, email string) (*models.UserWithCompanyAsObject, error) {
+	if err := checkScope(ctx); err != nil {
+		return nil, err
+	}
+	return t.db.FindUserByEmail(ctx, companyId, email)
+}
+
+func (t *TenantDatabase) FindUsersByEmail(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+	if err := checkScope(ctx); err != nil {
+		return nil, err
+	}
+	return t.db.FindUsersByEmail(ctx, email)
+}
+
+func (t *TenantDatabase) FindCredentialsByEmail(ctx context.Context, email string) ([]*models.UserWithCompanyAsObject, error) {
+	if err := checkScope(ctx); err != nil {
+		return nil, err
+	}
+	return t.db.FindCredentialsByEmail(ctx, email)
+}
+
+func (t *TenantDatabase) FindAllUsers(ctx context.Context, companyId primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error) {
+	if err := checkScope(ctx); err != nil {
+		return nil, err
+	}
+	return t.db.FindAllUsers(ctx, companyId)
+}
+
+func (t *TenantDatabase) FindUsers(ctx context.Context, companyId primitive.ObjectID, filter models.UserFilter) ([]*models.UserWithCompanyAsObject, error) {
+	if err := checkScope(ctx); err != nil {
+		return nil, err
+	}
+	return t.db.FindUsers(ctx, companyId, filter)
+}
+
+func (t *TenantDatabase) StreamUsers(ctx context.Context, companyId primitive.ObjectID, fn func(user *models.UserWithCompanyAsObject) error) error {
+	if err := checkScope(ctx); err != nil {
+		return err
+	}
+	return t.db.StreamUsers(ctx, companyId, fn)
+}
+
+func (t *TenantDatabase) FindReports(ctx context.Context, companyId, managerId primitive.ObjectID, depth int) ([]*models.UserWithCompanyAsObject, error) {
+	if err := checkScope(ctx); err != nil {
+		return nil, err
+	}
+	return t.db.FindReports(ctx, companyId, managerId, depth)
+}
+
+func (t *TenantDatabase) FindManagerChain(ctx context.Context, companyId, id primitive.ObjectID) ([]*models.UserWithCompanyAsObject, error) {
+	if err := checkScope(ctx); err != nil {
+		return nil, err
+	}
+	return t.db.FindManagerChain(ctx, companyId, id)
+}
+
+func (t *TenantDatabase) UpdateUser(ctx context.Context, id primitive.ObjectID, version int64, update models.UserUpdate) (*models.UserWithCompanyAsObject, error) {
+	if err := checkScope(ctx); err != nil {
+		return nil, err
+	}
+	return t.db.UpdateUser(ctx, id, version, update)
+}
+
+func (t *TenantDatabase) DeleteUser(ctx context.Context, id primitive.ObjectID) error {
+	if err := checkScope(ctx); err != nil {
+		return err
+	}
+	return t.db.DeleteUser(ctx, id)
+}
+
+func (t *TenantDatabase) UpdateUserPassword(ctx context.Context, id primitive.ObjectID, passwordHash string) error {
+	if err := checkScope(ctx); err != nil {
+		return err
+	}
+	return t.db.UpdateUserPassword(ctx, id, passwordHash)
+}
+
+func (t *TenantDatabase) UpdateUserPasswordHash(ctx context.Context, id primitive.ObjectID, oldHash, newHash string) error {
+	if err := checkScope(ctx); err != nil {
+		return err
+	}
+	return t.db.UpdateUserPasswordHash(ctx, id, oldHash, newHash)
+}
+
+func (t *TenantDatabase) UpdateUserMFA(ctx context.Context, id primitive.ObjectID, mfa *models.MFA) error {
+	if err := checkScope(ctx); err != nil {
+		return err
+	}
+	return t.db.UpdateUserMFA(ctx, id, mfa)
+}
+
+func (t *TenantDatabase) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, codeHash string) (bool, error) {
+	if err := checkScope(ctx); err != nil {
+		return false, err
+	}
+	return t.db.UseRecoveryCode(ctx, id, codeHash)
+}
+
+func (t *TenantDatabase) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	if err := checkScope(ctx); err != nil {
+		return false, err
+	}
+	return t.db.UseTOTPStep(ctx, id, step)
+}
+
+// checkScope returns ErrNoTenantScope when the context has no scope
+func checkScope(ctx context.Context) error {
+	if _, _, ok := TenantFromContext(ctx); !ok {
+		return ErrNoTenantScope
+	}
+	return nil
+}
+
+// checkCompany returns ErrCrossTenant unless the company is the one of the scope
+func checkCompany(ctx context.Context, companyId primitive.ObjectID) error {
+	company, system, ok := TenantFromContext(ctx)
+	if !ok {
+		return ErrNoTenantScope
+	}
+	if !system && companyId != company {
+		return ErrCrossTenant
+	}
+	return nil
+}
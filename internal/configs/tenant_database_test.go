@@ -0,0 +1,23 @@
+package configs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestScopedFilter(t *testing.T) {
+	id, company := primitive.NewObjectID(), primitive.NewObjectID()
+	filter := bson.M{"_id": id}
+
+	// A company scope is part of the filter, so users of other companies match nothing
+	scoped := scopedFilter(WithTenant(context.Background(), company), filter)
+	assert.Equal(t, bson.M{"$and": bson.A{filter, bson.M{"company": company}}}, scoped)
+
+	// The system scope and contexts without one reach every company
+	assert.Equal(t, filter, scopedFilter(WithSystemScope(context.Background()), filter))
+	assert.Equal(t, filter, scopedFilter(context.Background(), filter))
+}
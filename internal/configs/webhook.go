@@ -0,0 +1,229 @@
+package configs
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// webhookDeliveryTTL is how long the deliveries and their logs are kept
+const webhookDeliveryTTL = 30 * 24 * time.Hour
+
+// WebhookStore interface
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, webhook models.Webhook) (primitive.ObjectID, error)
+	FindWebhook(ctx context.Context, companyId, id primitive.ObjectID) (*models.Webhook, error)
+	FindCompanyWebhooks(ctx context.Context, companyId primitive.ObjectID) ([]*models.Webhook, error)
+	// FindEventWebhooks returns the webhooks of the company subscribed to the event type
+	FindEventWebhooks(ctx context.Context, companyId primitive.ObjectID, eventType string) ([]*models.Webhook, error)
+	DeleteWebhook(ctx context.Context, companyId, id primitive.ObjectID) error
+
+	// CreateWebhookDeliveries stores new deliveries, skipping the events that were already stored for the same webhook
+	CreateWebhookDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error
+	FindWebhookDeliveries(ctx context.Context, webhookId primitive.ObjectID, limit int) ([]*models.WebhookDelivery, error)
+	// ClaimWebhookDeliveries returns up to limit pending deliveries that are due, hiding them from other workers for lease
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookDelivery, error)
+	// RedeliverWebhookDelivery claims a delivery of the webhook whatever its status, nil when it does not exist
+	RedeliverWebhookDelivery(ctx context.Context, webhookId, id primitive.ObjectID, lease time.Duration) (*models.WebhookDelivery, error)
+	// RecordWebhookAttempt appends the attempt to the log and sets the new status, nextAttempt is nil unless it is pending
+	RecordWebhookAttempt(ctx context.Context, id primitive.ObjectID, attempt models.WebhookAttempt, status string, nextAttempt *time.Time) (*models.WebhookDelivery, error)
+}
+
+// MongoWebhookStore implements the WebhookStore interface
+type MongoWebhookStore struct {
+	webhookCollection  *mongo.Collection
+	deliveryCollection *mongo.Collection
+}
+
+// NewMongoWebhookStore creates a new MongoWebhookStore instance, old deliveries are removed through a TTL index
+func NewMongoWebhookStore(client *mongo.Client) *MongoWebhookStore {
+	store := &MongoWebhookStore{
+		webhookCollection:  GetCollection(client, "webhooks"),
+		deliveryCollection: GetCollection(client, "webhook_deliveries"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := store.webhookCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "company", Value: 1}, {Key: "events", Value: 1}},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating webhook indexes")
+	}
+	_, err = store.deliveryCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "webhook", Value: 1}, {Key: "eventId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "webhook", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "nextAttemptAt", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"status": models.WebhookDeliveryPending}),
+		},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(webhookDeliveryTTL.Seconds()))},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating webhook delivery indexes")
+	}
+	return store
+}
+
+// CreateWebhook stores a new webhook
+func (s *MongoWebhookStore) CreateWebhook(ctx context.Context, webhook models.Webhook) (primitive.ObjectID, error) {
+	result, err := s.webhookCollection.InsertOne(ctx, webhook)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+// FindWebhook returns a webhook of the company, nil when it does not exist
+func (s *MongoWebhookStore) FindWebhook(ctx context.Context, companyId, id primitive.ObjectID) (*models.Webhook, error) {
+	var webhook models.Webhook
+	err := s.webhookCollection.FindOne(ctx, bson.M{"_id": id, "company": companyId}).Decode(&webhook)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// FindCompanyWebhooks returns every webhook of a company
+func (s *MongoWebhookStore) FindCompanyWebhooks(ctx context.Context, companyId primitive.ObjectID) ([]*models.Webhook, error) {
+	return s.findWebhooks(ctx, bson.M{"company": companyId})
+}
+
+func (s *MongoWebhookStore) FindEventWebhooks(ctx context.Context, companyId primitive.ObjectID, eventType string) ([]*models.Webhook, error) {
+	return s.findWebhooks(ctx, bson.M{"company": companyId, "events": eventType})
+}
+
+func (s *MongoWebhookStore) findWebhooks(ctx context.Context, filter bson.M) ([]*models.Webhook, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := s.webhookCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	webhooks := []*models.Webhook{}
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a webhook of the company, it returns mongo.ErrNoDocuments when it does not exist.
+// Its pending deliveries are dead-lettered by the worker
+func (s *MongoWebhookStore) DeleteWebhook(ctx context.Context, companyId, id primitive.ObjectID) error {
+	result, err := s.webhookCollection.DeleteOne(ctx, bson.M{"_id": id, "company": companyId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (s *MongoWebhookStore) CreateWebhookDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	documents := make([]interface{}, len(deliveries))
+	for i, delivery := range deliveries {
+		documents[i] = delivery
+	}
+	_, err := s.deliveryCollection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		for _, writeErr := range bulkErr.WriteErrors {
+			if !mongo.IsDuplicateKeyError(writeErr) {
+				return err
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+// FindWebhookDeliveries returns the latest deliveries of a webhook, newest first
+func (s *MongoWebhookStore) FindWebhookDeliveries(ctx context.Context, webhookId primitive.ObjectID, limit int) ([]*models.WebhookDelivery, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := s.deliveryCollection.Find(ctx, bson.M{"webhook": webhookId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []*models.WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// ClaimWebhookDeliveries claims the deliveries one by one, oldest first, so two workers never send the same one at once
+func (s *MongoWebhookStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	var deliveries []*models.WebhookDelivery
+	for len(deliveries) < limit {
+		now := time.Now()
+		filter := bson.M{"status": models.WebhookDeliveryPending, "nextAttemptAt": bson.M{"$lte": now}}
+		update := bson.M{"$set": bson.M{"nextAttemptAt": now.Add(lease)}}
+		opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).SetReturnDocument(options.After)
+
+		var delivery models.WebhookDelivery
+		err := s.deliveryCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&delivery)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	return deliveries, nil
+}
+
+func (s *MongoWebhookStore) RedeliverWebhookDelivery(ctx context.Context, webhookId, id primitive.ObjectID, lease time.Duration) (*models.WebhookDelivery, error) {
+	update := bson.M{"$set": bson.M{"nextAttemptAt": time.Now().Add(lease)}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var delivery models.WebhookDelivery
+	err := s.deliveryCollection.FindOneAndUpdate(ctx, bson.M{"_id": id, "webhook": webhookId}, update, opts).Decode(&delivery)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (s *MongoWebhookStore) RecordWebhookAttempt(ctx context.Context, id primitive.ObjectID, attempt models.WebhookAttempt, status string, nextAttempt *time.Time) (*models.WebhookDelivery, error) {
+	set := bson.M{"status": status}
+	unset := bson.M{}
+	if nextAttempt != nil {
+		set["nextAttemptAt"] = *nextAttempt
+	} else {
+		unset["nextAttemptAt"] = ""
+	}
+	if status == models.WebhookDeliveryDelivered {
+		set["deliveredAt"] = attempt.At
+	}
+	update := bson.M{"$set": set, "$push": bson.M{"attempts": attempt}}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var delivery models.WebhookDelivery
+	if err := s.deliveryCollection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
@@ -0,0 +1,150 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"user-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// broadcasterHistory is how many events the broadcaster remembers to drop redeliveries and resume streams
+	broadcasterHistory = 1024
+	// broadcasterStreamBuffer is how many events a stream can fall behind before it is closed
+	broadcasterStreamBuffer = 64
+)
+
+// Broadcaster is an in-process publisher that hands every event to the handlers subscribed in this replica.
+// Events redelivered by the relay are only handed once
+type Broadcaster struct {
+	mu          sync.Mutex
+	handlers    map[int]func(event models.OutboxEvent)
+	nextHandler int
+	seen        map[primitive.ObjectID]bool
+	history     []models.OutboxEvent
+}
+
+// NewBroadcaster creates a new Broadcaster instance
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{
+		handlers: map[int]func(event models.OutboxEvent){},
+		seen:     map[primitive.ObjectID]bool{},
+	}
+}
+
+// Subscribe calls handler with every new event until the returned function is called.
+// Handlers run on the relay goroutine so they should not block
+func (b *Broadcaster) Subscribe(handler func(event models.OutboxEvent)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.subscribe(handler)
+}
+
+func (b *Broadcaster) subscribe(handler func(event models.OutboxEvent)) func() {
+	id := b.nextHandler
+	b.nextHandler++
+	b.handlers[id] = handler
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}
+}
+
+func (b *Broadcaster) Publish(ctx context.Context, event models.OutboxEvent) error {
+	b.mu.Lock()
+	if b.seen[event.Id] {
+		b.mu.Unlock()
+		return nil
+	}
+	b.seen[event.Id] = true
+	b.history = append(b.history, event)
+	if len(b.history) > broadcasterHistory {
+		delete(b.seen, b.history[0].Id)
+		b.history = b.history[1:]
+	}
+	handlers := make([]func(event models.OutboxEvent), 0, len(b.handlers))
+	for _, handler := range b.handlers {
+		handlers = append(handlers, handler)
+	}
+	b.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+	return nil
+}
+
+// StreamCompanyEvents implements Source with the events published in this replica. It resumes from the events
+// it still remembers, and closes the channel when the reader falls too far behind so it can resume again
+func (b *Broadcaster) StreamCompanyEvents(ctx context.Context, companyId, after primitive.ObjectID) (<-chan models.OutboxEvent, error) {
+	live := make(chan models.OutboxEvent, broadcasterStreamBuffer)
+	overflow := make(chan struct{})
+	var overflowOnce sync.Once
+
+	// The history is read and the handler added at once so every event is either replayed or sent live
+	b.mu.Lock()
+	replay := b.companyEventsAfter(companyId, after)
+	unsubscribe := b.subscribe(func(event models.OutboxEvent) {
+		if event.Company != companyId {
+			return
+		}
+		select {
+		case live <- event:
+		default:
+			overflowOnce.Do(func() { close(overflow) })
+		}
+	})
+	b.mu.Unlock()
+
+	events := make(chan models.OutboxEvent)
+	go func() {
+		defer close(events)
+		defer unsubscribe()
+		for _, event := range replay {
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for {
+			select {
+			case event := <-live:
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			case <-overflow:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// companyEventsAfter returns the remembered events of the company published after the given one, all of them
+// when it was already forgotten and none when after is zero
+func (b *Broadcaster) companyEventsAfter(companyId, after primitive.ObjectID) []models.OutboxEvent {
+	if after.IsZero() {
+		return nil
+	}
+	start := 0
+	for i, event := range b.history {
+		if event.Id == after {
+			start = i + 1
+			break
+		}
+	}
+	var events []models.OutboxEvent
+	for _, event := range b.history[start:] {
+		if event.Company == companyId {
+			events = append(events, event)
+		}
+	}
+	return events
+}
@@ -0,0 +1,150 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryOutbox keeps the outbox in memory
+type memoryOutbox struct {
+	events map[primitive.ObjectID]*models.OutboxEvent
+}
+
+func newMemoryOutbox(events ...models.OutboxEvent) *memoryOutbox {
+	outbox := &memoryOutbox{events: map[primitive.ObjectID]*models.OutboxEvent{}}
+	for i := range events {
+		outbox.events[events[i].Id] = &events[i]
+	}
+	return outbox
+}
+
+func (o *memoryOutbox) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxEvent, error) {
+	now := time.Now()
+	var due []*models.OutboxEvent
+	for _, event := range o.events {
+		if event.PublishedAt == nil && !event.NextAttemptAt.After(now) {
+			due = append(due, event)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	claimed := make([]*models.OutboxEvent, len(due))
+	for i, event := range due {
+		event.NextAttemptAt = now.Add(lease)
+		copied := *event
+		claimed[i] = &copied
+	}
+	return claimed, nil
+}
+
+func (o *memoryOutbox) MarkOutboxEventPublished(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now()
+	o.events[id].PublishedAt = &now
+	o.events[id].Attempts++
+	return nil
+}
+
+func (o *memoryOutbox) MarkOutboxEventFailed(ctx context.Context, id primitive.ObjectID, eventErr string, nextAttempt time.Time) error {
+	o.events[id].LastError = eventErr
+	o.events[id].NextAttemptAt = nextAttempt
+	o.events[id].Attempts++
+	return nil
+}
+
+// publisherFunc adapts a function to the Publisher interface
+type publisherFunc func(ctx context.Context, event models.OutboxEvent) error
+
+func (f publisherFunc) Publish(ctx context.Context, event models.OutboxEvent) error {
+	return f(ctx, event)
+}
+
+func testUserEvent(eventType string) models.OutboxEvent {
+	user := models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Jane Smith", Email: "jane@example.com", Password: "$argon2id$hash", Role: "user", Company: primitive.NewObjectID()}
+	return models.NewUserEvent(eventType, user)
+}
+
+func TestRelayPublishesPendingEvents(t *testing.T) {
+	created, deleted := testUserEvent(models.EventUserCreated), testUserEvent(models.EventUserDeleted)
+	outbox := newMemoryOutbox(created, deleted)
+	broadcaster := NewBroadcaster()
+	var received []models.OutboxEvent
+	broadcaster.Subscribe(func(event models.OutboxEvent) { received = append(received, event) })
+	relay := NewRelay(outbox, NewMultiPublisher(NewLogPublisher(), broadcaster))
+
+	published, err := relay.PublishPending(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, published)
+	assert.Len(t, received, 2)
+	assert.NotNil(t, outbox.events[created.Id].PublishedAt)
+
+	// Published events are not published again
+	published, err = relay.PublishPending(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, published)
+}
+
+func TestRelayRetriesFailedEventsWithBackoff(t *testing.T) {
+	event := testUserEvent(models.EventRoleChanged)
+	outbox := newMemoryOutbox(event)
+	fail := true
+	var ids []primitive.ObjectID
+	relay := NewRelay(outbox, publisherFunc(func(ctx context.Context, event models.OutboxEvent) error {
+		ids = append(ids, event.Id)
+		if fail {
+			return errors.New("broker unavailable")
+		}
+		return nil
+	}))
+
+	published, err := relay.PublishPending(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, published)
+	stored := outbox.events[event.Id]
+	assert.Equal(t, "broker unavailable", stored.LastError)
+	assert.Equal(t, 1, stored.Attempts)
+	assert.WithinDuration(t, time.Now().Add(time.Second), stored.NextAttemptAt, 100*time.Millisecond)
+
+	// Once it is due it is delivered again with the same dedupe id
+	stored.NextAttemptAt = time.Now()
+	fail = false
+	published, _ = relay.PublishPending(context.Background())
+	assert.Equal(t, 1, published)
+	assert.Equal(t, []primitive.ObjectID{event.Id, event.Id}, ids)
+}
+
+func TestRelayBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, relayBackoff(1))
+	assert.Equal(t, 8*time.Second, relayBackoff(4))
+	assert.Equal(t, relayMaxBackoff, relayBackoff(30))
+}
+
+func TestBroadcasterDropsRedeliveries(t *testing.T) {
+	broadcaster := NewBroadcaster()
+	count := 0
+	unsubscribe := broadcaster.Subscribe(func(event models.OutboxEvent) { count++ })
+
+	event := testUserEvent(models.EventUserUpdated)
+	broadcaster.Publish(context.Background(), event)
+	broadcaster.Publish(context.Background(), event)
+	assert.Equal(t, 1, count)
+
+	unsubscribe()
+	broadcaster.Publish(context.Background(), testUserEvent(models.EventUserUpdated))
+	assert.Equal(t, 1, count)
+}
+
+func TestUserEventHasNoSecrets(t *testing.T) {
+	event := testUserEvent(models.EventUserCreated)
+	assert.Equal(t, "jane@example.com", event.User.Email)
+	assert.Equal(t, event.User.Company, event.Company)
+	assert.False(t, event.Id.IsZero())
+}
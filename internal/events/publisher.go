@@ -0,0 +1,51 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"user-service/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Publisher delivers domain events to other services. Delivery is at least once, so the same event, with the
+// same Id, can be published more than once and consumers should ignore the ids they have already seen
+type Publisher interface {
+	Publish(ctx context.Context, event models.OutboxEvent) error
+}
+
+// LogPublisher writes the events to the log, for local development
+type LogPublisher struct{}
+
+// NewLogPublisher creates a new LogPublisher instance
+func NewLogPublisher() *LogPublisher {
+	return &LogPublisher{}
+}
+
+func (p *LogPublisher) Publish(ctx context.Context, event models.OutboxEvent) error {
+	log.Info().
+		Str("eventId", event.Id.Hex()).
+		Str("type", event.Type).
+		Str("company", event.Company.Hex()).
+		Str("user", event.User.Id.Hex()).
+		Msg("Domain event published")
+	return nil
+}
+
+// MultiPublisher publishes every event to all its publishers, it fails when any of them does
+type MultiPublisher []Publisher
+
+// NewMultiPublisher creates a publisher that fans out to the given ones
+func NewMultiPublisher(publishers ...Publisher) MultiPublisher {
+	return MultiPublisher(publishers)
+}
+
+func (p MultiPublisher) Publish(ctx context.Context, event models.OutboxEvent) error {
+	var errs []error
+	for _, publisher := range p {
+		if err := publisher.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,98 @@
+package events
+
+import (
+	"context"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// relayInterval is how often the relay looks for pending events
+	relayInterval = time.Second
+	// relayBatchSize is the most events published on each round
+	relayBatchSize = 100
+	// relayLease is how long a claimed event is hidden from other relays while it is published
+	relayLease = time.Minute
+	// relayMaxBackoff caps the wait between two attempts of a failing event
+	relayMaxBackoff = 10 * time.Minute
+)
+
+// Outbox is where the events waiting to be published are stored, configs.MongoOutbox keeps it in Mongo
+type Outbox interface {
+	// ClaimOutboxEvents returns up to limit events that are due, hiding them from other relays for lease
+	ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxEvent, error)
+	MarkOutboxEventPublished(ctx context.Context, id primitive.ObjectID) error
+	// MarkOutboxEventFailed records the error and when the event should be tried again
+	MarkOutboxEventFailed(ctx context.Context, id primitive.ObjectID, eventErr string, nextAttempt time.Time) error
+}
+
+// Relay publishes the events of the outbox. An event is only marked as published after the publisher accepts it,
+// so a crash in between publishes it again: delivery is at least once
+type Relay struct {
+	store     Outbox
+	publisher Publisher
+}
+
+// NewRelay creates a relay that publishes the events of the store
+func NewRelay(store Outbox, publisher Publisher) *Relay {
+	return &Relay{store: store, publisher: publisher}
+}
+
+// Run publishes the pending events until the context is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(relayInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.PublishPending(ctx); err != nil {
+			log.Error().Err(err).Msg("Error publishing outbox events")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PublishPending publishes the events that are due and returns how many were published
+func (r *Relay) PublishPending(ctx context.Context) (int, error) {
+	published := 0
+	for {
+		events, err := r.store.ClaimOutboxEvents(ctx, relayBatchSize, relayLease)
+		if err != nil || len(events) == 0 {
+			return published, err
+		}
+		for _, event := range events {
+			if err := r.publisher.Publish(ctx, *event); err != nil {
+				log.Error().Err(err).Msg("Error publishing outbox event: " + event.Id.Hex())
+				nextAttempt := time.Now().Add(relayBackoff(event.Attempts + 1))
+				if err := r.store.MarkOutboxEventFailed(ctx, event.Id, err.Error(), nextAttempt); err != nil {
+					return published, err
+				}
+				continue
+			}
+			if err := r.store.MarkOutboxEventPublished(ctx, event.Id); err != nil {
+				return published, err
+			}
+			published++
+		}
+		if len(events) < relayBatchSize {
+			return published, nil
+		}
+	}
+}
+
+// relayBackoff doubles the wait after each failed attempt, starting at one second
+func relayBackoff(attempts int) time.Duration {
+	backoff := time.Second
+	for i := 1; i < attempts && backoff < relayMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > relayMaxBackoff {
+		return relayMaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,16 @@
+package events
+
+import (
+	"context"
+	"user-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Source streams the domain events of a company as they happen, configs.MongoOutbox reads them with change streams
+// and Broadcaster from the events published in this replica
+type Source interface {
+	// StreamCompanyEvents sends the events of the company on the channel until ctx is done or the source fails,
+	// then it closes it. When after is not zero the events stored after that one are sent first
+	StreamCompanyEvents(ctx context.Context, companyId, after primitive.ObjectID) (<-chan models.OutboxEvent, error)
+}
@@ -0,0 +1,76 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeRows(t *testing.T, format string, columns []string, rows ...[]interface{}) []byte {
+	var buffer bytes.Buffer
+	writer, err := NewWriter(format, &buffer, columns)
+	assert.NoError(t, err)
+	for _, row := range rows {
+		assert.NoError(t, writer.WriteRow(row))
+	}
+	assert.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func TestCSVWriter(t *testing.T) {
+	changedAt := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	output := writeRows(t, FormatCSV, []string{"name", "email", "passwordChangedAt", "mfaEnabled"},
+		[]interface{}{"Doe, Jane", "jane@example.com", &changedAt, true},
+		[]interface{}{"=HYPERLINK(\"http://evil\")", "john@example.com", (*time.Time)(nil), false},
+	)
+
+	assert.Equal(t, "name,email,passwordChangedAt,mfaEnabled\n"+
+		"\"Doe, Jane\",jane@example.com,2023-06-01T12:00:00Z,true\n"+
+		"\"'=HYPERLINK(\"\"http://evil\"\")\",john@example.com,,false\n", string(output))
+}
+
+func TestNDJSONWriterKeepsColumnOrder(t *testing.T) {
+	output := writeRows(t, FormatNDJSON, []string{"name", "email", "mfaEnabled"},
+		[]interface{}{"Jane", "jane@example.com", true},
+		[]interface{}{"=1+1", "john@example.com", false},
+	)
+
+	assert.Equal(t, `{"name":"Jane","email":"jane@example.com","mfaEnabled":true}
+{"name":"=1+1","email":"john@example.com","mfaEnabled":false}
+`, string(output))
+}
+
+func TestXLSXWriter(t *testing.T) {
+	output := writeRows(t, FormatXLSX, []string{"name", "email"},
+		[]interface{}{"Jane <Admin> & Co", "jane@example.com"},
+		[]interface{}{"John", ""},
+	)
+
+	archive, err := zip.NewReader(bytes.NewReader(output), int64(len(output)))
+	assert.NoError(t, err)
+
+	files := map[string]string{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		assert.NoError(t, err)
+		content, _ := io.ReadAll(reader)
+		files[file.Name] = string(content)
+	}
+	assert.Contains(t, files, "[Content_Types].xml")
+	assert.Contains(t, files, "xl/workbook.xml")
+
+	sheet := files["xl/worksheets/sheet1.xml"]
+	assert.Equal(t, 3, strings.Count(sheet, "<row>"))
+	assert.Contains(t, sheet, "<t xml:space=\"preserve\">Jane &lt;Admin&gt; &amp; Co</t>")
+	assert.True(t, strings.HasSuffix(sheet, "</sheetData></worksheet>"))
+}
+
+func TestNewWriterUnknownFormat(t *testing.T) {
+	_, err := NewWriter("pdf", io.Discard, []string{"name"})
+	assert.Error(t, err)
+}
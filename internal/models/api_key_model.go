@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// APIKeyPrefix starts every API key so they can be told apart from session tokens
+	APIKeyPrefix = "usk_"
+
+	APIKeyScopeUsersRead = "users:read"
+	// APIKeyScopeUsersWrite lets the backend of the company create its users
+	APIKeyScopeUsersWrite = "users:write"
+	// APIKeyScopeSCIM lets the identity provider of the company provision its users over SCIM
+	APIKeyScopeSCIM = "scim"
+)
+
+// APIKey lets the backend of a company call the service without a user, only its hash is stored
+type APIKey struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Company    primitive.ObjectID `json:"company" bson:"company"`
+	Name       string             `json:"name" bson:"name"`
+	KeyHash    string             `json:"-" bson:"keyHash"`
+	Hint       string             `json:"hint" bson:"hint"`
+	Scopes     []string           `json:"scopes" bson:"scopes"`
+	AllowedIPs []string           `json:"allowedIps,omitempty" bson:"allowedIps,omitempty"`
+	CreatedBy  primitive.ObjectID `json:"createdBy" bson:"createdBy"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+	RotatedAt  *time.Time         `json:"rotatedAt,omitempty" bson:"rotatedAt,omitempty"`
+	LastUsedAt *time.Time         `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time         `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+// HasScope reports whether the key was given the permission
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type CreateAPIKeyRequest struct {
+	Name       string   `json:"name,omitempty" validate:"required,max=100"`
+	Scopes     []string `json:"scopes,omitempty" validate:"required,min=1,dive,oneof=users:read users:write scim"`
+	AllowedIPs []string `json:"allowedIps,omitempty" validate:"omitempty,dive,ip|cidr"`
+}
@@ -0,0 +1,31 @@
+package models
+
+// Types of the custom attributes of the users
+const (
+	AttributeTypeString  = "string"
+	AttributeTypeNumber  = "number"
+	AttributeTypeBoolean = "boolean"
+)
+
+// AttributeFilterPrefix starts the query parameters of GET /users that filter on a custom attribute,
+// like attributes.department=Sales
+const AttributeFilterPrefix = "attributes."
+
+// AttributeDefinition is a custom attribute the users of a company can have, like an employee number.
+// Enum and Pattern only apply to string attributes
+type AttributeDefinition struct {
+	Name     string `json:"name" bson:"name" validate:"required,max=64"`
+	Type     string `json:"type" bson:"type" validate:"required,oneof=string number boolean"`
+	Required bool   `json:"required" bson:"required"`
+	// Enum lists the values the attribute can have, any value when it is empty
+	Enum []string `json:"enum,omitempty" bson:"enum,omitempty" validate:"omitempty,dive,required"`
+	// Pattern is a regular expression the values have to match, anywhere in the value unless it is anchored
+	Pattern string `json:"pattern,omitempty" bson:"pattern,omitempty" validate:"max=500"`
+	// Indexed attributes can filter the users of the company
+	Indexed bool `json:"indexed" bson:"indexed"`
+}
+
+// AttributeSchemaRequest replaces the attribute schema of a company
+type AttributeSchemaRequest struct {
+	Attributes []AttributeDefinition `json:"attributes" validate:"max=50,dive"`
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	AuditActionUserCreated       = "user_created"
+	AuditActionUserUpdated       = "user_updated"
+	AuditActionUserRoleChanged   = "user_role_changed"
+	AuditActionUserDeleted       = "user_deleted"
+	AuditActionPasswordChanged   = "password_changed"
+	AuditActionPasswordRehashed  = "password_rehashed"
+	AuditActionRecoveryCodeUsed  = "recovery_code_used"
+	AuditActionLogin             = "login"
+	AuditActionLoginLocked       = "login_locked"
+	AuditActionLoginUnlocked     = "login_unlocked"
+	AuditActionMFAEnabled        = "mfa_enabled"
+	AuditActionMFADisabled       = "mfa_disabled"
+	AuditActionMFAUpdated        = "mfa_updated"
+	AuditActionAPIKeyCreated     = "api_key_created"
+	AuditActionAPIKeyRevoked     = "api_key_revoked"
+	AuditActionAPIKeyRotated     = "api_key_rotated"
+	AuditActionUsersExported     = "users_exported"
+	AuditActionOIDCClientCreated = "oidc_client_created"
+	AuditActionOIDCClientDeleted = "oidc_client_deleted"
+	AuditActionOIDCTokenIssued   = "oidc_token_issued"
+
+	// AuditRedacted replaces the values of secrets on the changes of an event
+	AuditRedacted = "[REDACTED]"
+)
+
+type AuditEvent struct {
+	Id        primitive.ObjectID     `json:"_id,omitempty" bson:"_id,omitempty"`
+	Action    string                 `json:"action" bson:"action"`
+	Actor     *primitive.ObjectID    `json:"actor,omitempty" bson:"actor,omitempty"`
+	APIKey    *primitive.ObjectID    `json:"apiKey,omitempty" bson:"apiKey,omitempty"`
+	Target    *primitive.ObjectID    `json:"target,omitempty" bson:"target,omitempty"`
+	Company   *primitive.ObjectID    `json:"company,omitempty" bson:"company,omitempty"`
+	RequestId string                 `json:"requestId,omitempty" bson:"requestId,omitempty"`
+	IP        string                 `json:"ip,omitempty" bson:"ip,omitempty"`
+	Changes   map[string]AuditChange `json:"changes,omitempty" bson:"changes,omitempty"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	CreatedAt time.Time              `json:"createdAt" bson:"createdAt"`
+}
+
+// AuditChange is the value of a field before and after a change, nil when the field did not exist
+type AuditChange struct {
+	Before interface{} `json:"before" bson:"before"`
+	After  interface{} `json:"after" bson:"after"`
+}
+
+// AuditFilter selects events of the audit log, empty fields match every event
+type AuditFilter struct {
+	Company *primitive.ObjectID
+	Actor   *primitive.ObjectID
+	Target  *primitive.ObjectID
+	Action  string
+	From    *time.Time
+	To      *time.Time
+}
@@ -0,0 +1,41 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// CompanySettings holds the per company overrides of the service defaults
+type CompanySettings struct {
+	Company        primitive.ObjectID      `json:"company" bson:"_id"`
+	PasswordPolicy *PasswordPolicyOverride `json:"passwordPolicy,omitempty" bson:"passwordPolicy,omitempty"`
+	RequireMFA     bool                    `json:"requireMfa" bson:"requireMfa"`
+	// AttributeSchema has the custom attributes the users of the company can have
+	AttributeSchema []AttributeDefinition `json:"attributeSchema,omitempty" bson:"attributeSchema,omitempty"`
+}
+
+type RequireMFARequest struct {
+	Required *bool `json:"required,omitempty" validate:"required"`
+}
+
+type PasswordPolicy struct {
+	MinLength           int  `json:"minLength" bson:"minLength"`
+	MaxLength           int  `json:"maxLength" bson:"maxLength"`
+	RequireLower        bool `json:"requireLower" bson:"requireLower"`
+	RequireUpper        bool `json:"requireUpper" bson:"requireUpper"`
+	RequireDigit        bool `json:"requireDigit" bson:"requireDigit"`
+	RequireSymbol       bool `json:"requireSymbol" bson:"requireSymbol"`
+	MinCharacterClasses int  `json:"minCharacterClasses" bson:"minCharacterClasses"`
+	RejectCommon        bool `json:"rejectCommon" bson:"rejectCommon"`
+	RejectPersonalInfo  bool `json:"rejectPersonalInfo" bson:"rejectPersonalInfo"`
+}
+
+// PasswordPolicyOverride only changes the rules that are set
+type PasswordPolicyOverride struct {
+	MinLength           *int  `json:"minLength,omitempty" bson:"minLength,omitempty"`
+	MaxLength           *int  `json:"maxLength,omitempty" bson:"maxLength,omitempty"`
+	RequireLower        *bool `json:"requireLower,omitempty" bson:"requireLower,omitempty"`
+	RequireUpper        *bool `json:"requireUpper,omitempty" bson:"requireUpper,omitempty"`
+	RequireDigit        *bool `json:"requireDigit,omitempty" bson:"requireDigit,omitempty"`
+	RequireSymbol       *bool `json:"requireSymbol,omitempty" bson:"requireSymbol,omitempty"`
+	MinCharacterClasses *int  `json:"minCharacterClasses,omitempty" bson:"minCharacterClasses,omitempty"`
+	RejectCommon        *bool `json:"rejectCommon,omitempty" bson:"rejectCommon,omitempty"`
+	RejectPersonalInfo  *bool `json:"rejectPersonalInfo,omitempty" bson:"rejectPersonalInfo,omitempty"`
+}
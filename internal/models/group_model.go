@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Group is a team of users of a company. Groups can be nested, the members of a nested group are also members
+// of every group it is nested in
+type Group struct {
+	Id        primitive.ObjectID   `json:"_id,omitempty" bson:"_id,omitempty"`
+	Company   primitive.ObjectID   `json:"company" bson:"company"`
+	Name      string               `json:"name" bson:"name"`
+	Members   []primitive.ObjectID `json:"members" bson:"members"`
+	Groups    []primitive.ObjectID `json:"groups" bson:"groups"`
+	CreatedAt time.Time            `json:"createdAt" bson:"createdAt"`
+}
+
+type GroupRequest struct {
+	Name string `json:"name,omitempty" validate:"required,max=100"`
+}
+
+type GroupMemberRequest struct {
+	UserId string `json:"userId,omitempty" validate:"required,mongodb"`
+}
+
+type NestedGroupRequest struct {
+	GroupId string `json:"groupId,omitempty" validate:"required,mongodb"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// IdempotencyRecord is the response to the first request made with an Idempotency-Key. Id combines the key
+// with its caller, Status is 0 while that first request is still running
+type IdempotencyRecord struct {
+	Id          string    `json:"id" bson:"_id"`
+	Fingerprint string    `json:"fingerprint" bson:"fingerprint"`
+	Status      int       `json:"status,omitempty" bson:"status,omitempty"`
+	Body        []byte    `json:"body,omitempty" bson:"body,omitempty"`
+	ContentType string    `json:"contentType,omitempty" bson:"contentType,omitempty"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt   time.Time `json:"expiresAt" bson:"expiresAt"`
+}
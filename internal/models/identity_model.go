@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Identity is the person behind an email, with the credentials it logs in with. Its memberships are the users
+// that reference it, one per company with its own role, so the password and MFA are shared by every company
+type Identity struct {
+	Id                    primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Email                 string             `json:"email" bson:"email"`
+	Password              string             `json:"-" bson:"password,omitempty"`
+	PasswordChangedAt     *time.Time         `json:"passwordChangedAt,omitempty" bson:"passwordChangedAt,omitempty"`
+	PasswordResetRequired bool               `json:"passwordResetRequired,omitempty" bson:"passwordResetRequired,omitempty"`
+	MFA                   *MFA               `json:"mfa,omitempty" bson:"mfa,omitempty"`
+	CreatedAt             time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// IdentityOf returns the identity with the credentials of a new user
+func IdentityOf(user UserWithCompanyAsObject) Identity {
+	return Identity{
+		Email:                 user.Email,
+		Password:              user.Password,
+		PasswordChangedAt:     user.PasswordChangedAt,
+		PasswordResetRequired: user.PasswordResetRequired,
+		MFA:                   user.MFA,
+		CreatedAt:             time.Now(),
+	}
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ImportRowCreated   = "created"
+	ImportRowValid     = "valid"
+	ImportRowDuplicate = "duplicate"
+	ImportRowInvalid   = "invalid"
+	ImportRowFailed    = "failed"
+
+	ImportJobPending   = "pending"
+	ImportJobRunning   = "running"
+	ImportJobCompleted = "completed"
+	ImportJobFailed    = "failed"
+)
+
+// ImportRowResult is what happened with one row of an import, valid is used instead of created on dry runs
+type ImportRowResult struct {
+	Row      int      `json:"row" bson:"row"`
+	Email    string   `json:"email,omitempty" bson:"email,omitempty"`
+	Status   string   `json:"status" bson:"status"`
+	UserId   string   `json:"userId,omitempty" bson:"userId,omitempty"`
+	Error    string   `json:"error,omitempty" bson:"error,omitempty"`
+	Password []string `json:"password,omitempty" bson:"password,omitempty"`
+}
+
+// ImportReport has the result of every row of an import and how many ended with each status
+type ImportReport struct {
+	DryRun     bool              `json:"dryRun" bson:"dryRun"`
+	Total      int               `json:"total" bson:"total"`
+	Created    int               `json:"created" bson:"created"`
+	Valid      int               `json:"valid" bson:"valid"`
+	Duplicates int               `json:"duplicates" bson:"duplicates"`
+	Invalid    int               `json:"invalid" bson:"invalid"`
+	Failed     int               `json:"failed" bson:"failed"`
+	Rows       []ImportRowResult `json:"rows" bson:"rows"`
+}
+
+// Add appends the result of a row and updates the counts
+func (r *ImportReport) Add(result ImportRowResult) {
+	switch result.Status {
+	case ImportRowCreated:
+		r.Created++
+	case ImportRowValid:
+		r.Valid++
+	case ImportRowDuplicate:
+		r.Duplicates++
+	case ImportRowInvalid:
+		r.Invalid++
+	case ImportRowFailed:
+		r.Failed++
+	}
+	r.Rows = append(r.Rows, result)
+}
+
+// ImportJob is an import of a large file that runs in the background, its progress can be polled
+type ImportJob struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Company    primitive.ObjectID `json:"company" bson:"company"`
+	CreatedBy  primitive.ObjectID `json:"createdBy" bson:"createdBy"`
+	Status     string             `json:"status" bson:"status"`
+	DryRun     bool               `json:"dryRun" bson:"dryRun"`
+	Total      int                `json:"total" bson:"total"`
+	Processed  int                `json:"processed" bson:"processed"`
+	Report     *ImportReport      `json:"report,omitempty" bson:"report,omitempty"`
+	Error      string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+	FinishedAt *time.Time         `json:"finishedAt,omitempty" bson:"finishedAt,omitempty"`
+	ExpiresAt  time.Time          `json:"expiresAt" bson:"expiresAt"`
+}
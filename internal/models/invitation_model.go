@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Invitation asks the person of an email that is already in other companies to accept a new membership. The user of
+// the membership can not log in until the invitation is accepted, only the hash of its token is stored
+type Invitation struct {
+	Id        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserId    primitive.ObjectID `json:"userId" bson:"userId"`
+	Company   primitive.ObjectID `json:"company" bson:"company"`
+	TokenHash string             `json:"-" bson:"tokenHash"`
+	ExpiresAt time.Time          `json:"expiresAt" bson:"expiresAt"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+type AcceptInvitationRequest struct {
+	Token string `json:"token,omitempty" validate:"required"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OIDCClient is an application of a company that signs its users in through the OpenID Connect provider.
+// Its id is the client_id, confidential clients also authenticate with a secret of which only the hash is stored
+type OIDCClient struct {
+	Id           primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Company      primitive.ObjectID `json:"company" bson:"company"`
+	Name         string             `json:"name" bson:"name"`
+	RedirectURIs []string           `json:"redirectUris" bson:"redirectUris"`
+	Confidential bool               `json:"confidential" bson:"confidential"`
+	SecretHash   string             `json:"-" bson:"secretHash,omitempty"`
+	CreatedBy    primitive.ObjectID `json:"createdBy" bson:"createdBy"`
+	CreatedAt    time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// AllowsRedirect reports whether the URI is one of the registered ones, they are compared exactly
+func (c *OIDCClient) AllowsRedirect(uri string) bool {
+	for _, registered := range c.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+type CreateOIDCClientRequest struct {
+	Name         string   `json:"name,omitempty" validate:"required,max=100"`
+	RedirectURIs []string `json:"redirectUris,omitempty" validate:"required,min=1,max=10,dive,url"`
+	Confidential bool     `json:"confidential,omitempty"`
+}
+
+// AuthorizationCode is given to a client once a user authorized it, and exchanged for tokens once.
+// Only the hash of the code is stored, with the PKCE challenge the exchange has to answer
+type AuthorizationCode struct {
+	Id            primitive.ObjectID `bson:"_id,omitempty"`
+	CodeHash      string             `bson:"codeHash"`
+	ClientId      primitive.ObjectID `bson:"clientId"`
+	UserId        primitive.ObjectID `bson:"userId"`
+	Company       primitive.ObjectID `bson:"company"`
+	RedirectURI   string             `bson:"redirectUri"`
+	Scope         string             `bson:"scope"`
+	Nonce         string             `bson:"nonce,omitempty"`
+	CodeChallenge string             `bson:"codeChallenge"`
+	CreatedAt     time.Time          `bson:"createdAt"`
+	ExpiresAt     time.Time          `bson:"expiresAt"`
+}
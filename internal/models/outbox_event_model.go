@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	EventUserCreated = "UserCreated"
+	EventUserUpdated = "UserUpdated"
+	EventUserDeleted = "UserDeleted"
+	EventRoleChanged = "RoleChanged"
+)
+
+// OutboxEvent is a domain event stored in the outbox collection in the same transaction as the change that
+// produced it, until the relay publishes it. Id is the dedupe id, it does not change when the event is redelivered
+type OutboxEvent struct {
+	Id         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Type       string             `json:"type" bson:"type"`
+	Company    primitive.ObjectID `json:"company" bson:"company"`
+	User       UserEventData      `json:"user" bson:"user"`
+	OccurredAt time.Time          `json:"occurredAt" bson:"occurredAt"`
+
+	// Delivery state, only used by the relay
+	Attempts      int        `json:"-" bson:"attempts"`
+	NextAttemptAt time.Time  `json:"-" bson:"nextAttemptAt"`
+	LastError     string     `json:"-" bson:"lastError,omitempty"`
+	PublishedAt   *time.Time `json:"-" bson:"publishedAt,omitempty"`
+}
+
+// UserEventData is the state of the user after the change, without any secret
+type UserEventData struct {
+	Id           primitive.ObjectID `json:"id" bson:"id"`
+	Name         string             `json:"name" bson:"name"`
+	Email        string             `json:"email" bson:"email"`
+	Role         string             `json:"role" bson:"role"`
+	Company      primitive.ObjectID `json:"company" bson:"company"`
+	PreviousRole string             `json:"previousRole,omitempty" bson:"previousRole,omitempty"`
+	Version      int64              `json:"version" bson:"version"`
+}
+
+// NewUserEvent creates an event about the user that is ready to be published
+func NewUserEvent(eventType string, user UserWithCompanyAsObject) OutboxEvent {
+	now := time.Now()
+	return OutboxEvent{
+		Id:      primitive.NewObjectID(),
+		Type:    eventType,
+		Company: user.Company,
+		User: UserEventData{
+			Id: user.Id, Name: user.Name, Email: user.Email, Role: user.Role, Company: user.Company, Version: user.Version,
+		},
+		OccurredAt:    now,
+		NextAttemptAt: now,
+	}
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// SessionScopeMFAChallenge sessions can only complete the second step of a login
+	SessionScopeMFAChallenge = "mfa_challenge"
+	// SessionScopeMFAEnrollment sessions can only enroll MFA, for users of companies that require it
+	SessionScopeMFAEnrollment = "mfa_enrollment"
+)
+
+// Session is a login, sessions without scope have full access
+type Session struct {
+	Id        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserId    primitive.ObjectID `json:"userId" bson:"userId"`
+	Company   primitive.ObjectID `json:"company" bson:"company"`
+	TokenHash string             `json:"-" bson:"tokenHash"`
+	IP        string             `json:"ip,omitempty" bson:"ip,omitempty"`
+	UserAgent string             `json:"userAgent,omitempty" bson:"userAgent,omitempty"`
+	Scope     string             `json:"scope,omitempty" bson:"scope,omitempty"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time          `json:"expiresAt" bson:"expiresAt"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email,omitempty" validate:"required,email"`
+	Password string `json:"password,omitempty" validate:"required"`
+	// Company is the one to log in to, only required when the email belongs to several companies
+	Company string `json:"company,omitempty" validate:"omitempty,mongodb"`
+}
+
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfaToken,omitempty" validate:"required"`
+	Code         string `json:"code,omitempty" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recoveryCode,omitempty"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code,omitempty" validate:"required"`
+}
+
+// LoginAttempts counts the failed logins of a key, either an account or an IP
+type LoginAttempts struct {
+	Key           string     `json:"key" bson:"_id"`
+	Failures      int        `json:"failures" bson:"failures"`
+	LastFailureAt time.Time  `json:"lastFailureAt" bson:"lastFailureAt"`
+	LockedUntil   *time.Time `json:"lockedUntil,omitempty" bson:"lockedUntil,omitempty"`
+	ExpiresAt     time.Time  `json:"expiresAt" bson:"expiresAt"`
+}
@@ -1,21 +1,118 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	RoleAdmin = "admin"
+	// RoleUser is the role of the users provisioned without one
+	RoleUser = "user"
+)
 
 type User struct {
 	Id       string `json:"_id,omitempty" bson:"_id,omitempty"`
 	Name     string `json:"name,omitempty" validate:"required"`
-	Password string `json:"password,omitempty" validate:"required"`
+	Password string `json:"password,omitempty" validate:"required,password"`
 	Email    string `json:"email,omitempty" validate:"required"`
 	Role     string `json:"role,omitempty" validate:"required"`
 	Company  string `json:"company,omitempty" validate:"required"`
+	// ManagerId is the id of the user of the same company the user reports to
+	ManagerId string `json:"managerId,omitempty" validate:"omitempty,mongodb"`
+	// Attributes has the values of the custom attributes of the company, checked against its attribute schema
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
 }
 
+// UserWithCompanyAsObject is the membership of an identity in a company. The email and the credentials belong
+// to the identity and are shared with its users of other companies, the rest is of the company
 type UserWithCompanyAsObject struct {
 	Id       primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
 	Name     string             `json:"name,omitempty"`
-	Password string             `json:"password,omitempty"`
+	Password string             `json:"password,omitempty" bson:"password,omitempty"`
 	Email    string             `json:"email,omitempty"`
 	Role     string             `json:"role,omitempty"`
 	Company  primitive.ObjectID `json:"company"`
+	// Identity is the id of the identity of the email, users of the same person in other companies have the same
+	Identity primitive.ObjectID `json:"-" bson:"identity,omitempty"`
+
+	PasswordChangedAt *time.Time `json:"passwordChangedAt,omitempty" bson:"passwordChangedAt,omitempty"`
+	// PasswordResetRequired is set on users whose password can not be used anymore, like the ones stored in plain text
+	PasswordResetRequired bool `json:"passwordResetRequired,omitempty" bson:"passwordResetRequired,omitempty"`
+	MFA                   *MFA `json:"mfa,omitempty" bson:"mfa,omitempty"`
+	// Version increments on every write, updates are only applied to the version they were based on
+	Version int64 `json:"version" bson:"version"`
+	// ExternalId is the id of the user in the identity provider that provisions it over SCIM
+	ExternalId string `json:"externalId,omitempty" bson:"externalId,omitempty"`
+	// Active is false for users deactivated by their identity provider, nil is active
+	Active *bool `json:"active,omitempty" bson:"active,omitempty"`
+	// Manager is the id of the user of the same company the user reports to, nil when it reports to nobody
+	Manager *primitive.ObjectID `json:"managerId,omitempty" bson:"managerId,omitempty"`
+	// Attributes has the values of the custom attributes of the company, see AttributeDefinition
+	Attributes map[string]interface{} `json:"attributes,omitempty" bson:"attributes,omitempty"`
+	// InvitationPending is set on the users created for an email that was already in other companies, until its
+	// person accepts the invitation they can not log in to the company. It is never returned, so creating a user does
+	// not tell whether its email exists
+	InvitationPending bool `json:"-" bson:"invitationPending,omitempty"`
+}
+
+// UserUpdate has the fields of a user that can be changed, nil fields are left as they are
+type UserUpdate struct {
+	Name *string `json:"name,omitempty" bson:"name,omitempty" validate:"omitempty,min=1"`
+	Role *string `json:"role,omitempty" bson:"role,omitempty" validate:"omitempty,min=1"`
+	// Manager is the new manager of the user, an empty id ("") removes it
+	Manager *primitive.ObjectID `json:"managerId,omitempty" bson:"managerId,omitempty"`
+	// Attributes changes the custom attributes that are set and removes the ones set to null. The service merges
+	// them with the current ones, so the database replaces every attribute with them
+	Attributes map[string]interface{} `json:"attributes,omitempty" bson:"attributes,omitempty"`
+
+	// The fields below are only changed by the provisioning of the identity provider
+	Email      *string `json:"-" bson:"email,omitempty" validate:"omitempty,email"`
+	ExternalId *string `json:"-" bson:"externalId,omitempty"`
+	Active     *bool   `json:"-" bson:"active,omitempty"`
+	// InvitationPending is only changed by the acceptance of the invitation
+	InvitationPending *bool `json:"-" bson:"invitationPending,omitempty"`
+}
+
+// IsEmpty reports whether the update changes nothing
+func (u UserUpdate) IsEmpty() bool {
+	return u.Name == nil && u.Role == nil && u.Manager == nil && u.Attributes == nil &&
+		u.Email == nil && u.ExternalId == nil && u.Active == nil && u.InvitationPending == nil
+}
+
+// RemovesManager reports whether the update takes the manager of the user away
+func (u UserUpdate) RemovesManager() bool {
+	return u.Manager != nil && u.Manager.IsZero()
+}
+
+// UserFilter narrows the users of a company, nil fields match every user
+type UserFilter struct {
+	Ids []primitive.ObjectID
+	// Attributes matches the users with these values of their custom attributes
+	Attributes map[string]interface{}
+}
+
+// MFA holds the TOTP settings of a user, secrets are encrypted and recovery codes hashed
+type MFA struct {
+	Enabled       bool       `json:"enabled" bson:"enabled"`
+	EnabledAt     *time.Time `json:"enabledAt,omitempty" bson:"enabledAt,omitempty"`
+	Secret        string     `json:"-" bson:"secret,omitempty"`
+	PendingSecret string     `json:"-" bson:"pendingSecret,omitempty"`
+	RecoveryCodes []string   `json:"-" bson:"recoveryCodes,omitempty"`
+	// LastTOTPStep is the time step of the last TOTP code accepted, codes of that step or earlier are rejected
+	LastTOTPStep int64 `json:"-" bson:"lastTotpStep,omitempty"`
+}
+
+// MaxReportsDepth is the deepest level of reports below a manager that can be read at once
+const MaxReportsDepth = 20
+
+// IsActive reports whether the user can log in, users are active until they are deactivated
+func (u *UserWithCompanyAsObject) IsActive() bool {
+	return u.Active == nil || *u.Active
+}
+
+// MFAEnabled reports whether the user has to give a TOTP code to log in
+func (u *UserWithCompanyAsObject) MFAEnabled() bool {
+	return u.MFA != nil && u.MFA.Enabled
 }
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// WebhookSecretPrefix starts every webhook signing secret
+	WebhookSecretPrefix = "whsec_"
+
+	WebhookDeliveryPending    = "pending"
+	WebhookDeliveryDelivered  = "delivered"
+	WebhookDeliveryDeadLetter = "dead_letter"
+)
+
+// Webhook is a subscription of a company to the domain events of its users
+type Webhook struct {
+	Id        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Company   primitive.ObjectID `json:"company" bson:"company"`
+	URL       string             `json:"url" bson:"url"`
+	Events    []string           `json:"events" bson:"events"`
+	Secret    string             `json:"-" bson:"secret"`
+	CreatedBy primitive.ObjectID `json:"createdBy" bson:"createdBy"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// WebhookDelivery is a domain event sent to a webhook, with the log of every attempt.
+// The body is stored so every attempt sends the same bytes
+type WebhookDelivery struct {
+	Id            primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Webhook       primitive.ObjectID `json:"webhook" bson:"webhook"`
+	Company       primitive.ObjectID `json:"company" bson:"company"`
+	EventId       primitive.ObjectID `json:"eventId" bson:"eventId"`
+	EventType     string             `json:"eventType" bson:"eventType"`
+	Body          string             `json:"body" bson:"body"`
+	Status        string             `json:"status" bson:"status"`
+	Attempts      []WebhookAttempt   `json:"attempts" bson:"attempts"`
+	NextAttemptAt *time.Time         `json:"nextAttemptAt,omitempty" bson:"nextAttemptAt,omitempty"`
+	CreatedAt     time.Time          `json:"createdAt" bson:"createdAt"`
+	DeliveredAt   *time.Time         `json:"deliveredAt,omitempty" bson:"deliveredAt,omitempty"`
+}
+
+// WebhookAttempt is the result of one request to a webhook, StatusCode is 0 when there was no response.
+// Response has the start of the response body
+type WebhookAttempt struct {
+	At         time.Time `json:"at" bson:"at"`
+	StatusCode int       `json:"statusCode,omitempty" bson:"statusCode,omitempty"`
+	Response   string    `json:"response,omitempty" bson:"response,omitempty"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+	DurationMs int64     `json:"durationMs" bson:"durationMs"`
+}
+
+// CreateWebhookRequest needs an https URL, the deliveries are refused when its host resolves to an address that is not public
+type CreateWebhookRequest struct {
+	URL    string   `json:"url,omitempty" validate:"required,url,startswith=https://"`
+	Events []string `json:"events,omitempty" validate:"required,min=1,dive,oneof=UserCreated UserUpdated UserDeleted RoleChanged"`
+}
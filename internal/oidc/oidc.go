@@ -0,0 +1,195 @@
+// Package oidc has the discovery document, tokens, claims and errors of the OpenID Connect provider. Only the
+// authorization code flow of OAuth 2.0 (RFC 6749) is supported, always with PKCE (RFC 7636)
+package oidc
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"user-service/internal/auth"
+	"user-service/internal/models"
+)
+
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+
+	ResponseTypeCode           = "code"
+	GrantTypeAuthorizationCode = "authorization_code"
+
+	// IDTokenType and AccessTokenType are the typ headers that keep ID tokens from being used as access tokens
+	IDTokenType     = "JWT"
+	AccessTokenType = "at+jwt"
+
+	AuthMethodBasic = "client_secret_basic"
+	AuthMethodPost  = "client_secret_post"
+	AuthMethodNone  = "none"
+)
+
+// Error codes of RFC 6749 sections 4.1.2.1 and 5.2, and RFC 6750 section 3.1
+const (
+	ErrorInvalidRequest          = "invalid_request"
+	ErrorInvalidClient           = "invalid_client"
+	ErrorInvalidGrant            = "invalid_grant"
+	ErrorInvalidScope            = "invalid_scope"
+	ErrorAccessDenied            = "access_denied"
+	ErrorUnsupportedGrantType    = "unsupported_grant_type"
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+	ErrorInvalidToken            = "invalid_token"
+	ErrorServerError             = "server_error"
+)
+
+// SupportedScopes are the scopes that can be granted, other requested scopes are ignored
+var SupportedScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// Configuration is the discovery document of the provider, served at /.well-known/openid-configuration
+type Configuration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// NewConfiguration returns the discovery document of the issuer, endpoints is the URL the endpoints are served under
+func NewConfiguration(issuer, endpoints string) Configuration {
+	return Configuration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             endpoints + "/authorize",
+		TokenEndpoint:                     endpoints + "/token",
+		UserinfoEndpoint:                  endpoints + "/userinfo",
+		JWKSURI:                           endpoints + "/jwks",
+		ScopesSupported:                   SupportedScopes,
+		ResponseTypesSupported:            []string{ResponseTypeCode},
+		GrantTypesSupported:               []string{GrantTypeAuthorizationCode},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{AuthMethodBasic, AuthMethodPost, AuthMethodNone},
+		CodeChallengeMethodsSupported:     []string{auth.PKCEMethodS256},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "nonce", "name", "email", "company", "role"},
+	}
+}
+
+// TokenResponse is the successful response of the token endpoint
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// Error is an OAuth error response, Code is one of the error codes
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	status      int
+}
+
+// NewError creates an error response answered with the status
+func NewError(status int, code, description string) *Error {
+	return &Error{Code: code, Description: description, status: status}
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// StatusCode returns the HTTP status of the error
+func (e *Error) StatusCode() int {
+	if e.status == 0 {
+		return http.StatusBadRequest
+	}
+	return e.status
+}
+
+// GrantedScope returns the supported scopes of the requested ones, separated by spaces. Requests without the
+// openid scope are not OpenID Connect requests and get an invalid_scope error
+func GrantedScope(requested string) (string, error) {
+	var granted []string
+	for _, supported := range SupportedScopes {
+		if HasScope(requested, supported) {
+			granted = append(granted, supported)
+		}
+	}
+	if !HasScope(requested, ScopeOpenID) {
+		return "", NewError(http.StatusBadRequest, ErrorInvalidScope, "The openid scope is required")
+	}
+	return strings.Join(granted, " "), nil
+}
+
+// HasScope reports whether the space separated scopes have the scope
+func HasScope(scopes, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Grant is what the user authorized a client to get, the claims of the tokens are made from it
+type Grant struct {
+	Issuer   string
+	ClientId string
+	Scope    string
+	Nonce    string
+	IssuedAt time.Time
+	TTL      time.Duration
+}
+
+// IDTokenClaims returns the claims of the ID token the client authenticates the user with
+func IDTokenClaims(user *models.UserWithCompanyAsObject, grant Grant) map[string]interface{} {
+	claims := UserInfo(user, grant.Scope)
+	claims["iss"] = grant.Issuer
+	claims["aud"] = grant.ClientId
+	claims["iat"] = grant.IssuedAt.Unix()
+	claims["exp"] = grant.IssuedAt.Add(grant.TTL).Unix()
+	if grant.Nonce != "" {
+		claims["nonce"] = grant.Nonce
+	}
+	return claims
+}
+
+// AccessTokenClaims returns the claims of the access token (RFC 9068) the client calls the userinfo endpoint
+// and its own services with, jti is its unique id
+func AccessTokenClaims(user *models.UserWithCompanyAsObject, grant Grant, jti string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":       grant.Issuer,
+		"sub":       user.Id.Hex(),
+		"aud":       grant.ClientId,
+		"client_id": grant.ClientId,
+		"scope":     grant.Scope,
+		"jti":       jti,
+		"iat":       grant.IssuedAt.Unix(),
+		"exp":       grant.IssuedAt.Add(grant.TTL).Unix(),
+		"company":   user.Company.Hex(),
+		"role":      user.Role,
+	}
+}
+
+// UserInfo returns the claims of the user the scope gives access to. The company and role of the user are
+// always given, services authorize their requests with them
+func UserInfo(user *models.UserWithCompanyAsObject, scope string) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub":     user.Id.Hex(),
+		"company": user.Company.Hex(),
+		"role":    user.Role,
+	}
+	if HasScope(scope, ScopeProfile) {
+		claims["name"] = user.Name
+	}
+	if HasScope(scope, ScopeEmail) {
+		claims["email"] = user.Email
+	}
+	return claims
+}
@@ -0,0 +1,47 @@
+package oidc
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+	"user-service/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGrantedScope(t *testing.T) {
+	scope, err := GrantedScope("email offline_access openid")
+	assert.NoError(t, err)
+	assert.Equal(t, "openid email", scope)
+
+	_, err = GrantedScope("profile email")
+	var oidcErr *Error
+	if assert.True(t, errors.As(err, &oidcErr)) {
+		assert.Equal(t, ErrorInvalidScope, oidcErr.Code)
+		assert.Equal(t, http.StatusBadRequest, oidcErr.StatusCode())
+	}
+}
+
+func TestClaims(t *testing.T) {
+	user := &models.UserWithCompanyAsObject{Id: primitive.NewObjectID(), Name: "Jane Smith", Email: "jane@example.com", Role: models.RoleAdmin, Company: primitive.NewObjectID()}
+	issuedAt := time.Unix(1700000000, 0)
+	grant := Grant{Issuer: "https://users.example.com", ClientId: "client", Scope: "openid email", Nonce: "n-0S6", IssuedAt: issuedAt, TTL: time.Hour}
+
+	idToken := IDTokenClaims(user, grant)
+	assert.Equal(t, map[string]interface{}{
+		"iss": "https://users.example.com", "sub": user.Id.Hex(), "aud": "client", "iat": int64(1700000000), "exp": int64(1700003600),
+		"nonce": "n-0S6", "email": "jane@example.com", "company": user.Company.Hex(), "role": models.RoleAdmin,
+	}, idToken)
+
+	accessToken := AccessTokenClaims(user, grant, "jti")
+	assert.Equal(t, user.Company.Hex(), accessToken["company"])
+	assert.Equal(t, models.RoleAdmin, accessToken["role"])
+	assert.Equal(t, "openid email", accessToken["scope"])
+	assert.NotContains(t, accessToken, "email")
+
+	// Profile claims are only given with the profile scope
+	assert.NotContains(t, UserInfo(user, "openid"), "name")
+	assert.Equal(t, "Jane Smith", UserInfo(user, "openid profile")["name"])
+}
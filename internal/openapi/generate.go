@@ -0,0 +1,228 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Route documents a route registered on gin, with the path it was registered with like /users/:userId
+type Route struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	// Security has the security schemes the route accepts, any of them is enough
+	Security []string
+	Query    []Parameter
+	Headers  []Parameter
+	// Request is the JSON body, RequestContent the content types of bodies that are not JSON like files
+	Request        interface{}
+	RequestContent []string
+	// Status is the status of the successful response, and Data the values of its data field by their key.
+	// Content is used instead for responses that are not the JSON envelope, like files and event streams
+	Status  int
+	Data    map[string]interface{}
+	Content []string
+	// Errors has the statuses of the errors the route answers besides the ones of its security
+	Errors []int
+}
+
+// Envelope is the JSON every response is wrapped in, data is null when there is nothing to return
+type Envelope struct {
+	Status  int                    `json:"status"`
+	Message string                 `json:"message"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+var typeOfEnvelope = reflect.TypeOf(Envelope{})
+
+// New creates an empty document
+func New(info Info, servers ...Server) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Servers: servers,
+		Paths:   map[string]PathItem{},
+		Components: Components{
+			Schemas:         map[string]*Schema{},
+			SecuritySchemes: map[string]SecurityScheme{},
+		},
+	}
+}
+
+// Generate documents the routes registered on gin under the prefix with the routes documentation.
+// Registered routes without documentation are left out, see Missing
+func (d *Document) Generate(registered gin.RoutesInfo, prefix string, routes []Route) {
+	documented := map[string]Route{}
+	for _, route := range routes {
+		documented[route.Method+" "+route.Path] = route
+	}
+	for _, info := range registered {
+		path, found := strings.CutPrefix(info.Path, prefix)
+		if !found {
+			continue
+		}
+		if route, found := documented[info.Method+" "+path]; found {
+			d.Add(route)
+		}
+	}
+}
+
+// Missing returns the routes registered under the prefix that the document does not have
+func (d *Document) Missing(registered gin.RoutesInfo, prefix string) []string {
+	var missing []string
+	for _, info := range registered {
+		path, found := strings.CutPrefix(info.Path, prefix)
+		if !found {
+			continue
+		}
+		if d.Paths[PathOf(path)][strings.ToLower(info.Method)] == nil {
+			missing = append(missing, info.Method+" "+info.Path)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// PathOf converts a gin path to an OpenAPI one, /users/:userId is /users/{userId}
+func PathOf(ginPath string) string {
+	segments := strings.Split(ginPath, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Add documents the operation of a route
+func (d *Document) Add(route Route) {
+	operation := &Operation{
+		OperationId: operationId(route),
+		Summary:     route.Summary,
+		Description: route.Description,
+		Tags:        []string{tagOf(route.Path)},
+		Responses:   map[string]Response{},
+	}
+
+	for _, segment := range strings.Split(route.Path, "/") {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			name := segment[1:]
+			schema := &Schema{Type: "string"}
+			if strings.HasSuffix(name, "Id") {
+				schema = ObjectIdSchema()
+			}
+			operation.Parameters = append(operation.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: schema})
+		}
+	}
+	for _, parameter := range route.Query {
+		parameter.In = "query"
+		operation.Parameters = append(operation.Parameters, withSchema(parameter))
+	}
+	for _, parameter := range route.Headers {
+		parameter.In = "header"
+		operation.Parameters = append(operation.Parameters, withSchema(parameter))
+	}
+
+	if route.Request != nil {
+		operation.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{"application/json": {Schema: d.SchemaOf(route.Request)}}}
+	} else if len(route.RequestContent) > 0 {
+		operation.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{}}
+		for _, contentType := range route.RequestContent {
+			operation.RequestBody.Content[contentType] = MediaType{Schema: &Schema{Type: "string", Format: "binary"}}
+		}
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := Response{Description: http.StatusText(status), Content: map[string]MediaType{}}
+	if len(route.Content) > 0 {
+		for _, contentType := range route.Content {
+			success.Content[contentType] = MediaType{Schema: &Schema{Type: "string", Format: "binary"}}
+		}
+	} else {
+		success.Content["application/json"] = MediaType{Schema: d.envelope(route.Data)}
+	}
+	operation.Responses[strconv.Itoa(status)] = success
+
+	errorStatuses := append([]int{}, route.Errors...)
+	for _, scheme := range route.Security {
+		operation.Security = append(operation.Security, map[string][]string{scheme: {}})
+	}
+	if len(route.Security) > 0 {
+		errorStatuses = append(errorStatuses, http.StatusUnauthorized)
+	}
+	for _, status := range errorStatuses {
+		operation.Responses[strconv.Itoa(status)] = Response{
+			Description: http.StatusText(status),
+			Content:     map[string]MediaType{"application/json": {Schema: d.SchemaOf(Envelope{})}},
+		}
+	}
+
+	path := PathOf(route.Path)
+	if d.Paths[path] == nil {
+		d.Paths[path] = PathItem{}
+	}
+	d.Paths[path][strings.ToLower(route.Method)] = operation
+}
+
+// envelope describes the response envelope with the values of its data field
+func (d *Document) envelope(data map[string]interface{}) *Schema {
+	schema := d.structSchema(typeOfEnvelope)
+	schema.Required = []string{"status", "message", "data"}
+	if data == nil {
+		schema.Properties["data"] = &Schema{Type: "object", Nullable: true}
+		return schema
+	}
+	properties := map[string]*Schema{}
+	for key, value := range data {
+		properties[key] = d.SchemaOf(value)
+	}
+	schema.Properties["data"] = &Schema{Type: "object", Properties: properties}
+	return schema
+}
+
+func withSchema(parameter Parameter) Parameter {
+	if parameter.Schema == nil {
+		parameter.Schema = &Schema{Type: "string"}
+	}
+	return parameter
+}
+
+// operationId names an operation after its method and path, GET /users/:userId is getUsersByUserId
+func operationId(route Route) string {
+	id := strings.ToLower(route.Method)
+	for _, segment := range strings.Split(route.Path, "/") {
+		if segment == "" {
+			continue
+		}
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			id += "By" + capitalize(segment[1:])
+			continue
+		}
+		for _, word := range strings.FieldsFunc(segment, func(r rune) bool { return r == '-' || r == '.' }) {
+			id += capitalize(word)
+		}
+	}
+	return id
+}
+
+// tagOf groups the operations by the first segment of their path
+func tagOf(path string) string {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return segment
+}
+
+func capitalize(word string) string {
+	if word == "" {
+		return word
+	}
+	return strings.ToUpper(word[:1]) + word[1:]
+}
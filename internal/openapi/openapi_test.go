@@ -0,0 +1,76 @@
+package openapi
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type testAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type testRequest struct {
+	Id        primitive.ObjectID `json:"_id,omitempty"`
+	Name      string             `json:"name,omitempty" validate:"required,max=100"`
+	Email     string             `json:"email" validate:"required,email"`
+	URL       string             `json:"url,omitempty" validate:"omitempty,url,startswith=http"`
+	Events    []string           `json:"events,omitempty" validate:"required,min=1,dive,oneof=UserCreated UserDeleted"`
+	Age       *int               `json:"age,omitempty" validate:"omitempty,min=18"`
+	Address   testAddress        `json:"address"`
+	Secret    string             `json:"-"`
+	CreatedAt time.Time          `json:"createdAt"`
+}
+
+func TestSchemaOf(t *testing.T) {
+	document := New(Info{Title: "Test", Version: "1"})
+	assert.Equal(t, &Schema{Ref: "#/components/schemas/testRequest"}, document.SchemaOf(testRequest{}))
+
+	schema := document.Components.Schemas["testRequest"]
+	assert.Equal(t, []string{"name", "email", "events"}, schema.Required)
+	assert.NotContains(t, schema.Properties, "Secret")
+	assert.Equal(t, ObjectIdSchema(), schema.Properties["_id"])
+	assert.Equal(t, 100, *schema.Properties["name"].MaxLength)
+	assert.Equal(t, "email", schema.Properties["email"].Format)
+	assert.Equal(t, "uri", schema.Properties["url"].Format)
+	assert.Equal(t, "^http", schema.Properties["url"].Pattern)
+	assert.Equal(t, 1, *schema.Properties["events"].MinItems)
+	assert.Equal(t, []interface{}{"UserCreated", "UserDeleted"}, schema.Properties["events"].Items.Enum)
+	assert.Equal(t, 18.0, *schema.Properties["age"].Minimum)
+	assert.True(t, schema.Properties["age"].Nullable)
+	assert.Equal(t, "#/components/schemas/testAddress", schema.Properties["address"].Ref)
+	assert.Equal(t, []string{"city"}, document.Components.Schemas["testAddress"].Required)
+	assert.Equal(t, "date-time", schema.Properties["createdAt"].Format)
+}
+
+func TestGenerate(t *testing.T) {
+	noop := func(c *gin.Context) {}
+	router := gin.New()
+	router.GET("/v1/users/:userId", noop)
+	router.POST("/v1/users", noop)
+	router.GET("/openapi.json", noop)
+
+	document := New(Info{Title: "Test", Version: "1"})
+	document.Generate(router.Routes(), "/v1", []Route{
+		{Method: http.MethodGet, Path: "/users/:userId", Summary: "Get a user", Data: map[string]interface{}{"user": testAddress{}}, Security: []string{"session"}, Errors: []int{http.StatusNotFound}},
+		{Method: http.MethodDelete, Path: "/users/:userId", Summary: "Not registered"},
+	})
+
+	operation := document.Paths["/users/{userId}"]["get"]
+	assert.Equal(t, "getUsersByUserId", operation.OperationId)
+	assert.Equal(t, []string{"users"}, operation.Tags)
+	assert.Equal(t, []Parameter{{Name: "userId", In: "path", Required: true, Schema: ObjectIdSchema()}}, operation.Parameters)
+	assert.Contains(t, operation.Responses, "200")
+	assert.Contains(t, operation.Responses, "401")
+	assert.Contains(t, operation.Responses, "404")
+	data := operation.Responses["200"].Content["application/json"].Schema.Properties["data"]
+	assert.Equal(t, "#/components/schemas/testAddress", data.Properties["user"].Ref)
+
+	// Only registered routes are documented, and only the ones under the prefix are missing
+	assert.Nil(t, document.Paths["/users/{userId}"]["delete"])
+	assert.Equal(t, []string{"POST /v1/users"}, document.Missing(router.Routes(), "/v1"))
+}
@@ -0,0 +1,181 @@
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIdType = reflect.TypeOf(primitive.ObjectID{})
+)
+
+// ObjectIdSchema describes the hex form of the MongoDB ids
+func ObjectIdSchema() *Schema {
+	return &Schema{Type: "string", Pattern: "^[0-9a-fA-F]{24}$"}
+}
+
+// SchemaOf describes the JSON encoding of the value. Named structs are added to the components once and referenced,
+// the validate tags of their fields become the required properties and the limits of the schema
+func (d *Document) SchemaOf(value interface{}) *Schema {
+	if value == nil {
+		return &Schema{}
+	}
+	return d.schemaOf(reflect.TypeOf(value))
+}
+
+func (d *Document) schemaOf(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case objectIdType:
+		return ObjectIdSchema()
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return d.schemaOf(t.Elem())
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: d.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		if d.Components.Schemas == nil {
+			d.Components.Schemas = map[string]*Schema{}
+		}
+		if _, found := d.Components.Schemas[t.Name()]; !found {
+			// The name is taken before the fields are described, so structs that contain themselves end
+			d.Components.Schemas[t.Name()] = &Schema{}
+			*d.Components.Schemas[t.Name()] = *d.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	// Interfaces can hold any value
+	return &Schema{}
+}
+
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	d.addFields(schema, t)
+	return schema
+}
+
+// addFields adds the exported fields of the struct to the schema, the ones of embedded structs included
+func (d *Document) addFields(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			d.addFields(schema, field.Type)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := d.schemaOf(field.Type)
+		if field.Type.Kind() == reflect.Pointer && property.Ref == "" {
+			property.Nullable = true
+		}
+		if applyValidateTag(property, field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+}
+
+// applyValidateTag adds the rules of a validate tag to the schema of the field, and reports whether the field is required.
+// The rules after dive apply to the items of the field
+func applyValidateTag(schema *Schema, tag string) bool {
+	if tag == "" {
+		return false
+	}
+	required := false
+	target := schema
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = target == schema
+		case "dive":
+			if schema.Items == nil {
+				return required
+			}
+			target = schema.Items
+		case "email":
+			target.Format = "email"
+		case "url":
+			target.Format = "uri"
+		case "startswith":
+			target.Pattern = "^" + param
+		case "oneof":
+			for _, value := range strings.Fields(param) {
+				target.Enum = append(target.Enum, value)
+			}
+		case "min", "max", "len":
+			applyLimit(target, name, param)
+		case "password":
+			target.Description = "Must follow the password policy of the company"
+		}
+	}
+	return required
+}
+
+// applyLimit sets min, max or len as the limit of the length of strings and arrays, or of the value of numbers
+func applyLimit(schema *Schema, rule, param string) {
+	value, err := strconv.Atoi(param)
+	if err != nil {
+		return
+	}
+	min, max := rule == "min" || rule == "len", rule == "max" || rule == "len"
+	switch schema.Type {
+	case "string":
+		if min {
+			schema.MinLength = &value
+		}
+		if max {
+			schema.MaxLength = &value
+		}
+	case "array":
+		if min {
+			schema.MinItems = &value
+		}
+		if max {
+			schema.MaxItems = &value
+		}
+	case "integer", "number":
+		limit := float64(value)
+		if min {
+			schema.Minimum = &limit
+		}
+		if max {
+			schema.Maximum = &limit
+		}
+	}
+}
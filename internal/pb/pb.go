@@ -0,0 +1,4 @@
+// Package pb holds the code generated from the protobuf definitions in /proto, one package per service version
+package pb
+
+//go:generate protoc --proto_path=../../proto --go_out=../.. --go_opt=module=user-service --go-grpc_out=../.. --go-grpc_opt=module=user-service user/v1/user_service.proto
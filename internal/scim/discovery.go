@@ -0,0 +1,152 @@
+package scim
+
+// ServiceProviderConfig tells clients which features of SCIM the server supports
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	DocumentationURI      string                 `json:"documentationUri,omitempty"`
+	Patch                 Supported              `json:"patch"`
+	Bulk                  BulkSupport            `json:"bulk"`
+	Filter                FilterSupport          `json:"filter"`
+	ChangePassword        Supported              `json:"changePassword"`
+	Sort                  Supported              `json:"sort"`
+	ETag                  Supported              `json:"etag"`
+	AuthenticationSchemes []AuthenticationScheme `json:"authenticationSchemes"`
+	Meta                  *Meta                  `json:"meta,omitempty"`
+}
+
+type Supported struct {
+	Supported bool `json:"supported"`
+}
+
+type BulkSupport struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type FilterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type AuthenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary,omitempty"`
+}
+
+// ResourceType describes an endpoint of resources and their schema
+type ResourceType struct {
+	Schemas     []string `json:"schemas"`
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Endpoint    string   `json:"endpoint"`
+	Description string   `json:"description,omitempty"`
+	Schema      string   `json:"schema"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// Schema describes the attributes of a resource
+type Schema struct {
+	Schemas     []string    `json:"schemas"`
+	Id          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Attributes  []Attribute `json:"attributes"`
+	Meta        *Meta       `json:"meta,omitempty"`
+}
+
+// Attribute describes an attribute of a schema, with the sub-attributes of complex ones
+type Attribute struct {
+	Name          string      `json:"name"`
+	Type          string      `json:"type"`
+	MultiValued   bool        `json:"multiValued"`
+	Description   string      `json:"description,omitempty"`
+	Required      bool        `json:"required"`
+	CaseExact     bool        `json:"caseExact"`
+	Mutability    string      `json:"mutability"`
+	Returned      string      `json:"returned"`
+	Uniqueness    string      `json:"uniqueness"`
+	SubAttributes []Attribute `json:"subAttributes,omitempty"`
+}
+
+// NewServiceProviderConfig returns the features of the server, location is the URL of the config
+func NewServiceProviderConfig(location string) ServiceProviderConfig {
+	return ServiceProviderConfig{
+		Schemas:        []string{ServiceProviderConfigSchema},
+		Patch:          Supported{Supported: true},
+		Bulk:           BulkSupport{},
+		Filter:         FilterSupport{Supported: true, MaxResults: MaxResults},
+		ChangePassword: Supported{},
+		Sort:           Supported{},
+		ETag:           Supported{},
+		AuthenticationSchemes: []AuthenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "OAuth Bearer Token",
+			Description: "An API key of the company with the scim scope, sent as a bearer token",
+			Primary:     true,
+		}},
+		Meta: &Meta{ResourceType: "ServiceProviderConfig", Location: location},
+	}
+}
+
+// UserResourceType describes the Users endpoint, location is the URL of the resource type
+func UserResourceType(location string) ResourceType {
+	return ResourceType{
+		Schemas:     []string{ResourceTypeSchema},
+		Id:          "User",
+		Name:        "User",
+		Endpoint:    "/Users",
+		Description: "User account",
+		Schema:      UserSchema,
+		Meta:        &Meta{ResourceType: "ResourceType", Location: location},
+	}
+}
+
+// UserSchemaDefinition describes the attributes of the users that are supported, location is the URL of the schema
+func UserSchemaDefinition(location string) Schema {
+	text := func(name string, required, caseExact bool, uniqueness, description string) Attribute {
+		return Attribute{Name: name, Type: "string", Required: required, CaseExact: caseExact, Mutability: "readWrite", Returned: "default", Uniqueness: uniqueness, Description: description}
+	}
+	multiValued := func(name, description string) Attribute {
+		return Attribute{
+			Name: name, Type: "complex", MultiValued: true, Mutability: "readWrite", Returned: "default", Uniqueness: "none", Description: description,
+			SubAttributes: []Attribute{
+				text("value", false, false, "none", ""),
+				text("display", false, false, "none", ""),
+				text("type", false, false, "none", ""),
+				{Name: "primary", Type: "boolean", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			},
+		}
+	}
+	password := text("password", false, false, "none", "Only read when the user is created, without it the user sets it with the forgot password flow")
+	password.Mutability, password.Returned = "writeOnly", "never"
+	name := Attribute{
+		Name: "name", Type: "complex", Mutability: "readWrite", Returned: "default", Uniqueness: "none",
+		Description: "Stored as a single name, given and family names are split at the first space",
+		SubAttributes: []Attribute{
+			text("formatted", false, false, "none", ""),
+			text("givenName", false, false, "none", ""),
+			text("familyName", false, false, "none", ""),
+		},
+	}
+
+	return Schema{
+		Schemas:     []string{SchemaSchema},
+		Id:          UserSchema,
+		Name:        "User",
+		Description: "User account",
+		Attributes: []Attribute{
+			text("userName", true, false, "server", "Email of the user"),
+			name,
+			text("displayName", false, false, "none", ""),
+			multiValued("emails", "The email of the userName, changes are ignored"),
+			multiValued("roles", "The primary role is the role of the user"),
+			{Name: "active", Type: "boolean", Mutability: "readWrite", Returned: "default", Uniqueness: "none", Description: "Inactive users can not log in"},
+			password,
+			text("externalId", false, true, "none", "Id of the user in the identity provider"),
+		},
+		Meta: &Meta{ResourceType: "Schema", Location: location},
+	}
+}
@@ -0,0 +1,293 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Filter is a parsed filter expression of RFC 7644 section 3.4.2.2, with its logical operators, grouping and
+// every comparison operator. Complex attribute filters like emails[type eq "work"] are not supported
+type Filter interface {
+	// Matches reports whether the user matches the filter
+	Matches(user User) bool
+}
+
+// filterAttributes has the attributes users can be filtered by, with the values of each one
+var filterAttributes = map[string]func(user User) []interface{}{
+	"id":                func(u User) []interface{} { return singleValue(u.Id) },
+	"externalid":        func(u User) []interface{} { return singleValue(u.ExternalId) },
+	"username":          func(u User) []interface{} { return singleValue(u.UserName) },
+	"displayname":       func(u User) []interface{} { return singleValue(u.DisplayName) },
+	"name.formatted":    func(u User) []interface{} { return nameValue(u, func(n *Name) string { return n.Formatted }) },
+	"name.givenname":    func(u User) []interface{} { return nameValue(u, func(n *Name) string { return n.GivenName }) },
+	"name.familyname":   func(u User) []interface{} { return nameValue(u, func(n *Name) string { return n.FamilyName }) },
+	"emails":            func(u User) []interface{} { return multiValues(u.Emails, func(v MultiValue) string { return v.Value }) },
+	"emails.value":      func(u User) []interface{} { return multiValues(u.Emails, func(v MultiValue) string { return v.Value }) },
+	"emails.type":       func(u User) []interface{} { return multiValues(u.Emails, func(v MultiValue) string { return v.Type }) },
+	"roles":             func(u User) []interface{} { return multiValues(u.Roles, func(v MultiValue) string { return v.Value }) },
+	"roles.value":       func(u User) []interface{} { return multiValues(u.Roles, func(v MultiValue) string { return v.Value }) },
+	"active":            func(u User) []interface{} { return []interface{}{isActive(u.Active)} },
+	"meta.resourcetype": func(u User) []interface{} { return singleValue("User") },
+}
+
+// caseExactAttributes are compared with their case, the others like userName are not
+var caseExactAttributes = map[string]bool{"id": true, "externalid": true}
+
+func singleValue(value string) []interface{} {
+	if value == "" {
+		return nil
+	}
+	return []interface{}{value}
+}
+
+func nameValue(u User, field func(n *Name) string) []interface{} {
+	if u.Name == nil {
+		return nil
+	}
+	return singleValue(field(u.Name))
+}
+
+func multiValues(values []MultiValue, field func(v MultiValue) string) []interface{} {
+	var result []interface{}
+	for _, value := range values {
+		if v := field(value); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// attributeName returns the lower case path of an attribute, without the URN of the user schema
+func attributeName(path string) string {
+	path = strings.ToLower(path)
+	if rest, found := strings.CutPrefix(path, strings.ToLower(UserSchema)+":"); found {
+		return rest
+	}
+	return path
+}
+
+type logicalFilter struct {
+	and         bool
+	left, right Filter
+}
+
+func (f logicalFilter) Matches(user User) bool {
+	if f.and {
+		return f.left.Matches(user) && f.right.Matches(user)
+	}
+	return f.left.Matches(user) || f.right.Matches(user)
+}
+
+type notFilter struct {
+	filter Filter
+}
+
+func (f notFilter) Matches(user User) bool {
+	return !f.filter.Matches(user)
+}
+
+type attributeFilter struct {
+	attribute string
+	operator  string
+	value     interface{}
+}
+
+func (f attributeFilter) Matches(user User) bool {
+	values := filterAttributes[f.attribute](user)
+	if f.operator == "pr" {
+		return len(values) > 0
+	}
+	if f.operator == "ne" {
+		return !attributeFilter{attribute: f.attribute, operator: "eq", value: f.value}.Matches(user)
+	}
+	for _, value := range values {
+		if compare(value, f.operator, f.value, caseExactAttributes[f.attribute]) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare applies the operator to a value of an attribute and the value of the filter
+func compare(value interface{}, operator string, expected interface{}, caseExact bool) bool {
+	if boolean, ok := value.(bool); ok {
+		other, ok := expected.(bool)
+		return ok && operator == "eq" && boolean == other
+	}
+	text, ok := value.(string)
+	other, otherOk := expected.(string)
+	if !ok || !otherOk {
+		return false
+	}
+	if !caseExact {
+		text, other = strings.ToLower(text), strings.ToLower(other)
+	}
+	switch operator {
+	case "eq":
+		return text == other
+	case "co":
+		return strings.Contains(text, other)
+	case "sw":
+		return strings.HasPrefix(text, other)
+	case "ew":
+		return strings.HasSuffix(text, other)
+	case "gt":
+		return text > other
+	case "ge":
+		return text >= other
+	case "lt":
+		return text < other
+	case "le":
+		return text <= other
+	}
+	return false
+}
+
+// ParseFilter parses a filter expression, an invalidFilter Error when it is not valid or not supported
+func ParseFilter(expression string) (Filter, error) {
+	tokens, err := filterTokens(expression)
+	if err != nil {
+		return nil, err
+	}
+	parser := &filterParser{tokens: tokens}
+	filter, err := parser.or()
+	if err != nil {
+		return nil, err
+	}
+	if parser.position < len(parser.tokens) {
+		return nil, invalidFilter("unexpected " + parser.tokens[parser.position])
+	}
+	return filter, nil
+}
+
+func invalidFilter(detail string) *Error {
+	return NewError(http.StatusBadRequest, ErrorInvalidFilter, "Invalid filter: "+detail)
+}
+
+// filterTokens splits an expression in parentheses, JSON strings and words
+func filterTokens(expression string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expression); {
+		switch c := expression[i]; {
+		case c == ' ':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(expression) && expression[end] != '"'; end++ {
+				if expression[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(expression) {
+				return nil, invalidFilter("unterminated string")
+			}
+			tokens = append(tokens, expression[i:end+1])
+			i = end + 1
+		default:
+			end := i
+			for end < len(expression) && !strings.ContainsRune(" ()\"", rune(expression[end])) {
+				end++
+			}
+			tokens = append(tokens, expression[i:end])
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens   []string
+	position int
+}
+
+func (p *filterParser) next() string {
+	if p.position >= len(p.tokens) {
+		return ""
+	}
+	token := p.tokens[p.position]
+	p.position++
+	return token
+}
+
+func (p *filterParser) peekKeyword(keyword string) bool {
+	return p.position < len(p.tokens) && strings.EqualFold(p.tokens[p.position], keyword)
+}
+
+func (p *filterParser) or() (Filter, error) {
+	left, err := p.and()
+	for err == nil && p.peekKeyword("or") {
+		p.position++
+		var right Filter
+		if right, err = p.and(); err == nil {
+			left = logicalFilter{left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) and() (Filter, error) {
+	left, err := p.unary()
+	for err == nil && p.peekKeyword("and") {
+		p.position++
+		var right Filter
+		if right, err = p.unary(); err == nil {
+			left = logicalFilter{and: true, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) unary() (Filter, error) {
+	if p.peekKeyword("not") {
+		p.position++
+		if p.next() != "(" {
+			return nil, invalidFilter("not must be followed by (")
+		}
+		filter, err := p.group()
+		return notFilter{filter: filter}, err
+	}
+	if p.peekKeyword("(") {
+		p.position++
+		return p.group()
+	}
+	return p.comparison()
+}
+
+// group parses the rest of a parenthesized filter
+func (p *filterParser) group() (Filter, error) {
+	filter, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.next() != ")" {
+		return nil, invalidFilter("missing )")
+	}
+	return filter, nil
+}
+
+func (p *filterParser) comparison() (Filter, error) {
+	path := p.next()
+	attribute := attributeName(path)
+	if _, found := filterAttributes[attribute]; !found {
+		return nil, invalidFilter("unsupported attribute " + path)
+	}
+	operator := strings.ToLower(p.next())
+	switch operator {
+	case "pr":
+		return attributeFilter{attribute: attribute, operator: operator}, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, invalidFilter("unsupported operator " + operator)
+	}
+
+	token := p.next()
+	var value interface{}
+	if err := json.Unmarshal([]byte(token), &value); err != nil || token == "" {
+		return nil, invalidFilter("invalid value " + token)
+	}
+	return attributeFilter{attribute: attribute, operator: operator, value: value}, nil
+}
@@ -0,0 +1,198 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// PatchRequest is the body of a PATCH, with the operations applied in order
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation adds, replaces or removes the attribute at the path. Without a path the value is an object
+// with the attributes to add or replace
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ApplyPatch applies the operations to the user, an Error with the reason when one can not be applied.
+// Operations are case insensitive like Azure AD sends them, and so are booleans sent as strings
+func ApplyPatch(user *User, request PatchRequest) error {
+	if len(request.Schemas) > 0 && !containsSchema(request.Schemas, PatchOpSchema) {
+		return NewError(http.StatusBadRequest, ErrorInvalidSyntax, "The request must have the schema "+PatchOpSchema)
+	}
+	if len(request.Operations) == 0 {
+		return NewError(http.StatusBadRequest, ErrorInvalidSyntax, "The request has no operations")
+	}
+
+	for _, operation := range request.Operations {
+		op := strings.ToLower(operation.Op)
+		switch {
+		case op == "remove" && operation.Path == "":
+			return NewError(http.StatusBadRequest, ErrorNoTarget, "Remove operations need a path")
+		case op == "remove":
+			if err := removeAttribute(user, operation.Path); err != nil {
+				return err
+			}
+		case op != "add" && op != "replace":
+			return NewError(http.StatusBadRequest, ErrorInvalidSyntax, "Unsupported operation "+operation.Op)
+		case operation.Path == "":
+			var attributes map[string]json.RawMessage
+			if err := json.Unmarshal(operation.Value, &attributes); err != nil {
+				return invalidValue("the value of an operation without a path must be an object")
+			}
+			for path, value := range attributes {
+				if err := setAttribute(user, path, value, op == "add"); err != nil {
+					return err
+				}
+			}
+		default:
+			if err := setAttribute(user, operation.Path, operation.Value, op == "add"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func containsSchema(schemas []string, schema string) bool {
+	for _, s := range schemas {
+		if strings.EqualFold(s, schema) {
+			return true
+		}
+	}
+	return false
+}
+
+func invalidValue(detail string) *Error {
+	return NewError(http.StatusBadRequest, ErrorInvalidValue, "Invalid value: "+detail)
+}
+
+// setAttribute sets the attribute at the path, add appends to multi-valued attributes instead of replacing them
+func setAttribute(user *User, path string, value json.RawMessage, add bool) error {
+	attribute := attributeName(path)
+	switch {
+	case attribute == "username":
+		return decodeString(value, path, &user.UserName)
+	case attribute == "displayname":
+		return decodeString(value, path, &user.DisplayName)
+	case attribute == "externalid":
+		return decodeString(value, path, &user.ExternalId)
+	case attribute == "active":
+		active, err := decodeBool(value, path)
+		if err != nil {
+			return err
+		}
+		user.Active = &active
+		return nil
+	case attribute == "name":
+		var name Name
+		if err := json.Unmarshal(value, &name); err != nil {
+			return invalidValue(path + " must be an object")
+		}
+		if user.Name == nil || !add {
+			user.Name = &Name{}
+		}
+		if name.GivenName != "" || name.FamilyName != "" {
+			user.Name.Formatted = ""
+		}
+		if name.Formatted != "" {
+			user.Name.Formatted = name.Formatted
+		}
+		if name.GivenName != "" {
+			user.Name.GivenName = name.GivenName
+		}
+		if name.FamilyName != "" {
+			user.Name.FamilyName = name.FamilyName
+		}
+		return nil
+	case strings.HasPrefix(attribute, "name."):
+		if user.Name == nil {
+			user.Name = &Name{}
+		}
+		switch attribute {
+		case "name.formatted":
+			return decodeString(value, path, &user.Name.Formatted)
+		case "name.givenname":
+			// The formatted name is made again from the given and family names
+			user.Name.Formatted = ""
+			return decodeString(value, path, &user.Name.GivenName)
+		case "name.familyname":
+			user.Name.Formatted = ""
+			return decodeString(value, path, &user.Name.FamilyName)
+		}
+	case attribute == "emails" || strings.HasPrefix(attribute, "emails.") || strings.HasPrefix(attribute, "emails["):
+		// The email of the user is its userName, the emails only mirror it
+		return nil
+	case attribute == "roles":
+		var roles []MultiValue
+		if err := json.Unmarshal(value, &roles); err != nil {
+			return invalidValue(path + " must be an array of roles")
+		}
+		if add {
+			roles = append(user.Roles, roles...)
+		}
+		user.Roles = roles
+		return nil
+	case strings.HasPrefix(attribute, "roles[") && strings.HasSuffix(attribute, "].value"):
+		var role string
+		if err := decodeString(value, path, &role); err != nil {
+			return err
+		}
+		user.Roles = []MultiValue{{Value: role, Primary: true}}
+		return nil
+	case attribute == "password":
+		return NewError(http.StatusBadRequest, ErrorMutability, "The password can not be changed over SCIM, users reset it themselves")
+	}
+	return NewError(http.StatusBadRequest, ErrorInvalidPath, "Unsupported path "+path)
+}
+
+// removeAttribute removes the attribute at the path, the attributes a user needs can not be removed
+func removeAttribute(user *User, path string) error {
+	attribute := attributeName(path)
+	switch {
+	case attribute == "externalid":
+		user.ExternalId = ""
+	case attribute == "active":
+		user.Active = nil
+	case attribute == "roles" || strings.HasPrefix(attribute, "roles["):
+		// Users keep their role when the identity provider removes it
+		user.Roles = nil
+	case attribute == "emails" || strings.HasPrefix(attribute, "emails.") || strings.HasPrefix(attribute, "emails["):
+	case attribute == "username", attribute == "displayname", attribute == "name" || strings.HasPrefix(attribute, "name."):
+		return NewError(http.StatusBadRequest, ErrorMutability, path+" is required and can not be removed")
+	default:
+		return NewError(http.StatusBadRequest, ErrorInvalidPath, "Unsupported path "+path)
+	}
+	return nil
+}
+
+func decodeString(value json.RawMessage, path string, target *string) error {
+	if err := json.Unmarshal(value, target); err != nil {
+		return invalidValue(path + " must be a string")
+	}
+	return nil
+}
+
+// decodeBool decodes a boolean, or a string with a boolean like "False"
+func decodeBool(value json.RawMessage, path string) (bool, error) {
+	var boolean bool
+	if err := json.Unmarshal(value, &boolean); err == nil {
+		return boolean, nil
+	}
+	var text string
+	if err := json.Unmarshal(value, &text); err == nil {
+		switch strings.ToLower(text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, invalidValue(path + " must be a boolean")
+}
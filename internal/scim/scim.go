@@ -0,0 +1,236 @@
+// Package scim has the resources, filters and patch operations of SCIM 2.0 (RFC 7643 and RFC 7644),
+// so identity providers like Okta and Azure AD can provision the users of a company
+package scim
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"user-service/internal/models"
+)
+
+const (
+	// ContentType is the media type of every SCIM request and response
+	ContentType = "application/scim+json"
+
+	UserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	ListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ResourceTypeSchema          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+
+	// MaxResults is the largest page of a list, and the page size when the client does not give a count
+	MaxResults = 100
+)
+
+// Error types of RFC 7644 section 3.12
+const (
+	ErrorInvalidFilter = "invalidFilter"
+	ErrorInvalidSyntax = "invalidSyntax"
+	ErrorInvalidPath   = "invalidPath"
+	ErrorInvalidValue  = "invalidValue"
+	ErrorNoTarget      = "noTarget"
+	ErrorMutability    = "mutability"
+	ErrorUniqueness    = "uniqueness"
+)
+
+// User is the SCIM representation of a user. userName is the email of the user, and name and displayName are
+// its name. The emails are the same email, changing them does not change the user
+type User struct {
+	Schemas     []string     `json:"schemas"`
+	Id          string       `json:"id,omitempty"`
+	ExternalId  string       `json:"externalId,omitempty"`
+	UserName    string       `json:"userName"`
+	Name        *Name        `json:"name,omitempty"`
+	DisplayName string       `json:"displayName,omitempty"`
+	Emails      []MultiValue `json:"emails,omitempty"`
+	Roles       []MultiValue `json:"roles,omitempty"`
+	Active      *bool        `json:"active,omitempty"`
+	// Password is only read, SCIM never returns it
+	Password string `json:"password,omitempty"`
+	Meta     *Meta  `json:"meta,omitempty"`
+}
+
+// Name is the complex name attribute of a user
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// MultiValue is an item of a multi-valued attribute like emails and roles
+type MultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Meta has the resource type, location and version of a resource
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	Location     string     `json:"location,omitempty"`
+	Version      string     `json:"version,omitempty"`
+}
+
+// ListResponse is a page of resources, StartIndex is the 1-based index of the first one
+type ListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// NewListResponse returns the page of the resources that starts at the 1-based startIndex
+func NewListResponse[T any](resources []T, startIndex, count int) ListResponse {
+	page := []T{}
+	if start := startIndex - 1; start < len(resources) {
+		end := len(resources)
+		if start+count < end {
+			end = start + count
+		}
+		page = resources[start:end]
+	}
+	return ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}
+}
+
+// Pagination reads the startIndex and count query parameters, invalid values are replaced by the nearest valid one
+func Pagination(startIndex, count string) (int, int) {
+	start, err := strconv.Atoi(startIndex)
+	if err != nil || start < 1 {
+		start = 1
+	}
+	size, err := strconv.Atoi(count)
+	if err != nil || size > MaxResults {
+		size = MaxResults
+	}
+	if size < 0 {
+		size = 0
+	}
+	return start, size
+}
+
+// Error is a SCIM error response, its status is a string as the RFC requires
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// NewError creates an error response, scimType is one of the error types or empty
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{Schemas: []string{ErrorSchema}, Status: strconv.Itoa(status), ScimType: scimType, Detail: detail}
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// StatusCode returns the HTTP status of the error
+func (e *Error) StatusCode() int {
+	status, err := strconv.Atoi(e.Status)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+// FromUser converts a user to its SCIM representation, location is the URL of the resource
+func FromUser(user *models.UserWithCompanyAsObject, location string) User {
+	givenName, familyName, _ := strings.Cut(user.Name, " ")
+	created := user.Id.Timestamp()
+	active := user.IsActive()
+	return User{
+		Schemas:     []string{UserSchema},
+		Id:          user.Id.Hex(),
+		ExternalId:  user.ExternalId,
+		UserName:    user.Email,
+		Name:        &Name{Formatted: user.Name, GivenName: givenName, FamilyName: familyName},
+		DisplayName: user.Name,
+		Emails:      []MultiValue{{Value: user.Email, Type: "work", Primary: true}},
+		Roles:       []MultiValue{{Value: user.Role, Primary: true}},
+		Active:      &active,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      &created,
+			Location:     location,
+			Version:      `W/"` + strconv.FormatInt(user.Version, 10) + `"`,
+		},
+	}
+}
+
+// FullName is the name of the user, its display name or else its formatted name or else its given and family names
+func (u User) FullName() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	if u.Name == nil {
+		return ""
+	}
+	return u.Name.fullName()
+}
+
+func (n Name) fullName() string {
+	if n.Formatted != "" {
+		return n.Formatted
+	}
+	return strings.TrimSpace(n.GivenName + " " + n.FamilyName)
+}
+
+// Role is the value of the primary role of the user, or of its first one, and defaultRole when it has none
+func (u User) Role(defaultRole string) string {
+	for _, role := range u.Roles {
+		if role.Primary && role.Value != "" {
+			return role.Value
+		}
+	}
+	if len(u.Roles) > 0 && u.Roles[0].Value != "" {
+		return u.Roles[0].Value
+	}
+	return defaultRole
+}
+
+// Update returns the changes between two versions of a user, only the fields that changed are set.
+// A changed display name wins over a changed name, and users without roles keep their role
+func Update(before, after User) models.UserUpdate {
+	var update models.UserUpdate
+	name := before.FullName()
+	if after.DisplayName != before.DisplayName && after.DisplayName != "" {
+		name = after.DisplayName
+	} else if after.Name != nil && (before.Name == nil || *after.Name != *before.Name) && after.Name.fullName() != "" {
+		name = after.Name.fullName()
+	}
+	if name != before.FullName() {
+		update.Name = &name
+	}
+	if after.UserName != before.UserName {
+		update.Email = &after.UserName
+	}
+	if role := after.Role(""); role != "" && role != before.Role("") {
+		update.Role = &role
+	}
+	if after.ExternalId != before.ExternalId {
+		update.ExternalId = &after.ExternalId
+	}
+	if isActive(after.Active) != isActive(before.Active) {
+		active := isActive(after.Active)
+		update.Active = &active
+	}
+	return update
+}
+
+func isActive(active *bool) bool {
+	return active == nil || *active
+}
@@ -0,0 +1,141 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"user-service/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func testUser() User {
+	inactive := false
+	user := FromUser(&models.UserWithCompanyAsObject{
+		Id: primitive.NewObjectID(), Name: "Jane Smith", Email: "Jane@Example.com", Role: "admin", Version: 3, ExternalId: "00u1",
+	}, "https://example.com/scim/v2/Users/1")
+	user.Active = &inactive
+	return user
+}
+
+func TestParseFilter(t *testing.T) {
+	user := testUser()
+	cases := map[string]bool{
+		`userName eq "jane@example.com"`:                                true,
+		`userName eq "john@example.com"`:                                false,
+		`USERNAME Eq "JANE@EXAMPLE.COM"`:                                true,
+		`urn:ietf:params:scim:schemas:core:2.0:User:userName sw "jane"`: true,
+		`externalId eq "00U1"`:                                          false,
+		`externalId eq "00u1" and active eq false`:                      true,
+		`name.givenName eq "Jane" and name.familyName co "mit"`:         true,
+		`emails.value ew "example.com" or userName eq "x"`:              true,
+		`not (roles eq "admin")`:                                        false,
+		`(displayName pr) and not (userName eq "x")`:                    true,
+		`active eq true or userName eq "x"`:                             false,
+	}
+	for expression, matches := range cases {
+		filter, err := ParseFilter(expression)
+		if assert.NoError(t, err, expression) {
+			assert.Equal(t, matches, filter.Matches(user), expression)
+		}
+	}
+
+	for _, expression := range []string{`password eq "x"`, `userName`, `userName eq`, `userName eq "x`, `userName xx "x"`, `(userName pr`, `emails[type eq "work"]`, `userName eq jane`} {
+		_, err := ParseFilter(expression)
+		var scimErr *Error
+		if assert.True(t, errors.As(err, &scimErr), expression) {
+			assert.Equal(t, ErrorInvalidFilter, scimErr.ScimType)
+			assert.Equal(t, "400", scimErr.Status)
+		}
+	}
+}
+
+func TestPagination(t *testing.T) {
+	start, count := Pagination("", "")
+	assert.Equal(t, []int{1, MaxResults}, []int{start, count})
+	start, count = Pagination("0", "-1")
+	assert.Equal(t, []int{1, 0}, []int{start, count})
+	start, count = Pagination("3", "1000")
+	assert.Equal(t, []int{3, MaxResults}, []int{start, count})
+
+	page := NewListResponse([]int{1, 2, 3, 4, 5}, 2, 2)
+	assert.Equal(t, []int{2, 3}, page.Resources)
+	assert.Equal(t, 5, page.TotalResults)
+	assert.Equal(t, 2, page.ItemsPerPage)
+	assert.Equal(t, 2, page.StartIndex)
+
+	page = NewListResponse([]int{1, 2}, 5, 10)
+	assert.Equal(t, []int{}, page.Resources)
+	assert.Equal(t, 0, page.ItemsPerPage)
+}
+
+func patch(t *testing.T, user *User, body string) error {
+	var request PatchRequest
+	assert.NoError(t, json.Unmarshal([]byte(body), &request))
+	return ApplyPatch(user, request)
+}
+
+func TestApplyPatch(t *testing.T) {
+	before := testUser()
+	after := testUser()
+	err := patch(t, &after, `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+		{"op":"Replace","path":"active","value":"True"},
+		{"op":"replace","path":"name.givenName","value":"Janet"},
+		{"op":"add","value":{"externalId":"00u2","userName":"janet@example.com"}},
+		{"op":"replace","path":"emails[type eq \"work\"].value","value":"ignored@example.com"}
+	]}`)
+	assert.NoError(t, err)
+
+	update := Update(before, after)
+	if assert.NotNil(t, update.Name) {
+		assert.Equal(t, "Janet Smith", *update.Name)
+	}
+	if assert.NotNil(t, update.Active) {
+		assert.True(t, *update.Active)
+	}
+	assert.Equal(t, "00u2", *update.ExternalId)
+	assert.Equal(t, "janet@example.com", *update.Email)
+	assert.Nil(t, update.Role)
+
+	// A changed display name wins over the name, and roles are only changed when some are given
+	after = testUser()
+	assert.NoError(t, patch(t, &after, `{"Operations":[{"op":"replace","path":"displayName","value":"J. Smith"},{"op":"remove","path":"roles"}]}`))
+	update = Update(before, after)
+	assert.Equal(t, "J. Smith", *update.Name)
+	assert.Nil(t, update.Role)
+	assert.NoError(t, patch(t, &after, `{"Operations":[{"op":"replace","path":"roles[primary eq true].value","value":"user"}]}`))
+	assert.Equal(t, "user", *Update(before, after).Role)
+
+	errorTypes := map[string]string{
+		`{"schemas":["urn:other"],"Operations":[{"op":"replace","path":"active","value":true}]}`: ErrorInvalidSyntax,
+		`{"Operations":[]}`: ErrorInvalidSyntax,
+		`{"Operations":[{"op":"move","path":"active","value":true}]}`:     ErrorInvalidSyntax,
+		`{"Operations":[{"op":"remove"}]}`:                                ErrorNoTarget,
+		`{"Operations":[{"op":"remove","path":"userName"}]}`:              ErrorMutability,
+		`{"Operations":[{"op":"replace","path":"password","value":"x"}]}`: ErrorMutability,
+		`{"Operations":[{"op":"replace","path":"nickName","value":"x"}]}`: ErrorInvalidPath,
+		`{"Operations":[{"op":"replace","path":"active","value":"yes"}]}`: ErrorInvalidValue,
+		`{"Operations":[{"op":"replace","value":"x"}]}`:                   ErrorInvalidValue,
+	}
+	for body, scimType := range errorTypes {
+		user := testUser()
+		var scimErr *Error
+		if assert.True(t, errors.As(patch(t, &user, body), &scimErr), body) {
+			assert.Equal(t, scimType, scimErr.ScimType, body)
+		}
+	}
+}
+
+func TestFromUser(t *testing.T) {
+	user := testUser()
+	encoded, _ := json.Marshal(user)
+	var decoded map[string]interface{}
+	json.Unmarshal(encoded, &decoded)
+
+	assert.Equal(t, []interface{}{UserSchema}, decoded["schemas"])
+	assert.Equal(t, "Jane@Example.com", decoded["userName"])
+	assert.Equal(t, map[string]interface{}{"formatted": "Jane Smith", "givenName": "Jane", "familyName": "Smith"}, decoded["name"])
+	assert.Equal(t, `W/"3"`, decoded["meta"].(map[string]interface{})["version"])
+	assert.NotContains(t, decoded, "password")
+}
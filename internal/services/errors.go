@@ -0,0 +1,55 @@
+package services
+
+import "errors"
+
+// Domain errors of the services, each API translates them to its own status codes
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrForbidden       = errors.New("you are not allowed to perform this action")
+	ErrOwnRole         = errors.New("you can not change your own role")
+	ErrDeleteSelf      = errors.New("you can not delete your own user")
+	ErrInvalidCompany  = errors.New("company is not a valid id")
+	ErrVersionConflict = errors.New("user was changed by another request")
+	ErrGroupNotFound   = errors.New("group not found")
+	ErrGroupExists     = errors.New("a group with the name already exists")
+	ErrGroupCycle      = errors.New("a group can not be nested in itself nor in the groups nested in it")
+	ErrManagerNotFound = errors.New("manager is not a user of the company")
+	ErrManagerCycle    = errors.New("a user can not report to itself nor to the users that report to it")
+)
+
+// ValidationError is returned when an input breaks its validation rules.
+// It wraps the validator errors, or an auth.PasswordPolicyError with the reasons the password was rejected
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// UserExistsError is returned when the email of a new user is already registered
+type UserExistsError struct {
+	Email string
+}
+
+func (e *UserExistsError) Error() string {
+	return "User already exists with email: " + e.Email
+}
+
+// VersionConflictError is returned when a user is not at the version an update was based on.
+// Current is the user as it is now, nil when the conflict was only found while writing
+type VersionConflictError struct {
+	Current *User
+}
+
+func (e *VersionConflictError) Error() string {
+	return ErrVersionConflict.Error()
+}
+
+func (e *VersionConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
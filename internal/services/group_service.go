@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Group is a team of users of a company
+type Group = models.Group
+
+// GroupService holds the rules of the groups of a company. Members and nested groups have to be of the same company,
+// and nesting can not make a group part of itself
+type GroupService struct {
+	groups configs.GroupStore
+	users  *UserService
+}
+
+// NewGroupService creates a group service, users finds the members among the users of the company
+func NewGroupService(groups configs.GroupStore, users *UserService) *GroupService {
+	return &GroupService{groups: groups, users: users}
+}
+
+// Create adds a group to the company, ErrGroupExists when it has one with the same name
+func (s *GroupService) Create(ctx context.Context, companyId primitive.ObjectID, name string) (*Group, error) {
+	group := &Group{
+		Company:   companyId,
+		Name:      name,
+		Members:   []primitive.ObjectID{},
+		Groups:    []primitive.ObjectID{},
+		CreatedAt: time.Now(),
+	}
+	var err error
+	if group.Id, err = s.groups.CreateGroup(ctx, *group); err != nil {
+		return nil, groupError(err)
+	}
+	return group, nil
+}
+
+// Get returns a group of the company, ErrGroupNotFound when there is none
+func (s *GroupService) Get(ctx context.Context, companyId primitive.ObjectID, id string) (*Group, error) {
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrGroupNotFound
+	}
+	group, err := s.groups.FindGroup(ctx, companyId, objId)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, ErrGroupNotFound
+	}
+	return group, nil
+}
+
+// List returns every group of the company
+func (s *GroupService) List(ctx context.Context, companyId primitive.ObjectID) ([]*Group, error) {
+	return s.groups.FindCompanyGroups(ctx, companyId)
+}
+
+// Rename changes the name of a group of the company, ErrGroupExists when another group has it
+func (s *GroupService) Rename(ctx context.Context, companyId primitive.ObjectID, id, name string) (*Group, error) {
+	return s.update(ctx, companyId, id, func(group *Group) error {
+		return s.groups.RenameGroup(ctx, companyId, group.Id, name)
+	})
+}
+
+// Delete removes a group of the company, its members are kept
+func (s *GroupService) Delete(ctx context.Context, companyId primitive.ObjectID, id string) error {
+	group, err := s.Get(ctx, companyId, id)
+	if err != nil {
+		return err
+	}
+	return groupError(s.groups.DeleteGroup(ctx, companyId, group.Id))
+}
+
+// AddMember adds a user of the company to a group, ErrUserNotFound when it is not one of its users
+func (s *GroupService) AddMember(ctx context.Context, companyId primitive.ObjectID, id, userId string) (*Group, error) {
+	user, err := s.users.UserOfCompany(ctx, companyId, userId)
+	if err != nil {
+		return nil, err
+	}
+	return s.update(ctx, companyId, id, func(group *Group) error {
+		return s.groups.AddGroupMember(ctx, companyId, group.Id, user.Id)
+	})
+}
+
+// RemoveMember takes a user out of a group, users that are not members are ignored
+func (s *GroupService) RemoveMember(ctx context.Context, companyId primitive.ObjectID, id, userId string) (*Group, error) {
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	return s.update(ctx, companyId, id, func(group *Group) error {
+		return s.groups.RemoveGroupMember(ctx, companyId, group.Id, objId)
+	})
+}
+
+// Nest makes a group of the company part of another one, ErrGroupCycle when the other one is nested in it.
+// Two concurrent nestings can still make a cycle, which the lookups of nested groups stop on
+func (s *GroupService) Nest(ctx context.Context, companyId primitive.ObjectID, id, nestedId string) (*Group, error) {
+	nested, err := s.Get(ctx, companyId, nestedId)
+	if err != nil {
+		return nil, err
+	}
+	return s.update(ctx, companyId, id, func(group *Group) error {
+		if group.Id == nested.Id {
+			return ErrGroupCycle
+		}
+		descendants, err := s.groups.FindNestedGroups(ctx, companyId, nested.Id)
+		if err != nil {
+			return err
+		}
+		for _, descendant := range descendants {
+			if descendant.Id == group.Id {
+				return ErrGroupCycle
+			}
+		}
+		return s.groups.AddNestedGroup(ctx, companyId, group.Id, nested.Id)
+	})
+}
+
+// Unnest takes a nested group out of a group, groups that are not nested in it are ignored
+func (s *GroupService) Unnest(ctx context.Context, companyId primitive.ObjectID, id, nestedId string) (*Group, error) {
+	objId, err := primitive.ObjectIDFromHex(nestedId)
+	if err != nil {
+		return nil, ErrGroupNotFound
+	}
+	return s.update(ctx, companyId, id, func(group *Group) error {
+		return s.groups.RemoveNestedGroup(ctx, companyId, group.Id, objId)
+	})
+}
+
+// UserGroups returns the effective groups of a user of the company, the ones it is a member of and the ones
+// they are nested in
+func (s *GroupService) UserGroups(ctx context.Context, companyId primitive.ObjectID, userId string) ([]*Group, error) {
+	user, err := s.users.UserOfCompany(ctx, companyId, userId)
+	if err != nil {
+		return nil, err
+	}
+	return s.groups.FindUserGroups(ctx, companyId, user.Id)
+}
+
+// MemberIds returns the users of a group of the company, with the members of the groups nested in it
+func (s *GroupService) MemberIds(ctx context.Context, companyId primitive.ObjectID, id string) ([]primitive.ObjectID, error) {
+	group, err := s.Get(ctx, companyId, id)
+	if err != nil {
+		return nil, err
+	}
+	nested, err := s.groups.FindNestedGroups(ctx, companyId, group.Id)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[primitive.ObjectID]bool{}
+	ids := []primitive.ObjectID{}
+	for _, group := range append([]*Group{group}, nested...) {
+		for _, member := range group.Members {
+			if !seen[member] {
+				seen[member] = true
+				ids = append(ids, member)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// InGroup reports whether the user is an effective member of a group of its company with any of the names
+func (s *GroupService) InGroup(ctx context.Context, user *User, names ...string) (bool, error) {
+	groups, err := s.groups.FindUserGroups(ctx, user.Company, user.Id)
+	if err != nil {
+		return false, err
+	}
+	for _, group := range groups {
+		for _, name := range names {
+			if group.Name == name {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// update runs fn on a group of the company and returns the group as it is after it
+func (s *GroupService) update(ctx context.Context, companyId primitive.ObjectID, id string, fn func(group *Group) error) (*Group, error) {
+	group, err := s.Get(ctx, companyId, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(group); err != nil {
+		return nil, groupError(err)
+	}
+	return s.Get(ctx, companyId, id)
+}
+
+// groupError translates the errors of the group store to the ones of the service
+func groupError(err error) error {
+	switch {
+	case errors.Is(err, configs.ErrGroupExists):
+		return ErrGroupExists
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrGroupNotFound
+	}
+	return err
+}
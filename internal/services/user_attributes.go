@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"user-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// attributeNamePattern is the form of the names of the custom attributes, which are part of the field paths of
+// the users so they can have neither dots nor a leading $
+var attributeNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// AttributeSchema returns the custom attributes the users of the company can have
+func (s *UserService) AttributeSchema(ctx context.Context, companyId primitive.ObjectID) ([]models.AttributeDefinition, error) {
+	settings, err := s.companySettings.FindCompanySettings(ctx, companyId)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil || settings.AttributeSchema == nil {
+		return []models.AttributeDefinition{}, nil
+	}
+	return settings.AttributeSchema, nil
+}
+
+// SetAttributeSchema replaces the custom attributes of the company. The values users already have are kept,
+// and are checked against the new schema the next time their attributes change
+func (s *UserService) SetAttributeSchema(ctx context.Context, companyId primitive.ObjectID, request models.AttributeSchemaRequest) ([]models.AttributeDefinition, error) {
+	if err := s.validate.Struct(&request); err != nil {
+		return nil, &ValidationError{Err: err}
+	}
+	names := map[string]bool{}
+	for _, attribute := range request.Attributes {
+		if !attributeNamePattern.MatchString(attribute.Name) {
+			return nil, &ValidationError{Err: fmt.Errorf("attribute %s: names have letters, digits and underscores and start with a letter", attribute.Name)}
+		}
+		if names[attribute.Name] {
+			return nil, &ValidationError{Err: fmt.Errorf("attribute %s is defined twice", attribute.Name)}
+		}
+		names[attribute.Name] = true
+		if attribute.Type != models.AttributeTypeString && (len(attribute.Enum) > 0 || attribute.Pattern != "") {
+			return nil, &ValidationError{Err: fmt.Errorf("attribute %s: only string attributes have enum and pattern", attribute.Name)}
+		}
+		if _, err := regexp.Compile(attribute.Pattern); err != nil {
+			return nil, &ValidationError{Err: fmt.Errorf("attribute %s: invalid pattern: %w", attribute.Name, err)}
+		}
+	}
+	if request.Attributes == nil {
+		request.Attributes = []models.AttributeDefinition{}
+	}
+	if err := s.companySettings.UpdateAttributeSchema(ctx, companyId, request.Attributes); err != nil {
+		return nil, err
+	}
+	return request.Attributes, nil
+}
+
+// NewUserAttributes checks the attributes of a new user of the company against its schema, like Create does, and
+// returns them. It is for the users that are stored without Create, like the imported ones
+func (s *UserService) NewUserAttributes(ctx context.Context, companyId primitive.ObjectID, attributes map[string]interface{}) (map[string]interface{}, error) {
+	return s.attributes(ctx, companyId, nil, attributes, true)
+}
+
+// ParseAttributes returns the values of the text, keyed by attribute name, parsed to the type of their attribute
+// of the company, like the columns of a CSV file
+func (s *UserService) ParseAttributes(ctx context.Context, companyId primitive.ObjectID, text map[string]string) (map[string]interface{}, error) {
+	schema, err := s.AttributeSchema(ctx, companyId)
+	if err != nil {
+		return nil, err
+	}
+	attributes := map[string]interface{}{}
+	for name, value := range text {
+		definition := findAttribute(schema, name)
+		if definition == nil {
+			return nil, &ValidationError{Err: fmt.Errorf("attribute %s is not in the attribute schema of the company", name)}
+		}
+		// Empty cells leave the attribute unset
+		if value == "" {
+			continue
+		}
+		if attributes[name], err = parseAttribute(*definition, value); err != nil {
+			return nil, err
+		}
+	}
+	return attributes, nil
+}
+
+// attributes returns the custom attributes of a user of the company after the changes, which are checked against
+// the schema of the company. Changes set to nil remove the attribute, and with required the required attributes
+// have to be set after them. Attributes no longer in the schema are kept until they are removed
+func (s *UserService) attributes(ctx context.Context, companyId primitive.ObjectID, current, changes map[string]interface{}, required bool) (map[string]interface{}, error) {
+	schema, err := s.AttributeSchema(ctx, companyId)
+	if err != nil {
+		return nil, err
+	}
+	definitions := map[string]models.AttributeDefinition{}
+	for _, definition := range schema {
+		definitions[definition.Name] = definition
+	}
+
+	attributes := map[string]interface{}{}
+	for name, value := range current {
+		attributes[name] = value
+	}
+	names := make([]string, 0, len(changes))
+	for name := range changes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := changes[name]
+		if value == nil {
+			delete(attributes, name)
+			continue
+		}
+		definition, found := definitions[name]
+		if !found {
+			return nil, &ValidationError{Err: fmt.Errorf("attribute %s is not in the attribute schema of the company", name)}
+		}
+		if err := checkAttribute(definition, value); err != nil {
+			return nil, &ValidationError{Err: err}
+		}
+		attributes[name] = value
+	}
+	for _, definition := range schema {
+		if _, found := attributes[definition.Name]; required && definition.Required && !found {
+			return nil, &ValidationError{Err: fmt.Errorf("attribute %s is required", definition.Name)}
+		}
+	}
+	return attributes, nil
+}
+
+// checkAttribute checks a value has the type of the attribute and passes its enum and pattern
+func checkAttribute(definition models.AttributeDefinition, value interface{}) error {
+	switch definition.Type {
+	case models.AttributeTypeNumber:
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("attribute %s must be a number", definition.Name)
+		}
+	case models.AttributeTypeBoolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("attribute %s must be a boolean", definition.Name)
+		}
+	default:
+		text, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("attribute %s must be a string", definition.Name)
+		}
+		if len(definition.Enum) > 0 && !containsString(definition.Enum, text) {
+			return fmt.Errorf("attribute %s must be one of %v", definition.Name, definition.Enum)
+		}
+		if definition.Pattern != "" {
+			if matched, err := regexp.MatchString(definition.Pattern, text); err != nil || !matched {
+				return fmt.Errorf("attribute %s must match %s", definition.Name, definition.Pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// AttributeFilter returns the filter of the users of the company with the values of the query, keyed by attribute
+// name. Only indexed attributes can filter, and the values are parsed to the type of their attribute
+func (s *UserService) AttributeFilter(ctx context.Context, companyId primitive.ObjectID, query map[string]string) (map[string]interface{}, error) {
+	schema, err := s.AttributeSchema(ctx, companyId)
+	if err != nil {
+		return nil, err
+	}
+	filter := map[string]interface{}{}
+	for name, text := range query {
+		definition := findAttribute(schema, name)
+		if definition == nil || !definition.Indexed {
+			return nil, &ValidationError{Err: fmt.Errorf("attribute %s is not an indexed attribute of the company", name)}
+		}
+		if filter[name], err = parseAttribute(*definition, text); err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
+
+// findAttribute returns the definition of the attribute of the schema, nil when it has none
+func findAttribute(schema []models.AttributeDefinition, name string) *models.AttributeDefinition {
+	for i := range schema {
+		if schema[i].Name == name {
+			return &schema[i]
+		}
+	}
+	return nil
+}
+
+// parseAttribute parses the text to the type of the attribute
+func parseAttribute(definition models.AttributeDefinition, text string) (interface{}, error) {
+	switch definition.Type {
+	case models.AttributeTypeNumber:
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, &ValidationError{Err: fmt.Errorf("attribute %s must be a number", definition.Name)}
+		}
+		return value, nil
+	case models.AttributeTypeBoolean:
+		value, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, &ValidationError{Err: fmt.Errorf("attribute %s must be a boolean", definition.Name)}
+		}
+		return value, nil
+	}
+	return text, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,453 @@
+// Package services holds the business rules of the service, shared by the REST and gRPC APIs and by background jobs.
+// Services return the domain errors of this package instead of status codes
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"user-service/internal/auth"
+	"user-service/internal/configs"
+	"user-service/internal/models"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// User is a user as it is stored, with its password hash
+type User = models.UserWithCompanyAsObject
+
+// CreateUserInput is a new user with its password in plain text, validated with the rules of models.User.
+// With PasswordResetRequired the password can be empty, and the user sets it with the forgot password flow
+type CreateUserInput struct {
+	Name     string
+	Email    string
+	Password string
+	Role     string
+	Company  string
+	// ManagerId is the id of the user of the same company the new user reports to, if any
+	ManagerId string
+	// Attributes has the values of the custom attributes, checked against the attribute schema of the company
+	Attributes map[string]interface{}
+
+	ExternalId            string
+	Active                *bool
+	PasswordResetRequired bool
+	// WithoutRequiredAttributes creates the user even when required attributes are missing, for identity providers
+	// that do not know the attribute schema. The values that are set are still checked
+	WithoutRequiredAttributes bool
+}
+
+// UpdateUserInput changes the fields of a user that are set.
+// Versions has the versions the update can be applied to, any version when it is nil
+type UpdateUserInput struct {
+	Id       string
+	Update   models.UserUpdate
+	Versions []int64
+}
+
+// Inviter asks the person of a user created with an email that is already in other companies to accept the
+// membership, see models.UserWithCompanyAsObject.InvitationPending
+type Inviter interface {
+	Invite(ctx context.Context, user *User) error
+}
+
+// UserService holds the rules to create, read, update and delete users
+type UserService struct {
+	db              configs.Database
+	companySettings configs.CompanySettingsStore
+	groups          configs.GroupStore
+	inviter         Inviter
+	validate        *validator.Validate
+}
+
+// NewUserService creates a user service, the validator must have the password validation registered.
+// Without an inviter the invitations are not sent
+func NewUserService(db configs.Database, companySettings configs.CompanySettingsStore, groups configs.GroupStore, inviter Inviter, validate *validator.Validate) *UserService {
+	return &UserService{db: db, companySettings: companySettings, groups: groups, inviter: inviter, validate: validate}
+}
+
+// Create validates a new user against the password policy of its company and stores it with the password hashed.
+// When the email is already in other companies the user keeps the credentials of its person and waits for it to
+// accept the invitation, the result is the same as for a new email so it does not tell which emails exist
+func (s *UserService) Create(ctx context.Context, input CreateUserInput) (*User, error) {
+	user := models.User{Name: input.Name, Email: input.Email, Password: input.Password, Role: input.Role, Company: input.Company, ManagerId: input.ManagerId}
+	withoutPassword := input.PasswordResetRequired && input.Password == ""
+	if withoutPassword {
+		if err := s.validate.StructExcept(&user, "Password"); err != nil {
+			return nil, &ValidationError{Err: err}
+		}
+	} else if err := s.ValidateUser(s.PasswordPolicyContext(ctx, user.Company), user); err != nil {
+		return nil, err
+	}
+
+	companyId, err := primitive.ObjectIDFromHex(user.Company)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCompany, err)
+	}
+	invite, err := s.NeedsInvitation(ctx, companyId, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := s.attributes(ctx, companyId, nil, input.Attributes, !input.WithoutRequiredAttributes)
+	if err != nil {
+		return nil, err
+	}
+	var manager *User
+	if user.ManagerId != "" {
+		if manager, err = s.manager(ctx, companyId, user.ManagerId); err != nil {
+			return nil, err
+		}
+	}
+	// The password is hashed even when the email has an identity and the hash is not stored, so both take the same time
+	passwordHash := ""
+	if !withoutPassword {
+		if passwordHash, err = auth.HashPassword(user.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	created := &User{
+		Name:                  user.Name,
+		Email:                 user.Email,
+		Password:              passwordHash,
+		Role:                  user.Role,
+		Company:               companyId,
+		Version:               1,
+		ExternalId:            input.ExternalId,
+		Active:                input.Active,
+		PasswordResetRequired: input.PasswordResetRequired,
+		InvitationPending:     invite,
+	}
+	if manager != nil {
+		created.Manager = &manager.Id
+	}
+	if len(attributes) > 0 {
+		created.Attributes = attributes
+	}
+	if created.Id, err = s.db.CreateUser(ctx, *created); err != nil {
+		return nil, err
+	}
+	if invite {
+		s.Invite(ctx, created)
+	}
+	return created, nil
+}
+
+// NeedsInvitation reports whether a new user of the company with the email has to be accepted by its person, because
+// the email is in other companies. It returns UserExistsError when the company already has a user with the email
+func (s *UserService) NeedsInvitation(ctx context.Context, companyId primitive.ObjectID, email string) (bool, error) {
+	// The person of the email may already be in other companies, so the check looks past the tenant scope
+	existing, err := s.db.FindUsersByEmail(configs.WithSystemScope(ctx), email)
+	if err != nil {
+		return false, err
+	}
+	for _, user := range existing {
+		if user.Company == companyId {
+			return false, &UserExistsError{Email: user.Email}
+		}
+	}
+	return len(existing) > 0, nil
+}
+
+// Invite sends the invitation of a user whose invitation is pending. Errors are only logged, the user was already created
+func (s *UserService) Invite(ctx context.Context, user *User) {
+	if s.inviter == nil {
+		log.Error().Msg("No inviter configured, the invitation of user: " + user.Id.Hex() + " was not sent")
+		return
+	}
+	if err := s.inviter.Invite(ctx, user); err != nil {
+		log.Error().Err(err).Msg("Error inviting user: " + user.Id.Hex())
+	}
+}
+
+// Get returns a user by id, ErrUserNotFound when there is none
+func (s *UserService) Get(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	user, err := s.db.FindUserByID(ctx, id)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// GetByEmail returns the user of a company by email, ErrUserNotFound when there is none
+func (s *UserService) GetByEmail(ctx context.Context, companyId primitive.ObjectID, email string) (*User, error) {
+	user, err := s.db.FindUserByEmail(ctx, companyId, email)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// ListByEmail returns the users of an email, one for each company it is in
+func (s *UserService) ListByEmail(ctx context.Context, email string) ([]*User, error) {
+	return s.db.FindUsersByEmail(ctx, email)
+}
+
+// ListByCompany returns every user of a company
+func (s *UserService) ListByCompany(ctx context.Context, companyId primitive.ObjectID) ([]*User, error) {
+	return s.db.FindAllUsers(ctx, companyId)
+}
+
+// List returns the users of a company that match the filter
+func (s *UserService) List(ctx context.Context, companyId primitive.ObjectID, filter models.UserFilter) ([]*User, error) {
+	return s.db.FindUsers(ctx, companyId, filter)
+}
+
+// StreamByCompany calls fn with every user of a company while they are read, stopping at the first error of fn
+func (s *UserService) StreamByCompany(ctx context.Context, companyId primitive.ObjectID, fn func(user *User) error) error {
+	return s.db.StreamUsers(ctx, companyId, fn)
+}
+
+// CompanyUser returns a user of the company of the actor, users of other companies are not found
+func (s *UserService) CompanyUser(ctx context.Context, actor *User, id string) (*User, error) {
+	return s.UserOfCompany(ctx, actor.Company, id)
+}
+
+// UserOfCompany returns a user of the company, users of other companies are not found
+func (s *UserService) UserOfCompany(ctx context.Context, companyId primitive.ObjectID, id string) (*User, error) {
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	user, err := s.Get(ctx, objId)
+	if err != nil {
+		return nil, err
+	}
+	if user.Company != companyId {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// Update changes the name, role, manager or attributes of a user. Users can change their own name, and admins any user
+// of their company but not their own role. The version is checked again by the database, in case the user changed since it was read
+func (s *UserService) Update(ctx context.Context, actor *User, input UpdateUserInput) (*User, error) {
+	if err := s.validate.Struct(&input.Update); err != nil {
+		return nil, &ValidationError{Err: err}
+	}
+	if input.Update.Name == nil && input.Update.Role == nil && input.Update.Manager == nil && input.Update.Attributes == nil {
+		return nil, &ValidationError{Err: errors.New("name, role, managerId or attributes is required")}
+	}
+
+	user, err := s.CompanyUser(ctx, actor, input.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	isAdmin := actor.Role == models.RoleAdmin
+	if !isAdmin && (user.Id != actor.Id || input.Update.Role != nil || input.Update.Manager != nil || input.Update.Attributes != nil) {
+		return nil, ErrForbidden
+	}
+	// Otherwise the last admin of a company could leave it without one
+	if user.Id == actor.Id && input.Update.Role != nil && *input.Update.Role != actor.Role {
+		return nil, ErrOwnRole
+	}
+
+	if input.Versions != nil && !containsVersion(input.Versions, user.Version) {
+		return nil, &VersionConflictError{Current: user}
+	}
+	if input.Update.Manager != nil && !input.Update.RemovesManager() {
+		if err := s.checkManager(ctx, user, *input.Update.Manager); err != nil {
+			return nil, err
+		}
+	}
+	if input.Update.Attributes != nil {
+		if input.Update.Attributes, err = s.attributes(ctx, user.Company, user.Attributes, input.Update.Attributes, true); err != nil {
+			return nil, err
+		}
+	}
+	updated, err := s.db.UpdateUser(ctx, user.Id, user.Version, input.Update)
+	if errors.Is(err, configs.ErrVersionConflict) {
+		return nil, &VersionConflictError{}
+	}
+	return updated, err
+}
+
+// manager returns the user of the company that is the manager of the id, a ValidationError with ErrManagerNotFound
+// when it is not one of its users
+func (s *UserService) manager(ctx context.Context, companyId primitive.ObjectID, id string) (*User, error) {
+	manager, err := s.UserOfCompany(ctx, companyId, id)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, &ValidationError{Err: ErrManagerNotFound}
+	}
+	return manager, err
+}
+
+// checkManager checks the user can report to the manager, ErrManagerCycle when the manager is the user or reports
+// to it. Two concurrent changes can still make a cycle, which the lookups of reports and chains stop on
+func (s *UserService) checkManager(ctx context.Context, user *User, managerId primitive.ObjectID) error {
+	manager, err := s.manager(ctx, user.Company, managerId.Hex())
+	if err != nil {
+		return err
+	}
+	if manager.Id == user.Id {
+		return ErrManagerCycle
+	}
+	chain, err := s.db.FindManagerChain(ctx, user.Company, manager.Id)
+	if err != nil {
+		return err
+	}
+	for _, above := range chain {
+		if above.Id == user.Id {
+			return ErrManagerCycle
+		}
+	}
+	return nil
+}
+
+// Reports returns the users that report to a user of the company, up to depth levels below it with 1 being its
+// direct reports. Depth goes from 1 to models.MaxReportsDepth
+func (s *UserService) Reports(ctx context.Context, companyId primitive.ObjectID, id string, depth int) ([]*User, error) {
+	if depth < 1 || depth > models.MaxReportsDepth {
+		return nil, &ValidationError{Err: fmt.Errorf("depth must be between 1 and %d", models.MaxReportsDepth)}
+	}
+	user, err := s.UserOfCompany(ctx, companyId, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.db.FindReports(ctx, companyId, user.Id, depth)
+}
+
+// ManagerChain returns the managers of a user of the company, from its own manager up to the top
+func (s *UserService) ManagerChain(ctx context.Context, companyId primitive.ObjectID, id string) ([]*User, error) {
+	user, err := s.UserOfCompany(ctx, companyId, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.db.FindManagerChain(ctx, companyId, user.Id)
+}
+
+// Delete removes a user of the company of the admin, admins can not delete themselves
+func (s *UserService) Delete(ctx context.Context, admin *User, id string) error {
+	user, err := s.CompanyUser(ctx, admin, id)
+	if err != nil {
+		return err
+	}
+	if user.Id == admin.Id {
+		return ErrDeleteSelf
+	}
+	return s.delete(ctx, user)
+}
+
+// Provision changes the fields of a user of the company that its identity provider manages, whatever its version.
+// Users can not be moved to the email of another user of the company
+func (s *UserService) Provision(ctx context.Context, companyId primitive.ObjectID, id string, update models.UserUpdate) (*User, error) {
+	if err := s.validate.Struct(&update); err != nil {
+		return nil, &ValidationError{Err: err}
+	}
+	user, err := s.UserOfCompany(ctx, companyId, id)
+	if err != nil {
+		return nil, err
+	}
+	// A new email of other companies moves the user to the identity of its person, who has to accept it
+	invite := false
+	if update.Email != nil && *update.Email != user.Email {
+		if invite, err = s.NeedsInvitation(ctx, companyId, *update.Email); err != nil {
+			return nil, err
+		}
+		if invite {
+			update.InvitationPending = &invite
+		}
+	}
+	if update.IsEmpty() {
+		return user, nil
+	}
+
+	updated, err := s.db.UpdateUser(ctx, user.Id, user.Version, update)
+	if errors.Is(err, configs.ErrVersionConflict) {
+		return nil, &VersionConflictError{}
+	}
+	if err == nil && invite {
+		s.Invite(ctx, updated)
+	}
+	return updated, err
+}
+
+// Deprovision removes a user of the company for its identity provider
+func (s *UserService) Deprovision(ctx context.Context, companyId primitive.ObjectID, id string) error {
+	user, err := s.UserOfCompany(ctx, companyId, id)
+	if err != nil {
+		return err
+	}
+	return s.delete(ctx, user)
+}
+
+// delete removes a user and takes it out of its groups. The user is already gone when that fails,
+// so the error is only logged and the groups keep an id that matches no user. Its reports are left without
+// a manager first, each one as an update of its own so it gets a new version and an audit event
+func (s *UserService) delete(ctx context.Context, user *User) error {
+	reports, err := s.db.FindReports(ctx, user.Company, user.Id, 1)
+	if err != nil {
+		return err
+	}
+	noManager := primitive.NilObjectID
+	for _, report := range reports {
+		if _, err := s.db.UpdateUser(ctx, report.Id, report.Version, models.UserUpdate{Manager: &noManager}); err != nil {
+			return err
+		}
+	}
+	if err := s.db.DeleteUser(ctx, user.Id); err != nil {
+		return err
+	}
+	if err := s.groups.RemoveUserFromGroups(ctx, user.Id); err != nil {
+		log.Error().Err(err).Msg("Error removing user: " + user.Id.Hex() + " from its groups")
+	}
+	return nil
+}
+
+// ValidateUser validates a new user, ctx has to carry the password policy of its company
+func (s *UserService) ValidateUser(ctx context.Context, user models.User) error {
+	if err := s.validate.StructCtx(ctx, &user); err != nil {
+		return &ValidationError{Err: WithPasswordReasons(ctx, err, user.Password, user.Email, user.Name)}
+	}
+	return nil
+}
+
+// PasswordPolicyContext returns a context carrying the password policy of the company for the password validator
+func (s *UserService) PasswordPolicyContext(ctx context.Context, companyId string, personalInfo ...string) context.Context {
+	policy := auth.DefaultPasswordPolicy
+	if companyIdObject, err := primitive.ObjectIDFromHex(companyId); err == nil {
+		lookupCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		settings, err := s.companySettings.FindCompanySettings(lookupCtx, companyIdObject)
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting company settings, using the default password policy")
+		} else if settings != nil {
+			policy = auth.ApplyOverride(policy, settings.PasswordPolicy)
+		}
+	}
+	return auth.WithPasswordPolicy(ctx, policy, personalInfo...)
+}
+
+// WithPasswordReasons adds the specific password policy violations to a validation error
+func WithPasswordReasons(ctx context.Context, err error, password string, personalInfo ...string) error {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+	for _, fieldErr := range validationErrors {
+		if fieldErr.Tag() == auth.PasswordTag {
+			return &auth.PasswordPolicyError{Reasons: auth.PasswordPolicyReasons(ctx, password, personalInfo...), Err: err}
+		}
+	}
+	return err
+}
+
+func containsVersion(versions []int64, version int64) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"os"
+	"user-service/cmd/controllers"
 	"user-service/cmd/routes"
+	"user-service/internal/configs"
+	"user-service/internal/events"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
@@ -13,11 +18,45 @@ import (
 func main() {
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	router := gin.Default()
+	// The client IP is used for the API key allowlists, the login lockouts and the audit log, so X-Forwarded-For is
+	// only read from the configured proxies
+	if err := router.SetTrustedProxies(configs.EnvTrustedProxies()); err != nil {
+		log.Error().Err(err).Msg("Error setting the trusted proxies")
+		panic(err)
+	}
+	router.Use(controllers.RequestID())
 
 	log.Info().Msg("Starting server...")
 
 	//routes
-	routes.UserRoute(router)
+	routes.Versions(router)
+	routes.OpenAPIRoute(router)
+	routes.SCIMRoute(router)
+	routes.OIDCRoute(router)
+
+	// Domain events written to the outbox are published in the background, to webhooks and event streams included
+	publisher := events.NewMultiPublisher(events.NewLogPublisher(), controllers.WebhookPublisher{}, controllers.Broadcaster)
+	relay := events.NewRelay(configs.NewMongoOutbox(configs.DB), publisher)
+	go relay.Run(context.Background())
+	go controllers.RunWebhookDeliveries(context.Background())
+
+	// Internal services call the same logic over gRPC on their own port
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		log.Info().Msg("No GRPC_PORT environment variable detected, defaulting to 6001")
+		grpcPort = "6001"
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(configs.EnvGRPCHost(), grpcPort))
+	if err != nil {
+		log.Error().Err(err).Msg("Error listening on gRPC port " + grpcPort)
+		panic(err)
+	}
+	go func() {
+		if err := routes.GRPCServer().Serve(listener); err != nil {
+			log.Error().Err(err).Msg("Error serving gRPC on port " + grpcPort)
+		}
+	}()
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Info().Msg("No PORT environment variable detected, defaulting to 6000")
@@ -25,7 +64,7 @@ func main() {
 	}
 
 	// Start the server on the specified port
-	err := http.ListenAndServe(":"+port, router)
+	err = http.ListenAndServe(":"+port, router)
 	if err != nil {
 		log.Error().Err(err).Msg("Error starting server on port " + port)
 		panic(err)